@@ -2,21 +2,36 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/research-computing/mole/internal/agent"
 	"github.com/research-computing/mole/internal/aws"
 	"github.com/research-computing/mole/internal/config"
+	"github.com/research-computing/mole/internal/forward"
+	"github.com/research-computing/mole/internal/keystore"
 	"github.com/research-computing/mole/internal/monitoring"
 	"github.com/research-computing/mole/internal/network"
+	"github.com/research-computing/mole/internal/peer"
+	"github.com/research-computing/mole/internal/rotation"
 	"github.com/research-computing/mole/internal/tunnel"
+	"github.com/research-computing/mole/internal/userspace"
 	"github.com/research-computing/mole/internal/version"
 	"github.com/spf13/cobra"
 )
@@ -44,8 +59,38 @@ func init() {
 	rootCmd.AddCommand(exportCmd())
 	rootCmd.AddCommand(createProfileCmd())
 	rootCmd.AddCommand(connectCmd())
+	rootCmd.AddCommand(sshCmd())
+	rootCmd.AddCommand(shellCmd())
+	rootCmd.AddCommand(imageCmd())
+	rootCmd.AddCommand(iamPolicyCmd())
 	rootCmd.AddCommand(downCmd())
+	rootCmd.AddCommand(logoutCmd())
+	rootCmd.AddCommand(peerCmd())
+	rootCmd.AddCommand(keysCmd())
+	rootCmd.AddCommand(agentCmd())
+	rootCmd.AddCommand(proxyCmd())
+	rootCmd.AddCommand(forwardCmd())
 	rootCmd.AddCommand(versionCmd())
+
+	rootCmd.PersistentFlags().String("role-arn", "", "IAM role to assume with the profile's credentials")
+	rootCmd.PersistentFlags().String("external-id", "", "External ID required by the role's trust policy")
+	rootCmd.PersistentFlags().String("mfa-serial", "", "MFA device ARN; prompts for a token code when assuming --role-arn")
+	rootCmd.PersistentFlags().Duration("session-duration", aws.DefaultSessionDuration, "Assumed-role session duration (15m-12h)")
+}
+
+// newAWSClient creates an AWS client honouring the global role assumption flags
+func newAWSClient(cmd *cobra.Command, profile, region string) (*aws.AWSClient, error) {
+	roleARN, _ := cmd.Flags().GetString("role-arn")
+	externalID, _ := cmd.Flags().GetString("external-id")
+	mfaSerial, _ := cmd.Flags().GetString("mfa-serial")
+	sessionDuration, _ := cmd.Flags().GetDuration("session-duration")
+
+	return aws.NewAWSClientWithOptions(profile, region, aws.CredentialOptions{
+		RoleARN:         roleARN,
+		ExternalID:      externalID,
+		MFASerial:       mfaSerial,
+		SessionDuration: sessionDuration,
+	})
 }
 
 func main() {
@@ -66,7 +111,7 @@ func initCmd() *cobra.Command {
 			region, _ := cmd.Flags().GetString("region")
 
 			// Test AWS credentials
-			client, err := aws.NewAWSClient(profile, region)
+			client, err := newAWSClient(cmd, profile, region)
 			if err != nil {
 				return fmt.Errorf("failed to initialize AWS client: %w", err)
 			}
@@ -173,51 +218,104 @@ func listVPCsCmd() *cobra.Command {
 		Use:   "list-vpcs",
 		Short: "List available VPCs in AWS region",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
 			region, _ := cmd.Flags().GetString("region")
 			profile, _ := cmd.Flags().GetString("profile")
+			vpcFilter, _ := cmd.Flags().GetString("vpc")
 
 			fmt.Printf("🔍 Discovering VPCs in region %s (profile: %s)...\n\n", region, profile)
 
-			// Mock VPC data - in real implementation, this would use AWS SDK
-			vpcs := []struct {
-				ID            string
-				Name          string
-				CIDR          string
-				State         string
-				IsDefault     bool
-				Subnets       int
-				PublicSubnets int
-			}{
-				{"vpc-0123456789abcdef0", "main-vpc", "10.0.0.0/16", "available", false, 6, 3},
-				{"vpc-0987654321fedcba0", "research-vpc", "172.16.0.0/12", "available", false, 4, 2},
-				{"vpc-default123456789", "default", "172.31.0.0/16", "available", true, 3, 3},
+			awsClient, err := newAWSClient(cmd, profile, region)
+			if err != nil {
+				return fmt.Errorf("failed to initialize AWS client: %w", err)
+			}
+
+			vpcs, err := awsClient.ListVPCs(ctx)
+			if err != nil {
+				return err
+			}
+
+			if len(vpcs) == 0 && vpcFilter == "" {
+				fmt.Println("No VPCs found in the current region.")
+				fmt.Println("💡 Create one with: mole up --create-vpc")
+				return nil
 			}
 
 			fmt.Println("📋 Available VPCs:")
 			fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 
+			suggestedVPC := ""
+			matched := 0
 			for _, vpc := range vpcs {
+				if vpcFilter != "" && vpc.VpcId != vpcFilter {
+					continue
+				}
+				matched++
+
 				defaultMark := ""
 				if vpc.IsDefault {
 					defaultMark = " (default)"
 				}
+				name := vpc.Name
+				if name == "" {
+					name = "-"
+				}
 
-				fmt.Printf("🏢 %s%s\n", vpc.Name, defaultMark)
-				fmt.Printf("   ID: %s\n", vpc.ID)
-				fmt.Printf("   CIDR: %s\n", vpc.CIDR)
-				fmt.Printf("   Subnets: %d total (%d public)\n", vpc.Subnets, vpc.PublicSubnets)
+				fmt.Printf("🏢 %s%s\n", name, defaultMark)
+				fmt.Printf("   ID: %s\n", vpc.VpcId)
+				fmt.Printf("   CIDR: %s\n", vpc.CidrBlock)
 				fmt.Printf("   State: %s\n", vpc.State)
 
-				if vpc.PublicSubnets > 0 {
-					fmt.Printf("   ✅ Suitable for mole deployment\n")
+				subnets, err := awsClient.DescribeVPCSubnets(ctx, vpc.VpcId)
+				if err != nil {
+					fmt.Printf("   ⚠️  Warning: %v\n\n", err)
+					continue
+				}
+
+				details := aws.VPCDetails{VPCInfo: vpc, Subnets: subnets}
+				fmt.Printf("   Subnets: %d total (%d public)\n", len(subnets), len(details.PublicSubnets()))
+
+				if len(subnets) > 0 {
+					fmt.Printf("   %-26s %-18s %-12s %-8s %-9s %s\n", "Subnet", "CIDR", "AZ", "Type", "Free IPs", "Name")
+					for _, subnet := range subnets {
+						subnetType := "private"
+						if subnet.Public {
+							subnetType = "public"
+						}
+						subnetName := subnet.Name
+						if subnetName == "" {
+							subnetName = "-"
+						}
+						fmt.Printf("   %-26s %-18s %-12s %-8s %-9d %s\n",
+							subnet.SubnetId, subnet.CidrBlock, subnet.AvailabilityZone,
+							subnetType, subnet.AvailableIPs, subnetName)
+					}
+				}
+
+				if public, private, err := aws.SelectDeploymentSubnets(subnets, false); err == nil {
+					fmt.Printf("   ✅ Suitable for mole deployment (bastion subnet: %s, %d private subnet(s) to route)\n",
+						public.SubnetId, len(private))
+					if suggestedVPC == "" {
+						suggestedVPC = vpc.VpcId
+					}
+				} else if len(details.PublicSubnets()) > 0 {
+					fmt.Printf("   ⚠️  Public subnets do not auto-assign public IPs - pass --public-subnet explicitly\n")
 				} else {
 					fmt.Printf("   ⚠️  No public subnets - bastion needs public subnet\n")
 				}
 				fmt.Println()
 			}
 
+			if vpcFilter != "" && matched == 0 {
+				return fmt.Errorf("VPC %s not found in %s", vpcFilter, region)
+			}
+
 			fmt.Println("💡 Usage:")
-			fmt.Printf("   mole up --vpc vpc-0123456789abcdef0\n")
+			if suggestedVPC != "" {
+				fmt.Printf("   mole up --vpc %s\n", suggestedVPC)
+			} else {
+				fmt.Printf("   mole up --create-vpc\n")
+			}
 			fmt.Printf("   mole probe --region %s\n", region)
 
 			return nil
@@ -226,6 +324,7 @@ func listVPCsCmd() *cobra.Command {
 
 	cmd.Flags().String("region", "us-west-2", "AWS region to query")
 	cmd.Flags().String("profile", "default", "AWS profile to use")
+	cmd.Flags().String("vpc", "", "Only show the given VPC")
 
 	return cmd
 }
@@ -240,7 +339,8 @@ func upCmd() *cobra.Command {
 			// Get flags
 			vpcId, _ := cmd.Flags().GetString("vpc")
 			publicSubnetId, _ := cmd.Flags().GetString("public-subnet")
-			privateSubnetId, _ := cmd.Flags().GetString("private-subnet")
+			privateSubnetIds, _ := cmd.Flags().GetStringSlice("private-subnet")
+			routeCIDRs, _ := cmd.Flags().GetStringSlice("route-cidr")
 			createVPC, _ := cmd.Flags().GetBool("create-vpc")
 			vpcCidr, _ := cmd.Flags().GetString("vpc-cidr")
 			publicSubnetCidr, _ := cmd.Flags().GetString("public-subnet-cidr")
@@ -254,6 +354,64 @@ func upCmd() *cobra.Command {
 			deployTarget, _ := cmd.Flags().GetBool("deploy-target")
 			targetInstanceType, _ := cmd.Flags().GetString("target-instance-type")
 			force, _ := cmd.Flags().GetBool("force")
+			accessFlag, _ := cmd.Flags().GetString("access")
+			enableIPv6, _ := cmd.Flags().GetBool("ipv6")
+			ipv6Underlay, _ := cmd.Flags().GetBool("ipv6-underlay")
+			amiID, _ := cmd.Flags().GetString("ami")
+			amiFromSSM, _ := cmd.Flags().GetBool("ami-ssm")
+			stockAMI, _ := cmd.Flags().GetBool("stock-ami")
+			agentURL, _ := cmd.Flags().GetString("agent-url")
+			skipPreflight, _ := cmd.Flags().GetBool("skip-preflight")
+			presharedKeys, _ := cmd.Flags().GetBool("psk")
+			keyStoreBackend, _ := cmd.Flags().GetString("key-store")
+			userspaceMode, _ := cmd.Flags().GetBool("userspace")
+			listen, _ := cmd.Flags().GetString("listen")
+			autoscale, _ := cmd.Flags().GetBool("autoscale")
+			pinElephants, _ := cmd.Flags().GetBool("pin-elephants")
+
+			if userspaceMode && !userspace.Available {
+				return fmt.Errorf("--userspace is not available: this mole binary was built without the user-space network stack (rebuild with -tags netstack)")
+			}
+
+			tags, err := resourceTags(cmd)
+			if err != nil {
+				return err
+			}
+
+			cfg, err := config.LoadConfig("")
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			presharedKeys = presharedKeys || cfg.Tunnel.PresharedKeys
+			if keyStoreBackend == "" {
+				keyStoreBackend = cfg.Tunnel.KeyStore
+			}
+
+			// Unlock the key store before anything is created in AWS
+			keys, err := keystore.Open(keyStoreBackend)
+			if err != nil {
+				return err
+			}
+			if err := keys.Unlock(); err != nil {
+				return fmt.Errorf("failed to unlock key store: %w", err)
+			}
+
+			if tunnelCount < 1 || tunnelCount > aws.MaxTunnelCount {
+				return fmt.Errorf("--tunnels must be between 1 and %d", aws.MaxTunnelCount)
+			}
+			if (autoscale || pinElephants) && userspaceMode {
+				return fmt.Errorf("--autoscale and --pin-elephants need kernel tunnels and cannot be used with --userspace")
+			}
+
+			// An IPv6 underlay needs the bastion to have an IPv6 address
+			enableIPv6 = enableIPv6 || ipv6Underlay
+			vpcIPv6Cidr := ""
+			var vpcCidrs []string // Every IPv4 block of an existing VPC
+
+			accessMode, err := aws.ParseAccessMode(accessFlag)
+			if err != nil {
+				return err
+			}
 
 			fmt.Println("🚀 Deploying AWS Cloud Mole tunnel terminator...")
 
@@ -277,16 +435,110 @@ func upCmd() *cobra.Command {
 			if !createVPC && vpcId == "" {
 				return fmt.Errorf("must either specify --vpc or use --create-vpc")
 			}
-			if !createVPC && publicSubnetId == "" {
-				return fmt.Errorf("public subnet ID is required when using existing VPC. Use --public-subnet flag")
+			if err := aws.ValidateRoutedCIDRs(routeCIDRs); err != nil {
+				return err
 			}
+			allPrivate := len(privateSubnetIds) == 1 && strings.EqualFold(privateSubnetIds[0], aws.AllPrivateSubnets)
 
 			// Initialize AWS client
-			awsClient, err := aws.NewAWSClient(profile, region)
+			awsClient, err := newAWSClient(cmd, profile, region)
 			if err != nil {
 				return fmt.Errorf("failed to initialize AWS client: %w", err)
 			}
 
+			// Fail before creating anything if the caller lacks permissions
+			if !skipPreflight {
+				if err := runPermissionPreflight(ctx, awsClient, aws.PolicyFeatures{
+					CreateVPC:     createVPC,
+					NAT:           enableNAT,
+					TestTarget:    deployTarget,
+					AccessMode:    accessMode,
+					PresharedKeys: presharedKeys,
+				}); err != nil {
+					return err
+				}
+			}
+
+			// Analyse the existing VPC and fill in anything not given on the command line
+			if !createVPC {
+				vpcDetails, err := awsClient.DescribeVPC(ctx, vpcId)
+				if err != nil {
+					return err
+				}
+
+				if !cmd.Flags().Changed("vpc-cidr") {
+					vpcCidr = vpcDetails.CidrBlock
+				}
+				// Secondary blocks are VPC addresses too, so the tunnels must stay clear of them
+				vpcCidrs = vpcDetails.CidrBlocks
+				vpcIPv6Cidr = vpcDetails.Ipv6CidrBlock
+
+				if allPrivate {
+					privateSubnetIds = nil
+					for _, subnet := range vpcDetails.PrivateSubnets() {
+						privateSubnetIds = append(privateSubnetIds, subnet.SubnetId)
+					}
+					if len(privateSubnetIds) == 0 {
+						return fmt.Errorf("--private-subnet all: VPC %s has no private subnets", vpcId)
+					}
+				}
+
+				if publicSubnetId == "" {
+					public, private, err := aws.SelectDeploymentSubnets(vpcDetails.Subnets, enableIPv6)
+					if err != nil {
+						return fmt.Errorf("cannot pick a bastion subnet in %s: %w. Use --public-subnet flag (see 'mole list-vpcs --vpc %s')", vpcId, err, vpcId)
+					}
+					publicSubnetId = public.SubnetId
+					fmt.Printf("  ✓ Selected public subnet: %s (%s, %s, %d free IPs)\n",
+						public.SubnetId, public.CidrBlock, public.AvailabilityZone, public.AvailableIPs)
+
+					if len(privateSubnetIds) == 0 {
+						for _, subnet := range private {
+							privateSubnetIds = append(privateSubnetIds, subnet.SubnetId)
+							fmt.Printf("  ✓ Selected private subnet: %s (%s, %s)\n",
+								subnet.SubnetId, subnet.CidrBlock, subnet.AvailabilityZone)
+						}
+					}
+				} else if enableIPv6 {
+					if subnet, ok := vpcDetails.FindSubnet(publicSubnetId); ok && subnet.Ipv6CidrBlock == "" {
+						return fmt.Errorf("--ipv6 requires a dual-stack public subnet, but %s has no IPv6 block", publicSubnetId)
+					}
+				}
+			}
+
+			// Carve the tunnel networks out of tunnel.base_cidr before anything is created,
+			// clear of the VPC, routed networks and this host's routes
+			ipam, err := loadIPAM(cfg)
+			if err != nil {
+				return err
+			}
+			if err := ipam.ReserveLocalRoutes(tunnel.TunnelInterfaces(aws.MaxTunnelCount)); err != nil {
+				return fmt.Errorf("failed to check local routes: %w", err)
+			}
+			for _, reserved := range []struct {
+				reason string
+				cidrs  []string
+			}{{"VPC", append([]string{vpcCidr}, vpcCidrs...)}, {"route CIDR", routeCIDRs}} {
+				for _, cidr := range reserved.cidrs {
+					prefix, err := netip.ParsePrefix(cidr)
+					if err != nil {
+						return fmt.Errorf("invalid %s %q: %w", reserved.reason, cidr, err)
+					}
+					if err := ipam.Reserve(reserved.reason, prefix); err != nil {
+						return err
+					}
+				}
+			}
+			var tunnelNetworks []string
+			for id := 0; id < tunnelCount; id++ {
+				allocation, err := ipam.Allocate(id)
+				if err != nil {
+					return err
+				}
+				tunnelNetworks = append(tunnelNetworks, allocation.Network.String())
+			}
+			fmt.Printf("  ✓ Tunnel networks: %s\n", strings.Join(tunnelNetworks, ", "))
+
 			// Phase 1: Network Infrastructure Setup
 			if createVPC {
 				fmt.Println("🏗️  Creating VPC and subnet infrastructure...")
@@ -294,8 +546,10 @@ func upCmd() *cobra.Command {
 					VPCCidr:           vpcCidr,
 					PublicSubnetCidr:  publicSubnetCidr,
 					PrivateSubnetCidr: privateSubnetCidr,
-					Region:           region,
-					EnableNAT:        enableNAT,
+					Region:            region,
+					EnableNAT:         enableNAT,
+					EnableIPv6:        enableIPv6,
+					Tags:              tags,
 				})
 				if err != nil {
 					// Check if it's a VPC limit error and handle gracefully
@@ -311,19 +565,35 @@ func upCmd() *cobra.Command {
 				// Use the created network elements
 				vpcId = networkResult.VPCId
 				publicSubnetId = networkResult.PublicSubnetId
-				privateSubnetId = networkResult.PrivateSubnetId
+				privateSubnetIds = nil
+				if networkResult.PrivateSubnetId != "" {
+					privateSubnetIds = []string{networkResult.PrivateSubnetId}
+				}
+				vpcIPv6Cidr = networkResult.VPCIPv6Cidr
 
 				fmt.Printf("  ✅ VPC created: %s (%s)\n", vpcId, vpcCidr)
+				if vpcIPv6Cidr != "" {
+					fmt.Printf("  ✅ IPv6 block: %s\n", vpcIPv6Cidr)
+				}
 				fmt.Printf("  ✅ Public subnet: %s (%s)\n", publicSubnetId, publicSubnetCidr)
-				if privateSubnetId != "" {
-					fmt.Printf("  ✅ Private subnet: %s (%s)\n", privateSubnetId, privateSubnetCidr)
+				if len(privateSubnetIds) > 0 {
+					fmt.Printf("  ✅ Private subnet: %s (%s)\n", privateSubnetIds[0], privateSubnetCidr)
 				}
 			} else {
 				fmt.Printf("🔗 Using existing VPC: %s\n", vpcId)
-				if privateSubnetId != "" {
-					fmt.Printf("🔗 Providing NAT for private subnet: %s\n", privateSubnetId)
+				for _, subnetId := range privateSubnetIds {
+					fmt.Printf("🔗 Providing NAT for private subnet: %s\n", subnetId)
 				}
 			}
+			for _, cidr := range routeCIDRs {
+				fmt.Printf("🔗 Routing %s through the bastion\n", cidr)
+			}
+
+			// The first private subnet hosts the test target; the rest are routed only
+			privateSubnetId := ""
+			if len(privateSubnetIds) > 0 {
+				privateSubnetId = privateSubnetIds[0]
+			}
 
 			var optimalMTU int = 1500
 			var recommendedInstanceType string = instanceType
@@ -337,7 +607,7 @@ func upCmd() *cobra.Command {
 					fmt.Printf("⚠️  Network probing failed: %v, using defaults\n", err)
 				} else {
 					optimalMTU = results.OptimalMTU
-					tunnelCount = results.OptimalStreams
+					tunnelCount = min(max(results.OptimalStreams, 1), aws.MaxTunnelCount)
 					fmt.Printf("  ✓ Optimal MTU: %d bytes\n", optimalMTU)
 					fmt.Printf("  ✓ Recommended tunnels: %d\n", tunnelCount)
 				}
@@ -358,22 +628,45 @@ func upCmd() *cobra.Command {
 				return fmt.Errorf("--deploy-target requires a private subnet. Use --create-vpc or specify --private-subnet")
 			}
 
+			// Bastion kernel tuning comes from the optimization section of the config
+			tuning := aws.KernelTuningFromConfig(cfg.Optimization)
+			fmt.Printf("  ✓ Kernel tuning: %d sysctls, congestion control %s, queue spreading %v\n",
+				len(tuning.Sysctls), tuning.CongestionControl(), tuning.SpreadQueues)
+
 			// Create deployment configuration
 			deployConfig := &aws.DeploymentConfig{
-				VPCId:           vpcId,
-				PublicSubnetId:  publicSubnetId,
-				PrivateSubnetId: privateSubnetId,
-				VPCCidr:         vpcCidr, // Will be detected by instance if not set
-				InstanceType:    aws.InstanceTypeFromString(recommendedInstanceType),
-				TunnelCount:     tunnelCount,
-				MTUSize:         optimalMTU,
-				AllowedCIDR:     "0.0.0.0/0", // This should be more restrictive in production
-				SSHPublicKey:    "", // AWS will create the key pair
-				Profile:         profile,
-				Region:          region,
-				EnableNAT:       enableNAT,
-				DeployTarget:    deployTarget,
-				TargetInstance:  aws.InstanceTypeFromString(targetInstanceType),
+				VPCId:            vpcId,
+				PublicSubnetId:   publicSubnetId,
+				PrivateSubnetId:  privateSubnetId,
+				PrivateSubnetIds: privateSubnetIds,
+				RoutedCIDRs:      routeCIDRs,
+				VPCCidr:          vpcCidr, // Will be detected by instance if not set
+				InstanceType:     aws.InstanceTypeFromString(recommendedInstanceType),
+				TunnelCount:      tunnelCount,
+				MTUSize:          optimalMTU,
+				AllowedCIDR:      "0.0.0.0/0", // This should be more restrictive in production
+				SSHPublicKey:     "",          // AWS will create the key pair
+				Profile:          profile,
+				Region:           region,
+				EnableNAT:        enableNAT,
+				DeployTarget:     deployTarget,
+				TargetInstance:   aws.InstanceTypeFromString(targetInstanceType),
+				AccessMode:       accessMode,
+				EnableIPv6:       enableIPv6,
+				IPv6Underlay:     ipv6Underlay,
+				VPCIPv6Cidr:      vpcIPv6Cidr,
+				AMI:              aws.AMISelection{AMIId: amiID, UseSSM: amiFromSSM, StockOnly: stockAMI},
+				Tags:             tags,
+				AgentURL:         agentURL,
+				Tuning:           tuning,
+				PresharedKeys:    presharedKeys,
+				KeyStore:         keys,
+				Userspace:        userspaceMode,
+				TunnelNetworks:   tunnelNetworks,
+			}
+			if autoscale {
+				// The security group admits every tunnel the scaler may add
+				deployConfig.MaxTunnels = min(max(cfg.Tunnel.MaxTunnels, tunnelCount), aws.MaxTunnelCount)
 			}
 
 			// Deploy infrastructure
@@ -382,34 +675,68 @@ func upCmd() *cobra.Command {
 				return fmt.Errorf("AWS deployment failed: %w", err)
 			}
 
+			// Userspace mode: no interfaces or routes, the first tunnel runs in this process
+			if userspaceMode {
+				fmt.Println("\n🎉 Deployment completed successfully!")
+				fmt.Printf("  Instance: %s (%s)\n", result.BastionInstanceID, result.BastionPublicIP)
+				fmt.Printf("  Cost: $%.2f/month\n", result.CostEstimate.MonthlyCost)
+				fmt.Println("\n💡 Run 'mole proxy' later to reconnect without re-deploying")
+				tunnelConfig, err := loadUserspaceConfig(keys, result.BastionInstanceID, result.Tunnels[0].Interface)
+				if err != nil {
+					return err
+				}
+				return serveUserspaceProxy(tunnelConfig, listen, true)
+			}
+
 			// Phase 3: WireGuard Tunnel Setup
 			fmt.Printf("🔒 Setting up %d WireGuard tunnels...\n", tunnelCount)
 
-			tunnelManager := tunnel.NewTunnelManager(&tunnel.TunnelConfig{
-				MinTunnels: 1,
-				MaxTunnels: tunnelCount,
-				BaseCIDR:   "10.100.0.0/16",
-				MTU:        optimalMTU,
-				ListenPort: 51820,
-			})
+			tunnelConfig := &tunnel.TunnelConfig{
+				MinTunnels:      1,
+				MaxTunnels:      tunnelCount,
+				BaseCIDR:        ipam.Base.String(),
+				TunnelPrefixLen: ipam.PrefixLen,
+				IPAMState:       tunnel.IPAMPath(),
+				MTU:             optimalMTU,
+				ListenPort:      51820,
+				Backend:         cfg.Tunnel.Backend,
+				// The AWS-side networks, spread across every tunnel
+				Destinations: result.ClientAllowedIPs,
+			}
+			if enableIPv6 {
+				tunnelConfig.BaseIPv6CIDR = tunnel.DefaultIPv6CIDR
+			}
+			if autoscale {
+				tunnelConfig.MinTunnels = min(max(cfg.Tunnel.MinTunnels, 1), tunnelCount)
+				tunnelConfig.MaxTunnels = deployConfig.MaxTunnels
+			}
+			tunnelManager := tunnel.NewTunnelManager(tunnelConfig)
 
-			if err := tunnelManager.CreateTunnels(tunnelCount); err != nil {
-				return fmt.Errorf("failed to create tunnels: %w", err)
+			// The interfaces were brought up with the bastion's per-tunnel keys during deployment
+			for _, spec := range result.Tunnels {
+				if err := tunnelManager.AttachTunnel(spec.ID, spec.Interface, spec.ClientPublicKey); err != nil {
+					return fmt.Errorf("failed to register tunnel %d: %w", spec.ID, err)
+				}
 			}
 
 			// Phase 4: Routing Configuration
 			fmt.Println("🗺️  Configuring ECMP routing...")
-			if err := tunnelManager.ConfigureECMP(); err != nil {
+			if err := tunnelManager.ConfigureECMP(); errors.Is(err, tunnel.ErrNeedsPrivilege) && tunnelCount > 1 {
+				return fmt.Errorf("%w: the tunnels are up but no multipath routes were installed; rerun 'mole up' with sudo", err)
+			} else if err != nil {
 				fmt.Printf("⚠️  ECMP configuration failed: %v\n", err)
 			} else {
-				fmt.Printf("  ✓ Equal-cost multi-path routing enabled\n")
-				fmt.Printf("  ✓ Load balancing across %d tunnels\n", tunnelCount)
+				fmt.Printf("  ✓ Equal-cost multi-path routing enabled (L4 hash)\n")
+				for _, route := range tunnelManager.Routes() {
+					fmt.Printf("  ✓ %s across %d tunnel(s)\n", route.Destination, len(route.Nexthops))
+				}
 			}
 
 			// Display success summary
 			fmt.Println("\n🎉 Deployment completed successfully!")
 			fmt.Printf("  Instance: %s (%s)\n", result.BastionInstanceID, result.BastionPublicIP)
 			fmt.Printf("  Tunnels: %d WireGuard tunnels active\n", tunnelCount)
+			fmt.Printf("  Agent: %s (token in %s)\n", result.AgentEndpoint, agent.TokenPath(result.BastionInstanceID))
 			fmt.Printf("  Cost: $%.2f/month\n", result.CostEstimate.MonthlyCost)
 			fmt.Println("\n💡 Use 'mole status' to monitor tunnel performance")
 
@@ -423,20 +750,24 @@ func upCmd() *cobra.Command {
 			fmt.Printf("Aggregate bandwidth: %.1f Gbps\n", float64(tunnelCount)*1.5)
 			fmt.Printf("Use 'mole status' to monitor performance\n")
 
+			if autoscale || pinElephants {
+				return runTunnelControl(tunnelManager, tunnelConfig, result, cfg, slices.Concat([]string{vpcCidr}, vpcCidrs, routeCIDRs), autoscale, pinElephants)
+			}
 			return nil
 		},
 	}
 
 	// Network specification options (use existing)
 	cmd.Flags().String("vpc", "", "AWS VPC ID to use (optional)")
-	cmd.Flags().String("public-subnet", "", "AWS public subnet ID (optional)")
-	cmd.Flags().String("private-subnet", "", "AWS private subnet ID to provide NAT for (optional)")
+	cmd.Flags().String("public-subnet", "", "AWS public subnet ID for the bastion (auto-selected when omitted)")
+	cmd.Flags().StringSlice("private-subnet", nil, "AWS private subnet IDs to provide NAT for, or 'all' (auto-selected when omitted)")
+	cmd.Flags().StringSlice("route-cidr", nil, "Extra destination CIDRs reachable from the VPC (peered VPCs, Transit Gateway)")
 
 	// Network creation options (create new)
 	cmd.Flags().Bool("create-vpc", false, "Create new VPC with public/private subnets")
-	cmd.Flags().String("vpc-cidr", "10.100.0.0/16", "CIDR block for new VPC")
-	cmd.Flags().String("public-subnet-cidr", "10.100.1.0/24", "CIDR block for public subnet")
-	cmd.Flags().String("private-subnet-cidr", "10.100.2.0/24", "CIDR block for private subnet")
+	cmd.Flags().String("vpc-cidr", "10.200.0.0/16", "CIDR block for new VPC (must not overlap tunnel.base_cidr)")
+	cmd.Flags().String("public-subnet-cidr", "10.200.1.0/24", "CIDR block for public subnet")
+	cmd.Flags().String("private-subnet-cidr", "10.200.2.0/24", "CIDR block for private subnet")
 
 	// General options
 	cmd.Flags().String("region", "us-west-2", "AWS region")
@@ -448,10 +779,134 @@ func upCmd() *cobra.Command {
 	cmd.Flags().Bool("deploy-target", false, "Deploy test target instance in private subnet for connectivity testing")
 	cmd.Flags().String("target-instance-type", "t4g.nano", "Instance type for test target (default: t4g.nano)")
 	cmd.Flags().Bool("force", false, "Force deployment without security warnings")
+	cmd.Flags().String("access", "keypair", "Bastion management access: keypair, instance-connect (ephemeral SSH keys), or ssm (no SSH ingress)")
+	cmd.Flags().Bool("ipv6", false, "Enable IPv6 dual-stack (VPC IPv6 block, ULA tunnel addresses, IPv6 forwarding)")
+	cmd.Flags().Bool("ipv6-underlay", false, "Reach the bastion over IPv6 when the campus has native v6 (implies --ipv6)")
+	cmd.Flags().String("ami", "", "Pin the bastion to a specific AMI ID (must match the instance architecture)")
+	cmd.Flags().Bool("ami-ssm", false, "Resolve the latest Amazon Linux 2023 AMI from the public SSM parameter")
+	cmd.Flags().Bool("stock-ami", false, "Ignore pre-built mole images and install packages at boot")
+	cmd.Flags().String("agent-url", "", "Download URL for the bastion agent archive, with its checksums.txt alongside (${ARCH} expands to amd64/arm64; default: this release)")
+	cmd.Flags().Bool("skip-preflight", false, "Skip the IAM permission simulation before deploying")
+	cmd.Flags().Bool("psk", false, "Add per-tunnel WireGuard pre-shared keys, delivered to the bastion through SSM Parameter Store")
+	cmd.Flags().String("key-store", "", "Where client keys are encrypted at rest: auto, keyring or file (default: tunnel.key_store)")
+	cmd.Flags().StringArray("tag", nil, "Resource tag key=value (repeatable; adds to the config file's aws.tags)")
+	cmd.Flags().Bool("userspace", false, "Run the tunnel inside mole without root and serve a local SOCKS5/HTTP proxy (see 'mole proxy')")
+	cmd.Flags().String("listen", "127.0.0.1:1080", "Proxy listen address in --userspace mode")
+	cmd.Flags().Bool("autoscale", false, "Keep running after deployment and add or remove tunnels between tunnel.min_tunnels and tunnel.max_tunnels from utilisation")
+	cmd.Flags().Bool("pin-elephants", false, "Keep running after deployment and give elephant flows (scaling.elephant_flow_threshold) dedicated tunnels")
 
 	return cmd
 }
 
+// flowSampleInterval is how often --pin-elephants reads connection tracking
+const flowSampleInterval = 10 * time.Second
+
+// runTunnelControl keeps managing the deployed tunnels until interrupted: with autoscale it
+// adds and removes tunnels from their utilisation, creating the bastion end through the
+// agent; with pinElephants it gives elephant flows tunnels of their own.
+func runTunnelControl(tunnelManager *tunnel.TunnelManager, tunnelConfig *tunnel.TunnelConfig, result *aws.DeploymentResult, cfg *config.Config, reserved []string, autoscale, pinElephants bool) error {
+	var scaler *tunnel.TunnelScaler
+	if autoscale {
+		token, err := agent.LoadToken(result.BastionInstanceID)
+		if err != nil {
+			return fmt.Errorf("failed to load agent token for %s: %w", result.BastionInstanceID, err)
+		}
+		if err := tunnelManager.ReserveNetworks("VPC or route CIDR", reserved...); err != nil {
+			return err
+		}
+		if err := tunnelManager.ReserveLocalRoutes(); err != nil {
+			return fmt.Errorf("failed to check local routes: %w", err)
+		}
+
+		host := result.BastionPublicIP
+		if result.IPv6Underlay {
+			host = result.BastionPublicIPv6
+		}
+		tunnelManager.SetProvisioner(&agent.TunnelProvisioner{
+			Client: agent.NewClient(result.AgentEndpoint, token),
+			Host:   host,
+		})
+
+		scaler = tunnel.NewTunnelScaler(tunnelManager, cfg.Scaling)
+		fmt.Printf("\n⚖️  Autoscaling between %d and %d tunnels (up above %.0f%%, down below %.0f%%)\n",
+			tunnelConfig.MinTunnels, tunnelConfig.MaxTunnels, cfg.Scaling.ScaleUpThreshold*100, cfg.Scaling.ScaleDownThreshold*100)
+	}
+
+	var tracker *monitoring.FlowTracker
+	if pinElephants {
+		tracker = monitoring.NewFlowTracker(cfg.Scaling.ElephantFlowThreshold, cfg.Scaling.BurstDetectionWindow)
+		for _, cidr := range result.ClientAllowedIPs {
+			if prefix, err := netip.ParsePrefix(cidr); err == nil {
+				tracker.Destinations = append(tracker.Destinations, prefix)
+			}
+		}
+		if _, err := tracker.Sample(); err != nil {
+			return fmt.Errorf("cannot track flows: %w", err)
+		}
+		fmt.Printf("\n🐘 Pinning flows over %.0f MB within %s to dedicated tunnels\n",
+			float64(cfg.Scaling.ElephantFlowThreshold)/1024/1024, cfg.Scaling.BurstDetectionWindow)
+	}
+	fmt.Println("Press Ctrl+C to stop")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var wg sync.WaitGroup
+	if scaler != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scaler.Run(ctx, func(decision tunnel.ScalingDecision) {
+				icon := "⚖️ "
+				switch {
+				case decision.Err != nil:
+					icon = "❌"
+				case decision.Action == tunnel.ScaleUp:
+					icon = "⬆️ "
+				case decision.Action == tunnel.ScaleDown:
+					icon = "⬇️ "
+				}
+				fmt.Printf("%s %s %s\n", decision.Time.Format("15:04:05"), icon, decision)
+			})
+		}()
+	}
+	if tracker != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(flowSampleInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+				flows, err := tracker.Sample()
+				if err != nil {
+					fmt.Printf("⚠️  Flow tracking failed: %v\n", err)
+					continue
+				}
+				pinned, unpinned, err := tunnelManager.PinElephants(flows)
+				now := time.Now().Format("15:04:05")
+				for _, pin := range pinned {
+					fmt.Printf("%s 🐘 Pinned %s to %s\n", now, pin.Flow, pin.Interface)
+				}
+				for _, pin := range unpinned {
+					fmt.Printf("%s 🐘 Released %s after the flow ended\n", now, pin.Interface)
+				}
+				if err != nil {
+					fmt.Printf("%s ⚠️  %v\n", now, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	fmt.Println("\n👋 Stopped; the current tunnels and pinned flows stay as they are until 'mole down'")
+	return nil
+}
+
 func multiUpCmd() *cobra.Command {
 	return &cobra.Command{
 		Use:   "multi-up",
@@ -517,6 +972,8 @@ func statusCmd() *cobra.Command {
 			fmt.Printf("  Daily: $2.07\n")
 			fmt.Printf("  Monthly: $62.98\n")
 
+			printBastionTuning(context.Background())
+
 			fmt.Println("\nUse 'mole monitor' for real-time performance tracking")
 
 			return nil
@@ -534,18 +991,46 @@ Provides htop-style system monitoring with:
 - CPU and memory usage
 - Network I/O statistics
 - Active tunnel metrics
-- Performance data collection`,
+- Performance data collection
+- Busiest connections and elephant flows (--flows)`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := context.Background()
 
 			dashboard, _ := cmd.Flags().GetBool("dashboard")
 			once, _ := cmd.Flags().GetBool("once")
 			interval, _ := cmd.Flags().GetInt("interval")
+			showFlows, _ := cmd.Flags().GetBool("flows")
 
 			// Initialize monitoring
 			monitor := monitoring.NewMonitor(time.Duration(interval) * time.Second)
 
-			if once {
+			// Elephant flows are measured between samples, so take a baseline first
+			var tracker *monitoring.FlowTracker
+			var window time.Duration
+			if showFlows {
+				cfg, err := config.LoadConfig("")
+				if err != nil {
+					return fmt.Errorf("failed to load config: %w", err)
+				}
+				window = cfg.Scaling.BurstDetectionWindow
+				tracker = monitoring.NewFlowTracker(cfg.Scaling.ElephantFlowThreshold, window)
+				if _, err := tracker.Sample(); err != nil {
+					return fmt.Errorf("cannot track flows: %w", err)
+				}
+			}
+			printFlows := func() {
+				if tracker == nil {
+					return
+				}
+				flows, err := tracker.Sample()
+				if err != nil {
+					fmt.Printf("Error tracking flows: %v\n", err)
+					return
+				}
+				monitoring.PrintFlows(flows, window, 15)
+			}
+
+			if once && tracker == nil {
 				// Show metrics once and exit
 				return monitor.PrintSystemStatus()
 			}
@@ -568,244 +1053,1781 @@ Provides htop-style system monitoring with:
 					if err := monitor.PrintSystemStatus(); err != nil {
 						fmt.Printf("Error collecting metrics: %v\n", err)
 					}
+					printFlows()
 
 					time.Sleep(time.Duration(interval) * time.Second)
 				}
 			}
 
 			// Default behavior - show current status once
-			return monitor.PrintSystemStatus()
+			if err := monitor.PrintSystemStatus(); err != nil {
+				return err
+			}
+			if tracker != nil {
+				time.Sleep(time.Duration(interval) * time.Second)
+				printFlows()
+			}
+			return nil
 		},
 	}
 
 	cmd.Flags().Bool("dashboard", false, "Show interactive htop-style dashboard")
 	cmd.Flags().Bool("once", false, "Show metrics once and exit")
 	cmd.Flags().Int("interval", 5, "Update interval in seconds for dashboard mode")
+	cmd.Flags().Bool("flows", false, "Show the busiest connections and elephant flows (Linux conntrack with accounting)")
+
+	return cmd
+}
+
+func scaleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "scale",
+		Short: "Add or drain tunnels on the running deployment",
+		Long: `Bring the running deployment to --tunnels tunnels.
+
+New tunnels take the lowest free IDs, so wgN keeps its port and network across
+scaling. Each gets a bastion peer through the agent and its WireGuard port in the
+bastion's security group before it joins the ECMP routes.
+
+Tunnels are removed from the highest ID down. A tunnel first leaves the ECMP
+routes, so new connections take the others, while its open connections carry on
+over it; it is torn down once they have finished (read from Linux conntrack) or
+after --drain-timeout, whichever comes first. --drain-timeout 0 removes tunnels
+without waiting.
+
+Do not run it while 'mole up --autoscale' is managing the same tunnels.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tunnelCount, _ := cmd.Flags().GetInt("tunnels")
+			instanceID, _ := cmd.Flags().GetString("instance-id")
+			drainTimeout, _ := cmd.Flags().GetDuration("drain-timeout")
+			profile, _ := cmd.Flags().GetString("profile")
+			region, _ := cmd.Flags().GetString("region")
+
+			if tunnelCount < 1 || tunnelCount > aws.MaxTunnelCount {
+				return fmt.Errorf("--tunnels must be between 1 and %d", aws.MaxTunnelCount)
+			}
+
+			instanceID, agentClient, err := bastionAgentClient(instanceID)
+			if err != nil {
+				return err
+			}
+			cfg, err := config.LoadConfig("")
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			awsClient, err := newAWSClient(cmd, profile, region)
+			if err != nil {
+				return fmt.Errorf("failed to initialize AWS client: %w", err)
+			}
+			target, err := awsClient.FindBastion(ctx, instanceID)
+			if err != nil {
+				return err
+			}
+
+			tunnelManager, count, err := attachDeployedTunnels(ctx, agentClient, cfg)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("⚖️  Scaling %s from %d to %d tunnels...\n", instanceID, count, tunnelCount)
+			if count == tunnelCount {
+				fmt.Println("✅ Nothing to do")
+				return nil
+			}
+
+			if err := tunnelManager.ConfigureECMP(); errors.Is(err, tunnel.ErrNeedsPrivilege) {
+				return fmt.Errorf("%w: rerun 'mole scale' with sudo", err)
+			} else if err != nil {
+				fmt.Printf("⚠️  ECMP configuration failed: %v\n", err)
+			}
+
+			for count < tunnelCount && ctx.Err() == nil {
+				id := tunnelManager.NextTunnelID()
+				if err := awsClient.OpenTunnelPort(ctx, target, id); err != nil {
+					return err
+				}
+				if err := tunnelManager.AddTunnel(); err != nil {
+					if closeErr := awsClient.CloseTunnelPort(ctx, target, id); closeErr != nil {
+						fmt.Printf("⚠️  %v\n", closeErr)
+					}
+					return fmt.Errorf("failed to add tunnel %d: %w", id, err)
+				}
+				fmt.Printf("  ⬆️  Added wg%d\n", id)
+				count++
+			}
+
+			connections := monitoring.CountConnections
+			if drainTimeout <= 0 {
+				connections = func([]netip.Addr) (int, error) { return 0, nil }
+			}
+			for count > tunnelCount && ctx.Err() == nil {
+				if drainTimeout > 0 {
+					fmt.Printf("  ⏳ Draining a tunnel (up to %s)...\n", drainTimeout)
+				}
+				drainCtx, cancel := context.WithTimeout(ctx, drainTimeout)
+				drain, err := tunnelManager.DrainTunnel(drainCtx, connections, tunnel.DrainPollInterval)
+				cancel()
+				if err != nil {
+					return fmt.Errorf("failed to drain a tunnel: %w", err)
+				}
+				if drain.Remaining > 0 {
+					fmt.Printf("  ⚠️  Removed %s with %d connection(s) still open\n", drain.Interface, drain.Remaining)
+				} else {
+					fmt.Printf("  ⬇️  Removed %s\n", drain.Interface)
+				}
+				if err := awsClient.CloseTunnelPort(ctx, target, drain.ID); err != nil {
+					fmt.Printf("⚠️  %v\n", err)
+				}
+				count--
+			}
+
+			if ctx.Err() != nil {
+				return fmt.Errorf("interrupted at %d tunnels", count)
+			}
+			for _, route := range tunnelManager.Routes() {
+				fmt.Printf("  ✓ %s across %d tunnel(s)\n", route.Destination, len(route.Nexthops))
+			}
+			fmt.Printf("✅ %d tunnels active\n", count)
+			return nil
+		},
+	}
+	cmd.Flags().Int("tunnels", 4, "Target tunnel count")
+	cmd.Flags().String("instance-id", "", "Bastion instance ID (default: the only bastion with a saved agent token)")
+	cmd.Flags().Duration("drain-timeout", tunnel.DefaultDrainTimeout, "How long a removed tunnel's connections may take to finish (0: do not wait)")
+	cmd.Flags().String("profile", "default", "AWS profile to use")
+	cmd.Flags().String("region", "us-west-2", "AWS region")
+	return cmd
+}
+
+// attachDeployedTunnels builds a tunnel manager over the tunnels running between this host
+// and the bastion, with the agent creating the bastion end of new ones. It returns the
+// manager and the number of tunnels attached.
+func attachDeployedTunnels(ctx context.Context, agentClient *agent.Client, cfg *config.Config) (*tunnel.TunnelManager, int, error) {
+	interfaces, err := agentClient.Interfaces(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query bastion agent (is the tunnel up?): %w", err)
+	}
+
+	type deployed struct {
+		id        int
+		iface     string
+		publicKey string
+	}
+	var tunnels []deployed
+	var clientConfig *peer.ClientConfig
+	for _, stats := range interfaces {
+		var id int
+		if _, err := fmt.Sscanf(stats.Name, "wg%d", &id); err != nil {
+			continue
+		}
+		found, _, err := peer.FindClientConfig(stats.Name)
+		if err != nil {
+			return nil, 0, err
+		}
+		if clientConfig == nil || id == 0 {
+			clientConfig = found
+		}
+		entry := deployed{id: id, iface: stats.Name}
+		if len(stats.Peers) > 0 {
+			entry.publicKey = stats.Peers[0].PublicKey
+		}
+		tunnels = append(tunnels, entry)
+	}
+	if len(tunnels) == 0 {
+		return nil, 0, fmt.Errorf("the bastion has no tunnels")
+	}
+
+	host, _, err := net.SplitHostPort(clientConfig.Endpoint)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid bastion endpoint %q: %w", clientConfig.Endpoint, err)
+	}
+	ipam, err := loadIPAM(cfg)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	tunnelConfig := &tunnel.TunnelConfig{
+		MinTunnels:      1,
+		MaxTunnels:      aws.MaxTunnelCount,
+		BaseCIDR:        ipam.Base.String(),
+		TunnelPrefixLen: ipam.PrefixLen,
+		IPAMState:       tunnel.IPAMPath(),
+		MTU:             1420,
+		ListenPort:      51820,
+		Backend:         cfg.Tunnel.Backend,
+	}
+	if mtu, err := strconv.Atoi(clientConfig.MTU); err == nil {
+		tunnelConfig.MTU = mtu
+	}
+	// The client's AllowedIPs are the AWS-side networks plus the tunnel's own
+	var ownNetworks []string
+	for _, address := range clientConfig.Address {
+		ownNetworks = append(ownNetworks, address.Masked().String())
+		if address.Addr().Is6() {
+			tunnelConfig.BaseIPv6CIDR = tunnel.DefaultIPv6CIDR
+		}
+	}
+	for _, allowed := range clientConfig.AllowedIPs {
+		if !slices.Contains(ownNetworks, allowed) {
+			tunnelConfig.Destinations = append(tunnelConfig.Destinations, allowed)
+		}
+	}
+
+	tunnelManager := tunnel.NewTunnelManager(tunnelConfig)
+	for _, spec := range tunnels {
+		if err := tunnelManager.AttachTunnel(spec.id, spec.iface, spec.publicKey); err != nil {
+			return nil, 0, fmt.Errorf("failed to register tunnel %d: %w", spec.id, err)
+		}
+	}
+	// New tunnel networks stay clear of the AWS side and of this host's other routes
+	if err := tunnelManager.ReserveNetworks("AWS destination", tunnelConfig.Destinations...); err != nil {
+		return nil, 0, err
+	}
+	if err := tunnelManager.ReserveLocalRoutes(); err != nil {
+		return nil, 0, fmt.Errorf("failed to check local routes: %w", err)
+	}
+	tunnelManager.SetProvisioner(&agent.TunnelProvisioner{Client: agentClient, Host: host})
+	return tunnelManager, len(tunnels), nil
+}
+
+func optimizeCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "optimize",
+		Short: "Apply performance recommendations",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fmt.Println("🎯 Applying performance optimizations...")
+			fmt.Println("🔧 Optimization features include:")
+			fmt.Println("   • MTU optimization")
+			fmt.Println("   • TCP congestion control tuning")
+			fmt.Println("   • Process pinning")
+			fmt.Println("   • Buffer size adjustments")
+			fmt.Println("⚠️  Performance optimization is planned for Phase 2")
+			fmt.Println("💡 Use 'mole probe' to see current recommendations")
+			return nil
+		},
+	}
+}
+
+func iamPolicyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "iam-policy",
+		Short: "Print the least-privilege IAM policy for the selected features",
+		Long: `Print an IAM policy covering exactly the API calls mole makes for the
+selected features, suitable for 'aws iam create-policy --policy-document'.
+
+Use --check to simulate the policy's actions against your current identity.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			createVPC, _ := cmd.Flags().GetBool("create-vpc")
+			enableNAT, _ := cmd.Flags().GetBool("enable-nat")
+			deployTarget, _ := cmd.Flags().GetBool("deploy-target")
+			elasticIP, _ := cmd.Flags().GetBool("eip")
+			imageBuild, _ := cmd.Flags().GetBool("image-build")
+			presharedKeys, _ := cmd.Flags().GetBool("psk")
+			accessFlag, _ := cmd.Flags().GetString("access")
+			check, _ := cmd.Flags().GetBool("check")
+
+			accessMode, err := aws.ParseAccessMode(accessFlag)
+			if err != nil {
+				return err
+			}
+
+			features := aws.PolicyFeatures{
+				CreateVPC:     createVPC,
+				NAT:           enableNAT,
+				TestTarget:    deployTarget,
+				ElasticIP:     elasticIP,
+				ImageBuild:    imageBuild,
+				AccessMode:    accessMode,
+				PresharedKeys: presharedKeys,
+			}
+
+			if check {
+				profile, _ := cmd.Flags().GetString("profile")
+				region, _ := cmd.Flags().GetString("region")
+
+				awsClient, err := newAWSClient(cmd, profile, region)
+				if err != nil {
+					return fmt.Errorf("failed to initialize AWS client: %w", err)
+				}
+				return runPermissionPreflight(context.Background(), awsClient, features)
+			}
+
+			policy, err := aws.OperatorPolicy(features).JSON()
+			if err != nil {
+				return err
+			}
+			fmt.Println(policy)
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("create-vpc", false, "Include permissions to create a new VPC")
+	cmd.Flags().Bool("enable-nat", true, "Include permissions to route private subnets through the bastion")
+	cmd.Flags().Bool("deploy-target", false, "Include permissions for the test target instance")
+	cmd.Flags().Bool("eip", false, "Include permissions for an Elastic IP on the bastion")
+	cmd.Flags().Bool("image-build", false, "Include permissions for 'mole image build'")
+	cmd.Flags().Bool("psk", false, "Include permissions for 'mole up --psk'")
+	cmd.Flags().String("access", "keypair", "Bastion access mode: keypair, instance-connect or ssm")
+	cmd.Flags().Bool("check", false, "Simulate the policy against the current identity instead of printing it")
+	cmd.Flags().String("profile", "default", "AWS profile to use")
+	cmd.Flags().String("region", "us-west-2", "AWS region")
+
+	return cmd
+}
+
+// runPermissionPreflight simulates the required actions and fails when any are denied.
+// Identities that may not call the simulator, and actions that hinge on conditions it
+// cannot evaluate, get a warning rather than a hard failure.
+func runPermissionPreflight(ctx context.Context, awsClient *aws.AWSClient, features aws.PolicyFeatures) error {
+	fmt.Println("🔐 Checking IAM permissions...")
+	result, err := awsClient.CheckPermissions(ctx, features)
+	if err != nil {
+		fmt.Printf("  ⚠️  Warning: could not simulate permissions: %v\n", err)
+		fmt.Println("  💡 Run 'mole iam-policy' to see the permissions mole needs, including iam:SimulatePrincipalPolicy for this check")
+		return nil
+	}
+
+	if !result.Allowed() {
+		fmt.Printf("  ❌ %s is missing %d of %d required permissions:\n", result.PrincipalARN, len(result.Denied), result.Checked)
+		for _, action := range result.Denied {
+			fmt.Printf("    • %s\n", action)
+		}
+		fmt.Println("  💡 Run 'mole iam-policy' with the same flags and ask your cloud team to grant it")
+		return fmt.Errorf("missing IAM permissions for deployment")
+	}
+
+	if len(result.Inconclusive) > 0 {
+		fmt.Printf("  ⚠️  Could not confirm %d permission(s); they depend on conditions the simulator was not given:\n", len(result.Inconclusive))
+		for _, action := range result.Inconclusive {
+			fmt.Printf("    • %s\n", action)
+		}
+		fmt.Printf("  ✓ The other %d required permissions allowed for %s\n", result.Checked-len(result.Inconclusive), result.PrincipalARN)
+		return nil
+	}
+
+	fmt.Printf("  ✓ All %d required permissions allowed for %s\n", result.Checked, result.PrincipalARN)
+	return nil
+}
+
+func exportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export infrastructure as code templates",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format, _ := cmd.Flags().GetString("format")
+			output, _ := cmd.Flags().GetString("output")
+			vpcId, _ := cmd.Flags().GetString("vpc")
+			subnetId, _ := cmd.Flags().GetString("subnet")
+			tunnels, _ := cmd.Flags().GetInt("tunnels")
+
+			if vpcId == "" || subnetId == "" {
+				return fmt.Errorf("VPC ID and Subnet ID are required")
+			}
+
+			fmt.Printf("📤 Exporting %s template for infrastructure...\n", format)
+
+			tags, err := resourceTags(cmd)
+			if err != nil {
+				return err
+			}
+
+			cfg, err := config.LoadConfig("")
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+
+			deployConfig := &aws.DeploymentConfig{
+				VPCId:          vpcId,
+				PublicSubnetId: subnetId,
+				InstanceType:   aws.InstanceTypeFromString("c6gn.medium"),
+				TunnelCount:    tunnels,
+				MTUSize:        1420,
+				AllowedCIDR:    "0.0.0.0/0",
+				SSHPublicKey:   "ssh-rsa AAAAB3NzaC1yc2E... your-public-key-here",
+				Profile:        "default",
+				Region:         "us-west-2",
+				Tags:           tags,
+				Tuning:         aws.KernelTuningFromConfig(cfg.Optimization),
+			}
+
+			// Template generation needs no AWS credentials
+			var exporter aws.AWSClient
+			var template string
+			var filename string
+
+			switch format {
+			case "terraform", "tf":
+				template, err = exporter.ExportTerraform(deployConfig)
+				filename = "mole-infrastructure.tf"
+			case "cloudformation", "cf":
+				template, err = exporter.ExportCloudFormation(deployConfig)
+				filename = "mole-infrastructure.yaml"
+			case "pulumi":
+				template, err = exporter.ExportPulumi(deployConfig)
+				filename = "main.go"
+			default:
+				return fmt.Errorf("unsupported format: %s (supported: terraform, cloudformation, pulumi)", format)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to export %s template: %w", format, err)
+			}
+
+			if output != "" {
+				filename = output
+			}
+
+			// Write to file or stdout
+			if output == "-" {
+				fmt.Print(template)
+			} else {
+				if err := writeToFile(filename, template); err != nil {
+					return fmt.Errorf("failed to write template: %w", err)
+				}
+				fmt.Printf("✅ %s template exported to %s\n", format, filename)
+				fmt.Printf("💡 Edit the template and deploy with your preferred tool:\n")
+
+				switch format {
+				case "terraform", "tf":
+					fmt.Printf("   terraform init && terraform plan && terraform apply\n")
+				case "cloudformation", "cf":
+					fmt.Printf("   aws cloudformation deploy --template-file %s --stack-name mole-stack\n", filename)
+				case "pulumi":
+					fmt.Printf("   pulumi up\n")
+				}
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().String("format", "terraform", "Export format (terraform, cloudformation, pulumi)")
+	cmd.Flags().String("output", "", "Output file (default: auto-generated name, use '-' for stdout)")
+	cmd.Flags().String("vpc", "", "VPC ID (required)")
+	cmd.Flags().String("subnet", "", "Public subnet ID (required)")
+	cmd.Flags().Int("tunnels", 3, "Number of tunnels")
+	cmd.Flags().StringArray("tag", nil, "Resource tag key=value (repeatable; adds to the config file's aws.tags)")
+
+	return cmd
+}
+
+func createProfileCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "create-profile [name]",
+		Short: "Create a saved tunnel profile",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			profileName := args[0]
+			fmt.Printf("💾 Creating profile: %s\n", profileName)
+			fmt.Println("📋 Profile features include:")
+			fmt.Println("   • Saved tunnel configurations")
+			fmt.Println("   • AWS connection details")
+			fmt.Println("   • Performance settings")
+			fmt.Println("⚠️  Profile management is planned for Phase 2")
+			return nil
+		},
+	}
+}
+
+func connectCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "connect [profile]",
+		Short: "Connect using saved profile",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			profileName := args[0]
+			fmt.Printf("🔗 Connecting using profile: %s\n", profileName)
+			fmt.Println("⚠️  Profile connection is planned for Phase 2")
+			fmt.Println("💡 Use 'mole up' with appropriate flags for now")
+			return nil
+		},
+	}
+}
+
+func sshCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ssh [-- ssh-args...]",
+		Short: "SSH to the bastion with an ephemeral EC2 Instance Connect key",
+		Long: `Open an SSH session to the bastion without a long-lived key pair.
+
+A one-time ed25519 key is generated locally, pushed to the bastion through
+EC2 Instance Connect (valid for 60 seconds) and removed when the session ends.
+Use --ssm to fall back to a Session Manager shell when SSH ingress is closed.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			profile, _ := cmd.Flags().GetString("profile")
+			region, _ := cmd.Flags().GetString("region")
+			instanceID, _ := cmd.Flags().GetString("instance-id")
+			osUser, _ := cmd.Flags().GetString("user")
+			useSSM, _ := cmd.Flags().GetBool("ssm")
+
+			awsClient, err := newAWSClient(cmd, profile, region)
+			if err != nil {
+				return fmt.Errorf("failed to initialize AWS client: %w", err)
+			}
+
+			target, err := awsClient.FindBastion(ctx, instanceID)
+			if err != nil {
+				return err
+			}
+
+			if useSSM {
+				return runSSMSession(awsClient, target)
+			}
+
+			if target.PublicIP == "" {
+				return fmt.Errorf("bastion %s has no public IP; use 'mole shell' for a Session Manager session", target.InstanceID)
+			}
+
+			fmt.Printf("🔑 Pushing ephemeral key to %s via EC2 Instance Connect...\n", target.InstanceID)
+			keyFile, cleanup, err := awsClient.PushEphemeralSSHKey(ctx, target, osUser)
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+
+			if osUser == "" {
+				osUser = "ec2-user"
+			}
+
+			sshArgs := []string{
+				"-i", keyFile,
+				"-o", "IdentitiesOnly=yes",
+				"-o", "StrictHostKeyChecking=accept-new",
+				fmt.Sprintf("%s@%s", osUser, target.PublicIP),
+			}
+			sshArgs = append(sshArgs, args...)
+
+			fmt.Printf("🔗 Connecting to %s@%s...\n", osUser, target.PublicIP)
+			sshProcess := exec.Command("ssh", sshArgs...)
+			sshProcess.Stdin = os.Stdin
+			sshProcess.Stdout = os.Stdout
+			sshProcess.Stderr = os.Stderr
+			return sshProcess.Run()
+		},
+	}
+
+	cmd.Flags().String("profile", "default", "AWS profile to use")
+	cmd.Flags().String("region", "us-west-2", "AWS region")
+	cmd.Flags().String("instance-id", "", "Bastion instance ID (default: most recent mole bastion)")
+	cmd.Flags().String("user", "ec2-user", "OS user on the bastion")
+	cmd.Flags().Bool("ssm", false, "Use a Session Manager session instead of SSH")
+
+	return cmd
+}
+
+func imageCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "image",
+		Short: "Build and list pre-built bastion AMIs",
+	}
+
+	cmd.AddCommand(imageBuildCmd())
+	cmd.AddCommand(imageListCmd())
+
+	return cmd
+}
+
+func imageBuildCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "build",
+		Short: "Build a versioned bastion AMI with WireGuard and tuning pre-installed",
+		Long: `Launch a temporary builder instance, install WireGuard tools, firewall
+tooling and network sysctls, and snapshot it into a private AMI tagged for mole.
+
+'mole up' prefers the newest such image for the bastion architecture, so boot
+no longer waits on package mirrors. Use 'mole up --stock-ami' to bypass it.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			profile, _ := cmd.Flags().GetString("profile")
+			region, _ := cmd.Flags().GetString("region")
+			instanceType, _ := cmd.Flags().GetString("instance-type")
+			subnetID, _ := cmd.Flags().GetString("subnet")
+			version, _ := cmd.Flags().GetString("version")
+			amiFromSSM, _ := cmd.Flags().GetBool("ami-ssm")
+
+			tags, err := resourceTags(cmd)
+			if err != nil {
+				return err
+			}
+
+			awsClient, err := newAWSClient(cmd, profile, region)
+			if err != nil {
+				return fmt.Errorf("failed to initialize AWS client: %w", err)
+			}
+
+			fmt.Printf("🏗️  Building mole bastion image in %s...\n", region)
+			result, err := awsClient.BuildBastionImage(ctx, &aws.ImageBuildConfig{
+				InstanceType: aws.InstanceTypeFromString(instanceType),
+				SubnetId:     subnetID,
+				Version:      version,
+				UseSSM:       amiFromSSM,
+				Tags:         tags,
+			})
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("✅ Image %s ready\n", result.ImageId)
+			fmt.Printf("   Name: %s\n", result.Name)
+			fmt.Printf("   Architecture: %s\n", result.Architecture)
+			fmt.Printf("   Base image: %s\n", result.BaseImageId)
+			fmt.Printf("💡 'mole up' will use it for %s bastions in %s\n", result.Architecture, region)
+			return nil
+		},
+	}
+
+	cmd.Flags().String("profile", "default", "AWS profile to use")
+	cmd.Flags().String("region", "us-west-2", "AWS region")
+	cmd.Flags().String("instance-type", "t4g.small", "Builder instance type (determines image architecture)")
+	cmd.Flags().String("subnet", "", "Subnet with internet access for the builder (default VPC when empty)")
+	cmd.Flags().String("version", "", "Image version (default: UTC timestamp)")
+	cmd.Flags().Bool("ami-ssm", false, "Resolve the base Amazon Linux 2023 AMI from the public SSM parameter")
+	cmd.Flags().StringArray("tag", nil, "Resource tag key=value (repeatable; adds to the config file's aws.tags)")
+
+	return cmd
+}
+
+func imageListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List pre-built bastion AMIs in this account",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			profile, _ := cmd.Flags().GetString("profile")
+			region, _ := cmd.Flags().GetString("region")
+			arch, _ := cmd.Flags().GetString("arch")
+
+			awsClient, err := newAWSClient(cmd, profile, region)
+			if err != nil {
+				return fmt.Errorf("failed to initialize AWS client: %w", err)
+			}
+
+			images, err := awsClient.ListMoleImages(ctx, arch)
+			if err != nil {
+				return err
+			}
+
+			if len(images) == 0 {
+				fmt.Printf("No mole images in %s; run 'mole image build' to create one\n", region)
+				return nil
+			}
+
+			fmt.Printf("🖼️  Mole images in %s (newest first):\n", region)
+			fmt.Printf("%-22s %-8s %-18s %s\n", "Image", "Arch", "Version", "Created")
+			for _, image := range images {
+				fmt.Printf("%-22s %-8s %-18s %s\n", image.ImageId, image.Architecture, image.Version, image.CreationDate)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("profile", "default", "AWS profile to use")
+	cmd.Flags().String("region", "us-west-2", "AWS region")
+	cmd.Flags().String("arch", "", "Filter by architecture (arm64 or x86_64)")
+
+	return cmd
+}
+
+func shellCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "shell",
+		Short: "Open a Session Manager shell on the bastion (no SSH required)",
+		Long: `Open an interactive shell on the bastion through AWS Systems Manager.
+
+Requires the bastion to be deployed with --access ssm (or otherwise have the
+AmazonSSMManagedInstanceCore policy) and the Session Manager plugin for the
+AWS CLI installed locally.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+
+			profile, _ := cmd.Flags().GetString("profile")
+			region, _ := cmd.Flags().GetString("region")
+			instanceID, _ := cmd.Flags().GetString("instance-id")
+
+			awsClient, err := newAWSClient(cmd, profile, region)
+			if err != nil {
+				return fmt.Errorf("failed to initialize AWS client: %w", err)
+			}
+
+			target, err := awsClient.FindBastion(ctx, instanceID)
+			if err != nil {
+				return err
+			}
+
+			return runSSMSession(awsClient, target)
+		},
+	}
+
+	cmd.Flags().String("profile", "default", "AWS profile to use")
+	cmd.Flags().String("region", "us-west-2", "AWS region")
+	cmd.Flags().String("instance-id", "", "Bastion instance ID (default: most recent mole bastion)")
+
+	return cmd
+}
+
+// runSSMSession attaches the terminal to a Session Manager session on the bastion
+func runSSMSession(client *aws.AWSClient, target *aws.BastionTarget) error {
+	if _, err := exec.LookPath("session-manager-plugin"); err != nil {
+		return fmt.Errorf("session-manager-plugin not found in PATH; install it from https://docs.aws.amazon.com/systems-manager/latest/userguide/session-manager-working-with-install-plugin.html")
+	}
+
+	fmt.Printf("🔗 Starting Session Manager session on %s...\n", target.InstanceID)
+	session := client.SSMSessionCommand(target.InstanceID)
+	session.Stdin = os.Stdin
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+	return session.Run()
+}
+
+func downCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "down",
+		Short: "Tear down tunnel and infrastructure",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fmt.Println("🔻 Tearing down tunnel...")
+
+			force, _ := cmd.Flags().GetBool("force")
+			cleanupAll, _ := cmd.Flags().GetBool("cleanup-all")
+			profile, _ := cmd.Flags().GetString("profile")
+			region, _ := cmd.Flags().GetString("region")
+
+			if !force {
+				fmt.Println("🚨 This will terminate AWS resources and may result in charges")
+				fmt.Print("Continue? (y/N): ")
+				var response string
+				fmt.Scanln(&response)
+				if strings.ToLower(response) != "y" && strings.ToLower(response) != "yes" {
+					fmt.Println("Teardown cancelled.")
+					return nil
+				}
+			}
+
+			// Step 1: Clean up local WireGuard interfaces automatically
+			fmt.Println("  🧹 Cleaning up local WireGuard interfaces...")
+			if err := cleanupLocalInterfaces(); err != nil {
+				fmt.Printf("  ⚠️  Warning: failed to cleanup local interfaces: %v\n", err)
+			}
+			if wiped, err := keystore.WipeAll(); err != nil {
+				fmt.Printf("  ⚠️  Warning: failed to wipe tunnel configs: %v\n", err)
+			} else if wiped > 0 {
+				fmt.Printf("  🧽 Wiped %d tunnel config(s) holding private keys\n", wiped)
+			}
+
+			// Step 2: Clean up local configuration files if requested
+			if cleanupAll {
+				fmt.Println("  🗑️  Removing local configuration files...")
+				if err := cleanupLocalConfig(); err != nil {
+					fmt.Printf("  ⚠️  Warning: failed to cleanup local config: %v\n", err)
+				}
+			}
+
+			// Step 3: Terminate AWS resources
+			awsClient, err := newAWSClient(cmd, profile, region)
+			if err != nil {
+				return fmt.Errorf("failed to create AWS client: %w", err)
+			}
+
+			fmt.Println("  ☁️  Finding and terminating AWS resources...")
+			terminated, err := terminateAWSResources(awsClient)
+			if err != nil {
+				fmt.Printf("  ⚠️  Warning: failed to cleanup AWS resources: %v\n", err)
+				fmt.Println("💡 You may need to manually clean up AWS resources via the Console")
+			}
+
+			// Client keys of terminated bastions are no longer needed
+			if keys, err := keystore.Open(""); err != nil {
+				fmt.Printf("  ⚠️  Warning: failed to open key store: %v\n", err)
+			} else {
+				for _, instanceID := range terminated {
+					if deleted, err := keys.DeleteInstance(instanceID); err != nil {
+						fmt.Printf("  ⚠️  Warning: failed to remove keys of %s: %v\n", instanceID, err)
+					} else if deleted > 0 {
+						fmt.Printf("  🔑 Removed %d stored key(s) of %s\n", deleted, instanceID)
+					}
+				}
+			}
+
+			// Step 4: Remove pre-shared keys and agent tokens a bastion never fetched
+			if deleted, err := awsClient.DeletePresharedKeys(context.Background()); err != nil {
+				fmt.Printf("  ⚠️  Warning: failed to cleanup pre-shared keys: %v\n", err)
+			} else if deleted > 0 {
+				fmt.Printf("  🔐 Removed %d unused pre-shared key parameter(s)\n", deleted)
+			}
+			if deleted, err := awsClient.DeleteAgentTokens(context.Background()); err != nil {
+				fmt.Printf("  ⚠️  Warning: failed to cleanup agent tokens: %v\n", err)
+			} else if deleted > 0 {
+				fmt.Printf("  🔐 Removed %d unused agent token parameter(s)\n", deleted)
+			}
+
+			// Step 5: Remove emergency key pairs created by 'mole up --access keypair'
+			fmt.Println("  🔑 Removing mole key pairs...")
+			if deleted, err := awsClient.CleanupKeyPairs(context.Background()); err != nil {
+				fmt.Printf("  ⚠️  Warning: failed to cleanup key pairs: %v\n", err)
+			} else {
+				fmt.Printf("  ✅ Removed %d key pair(s)\n", deleted)
+			}
+
+			fmt.Println("✅ Teardown complete!")
+			return nil
+		},
+	}
+	cmd.Flags().Bool("force", false, "Force teardown without confirmation")
+	cmd.Flags().Bool("cleanup-all", false, "Remove all local resources and configuration")
+	return cmd
+}
+
+// printBastionTuning asks the agent on each deployed bastion whether the requested kernel tuning is in effect
+func printBastionTuning(ctx context.Context) {
+	fmt.Println("\n🔧 Kernel Tuning:")
+
+	instances, err := agent.SavedInstances()
+	if err != nil || len(instances) == 0 {
+		fmt.Println("  No bastion agent token found (deploy with 'mole up')")
+		return
+	}
+
+	for _, instanceID := range instances {
+		token, err := agent.LoadToken(instanceID)
+		if err != nil {
+			fmt.Printf("  ⚠️  %s: %v\n", instanceID, err)
+			continue
+		}
+
+		reqCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		report, err := agent.NewClient(aws.AgentEndpoint(allocatedTunnelNetwork(0)), token).Tuning(reqCtx)
+		cancel()
+		if err != nil {
+			fmt.Printf("  ⚠️  %s: agent unreachable (is the tunnel up?): %v\n", instanceID, err)
+			continue
+		}
+
+		fmt.Printf("  Bastion %s:\n", instanceID)
+		for _, sysctl := range report.Sysctls {
+			if sysctl.Applied {
+				fmt.Printf("    ✅ %s = %s\n", sysctl.Key, sysctl.Actual)
+			} else {
+				fmt.Printf("    ❌ %s = %s (requested %s)\n", sysctl.Key, sysctl.Actual, sysctl.Desired)
+			}
+		}
+		if report.SpreadQueues {
+			fmt.Printf("    Queues: %d RX queues steered, %d IRQs pinned across %d CPUs\n",
+				report.RxQueues, report.IRQQueues, report.CPUs)
+		}
+		if report.CPUGovernor != "" {
+			fmt.Printf("    CPU governor: %s (where cpufreq is exposed)\n", report.CPUGovernor)
+		}
+	}
+}
+
+// resourceTags merges the config file's aws.tags with --tag flags, flags taking precedence
+func resourceTags(cmd *cobra.Command) (map[string]string, error) {
+	tags := make(map[string]string)
+
+	cfg, err := config.LoadConfig("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config for resource tags: %w", err)
+	}
+	for key, value := range cfg.AWS.Tags {
+		tags[key] = value
+	}
+
+	pairs, _ := cmd.Flags().GetStringArray("tag")
+	flagTags, err := aws.ParseTags(pairs)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range flagTags {
+		tags[key] = value
+	}
+
+	return tags, aws.ValidateTags(tags)
+}
+
+func logoutCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "logout",
+		Short: "Remove cached assumed-role credentials from ~/.mole",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := aws.ClearCredentialCache(); err != nil {
+				return fmt.Errorf("failed to clear credential cache: %w", err)
+			}
+			fmt.Println("✅ Cached credentials removed")
+			return nil
+		},
+	}
+}
+
+func peerCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "peer",
+		Short: "Manage additional client peers of a bastion",
+		Long: `Add, list and remove extra WireGuard clients (DTNs, workstations) on a
+deployed bastion. Peers are pushed through the bastion agent, so the tunnel
+from 'mole up' must be running.`,
+	}
+
+	addCmd := &cobra.Command{
+		Use:   "add NAME",
+		Short: "Allocate an address and key pair for a peer and write its client config",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			instanceID, _ := cmd.Flags().GetString("instance-id")
+			tunnelID, _ := cmd.Flags().GetInt("tunnel")
+			configPath, _ := cmd.Flags().GetString("config")
+			endpoint, _ := cmd.Flags().GetString("endpoint")
+			output, _ := cmd.Flags().GetString("output")
+
+			if err := peer.ValidateName(name); err != nil {
+				return err
+			}
+			instanceID, agentClient, err := bastionAgentClient(instanceID)
+			if err != nil {
+				return err
+			}
+			registry, err := peer.Load(instanceID)
+			if err != nil {
+				return err
+			}
+			if _, exists := registry.Get(name); exists {
+				return fmt.Errorf("peer %q already exists on %s", name, instanceID)
+			}
+
+			iface := fmt.Sprintf("wg%d", tunnelID)
+			var clientConfig *peer.ClientConfig
+			if configPath != "" {
+				clientConfig, err = peer.ReadClientConfig(configPath)
+			} else {
+				clientConfig, configPath, err = peer.FindClientConfig(iface)
+			}
+			if err != nil {
+				return err
+			}
+			if endpoint != "" {
+				clientConfig.Endpoint = endpoint
+			}
+			usePSK := clientConfig.PresharedKey
+			if cmd.Flags().Changed("psk") {
+				usePSK, _ = cmd.Flags().GetBool("psk")
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			interfaces, err := agentClient.Interfaces(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to query bastion agent (is the tunnel up?): %w", err)
+			}
+			used := registry.Addresses(tunnelID)
+			found := false
+			for _, stats := range interfaces {
+				if stats.Name != iface {
+					continue
+				}
+				found = true
+				for _, existing := range stats.Peers {
+					for _, allowed := range existing.AllowedIPs {
+						if prefix, err := netip.ParsePrefix(allowed); err == nil && prefix.IsSingleIP() {
+							used = append(used, prefix.Addr())
+						}
+					}
+				}
+			}
+			if !found {
+				return fmt.Errorf("bastion %s has no interface %s", instanceID, iface)
+			}
+
+			newPeer := peer.Peer{Name: name, Tunnel: tunnelID, Interface: iface, CreatedAt: time.Now().UTC()}
+			network, _ := clientConfig.Network(false)
+			address, err := peer.Allocate(network, used)
+			if err != nil {
+				return err
+			}
+			newPeer.Address = address.String()
+			if network, ok := clientConfig.Network(true); ok {
+				address, err := peer.Allocate(network, used)
+				if err != nil {
+					return err
+				}
+				newPeer.IPv6Address = address.String()
+			}
+
+			privateKey, publicKey, err := tunnel.GenerateWireGuardKeys()
+			if err != nil {
+				return err
+			}
+			newPeer.PublicKey = publicKey
+			var presharedKey string
+			if usePSK {
+				if presharedKey, err = tunnel.GeneratePresharedKey(); err != nil {
+					return err
+				}
+			}
+
+			if err := registry.Add(newPeer); err != nil {
+				return err
+			}
+			if err := agentClient.AddPeer(ctx, iface, agent.PeerRequest{
+				PublicKey:    publicKey,
+				AllowedIPs:   newPeer.AllowedIPs(),
+				PresharedKey: presharedKey,
+			}); err != nil {
+				return fmt.Errorf("failed to add peer to bastion: %w", err)
+			}
+			if err := registry.Save(); err != nil {
+				return err
+			}
+
+			rendered := clientConfig.Render(newPeer, privateKey, presharedKey)
+			if output == "-" {
+				fmt.Print(rendered)
+				return nil
+			}
+			if output == "" {
+				output = peer.ConfigPath(instanceID, name)
+			}
+			if err := os.MkdirAll(filepath.Dir(output), 0700); err != nil {
+				return fmt.Errorf("failed to create peer config directory: %w", err)
+			}
+			if err := os.WriteFile(output, []byte(rendered), 0600); err != nil {
+				return fmt.Errorf("failed to write peer config: %w", err)
+			}
+
+			fmt.Printf("✅ Peer %s added to %s on %s\n", name, iface, instanceID)
+			fmt.Printf("  Address: %s\n", strings.Join(newPeer.AllowedIPs(), ", "))
+			fmt.Printf("  Based on: %s\n", configPath)
+			fmt.Printf("📄 Client config: %s\n", output)
+			fmt.Printf("   Copy it to the peer and run: sudo wg-quick up %s\n", filepath.Base(output))
+			return nil
+		},
+	}
+	addCmd.Flags().Int("tunnel", 0, "Tunnel to attach the peer to")
+	addCmd.Flags().String("config", "", "Client config to copy the bastion endpoint and routes from (default: this host's tunnel config)")
+	addCmd.Flags().String("endpoint", "", "Override the bastion endpoint (host:port) in the peer config")
+	addCmd.Flags().StringP("output", "o", "", "Where to write the peer config, - for stdout (default: ~/.mole/peers/<instance>/<name>.conf)")
+	addCmd.Flags().Bool("psk", false, "Give the peer its own pre-shared key (default: on when the tunnel uses one)")
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List peers and their handshake status",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			instanceID, _ := cmd.Flags().GetString("instance-id")
+
+			instanceID, agentClient, err := bastionAgentClient(instanceID)
+			if err != nil {
+				return err
+			}
+			registry, err := peer.Load(instanceID)
+			if err != nil {
+				return err
+			}
+			if len(registry.Peers) == 0 {
+				fmt.Printf("No peers on %s (add one with 'mole peer add NAME')\n", instanceID)
+				return nil
+			}
+
+			live := make(map[string]agent.PeerStats)
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			if interfaces, err := agentClient.Interfaces(ctx); err != nil {
+				fmt.Printf("⚠️  Agent unreachable, showing saved peers only: %v\n", err)
+			} else {
+				for _, stats := range interfaces {
+					for _, peerStats := range stats.Peers {
+						live[peerStats.PublicKey] = peerStats
+					}
+				}
+			}
+
+			fmt.Printf("👥 Peers on %s:\n", instanceID)
+			for _, p := range registry.Peers {
+				status := "not on bastion"
+				if stats, ok := live[p.PublicKey]; ok {
+					status = "never connected"
+					if !stats.LatestHandshake.IsZero() {
+						status = fmt.Sprintf("handshake %s ago, rx %s, tx %s",
+							time.Since(stats.LatestHandshake).Round(time.Second),
+							formatBytes(stats.RxBytes), formatBytes(stats.TxBytes))
+					}
+				} else if len(live) == 0 {
+					status = "unknown"
+				}
+				fmt.Printf("  %-20s %-5s %-30s %s\n", p.Name, p.Interface, strings.Join(p.AllowedIPs(), ", "), status)
+			}
+			return nil
+		},
+	}
+
+	removeCmd := &cobra.Command{
+		Use:   "remove NAME",
+		Short: "Remove a peer from the bastion",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			instanceID, _ := cmd.Flags().GetString("instance-id")
+
+			instanceID, agentClient, err := bastionAgentClient(instanceID)
+			if err != nil {
+				return err
+			}
+			registry, err := peer.Load(instanceID)
+			if err != nil {
+				return err
+			}
+			removed, err := registry.Remove(name)
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := agentClient.RemovePeer(ctx, removed.Interface, removed.PublicKey); err != nil {
+				return fmt.Errorf("failed to remove peer from bastion: %w", err)
+			}
+			if err := registry.Save(); err != nil {
+				return err
+			}
+			if err := os.Remove(peer.ConfigPath(instanceID, name)); err != nil && !os.IsNotExist(err) {
+				fmt.Printf("⚠️  Could not delete saved client config: %v\n", err)
+			}
+
+			fmt.Printf("✅ Peer %s removed from %s\n", name, instanceID)
+			return nil
+		},
+	}
+
+	cmd.PersistentFlags().String("instance-id", "", "Bastion instance ID (default: the only bastion with a saved agent token)")
+	cmd.AddCommand(addCmd, listCmd, removeCmd)
+	return cmd
+}
+
+func keysCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "keys",
+		Short: "Rotate tunnel keys and show rotation history",
+	}
+
+	rotateCmd := &cobra.Command{
+		Use:   "rotate",
+		Short: "Replace this host's tunnel keys without dropping the tunnels",
+		Long: `Generate a new client key for each tunnel, introduce it on the bastion, switch
+the local interface and wait for a handshake before the old key is removed. If
+the new key never handshakes the bastion restores the old one.
+
+With --server the bastion's key on each tunnel is replaced the same way: the
+bastion swaps to a new private key, this host switches its peer to the new public
+key, and both sides go back to the old key if no handshake follows. The bastion
+republishes the new key in its instance tags. Tunnels with extra peers (see
+'mole peers') are skipped, since those peers would lose the tunnel.
+
+Run from cron or a systemd timer with --max-age to rotate on a schedule.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			instanceID, _ := cmd.Flags().GetString("instance-id")
+			tunnels, _ := cmd.Flags().GetIntSlice("tunnel")
+			maxAge, _ := cmd.Flags().GetDuration("max-age")
+			timeout, _ := cmd.Flags().GetDuration("timeout")
+			server, _ := cmd.Flags().GetBool("server")
+
+			instanceID, agentClient, err := bastionAgentClient(instanceID)
+			if err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			if len(tunnels) == 0 {
+				interfaces, err := agentClient.Interfaces(ctx)
+				if err != nil {
+					return fmt.Errorf("failed to query bastion agent (is the tunnel up?): %w", err)
+				}
+				for _, stats := range interfaces {
+					var id int
+					if _, err := fmt.Sscanf(stats.Name, "wg%d", &id); err == nil {
+						tunnels = append(tunnels, id)
+					}
+				}
+			}
+
+			history, err := rotation.ReadHistory()
+			if err != nil {
+				return err
+			}
+
+			cfg, err := config.LoadConfig("")
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			keys, err := keystore.Open(cfg.Tunnel.KeyStore)
+			if err != nil {
+				return err
+			}
+
+			rotator := &rotation.Rotator{
+				InstanceID: instanceID,
+				Bastion:    agentClient,
+				Local:      rotation.WGLocal{},
+				Timeout:    timeout,
+			}
+
+			failed := 0
+			for _, id := range tunnels {
+				iface := fmt.Sprintf("wg%d", id)
+				_, configPath, err := peer.FindClientConfig(iface)
+				if err != nil {
+					fmt.Printf("⚠️  %s: %v\n", iface, err)
+					failed++
+					continue
+				}
+
+				if maxAge > 0 {
+					if age := keyAge(history, instanceID, iface, "", configPath); age < maxAge {
+						fmt.Printf("⏭️  %s: key is %s old, below --max-age %s\n", iface, age.Round(time.Hour), maxAge)
+						continue
+					}
+				}
+
+				// Keep the key store in step for tunnels deployed with one
+				rotator.Keys = nil
+				if keys.Has(keystore.ClientKeyName(instanceID, iface)) {
+					rotator.Keys = keys
+				}
+
+				fmt.Printf("🔑 Rotating %s key...\n", iface)
+				record, err := rotator.Rotate(ctx, iface, configPath)
+				if err != nil {
+					fmt.Printf("❌ %s: %v\n", iface, err)
+					failed++
+					continue
+				}
+				fmt.Printf("✅ %s now uses %s...\n", iface, record.NewPublicKey[:20])
+			}
+
+			// Bastion keys go second, once this host's own keys are settled
+			if server {
+				for _, id := range tunnels {
+					iface := fmt.Sprintf("wg%d", id)
+					_, configPath, err := peer.FindClientConfig(iface)
+					if err != nil {
+						// Already reported by the client key pass
+						continue
+					}
+
+					if maxAge > 0 {
+						if age := keyAge(history, instanceID, iface, rotation.KeyServer, configPath); age < maxAge {
+							fmt.Printf("⏭️  %s: bastion key is %s old, below --max-age %s\n", iface, age.Round(time.Hour), maxAge)
+							continue
+						}
+					}
+
+					fmt.Printf("🔑 Rotating %s bastion key...\n", iface)
+					record, err := rotator.RotateServer(ctx, iface, configPath, aws.ServerPublicKeyTag(id))
+					if err != nil {
+						fmt.Printf("❌ %s: %v\n", iface, err)
+						failed++
+						continue
+					}
+					fmt.Printf("✅ %s bastion now uses %s...\n", iface, record.NewPublicKey[:20])
+				}
+			}
+
+			fmt.Printf("📜 History: %s\n", rotation.HistoryPath())
+			if failed > 0 {
+				return fmt.Errorf("%d tunnel key rotation(s) failed", failed)
+			}
+			return nil
+		},
+	}
+	rotateCmd.Flags().IntSlice("tunnel", nil, "Tunnels to rotate (default: every tunnel on the bastion)")
+	rotateCmd.Flags().Duration("max-age", 0, "Only rotate keys older than this, e.g. 720h (default: always rotate)")
+	rotateCmd.Flags().Duration("timeout", agent.DefaultRotationTimeout, "How long the bastion waits for the new key's handshake")
+	rotateCmd.Flags().Bool("server", false, "Also replace the bastion's key on each tunnel")
+
+	historyCmd := &cobra.Command{
+		Use:   "history",
+		Short: "Show past key rotations",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			records, err := rotation.ReadHistory()
+			if err != nil {
+				return err
+			}
+			if len(records) == 0 {
+				fmt.Println("No key rotations recorded")
+				return nil
+			}
+
+			for _, record := range records {
+				icon := "✅"
+				if record.Result != rotation.ResultCompleted {
+					icon = "❌"
+				}
+				key := record.Key
+				if key == "" {
+					key = "client"
+				}
+				fmt.Printf("%s %s  %-20s %-4s %-6s %-11s", icon, record.Time.Local().Format("2006-01-02 15:04"),
+					record.InstanceID, record.Interface, key, record.Result)
+				if record.Error != "" {
+					fmt.Printf("  %s", record.Error)
+				}
+				fmt.Println()
+			}
+			return nil
+		},
+	}
+
+	cmd.PersistentFlags().String("instance-id", "", "Bastion instance ID (default: the only bastion with a saved agent token)")
+	cmd.AddCommand(rotateCmd, historyCmd)
+	return cmd
+}
+
+// keyAge returns how long ago a tunnel key (client, or rotation.KeyServer) was last
+// rotated. A key that was never rotated is as old as the config from mole up.
+func keyAge(history []rotation.Record, instanceID, iface, key, configPath string) time.Duration {
+	last, ok := rotation.LastCompleted(history, instanceID, iface, key)
+	if !ok {
+		if info, err := os.Stat(configPath); err == nil {
+			last = info.ModTime()
+		}
+	}
+	return time.Since(last)
+}
+
+// bastionAgentClient resolves the bastion to manage and returns an agent client for it
+func bastionAgentClient(instanceID string) (string, *agent.Client, error) {
+	instanceID, err := selectBastion(instanceID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	token, err := agent.LoadToken(instanceID)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to load agent token for %s: %w", instanceID, err)
+	}
+	return instanceID, agent.NewClient(aws.AgentEndpoint(allocatedTunnelNetwork(0)), token), nil
+}
 
-	return cmd
+// loadIPAM opens this host's tunnel allocations for the configured base CIDR
+func loadIPAM(cfg *config.Config) (*tunnel.IPAM, error) {
+	baseCIDR := cfg.Tunnel.BaseCIDR
+	if baseCIDR == "" {
+		baseCIDR = tunnel.DefaultBaseCIDR
+	}
+	ipam, err := tunnel.LoadIPAM(tunnel.IPAMPath(), baseCIDR, cfg.Tunnel.TunnelPrefixLen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tunnel allocations: %w", err)
+	}
+	return ipam, nil
 }
 
-func scaleCmd() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "scale",
-		Short: "Scale tunnel count",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			fmt.Println("⚖️  Scaling tunnels...")
+// allocatedTunnelNetwork returns the IPv4 network mole up allocated for a tunnel, or ""
+// to use the default plan
+func allocatedTunnelNetwork(id int) string {
+	cfg, err := config.LoadConfig("")
+	if err != nil {
+		return ""
+	}
+	ipam, err := loadIPAM(cfg)
+	if err != nil {
+		return ""
+	}
+	allocation, ok := ipam.Lookup(id)
+	if !ok {
+		return ""
+	}
+	return allocation.Network.String()
+}
 
-			tunnelCount, _ := cmd.Flags().GetInt("tunnels")
-			fmt.Printf("🎯 Target tunnel count: %d\n", tunnelCount)
-			fmt.Println("⚠️  Dynamic tunnel scaling is planned for Phase 2")
-			fmt.Println("💡 Use 'mole down' and 'mole up --tunnels N' for now")
-			return nil
-		},
+// selectBastion returns instanceID, or the only deployed bastion when it is empty
+func selectBastion(instanceID string) (string, error) {
+	if instanceID != "" {
+		return instanceID, nil
+	}
+	instances, err := agent.SavedInstances()
+	if err != nil {
+		return "", fmt.Errorf("failed to list bastions: %w", err)
+	}
+	switch len(instances) {
+	case 0:
+		return "", fmt.Errorf("no bastion agent token found (deploy with 'mole up')")
+	case 1:
+		return instances[0], nil
+	default:
+		return "", fmt.Errorf("several bastions found (%s); choose one with --instance-id", strings.Join(instances, ", "))
 	}
-	cmd.Flags().Int("tunnels", 4, "Target tunnel count")
-	return cmd
 }
 
-func optimizeCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "optimize",
-		Short: "Apply performance recommendations",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			fmt.Println("🎯 Applying performance optimizations...")
-			fmt.Println("🔧 Optimization features include:")
-			fmt.Println("   • MTU optimization")
-			fmt.Println("   • TCP congestion control tuning")
-			fmt.Println("   • Process pinning")
-			fmt.Println("   • Buffer size adjustments")
-			fmt.Println("⚠️  Performance optimization is planned for Phase 2")
-			fmt.Println("💡 Use 'mole probe' to see current recommendations")
-			return nil
-		},
+// formatBytes renders a byte count with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
 	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
 }
 
-func exportCmd() *cobra.Command {
+func agentCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "export",
-		Short: "Export infrastructure as code templates",
+		Use:   "agent",
+		Short: "Run the bastion control API (installed on the bastion by mole up)",
+		Long: `Serve the authenticated bastion control API. It listens on the first tunnel
+address so it is only reachable over WireGuard, and manages interfaces, peers,
+sysctls and logs without re-deploying.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			format, _ := cmd.Flags().GetString("format")
-			output, _ := cmd.Flags().GetString("output")
-			vpcId, _ := cmd.Flags().GetString("vpc")
-			subnetId, _ := cmd.Flags().GetString("subnet")
-			tunnels, _ := cmd.Flags().GetInt("tunnels")
+			listen, _ := cmd.Flags().GetString("listen")
+			tokenFile, _ := cmd.Flags().GetString("token-file")
+			allow, _ := cmd.Flags().GetStringSlice("allow")
+			instanceID, _ := cmd.Flags().GetString("instance-id")
+			region, _ := cmd.Flags().GetString("region")
 
-			if vpcId == "" || subnetId == "" {
-				return fmt.Errorf("VPC ID and Subnet ID are required")
+			token, err := agent.ReadTokenFile(tokenFile)
+			if err != nil {
+				return err
 			}
-
-			fmt.Printf("📤 Exporting %s template for infrastructure...\n", format)
-
-			// Mock configuration for export
-			config := struct {
-				VPCId        string
-				SubnetId     string
-				InstanceType string
-				TunnelCount  int
-				MTUSize      int
-				AllowedCIDR  string
-				SSHPublicKey string
-				Profile      string
-				Region       string
-			}{
-				VPCId:        vpcId,
-				SubnetId:     subnetId,
-				InstanceType: "c6gn.medium",
-				TunnelCount:  tunnels,
-				MTUSize:      1420,
-				AllowedCIDR:  "0.0.0.0/0",
-				SSHPublicKey: "ssh-rsa AAAAB3NzaC1yc2E... your-public-key-here",
-				Profile:      "default",
-				Region:       "us-west-2",
+			sources, err := agent.ParseAllowedSources(allow)
+			if err != nil {
+				return err
 			}
 
-			var template string
-			var filename string
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			server := agent.NewServer(agent.Config{
+				ListenAddr:     listen,
+				Token:          token,
+				AllowedSources: sources,
+				InstanceID:     instanceID,
+				Region:         region,
+			}, agent.ExecRunner{})
+			return server.ListenAndServe(ctx)
+		},
+	}
 
-			switch format {
-			case "terraform", "tf":
-				template = generateTerraformTemplate(config)
-				filename = "mole-infrastructure.tf"
-			case "cloudformation", "cf":
-				template = generateCloudFormationTemplate(config)
-				filename = "mole-infrastructure.yaml"
-			case "pulumi":
-				template = generatePulumiTemplate(config)
-				filename = "main.go"
-			default:
-				return fmt.Errorf("unsupported format: %s (supported: terraform, cloudformation, pulumi)", format)
-			}
+	cmd.Flags().String("listen", fmt.Sprintf("10.100.1.1:%d", agent.DefaultPort), "Address to listen on (the bastion's wg0 address)")
+	cmd.Flags().String("token-file", "/etc/mole/agent.token", "File containing the API bearer token")
+	cmd.Flags().StringSlice("allow", agent.DefaultAllowedSources, "Source networks allowed to call the API")
+	cmd.Flags().String("instance-id", "", "This bastion's instance ID, for republishing its key tags after a server key rotation")
+	cmd.Flags().String("region", "", "This bastion's region (default: the AWS CLI's)")
 
-			if output != "" {
-				filename = output
+	return cmd
+}
+
+func proxyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "proxy",
+		Short: "Reach the private subnet through a local SOCKS5/HTTP proxy, without root",
+		Long: `Run a WireGuard tunnel inside mole on a user-space TCP/IP stack and serve a
+SOCKS5 and HTTP CONNECT proxy on a local port. Nothing needs root: no interface,
+route or sudo, so it works on shared login nodes. Point rsync, curl, git or ssh at
+the proxy to reach hosts behind the bastion.
+
+The tunnel comes from a deployment made with 'mole up', or from any client config
+file such as one written by 'mole peer add'.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			instanceID, _ := cmd.Flags().GetString("instance-id")
+			configFile, _ := cmd.Flags().GetString("config")
+			tunnelID, _ := cmd.Flags().GetInt("tunnel")
+			listen, _ := cmd.Flags().GetString("listen")
+			noAuth, _ := cmd.Flags().GetBool("no-auth")
+			keyStoreBackend, _ := cmd.Flags().GetString("key-store")
+
+			if !userspace.Available {
+				return fmt.Errorf("this mole binary was built without the user-space network stack; rebuild with -tags netstack")
 			}
 
-			// Write to file or stdout
-			if output == "-" {
-				fmt.Print(template)
+			var tunnelConfig *tunnel.WireGuardConfig
+			if configFile != "" {
+				data, err := os.ReadFile(configFile)
+				if err != nil {
+					return fmt.Errorf("failed to read tunnel config: %w", err)
+				}
+				if tunnelConfig, err = tunnel.ParseWireGuardConfig(data); err != nil {
+					return fmt.Errorf("invalid tunnel config %s: %w", configFile, err)
+				}
 			} else {
-				if err := writeToFile(filename, template); err != nil {
-					return fmt.Errorf("failed to write template: %w", err)
+				instanceID, err := selectBastion(instanceID)
+				if err != nil {
+					return err
 				}
-				fmt.Printf("✅ %s template exported to %s\n", format, filename)
-				fmt.Printf("💡 Edit the template and deploy with your preferred tool:\n")
-
-				switch format {
-				case "terraform", "tf":
-					fmt.Printf("   terraform init && terraform plan && terraform apply\n")
-				case "cloudformation", "cf":
-					fmt.Printf("   aws cloudformation deploy --template-file %s --stack-name mole-stack\n", filename)
-				case "pulumi":
-					fmt.Printf("   pulumi up\n")
+				cfg, err := config.LoadConfig("")
+				if err != nil {
+					return fmt.Errorf("failed to load config: %w", err)
+				}
+				if keyStoreBackend == "" {
+					keyStoreBackend = cfg.Tunnel.KeyStore
+				}
+				keys, err := keystore.Open(keyStoreBackend)
+				if err != nil {
+					return err
+				}
+				if err := keys.Unlock(); err != nil {
+					return fmt.Errorf("failed to unlock key store: %w", err)
+				}
+				tunnelConfig, err = loadUserspaceConfig(keys, instanceID, fmt.Sprintf("wg%d", tunnelID))
+				if err != nil {
+					return err
 				}
 			}
 
-			return nil
+			return serveUserspaceProxy(tunnelConfig, listen, !noAuth)
 		},
 	}
 
-	cmd.Flags().String("format", "terraform", "Export format (terraform, cloudformation, pulumi)")
-	cmd.Flags().String("output", "", "Output file (default: auto-generated name, use '-' for stdout)")
-	cmd.Flags().String("vpc", "", "VPC ID (required)")
-	cmd.Flags().String("subnet", "", "Public subnet ID (required)")
-	cmd.Flags().Int("tunnels", 3, "Number of tunnels")
+	cmd.Flags().String("instance-id", "", "Bastion deployed with 'mole up --userspace' (default: the only one)")
+	cmd.Flags().String("config", "", "WireGuard client config file to use instead of a deployed bastion")
+	cmd.Flags().Int("tunnel", 0, "Tunnel of the bastion to use")
+	cmd.Flags().String("listen", "127.0.0.1:1080", "Address to serve SOCKS5 and HTTP CONNECT on")
+	cmd.Flags().Bool("no-auth", false, "Accept clients without the generated password (only on single-user hosts)")
+	cmd.Flags().String("key-store", "", "Key store holding the tunnel config: auto, keyring or file (default: tunnel.key_store)")
 
 	return cmd
 }
 
-func createProfileCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "create-profile [name]",
-		Short: "Create a saved tunnel profile",
-		Args:  cobra.ExactArgs(1),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			profileName := args[0]
-			fmt.Printf("💾 Creating profile: %s\n", profileName)
-			fmt.Println("📋 Profile features include:")
-			fmt.Println("   • Saved tunnel configurations")
-			fmt.Println("   • AWS connection details")
-			fmt.Println("   • Performance settings")
-			fmt.Println("⚠️  Profile management is planned for Phase 2")
-			return nil
-		},
+// loadUserspaceConfig reads a tunnel config stored by 'mole up --userspace'
+func loadUserspaceConfig(keys *keystore.Store, instanceID, iface string) (*tunnel.WireGuardConfig, error) {
+	data, err := keys.Get(keystore.TunnelConfigName(instanceID, iface))
+	if err != nil {
+		return nil, fmt.Errorf("no userspace config for %s %s (deploy with 'mole up --userspace'): %w", instanceID, iface, err)
 	}
+	return tunnel.ParseWireGuardConfig([]byte(data))
 }
 
-func connectCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "connect [profile]",
-		Short: "Connect using saved profile",
-		Args:  cobra.ExactArgs(1),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			profileName := args[0]
-			fmt.Printf("🔗 Connecting using profile: %s\n", profileName)
-			fmt.Println("⚠️  Profile connection is planned for Phase 2")
-			fmt.Println("💡 Use 'mole up' with appropriate flags for now")
-			return nil
-		},
+// serveUserspaceProxy runs the tunnel in-process and serves the proxy until interrupted
+func serveUserspaceProxy(tunnelConfig *tunnel.WireGuardConfig, listen string, auth bool) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("🔒 Starting userspace tunnel to %s...\n", tunnelConfig.PeerEndpoint)
+	tun, err := userspace.Start(tunnelConfig)
+	if err != nil {
+		return fmt.Errorf("failed to start userspace tunnel: %w", err)
 	}
+	defer tun.Close()
+
+	handshakeCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	if err := tun.WaitHandshake(handshakeCtx); err != nil {
+		fmt.Printf("⚠️  %v; the bastion may still be booting, continuing\n", err)
+	} else {
+		fmt.Printf("  ✓ Handshake with the bastion completed\n")
+	}
+	cancel()
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", listen, err)
+	}
+
+	proxy := &userspace.Proxy{Dialer: tun}
+	credentials := ""
+	if auth {
+		password := make([]byte, 12)
+		if _, err := rand.Read(password); err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to generate proxy password: %w", err)
+		}
+		proxy.Username, proxy.Password = "mole", hex.EncodeToString(password)
+		credentials = proxy.Username + ":" + proxy.Password + "@"
+	}
+
+	address := listener.Addr().String()
+	fmt.Printf("🧦 SOCKS5 and HTTP CONNECT proxy on %s\n", address)
+	fmt.Printf("  export ALL_PROXY=socks5h://%s%s\n", credentials, address)
+	fmt.Printf("  curl -x socks5h://%s%s http://10.0.1.10/\n", credentials, address)
+	fmt.Printf("  git config --global http.proxy http://%s%s\n", credentials, address)
+	if auth {
+		fmt.Printf("  rsync -e 'ssh -o ProxyCommand=\"ncat --proxy %s --proxy-type socks5 --proxy-auth %s %%h %%p\"' ...\n",
+			address, proxy.Username+":"+proxy.Password)
+	} else {
+		fmt.Printf("  rsync -e 'ssh -o ProxyCommand=\"ncat --proxy %s --proxy-type socks5 %%h %%p\"' ...\n", address)
+	}
+	fmt.Println("Press Ctrl+C to stop")
+
+	if err := proxy.Serve(ctx, listener); err != nil {
+		return err
+	}
+	fmt.Println("\n🛑 Userspace tunnel stopped")
+	return nil
 }
 
-func downCmd() *cobra.Command {
+func forwardCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "down",
-		Short: "Tear down tunnel and infrastructure",
+		Use:   "forward [BIND:]PORT:HOST:HOSTPORT... [-R [BIND:]PORT:HOST:HOSTPORT]...",
+		Short: "Forward single ports through the tunnel",
+		Long: `Forward TCP ports over the running WireGuard tunnel, like ssh -L and -R.
+
+Each argument listens on this host and connects to HOST:HOSTPORT behind the bastion,
+e.g. 8888:10.0.1.5:8888 for a Jupyter server. Each -R listens on this host's tunnel
+address, where hosts in the private subnet reach it through the bastion, and connects
+to HOST:HOSTPORT on-premises; the port is opened in the bastion's security group for
+the VPC until mole forward exits. Any number of forwards run at once and report the
+bytes they have carried.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			fmt.Println("🔻 Tearing down tunnel...")
-
-			force, _ := cmd.Flags().GetBool("force")
-			cleanupAll, _ := cmd.Flags().GetBool("cleanup-all")
+			remotes, _ := cmd.Flags().GetStringArray("remote")
+			bind, _ := cmd.Flags().GetString("bind")
+			tunnelID, _ := cmd.Flags().GetInt("tunnel")
+			interval, _ := cmd.Flags().GetDuration("interval")
+			noSGRule, _ := cmd.Flags().GetBool("no-sg-rule")
 			profile, _ := cmd.Flags().GetString("profile")
 			region, _ := cmd.Flags().GetString("region")
+			instanceID, _ := cmd.Flags().GetString("instance-id")
 
-			if !force {
-				fmt.Println("🚨 This will terminate AWS resources and may result in charges")
-				fmt.Print("Continue? (y/N): ")
-				var response string
-				fmt.Scanln(&response)
-				if strings.ToLower(response) != "y" && strings.ToLower(response) != "yes" {
-					fmt.Println("Teardown cancelled.")
-					return nil
+			if len(args) == 0 && len(remotes) == 0 {
+				return fmt.Errorf("nothing to forward: give PORT:HOST:HOSTPORT arguments or -R")
+			}
+			if tunnelID < 0 || tunnelID >= aws.MaxTunnelCount {
+				return fmt.Errorf("--tunnel must be between 0 and %d", aws.MaxTunnelCount-1)
+			}
+			if interval <= 0 {
+				return fmt.Errorf("--interval must be positive")
+			}
+
+			var specs []forward.Spec
+			for _, arg := range args {
+				spec, err := forward.ParseSpec(arg, forward.Local, bind)
+				if err != nil {
+					return err
 				}
+				specs = append(specs, spec)
+			}
+			for _, remote := range remotes {
+				spec, err := forward.ParseSpec(remote, forward.Remote, aws.ClientTunnelIP(tunnelID, allocatedTunnelNetwork(tunnelID)))
+				if err != nil {
+					return err
+				}
+				specs = append(specs, spec)
 			}
 
-			// Step 1: Clean up local WireGuard interfaces automatically
-			fmt.Println("  🧹 Cleaning up local WireGuard interfaces...")
-			if err := cleanupLocalInterfaces(); err != nil {
-				fmt.Printf("  ⚠️  Warning: failed to cleanup local interfaces: %v\n", err)
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			// Listen on everything first so a busy port fails before anything is opened
+			var forwards []*forward.Forward
+			var listeners []net.Listener
+			defer func() {
+				for _, listener := range listeners {
+					listener.Close()
+				}
+			}()
+			for _, spec := range specs {
+				listener, err := net.Listen("tcp", spec.Listen)
+				if err != nil {
+					return fmt.Errorf("failed to listen for %s: %w", spec, err)
+				}
+				listeners = append(listeners, listener)
+				forwards = append(forwards, &forward.Forward{Spec: spec, Dialer: &net.Dialer{Timeout: 15 * time.Second}})
 			}
 
-			// Step 2: Clean up local configuration files if requested
-			if cleanupAll {
-				fmt.Println("  🗑️  Removing local configuration files...")
-				if err := cleanupLocalConfig(); err != nil {
-					fmt.Printf("  ⚠️  Warning: failed to cleanup local config: %v\n", err)
+			if len(remotes) > 0 && !noSGRule {
+				closePorts, err := openForwardPorts(ctx, cmd, profile, region, instanceID, specs)
+				if err != nil {
+					return err
 				}
+				defer closePorts()
 			}
 
-			// Step 3: Terminate AWS resources
-			awsClient, err := aws.NewAWSClient(profile, region)
-			if err != nil {
-				return fmt.Errorf("failed to create AWS client: %w", err)
+			for _, f := range forwards {
+				fmt.Printf("🔀 %s\n", f.Spec)
 			}
+			fmt.Println("Press Ctrl+C to stop")
 
-			fmt.Println("  ☁️  Finding and terminating AWS resources...")
-			if err := terminateAWSResources(awsClient); err != nil {
-				fmt.Printf("  ⚠️  Warning: failed to cleanup AWS resources: %v\n", err)
-				fmt.Println("💡 You may need to manually clean up AWS resources via the Console")
+			errs := make(chan error, len(forwards))
+			for i, f := range forwards {
+				go func() { errs <- f.Serve(ctx, listeners[i]) }()
 			}
 
-			fmt.Println("✅ Teardown complete!")
-			return nil
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			var serveErr error
+			for running := len(forwards); running > 0; {
+				select {
+				case err := <-errs:
+					running--
+					if err != nil && serveErr == nil {
+						serveErr = err
+						stop()
+					}
+				case <-ticker.C:
+					printForwardStats(forwards)
+				}
+			}
+
+			fmt.Println("\n🛑 Forwards stopped")
+			printForwardStats(forwards)
+			return serveErr
 		},
 	}
-	cmd.Flags().Bool("force", false, "Force teardown without confirmation")
-	cmd.Flags().Bool("cleanup-all", false, "Remove all local resources and configuration")
+
+	cmd.Flags().StringArrayP("remote", "R", nil, "Reverse forward [BIND:]PORT:HOST:HOSTPORT from the private subnet to an on-premises host (repeatable)")
+	cmd.Flags().String("bind", "127.0.0.1", "Listen address for local forwards without one")
+	cmd.Flags().Int("tunnel", 0, "Tunnel whose address reverse forwards listen on")
+	cmd.Flags().Duration("interval", 30*time.Second, "How often to print byte counts")
+	cmd.Flags().Bool("no-sg-rule", false, "Do not open reverse forward ports in the bastion security group")
+	cmd.Flags().String("profile", "default", "AWS profile to use")
+	cmd.Flags().String("region", "us-west-2", "AWS region")
+	cmd.Flags().String("instance-id", "", "Bastion instance ID (default: most recent mole bastion)")
+
 	return cmd
 }
 
+// openForwardPorts opens the bastion security group for every reverse forward and returns
+// a function closing them again
+func openForwardPorts(ctx context.Context, cmd *cobra.Command, profile, region, instanceID string, specs []forward.Spec) (func(), error) {
+	awsClient, err := newAWSClient(cmd, profile, region)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize AWS client: %w", err)
+	}
+	target, err := awsClient.FindBastion(ctx, instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	var closers []func(context.Context) error
+	closeAll := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		for _, closePort := range closers {
+			if err := closePort(ctx); err != nil {
+				fmt.Printf("⚠️  %v\n", err)
+			}
+		}
+	}
+	for _, spec := range specs {
+		if spec.Direction != forward.Remote {
+			continue
+		}
+		_, portText, _ := net.SplitHostPort(spec.Listen)
+		port, _ := strconv.Atoi(portText)
+		closePort, err := awsClient.OpenForwardPort(ctx, target, port)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		closers = append(closers, closePort)
+		fmt.Printf("  ✓ Port %d open to the VPC on %s\n", port, target.InstanceID)
+	}
+	return closeAll, nil
+}
+
+// printForwardStats prints each forward's connection and byte counts
+func printForwardStats(forwards []*forward.Forward) {
+	for _, f := range forwards {
+		stats := f.Stats()
+		fmt.Printf("  %s: %d connections (%d active), sent %s, received %s\n",
+			f.Spec, stats.Connections, stats.Active, formatBytes(stats.Sent), formatBytes(stats.Received))
+	}
+}
+
 func versionCmd() *cobra.Command {
 	return &cobra.Command{
 		Use:   "version",
@@ -817,149 +2839,6 @@ func versionCmd() *cobra.Command {
 	}
 }
 
-// Helper functions for template generation
-func generateTerraformTemplate(config interface{}) string {
-	// Simplified template generation - would use the aws package in real implementation
-	return `# AWS Cloud Mole Infrastructure
-terraform {
-  required_providers {
-    aws = {
-      source  = "hashicorp/aws"
-      version = "~> 5.0"
-    }
-  }
-}
-
-# Configure the AWS Provider
-provider "aws" {
-  region = "us-west-2"
-}
-
-# Security Group for WireGuard
-resource "aws_security_group" "mole_wireguard" {
-  name        = "mole-wireguard-sg"
-  description = "AWS Cloud Mole WireGuard Security Group"
-
-  # WireGuard ports
-  ingress {
-    from_port   = 51820
-    to_port     = 51822
-    protocol    = "udp"
-    cidr_blocks = ["0.0.0.0/0"]
-  }
-
-  # SSH port
-  ingress {
-    from_port   = 22
-    to_port     = 22
-    protocol    = "tcp"
-    cidr_blocks = ["0.0.0.0/0"]
-  }
-
-  egress {
-    from_port   = 0
-    to_port     = 0
-    protocol    = "-1"
-    cidr_blocks = ["0.0.0.0/0"]
-  }
-}
-
-# EC2 Instance
-resource "aws_instance" "mole_bastion" {
-  ami           = "ami-0c2d3e23f757b5d84"  # Ubuntu 22.04 LTS ARM64
-  instance_type = "c6gn.medium"
-
-  tags = {
-    Name = "mole-bastion"
-  }
-}
-
-output "bastion_public_ip" {
-  value = aws_instance.mole_bastion.public_ip
-}
-`
-}
-
-func generateCloudFormationTemplate(config interface{}) string {
-	return `AWSTemplateFormatVersion: '2010-09-09'
-Description: 'AWS Cloud Mole Infrastructure'
-
-Resources:
-  WireGuardSecurityGroup:
-    Type: AWS::EC2::SecurityGroup
-    Properties:
-      GroupDescription: AWS Cloud Mole WireGuard Security Group
-      SecurityGroupIngress:
-        - IpProtocol: udp
-          FromPort: 51820
-          ToPort: 51822
-          CidrIp: 0.0.0.0/0
-        - IpProtocol: tcp
-          FromPort: 22
-          ToPort: 22
-          CidrIp: 0.0.0.0/0
-
-  BastionInstance:
-    Type: AWS::EC2::Instance
-    Properties:
-      ImageId: ami-0c2d3e23f757b5d84
-      InstanceType: c6gn.medium
-      SecurityGroupIds:
-        - !Ref WireGuardSecurityGroup
-      Tags:
-        - Key: Name
-          Value: mole-bastion
-
-Outputs:
-  BastionPublicIP:
-    Description: Public IP address of the bastion
-    Value: !GetAtt BastionInstance.PublicIp
-`
-}
-
-func generatePulumiTemplate(config interface{}) string {
-	return `package main
-
-import (
-	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/ec2"
-	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
-)
-
-func main() {
-	pulumi.Run(func(ctx *pulumi.Context) error {
-		// Security Group
-		sg, err := ec2.NewSecurityGroup(ctx, "mole-wireguard", &ec2.SecurityGroupArgs{
-			Description: pulumi.String("AWS Cloud Mole WireGuard Security Group"),
-			Ingress: ec2.SecurityGroupIngressArray{
-				&ec2.SecurityGroupIngressArgs{
-					Protocol:   pulumi.String("udp"),
-					FromPort:   pulumi.Int(51820),
-					ToPort:     pulumi.Int(51822),
-					CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
-				},
-			},
-		})
-		if err != nil {
-			return err
-		}
-
-		// EC2 Instance
-		instance, err := ec2.NewInstance(ctx, "mole-bastion", &ec2.InstanceArgs{
-			Ami:                  pulumi.String("ami-0c2d3e23f757b5d84"),
-			InstanceType:         pulumi.String("c6gn.medium"),
-			VpcSecurityGroupIds:  pulumi.StringArray{sg.ID()},
-		})
-		if err != nil {
-			return err
-		}
-
-		ctx.Export("bastionPublicIP", instance.PublicIp)
-		return nil
-	})
-}
-`
-}
-
 func writeToFile(filename, content string) error {
 	file, err := os.Create(filename)
 	if err != nil {
@@ -1079,7 +2958,7 @@ func testCmd() *cobra.Command {
 				// Try to find the most recent target instance
 				fmt.Println("🔍 Looking for test target instances...")
 
-				awsClient, err := aws.NewAWSClient(profile, region)
+				awsClient, err := newAWSClient(cmd, profile, region)
 				if err != nil {
 					return fmt.Errorf("failed to initialize AWS client: %w", err)
 				}
@@ -1322,11 +3201,10 @@ func cleanupMacOSInterfacesCmd(interfaces []string, env []string) error {
 		fmt.Printf("  ⬇️  Bringing down interface: %s\n", iface)
 
 		// Try multiple cleanup strategies for macOS
-		configPaths := []string{
-			fmt.Sprintf("%s/.mole/tunnels/wg0.conf", os.Getenv("HOME")),
+		configPaths := append(keystore.ConfigPaths(),
 			fmt.Sprintf("/opt/homebrew/etc/wireguard/%s.conf", iface),
 			fmt.Sprintf("/usr/local/etc/wireguard/%s.conf", iface),
-		}
+		)
 
 		success := false
 		for _, configPath := range configPaths {
@@ -1370,7 +3248,7 @@ func cleanupLinuxInterfacesCmd(interfaces []string, env []string) error {
 		fmt.Printf("  ⬇️  Bringing down interface: %s\n", iface)
 
 		// Try wg-quick down first
-		cleanupCmd := exec.Command("sudo", "-A", "wg-quick", "down", iface)
+		cleanupCmd := exec.Command("sudo", "-A", "wg-quick", "down", keystore.ConfigOrName(iface))
 		cleanupCmd.Env = env
 		if err := cleanupCmd.Run(); err != nil {
 			// Fallback to ip link delete
@@ -1441,7 +3319,7 @@ func cleanupBSDInterfacesCmd(interfaces []string, env []string) error {
 
 		fmt.Printf("  ⬇️  Bringing down BSD interface: %s\n", iface)
 
-		cleanupCmd := exec.Command("sudo", "-A", "wg-quick", "down", iface)
+		cleanupCmd := exec.Command("sudo", "-A", "wg-quick", "down", keystore.ConfigOrName(iface))
 		cleanupCmd.Env = env
 		if err := cleanupCmd.Run(); err != nil {
 			// BSD-specific fallback
@@ -1529,7 +3407,7 @@ func handleVPCLimitError(ctx context.Context, client *aws.AWSClient) error {
 	}
 
 	fmt.Println("\n📋 Your options:")
-	fmt.Println("  1. Use an existing VPC: Run 'mole up --vpc <vpc-id> --public-subnet <subnet-id>'")
+	fmt.Println("  1. Use an existing VPC: Run 'mole up --vpc <vpc-id>' (a public subnet is picked automatically)")
 	fmt.Println("  2. Delete unused VPCs: Use AWS Console or CLI to delete VPCs you don't need")
 	fmt.Println("  3. Request VPC limit increase: Contact AWS Support to increase your VPC limit")
 	fmt.Println("  4. Use default VPC: If available, specify the default VPC shown above")
@@ -1538,37 +3416,37 @@ func handleVPCLimitError(ctx context.Context, client *aws.AWSClient) error {
 	for _, vpc := range vpcs {
 		if vpc.IsDefault && vpc.State == "available" {
 			fmt.Printf("\n💡 Example using default VPC:\n")
-			fmt.Printf("   mole up --vpc %s --public-subnet <get-from-aws-console>\n", vpc.VpcId)
+			fmt.Printf("   mole up --vpc %s\n", vpc.VpcId)
 			break
 		}
 	}
 
 	fmt.Println("\n💡 To see subnets in a VPC:")
-	fmt.Println("   aws ec2 describe-subnets --filters \"Name=vpc-id,Values=<vpc-id>\" --query 'Subnets[*].[SubnetId,CidrBlock,MapPublicIpOnLaunch]' --output table")
+	fmt.Println("   mole list-vpcs --vpc <vpc-id>")
 
 	return nil
 }
 
-// terminateAWSResources finds and terminates all mole-created AWS resources
-func terminateAWSResources(client *aws.AWSClient) error {
+// terminateAWSResources finds and terminates all mole-created AWS resources, returning the terminated instance IDs
+func terminateAWSResources(client *aws.AWSClient) ([]string, error) {
 	ctx := context.Background()
 
 	// Find all instances created by mole
 	bastionInstances, err := client.FindInstancesByTag(ctx, "CreatedBy", "aws-cloud-mole")
 	if err != nil {
-		return fmt.Errorf("failed to find bastion instances: %w", err)
+		return nil, fmt.Errorf("failed to find bastion instances: %w", err)
 	}
 
 	targetInstances, err := client.FindInstancesByTag(ctx, "Purpose", "nat-bridge-testing")
 	if err != nil {
-		return fmt.Errorf("failed to find target instances: %w", err)
+		return nil, fmt.Errorf("failed to find target instances: %w", err)
 	}
 
 	allInstances := append(bastionInstances, targetInstances...)
 
 	if len(allInstances) == 0 {
 		fmt.Println("  ✅ No AWS instances to terminate")
-		return nil
+		return nil, nil
 	}
 
 	fmt.Printf("  🎯 Found %d instance(s) to terminate:\n", len(allInstances))
@@ -1577,16 +3455,19 @@ func terminateAWSResources(client *aws.AWSClient) error {
 	}
 
 	// Terminate all instances
+	var terminated []string
 	for _, instance := range allInstances {
 		if instance.State == "running" || instance.State == "stopped" {
 			fmt.Printf("  ⏹️  Terminating instance: %s\n", instance.InstanceID)
 			if err := client.TerminateBastion(ctx, instance.InstanceID); err != nil {
 				fmt.Printf("  ⚠️  Warning: failed to terminate %s: %v\n", instance.InstanceID, err)
+				continue
 			}
+			terminated = append(terminated, instance.InstanceID)
 		}
 	}
 
 	fmt.Println("  ✅ AWS resource termination initiated")
 	fmt.Println("  💡 It may take a few minutes for instances to fully terminate")
-	return nil
+	return terminated, nil
 }
@@ -0,0 +1,258 @@
+// Package agent implements the control API that runs on the bastion as `mole agent`.
+// It listens on the first tunnel's bastion address, so it is only reachable over WireGuard,
+// and lets the client manage interfaces, peers and sysctls without re-deploying.
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/research-computing/mole/internal/logger"
+	"github.com/research-computing/mole/internal/version"
+)
+
+// DefaultPort is the agent's TCP port on the bastion tunnel address
+const DefaultPort = 7800
+
+// DefaultAllowedSources are the tunnel networks the agent accepts requests from
+var DefaultAllowedSources = []string{"10.100.0.0/16", "fd6d:6f6c:6500::/48"}
+
+// Config configures the agent server
+type Config struct {
+	ListenAddr     string         // host:port, normally the wg0 bastion address
+	Token          string         // Bearer token shared with the client at deploy time
+	AllowedSources []netip.Prefix // Remote networks allowed to call the API; empty allows all
+	WireGuardDir   string         // wg-quick configs (default /etc/wireguard)
+	ProcDir        string         // procfs root for load and memory (default /proc)
+	SysctlFile     string         // Persisted sysctl overrides (default /etc/sysctl.d/95-mole-agent.conf)
+	TuningFile     string         // Tuning recorded by the bastion user data (default DefaultTuningFile)
+	InstanceID     string         // The bastion's instance, whose key tags follow server key rotations
+	Region         string         // For the tag update; empty leaves it to the AWS CLI
+}
+
+// Runner executes system commands; tests substitute a fake
+type Runner interface {
+	Run(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// ExecRunner runs commands on the host
+type ExecRunner struct{}
+
+// Run executes the command and returns its combined output
+func (ExecRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	output, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return output, fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return output, nil
+}
+
+// Server is the bastion control API
+type Server struct {
+	config Config
+	runner Runner
+	logger *logger.Logger
+
+	mu        sync.Mutex
+	rotations map[string]*RotationStatus // Latest key rotation per interface
+}
+
+// NewServer creates an agent server, filling in default paths
+func NewServer(config Config, runner Runner) *Server {
+	if config.WireGuardDir == "" {
+		config.WireGuardDir = "/etc/wireguard"
+	}
+	if config.ProcDir == "" {
+		config.ProcDir = "/proc"
+	}
+	if config.SysctlFile == "" {
+		config.SysctlFile = "/etc/sysctl.d/95-mole-agent.conf"
+	}
+	if config.TuningFile == "" {
+		config.TuningFile = DefaultTuningFile
+	}
+	if runner == nil {
+		runner = ExecRunner{}
+	}
+
+	s := &Server{config: config, runner: runner, rotations: make(map[string]*RotationStatus)}
+	if l, err := logger.New(logger.Config{Component: "agent", Level: logger.LevelInfo}); err == nil {
+		s.logger = l
+	}
+	return s
+}
+
+// Handler returns the authenticated API routes
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/health", s.handleHealth)
+	mux.HandleFunc("GET /v1/interfaces", s.handleListInterfaces)
+	mux.HandleFunc("POST /v1/interfaces", s.handleAddInterface)
+	mux.HandleFunc("DELETE /v1/interfaces/{name}", s.handleRemoveInterface)
+	mux.HandleFunc("POST /v1/interfaces/{name}/peers", s.handleAddPeer)
+	mux.HandleFunc("DELETE /v1/interfaces/{name}/peers", s.handleRemovePeer)
+	mux.HandleFunc("POST /v1/interfaces/{name}/rotation", s.handleStartRotation)
+	mux.HandleFunc("GET /v1/interfaces/{name}/rotation", s.handleGetRotation)
+	mux.HandleFunc("POST /v1/interfaces/{name}/rotation/server", s.handleStartServerRotation)
+	mux.HandleFunc("GET /v1/system", s.handleSystem)
+	mux.HandleFunc("PUT /v1/sysctl", s.handleSysctl)
+	mux.HandleFunc("GET /v1/tuning", s.handleTuning)
+	mux.HandleFunc("GET /v1/logs", s.handleLogs)
+	return s.authenticate(mux)
+}
+
+// ListenAndServe serves the API until ctx is cancelled
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	if s.config.Token == "" {
+		return fmt.Errorf("agent token is required")
+	}
+
+	server := &http.Server{
+		Addr:              s.config.ListenAddr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	if s.logger != nil {
+		s.logger.Info("Agent listening", "addr", s.config.ListenAddr)
+	}
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("agent server failed: %w", err)
+	}
+	return nil
+}
+
+// authenticate rejects requests from outside the tunnel networks or without the bearer token
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.sourceAllowed(r.RemoteAddr) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("source %s is not a tunnel address", r.RemoteAddr))
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Token)) != 1 {
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid or missing token"))
+			return
+		}
+
+		if s.logger != nil {
+			s.logger.Info("Request", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sourceAllowed checks the remote address against AllowedSources
+func (s *Server) sourceAllowed(remoteAddr string) bool {
+	if len(s.config.AllowedSources) == 0 {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, prefix := range s.config.AllowedSources {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// HealthResponse is returned by GET /v1/health
+type HealthResponse struct {
+	Status  string `json:"status"`
+	Version string `json:"version"`
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, HealthResponse{Status: "ok", Version: version.Version})
+}
+
+// GenerateToken returns a random API token
+func GenerateToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate agent token: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// ReadTokenFile reads a token written by the bastion user data
+func ReadTokenFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read agent token: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("agent token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// ParseAllowedSources parses CIDR strings for Config.AllowedSources
+func ParseAllowedSources(cidrs []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed source %q: %w", cidr, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// errorResponse is the body of every non-2xx response
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// decodeJSON decodes a request body, rejecting unknown fields
+func decodeJSON(r *http.Request, v any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
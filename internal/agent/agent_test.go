@@ -0,0 +1,372 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testPublicKey = "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+
+// fakeRunner records commands and returns canned output keyed by command name
+type fakeRunner struct {
+	mu       sync.Mutex
+	commands []string
+	outputs  map[string]string
+}
+
+func (f *fakeRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	command := strings.Join(append([]string{name}, args...), " ")
+	f.commands = append(f.commands, command)
+	return []byte(f.outputs[name]), nil
+}
+
+// history returns the commands run so far
+func (f *fakeRunner) history() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.commands...)
+}
+
+func newTestAgent(t *testing.T) (*Client, *fakeRunner, Config) {
+	t.Helper()
+	dir := t.TempDir()
+	config := Config{
+		Token:        "secret",
+		WireGuardDir: filepath.Join(dir, "wireguard"),
+		ProcDir:      filepath.Join(dir, "proc"),
+		SysctlFile:   filepath.Join(dir, "sysctl.d", "95-mole-agent.conf"),
+		TuningFile:   filepath.Join(dir, "tuning.json"),
+	}
+	os.MkdirAll(config.WireGuardDir, 0700)
+	os.MkdirAll(config.ProcDir, 0755)
+
+	runner := &fakeRunner{outputs: map[string]string{}}
+	server := httptest.NewServer(NewServer(config, runner).Handler())
+	t.Cleanup(server.Close)
+
+	return NewClient(server.URL, "secret"), runner, config
+}
+
+func TestAuthentication(t *testing.T) {
+	client, _, _ := newTestAgent(t)
+
+	if _, err := client.Health(context.Background()); err != nil {
+		t.Fatalf("Health with valid token failed: %v", err)
+	}
+
+	client.Token = "wrong"
+	_, err := client.Health(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Expected 401 with wrong token, got %v", err)
+	}
+}
+
+func TestSourceAllowed(t *testing.T) {
+	sources, err := ParseAllowedSources(DefaultAllowedSources)
+	if err != nil {
+		t.Fatalf("ParseAllowedSources failed: %v", err)
+	}
+	server := NewServer(Config{Token: "secret", AllowedSources: sources}, &fakeRunner{})
+
+	tests := []struct {
+		remote  string
+		allowed bool
+	}{
+		{"10.100.1.2:51000", true},
+		{"[fd6d:6f6c:6500:1::2]:51000", true},
+		{"[::ffff:10.100.3.2]:51000", true},
+		{"203.0.113.5:51000", false},
+		{"10.0.2.15:51000", false},
+		{"garbage", false},
+	}
+	for _, test := range tests {
+		if got := server.sourceAllowed(test.remote); got != test.allowed {
+			t.Errorf("sourceAllowed(%s) = %v, expected %v", test.remote, got, test.allowed)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/health", nil)
+	req.RemoteAddr = "203.0.113.5:51000"
+	req.Header.Set("Authorization", "Bearer secret")
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 from a non-tunnel source, got %d", recorder.Code)
+	}
+}
+
+func TestInterfaceLifecycle(t *testing.T) {
+	client, runner, config := newTestAgent(t)
+	ctx := context.Background()
+
+	created, err := client.AddInterface(ctx, InterfaceRequest{Name: "wg3", Address: "10.100.4.1/24", ListenPort: 51823})
+	if err != nil {
+		t.Fatalf("AddInterface failed: %v", err)
+	}
+	if created.PublicKey == "" || created.ListenPort != 51823 {
+		t.Errorf("Unexpected created interface: %+v", created)
+	}
+
+	data, err := os.ReadFile(filepath.Join(config.WireGuardDir, "wg3.conf"))
+	if err != nil {
+		t.Fatalf("Interface config not written: %v", err)
+	}
+	if !strings.Contains(string(data), "Address = 10.100.4.1/24\nListenPort = 51823\n") {
+		t.Errorf("Unexpected interface config:\n%s", data)
+	}
+
+	if _, err := client.AddInterface(ctx, InterfaceRequest{Name: "wg3", Address: "10.100.4.1/24", ListenPort: 51823}); err == nil {
+		t.Error("Adding an existing interface should fail")
+	}
+
+	if err := client.AddPeer(ctx, "wg3", PeerRequest{PublicKey: testPublicKey, AllowedIPs: []string{"10.100.4.2/32"}}); err != nil {
+		t.Fatalf("AddPeer failed: %v", err)
+	}
+	if err := client.RemovePeer(ctx, "wg3", testPublicKey); err != nil {
+		t.Fatalf("RemovePeer failed: %v", err)
+	}
+	if err := client.RemoveInterface(ctx, "wg3"); err != nil {
+		t.Fatalf("RemoveInterface failed: %v", err)
+	}
+
+	expected := []string{
+		"wg-quick up wg3",
+		"iptables -A INPUT -p udp --dport 51823 -j ACCEPT",
+		"iptables -A FORWARD -i wg3 -j ACCEPT",
+		"iptables -A FORWARD -o wg3 -j ACCEPT",
+		"wg set wg3 peer " + testPublicKey + " allowed-ips 10.100.4.2/32",
+		"wg-quick save wg3",
+		"wg set wg3 peer " + testPublicKey + " remove",
+		"wg-quick save wg3",
+		"wg-quick down wg3",
+		"iptables -D INPUT -p udp --dport 51823 -j ACCEPT",
+		"iptables -D FORWARD -i wg3 -j ACCEPT",
+		"iptables -D FORWARD -o wg3 -j ACCEPT",
+	}
+	if strings.Join(runner.commands, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected commands:\n%s", strings.Join(runner.commands, "\n"))
+	}
+	if _, err := os.Stat(filepath.Join(config.WireGuardDir, "wg3.conf")); !os.IsNotExist(err) {
+		t.Error("Interface config should be removed")
+	}
+}
+
+func TestAddPeerPresharedKey(t *testing.T) {
+	client, runner, config := newTestAgent(t)
+	os.WriteFile(filepath.Join(config.WireGuardDir, "wg0.conf"), []byte("[Interface]\n"), 0600)
+	ctx := context.Background()
+
+	if err := client.AddPeer(ctx, "wg0", PeerRequest{PublicKey: testPublicKey, AllowedIPs: []string{"10.100.1.3/32"}, PresharedKey: "not-a-key"}); err == nil {
+		t.Error("Expected invalid pre-shared key to be rejected")
+	}
+	if err := client.AddPeer(ctx, "wg0", PeerRequest{PublicKey: testPublicKey, AllowedIPs: []string{"10.100.1.3/32"}, PresharedKey: testPublicKey}); err != nil {
+		t.Fatalf("AddPeer failed: %v", err)
+	}
+
+	prefix := "wg set wg0 peer " + testPublicKey + " allowed-ips 10.100.1.3/32 preshared-key " + config.WireGuardDir + "/.psk-"
+	if len(runner.commands) == 0 || !strings.HasPrefix(runner.commands[0], prefix) {
+		t.Fatalf("Expected the PSK to be passed as a file, got:\n%s", strings.Join(runner.commands, "\n"))
+	}
+	keyFile := strings.TrimPrefix(runner.commands[0], "wg set wg0 peer "+testPublicKey+" allowed-ips 10.100.1.3/32 preshared-key ")
+	if _, err := os.Stat(keyFile); !os.IsNotExist(err) {
+		t.Error("PSK file should be removed after use")
+	}
+}
+
+func TestInterfaceValidation(t *testing.T) {
+	client, runner, config := newTestAgent(t)
+	ctx := context.Background()
+
+	invalid := []InterfaceRequest{
+		{Name: "eth0", Address: "10.100.4.1/24", ListenPort: 51823},
+		{Name: "wg3", Address: "10.100.4.1", ListenPort: 51823},
+		{Name: "wg3", Address: "10.100.4.1/24", ListenPort: 22},
+	}
+	for _, req := range invalid {
+		if _, err := client.AddInterface(ctx, req); err == nil {
+			t.Errorf("Expected %+v to be rejected", req)
+		}
+	}
+
+	if err := client.AddPeer(ctx, "wg9", PeerRequest{PublicKey: testPublicKey, AllowedIPs: []string{"10.100.4.2/32"}}); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Expected 404 for unknown interface, got %v", err)
+	}
+
+	os.WriteFile(filepath.Join(config.WireGuardDir, "wg0.conf"), []byte("[Interface]\n"), 0600)
+	if err := client.AddPeer(ctx, "wg0", PeerRequest{PublicKey: "not-a-key", AllowedIPs: []string{"10.100.1.2/32"}}); err == nil {
+		t.Error("Invalid public key should be rejected")
+	}
+	if err := client.RemoveInterface(ctx, "wg0"); err == nil || !strings.Contains(err.Error(), "409") {
+		t.Errorf("Removing the agent's interface should be refused, got %v", err)
+	}
+
+	if len(runner.commands) != 0 {
+		t.Errorf("Rejected requests should not run commands: %v", runner.commands)
+	}
+}
+
+func TestParseWireGuardDump(t *testing.T) {
+	dump := "wg0\tprivate\tserver-public\t51820\toff\n" +
+		"wg0\tpeer-public\t(none)\t198.51.100.7:51821\t10.100.1.2/32,fd6d:6f6c:6500:1::2/128\t1700000000\t1024\t2048\t25\n" +
+		"wg1\tprivate\tserver-public-1\t51821\toff\n"
+
+	interfaces, err := parseWireGuardDump(dump)
+	if err != nil {
+		t.Fatalf("parseWireGuardDump failed: %v", err)
+	}
+	if len(interfaces) != 2 || interfaces[0].ListenPort != 51820 || len(interfaces[1].Peers) != 0 {
+		t.Fatalf("Unexpected interfaces: %+v", interfaces)
+	}
+
+	peer := interfaces[0].Peers[0]
+	if peer.Endpoint != "198.51.100.7:51821" || len(peer.AllowedIPs) != 2 || peer.RxBytes != 1024 || peer.TxBytes != 2048 {
+		t.Errorf("Unexpected peer: %+v", peer)
+	}
+	if peer.LatestHandshake.Unix() != 1700000000 {
+		t.Errorf("Unexpected handshake time: %v", peer.LatestHandshake)
+	}
+
+	if _, err := parseWireGuardDump("wg0\tbroken\n"); err == nil {
+		t.Error("Malformed dump should fail")
+	}
+}
+
+func TestSystemStats(t *testing.T) {
+	client, _, config := newTestAgent(t)
+	os.WriteFile(filepath.Join(config.ProcDir, "loadavg"), []byte("0.52 0.31 0.20 1/123 4567\n"), 0644)
+	os.WriteFile(filepath.Join(config.ProcDir, "uptime"), []byte("3600.25 7000.00\n"), 0644)
+	os.WriteFile(filepath.Join(config.ProcDir, "meminfo"), []byte("MemTotal:        2000000 kB\nMemFree:          500000 kB\nMemAvailable:    1500000 kB\n"), 0644)
+
+	stats, err := client.System(context.Background())
+	if err != nil {
+		t.Fatalf("System failed: %v", err)
+	}
+	if stats.Load1 != 0.52 || stats.Load15 != 0.20 || stats.UptimeSeconds != 3600.25 {
+		t.Errorf("Unexpected load/uptime: %+v", stats)
+	}
+	if stats.MemoryTotalKB != 2000000 || stats.MemoryAvailableKB != 1500000 {
+		t.Errorf("Unexpected memory: %+v", stats)
+	}
+}
+
+func TestSysctl(t *testing.T) {
+	client, runner, config := newTestAgent(t)
+	ctx := context.Background()
+
+	settings := map[string]string{"net.core.rmem_max": "134217728", "net.ipv4.tcp_congestion_control": "bbr"}
+	if err := client.SetSysctls(ctx, settings); err != nil {
+		t.Fatalf("SetSysctls failed: %v", err)
+	}
+	if err := client.SetSysctls(ctx, map[string]string{"net.core.rmem_max": "268435456"}); err != nil {
+		t.Fatalf("SetSysctls failed: %v", err)
+	}
+
+	if runner.commands[0] != "sysctl -w net.core.rmem_max=134217728" {
+		t.Errorf("Unexpected sysctl command: %v", runner.commands)
+	}
+
+	data, _ := os.ReadFile(config.SysctlFile)
+	expected := "# Managed by mole agent\nnet.core.rmem_max = 268435456\nnet.ipv4.tcp_congestion_control = bbr\n"
+	if string(data) != expected {
+		t.Errorf("Unexpected persisted sysctls:\n%s", data)
+	}
+
+	for _, bad := range []map[string]string{
+		{"kernel.core_pattern": "|/tmp/x"},
+		{"net.core.rmem_max": "1; reboot"},
+	} {
+		if err := client.SetSysctls(ctx, bad); err == nil {
+			t.Errorf("Expected %v to be rejected", bad)
+		}
+	}
+}
+
+func TestTuningReport(t *testing.T) {
+	client, _, config := newTestAgent(t)
+
+	if _, err := client.Tuning(context.Background()); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Expected 404 before tuning is recorded, got %v", err)
+	}
+
+	recorded := `{"sysctls": {"net.core.rmem_max": "134217728", "net.ipv4.tcp_rmem": "4096 87380 134217728", "net.ipv4.tcp_congestion_control": "bbr"},
+	  "cpu_governor": "performance", "spread_queues": true, "cpus": 4, "rx_queues": 4, "irq_queues": 4}`
+	os.WriteFile(config.TuningFile, []byte(recorded), 0644)
+	for key, value := range map[string]string{
+		"net/core/rmem_max":               "134217728\n",
+		"net/ipv4/tcp_rmem":               "4096\t87380\t134217728\n",
+		"net/ipv4/tcp_congestion_control": "cubic\n",
+	} {
+		path := filepath.Join(config.ProcDir, "sys", key)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(value), 0644)
+	}
+
+	report, err := client.Tuning(context.Background())
+	if err != nil {
+		t.Fatalf("Tuning failed: %v", err)
+	}
+	if len(report.Sysctls) != 3 || report.RxQueues != 4 || !report.SpreadQueues {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+
+	applied := make(map[string]bool)
+	for _, sysctl := range report.Sysctls {
+		applied[sysctl.Key] = sysctl.Applied
+	}
+	if !applied["net.core.rmem_max"] || !applied["net.ipv4.tcp_rmem"] {
+		t.Errorf("Matching values (after whitespace normalisation) should be applied: %+v", report.Sysctls)
+	}
+	if applied["net.ipv4.tcp_congestion_control"] {
+		t.Error("cubic should not satisfy a bbr request")
+	}
+}
+
+func TestLogs(t *testing.T) {
+	client, runner, _ := newTestAgent(t)
+	runner.outputs["journalctl"] = "line one\nline two\n"
+
+	logs, err := client.Logs(context.Background(), "agent", 50)
+	if err != nil {
+		t.Fatalf("Logs failed: %v", err)
+	}
+	if len(logs.Lines) != 2 || logs.Lines[1] != "line two" {
+		t.Errorf("Unexpected log lines: %v", logs.Lines)
+	}
+	if runner.commands[0] != "journalctl -u mole-agent -n 50 --no-pager" {
+		t.Errorf("Unexpected log command: %s", runner.commands[0])
+	}
+
+	if _, err := client.Logs(context.Background(), "/etc/shadow", 10); err == nil {
+		t.Error("Unknown log source should be rejected")
+	}
+}
+
+func TestSaveLoadToken(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	if err := SaveToken("i-0123456789abcdef0", "token-value"); err != nil {
+		t.Fatalf("SaveToken failed: %v", err)
+	}
+	info, err := os.Stat(TokenPath("i-0123456789abcdef0"))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Token file should be 0600: %v %v", info, err)
+	}
+
+	token, err := LoadToken("i-0123456789abcdef0")
+	if err != nil || token != "token-value" {
+		t.Errorf("LoadToken = %q, %v", token, err)
+	}
+
+	instances, err := SavedInstances()
+	if err != nil || len(instances) != 1 || instances[0] != "i-0123456789abcdef0" {
+		t.Errorf("SavedInstances = %v, %v", instances, err)
+	}
+}
@@ -0,0 +1,221 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls a bastion agent over the tunnel
+type Client struct {
+	BaseURL    string // e.g. http://10.100.1.1:7800
+	Token      string
+	HTTPClient *http.Client
+}
+
+// NewClient creates an agent client for a base URL and token
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Health checks that the agent is up
+func (c *Client) Health(ctx context.Context) (*HealthResponse, error) {
+	var health HealthResponse
+	if err := c.do(ctx, http.MethodGet, "/v1/health", nil, &health); err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+// Interfaces returns WireGuard interface and peer statistics
+func (c *Client) Interfaces(ctx context.Context) ([]InterfaceStats, error) {
+	var interfaces []InterfaceStats
+	if err := c.do(ctx, http.MethodGet, "/v1/interfaces", nil, &interfaces); err != nil {
+		return nil, err
+	}
+	return interfaces, nil
+}
+
+// AddInterface creates a WireGuard interface and returns its public key
+func (c *Client) AddInterface(ctx context.Context, req InterfaceRequest) (*InterfaceStats, error) {
+	var created InterfaceStats
+	if err := c.do(ctx, http.MethodPost, "/v1/interfaces", req, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// RemoveInterface tears down a WireGuard interface
+func (c *Client) RemoveInterface(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/v1/interfaces/"+url.PathEscape(name), nil, nil)
+}
+
+// AddPeer adds or updates a peer on an interface
+func (c *Client) AddPeer(ctx context.Context, iface string, req PeerRequest) error {
+	return c.do(ctx, http.MethodPost, "/v1/interfaces/"+url.PathEscape(iface)+"/peers", req, nil)
+}
+
+// RemovePeer removes a peer from an interface
+func (c *Client) RemovePeer(ctx context.Context, iface, publicKey string) error {
+	path := "/v1/interfaces/" + url.PathEscape(iface) + "/peers?public_key=" + url.QueryEscape(publicKey)
+	return c.do(ctx, http.MethodDelete, path, nil, nil)
+}
+
+// StartRotation starts replacing a client key on an interface; poll Rotation for the outcome
+func (c *Client) StartRotation(ctx context.Context, iface string, req RotationRequest) (*RotationStatus, error) {
+	var status RotationStatus
+	if err := c.do(ctx, http.MethodPost, "/v1/interfaces/"+url.PathEscape(iface)+"/rotation", req, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// StartServerRotation starts replacing the bastion's own key on an interface. The returned
+// status carries the new public key, which the client must switch its peer to.
+func (c *Client) StartServerRotation(ctx context.Context, iface string, req ServerRotationRequest) (*RotationStatus, error) {
+	var status RotationStatus
+	if err := c.do(ctx, http.MethodPost, "/v1/interfaces/"+url.PathEscape(iface)+"/rotation/server", req, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Rotation returns the state of the latest key rotation on an interface
+func (c *Client) Rotation(ctx context.Context, iface string) (*RotationStatus, error) {
+	var status RotationStatus
+	if err := c.do(ctx, http.MethodGet, "/v1/interfaces/"+url.PathEscape(iface)+"/rotation", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// System returns bastion load and memory
+func (c *Client) System(ctx context.Context) (*SystemStats, error) {
+	var stats SystemStats
+	if err := c.do(ctx, http.MethodGet, "/v1/system", nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// SetSysctls applies and persists kernel parameters
+func (c *Client) SetSysctls(ctx context.Context, settings map[string]string) error {
+	return c.do(ctx, http.MethodPut, "/v1/sysctl", SysctlRequest{Settings: settings}, nil)
+}
+
+// Tuning reports the kernel tuning applied at deploy time and whether it is still in effect
+func (c *Client) Tuning(ctx context.Context) (*TuningReport, error) {
+	var report TuningReport
+	if err := c.do(ctx, http.MethodGet, "/v1/tuning", nil, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// Logs returns the last lines of a log source (cloud-init, agent or kernel)
+func (c *Client) Logs(ctx context.Context, source string, lines int) (*LogsResponse, error) {
+	query := url.Values{"source": {source}}
+	if lines > 0 {
+		query.Set("lines", strconv.Itoa(lines))
+	}
+
+	var logs LogsResponse
+	if err := c.do(ctx, http.MethodGet, "/v1/logs?"+query.Encode(), nil, &logs); err != nil {
+		return nil, err
+	}
+	return &logs, nil
+}
+
+// do sends an authenticated request and decodes the JSON response into out (if non-nil)
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("agent request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr errorResponse
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("agent returned %d: %s", resp.StatusCode, apiErr.Error)
+		}
+		return fmt.Errorf("agent returned %d", resp.StatusCode)
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode agent response: %w", err)
+		}
+	}
+	return nil
+}
+
+// TokenPath is where the client keeps the agent token for a bastion
+func TokenPath(instanceID string) string {
+	return filepath.Join(tokenDir(), instanceID+".token")
+}
+
+func tokenDir() string {
+	return filepath.Join(os.Getenv("HOME"), ".mole", "agent")
+}
+
+// SaveToken stores a bastion's agent token readable only by the current user
+func SaveToken(instanceID, token string) error {
+	path := TokenPath(instanceID)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create agent token directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to save agent token: %w", err)
+	}
+	return nil
+}
+
+// SavedInstances lists the bastions with a saved agent token
+func SavedInstances() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(tokenDir(), "*.token"))
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]string, 0, len(paths))
+	for _, path := range paths {
+		instances = append(instances, strings.TrimSuffix(filepath.Base(path), ".token"))
+	}
+	return instances, nil
+}
+
+// LoadToken reads the agent token saved for a bastion
+func LoadToken(instanceID string) (string, error) {
+	return ReadTokenFile(TokenPath(instanceID))
+}
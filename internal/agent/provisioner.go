@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/research-computing/mole/internal/tunnel"
+)
+
+// provisionTimeout bounds each far-end change made for the tunnel manager
+const provisionTimeout = time.Minute
+
+// TunnelProvisioner creates and removes the bastion end of tunnels through the agent, so
+// the tunnel manager can add tunnels after deployment
+type TunnelProvisioner struct {
+	Client *Client
+	Host   string // Bastion address the client endpoint points at
+}
+
+// Provision creates the bastion interface and admits this host as its peer. The tunnel
+// networks are NATed on the bastion, since the VPC route tables only know the deployed ones.
+func (p *TunnelProvisioner) Provision(req tunnel.ProvisionRequest) (*tunnel.ProvisionedPeer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), provisionTimeout)
+	defer cancel()
+
+	created, err := p.Client.AddInterface(ctx, InterfaceRequest{
+		Name:       req.Interface,
+		Address:    req.ServerAddress,
+		ListenPort: req.Port,
+		Masquerade: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var allowedIPs []string
+	for _, address := range strings.Split(req.ClientAddress, ",") {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(address))
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("invalid client address %q: %w", address, err), p.Client.RemoveInterface(ctx, req.Interface))
+		}
+		allowedIPs = append(allowedIPs, netip.PrefixFrom(prefix.Addr(), prefix.Addr().BitLen()).String())
+	}
+	if err := p.Client.AddPeer(ctx, req.Interface, PeerRequest{PublicKey: req.PublicKey, AllowedIPs: allowedIPs}); err != nil {
+		return nil, errors.Join(err, p.Client.RemoveInterface(ctx, req.Interface))
+	}
+
+	return &tunnel.ProvisionedPeer{
+		PublicKey: created.PublicKey,
+		Endpoint:  net.JoinHostPort(p.Host, strconv.Itoa(req.Port)),
+	}, nil
+}
+
+// Deprovision removes the bastion interface along with its peer and NAT rules
+func (p *TunnelProvisioner) Deprovision(iface string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), provisionTimeout)
+	defer cancel()
+	return p.Client.RemoveInterface(ctx, iface)
+}
@@ -0,0 +1,60 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/research-computing/mole/internal/tunnel"
+)
+
+func TestTunnelProvisioner(t *testing.T) {
+	client, runner, config := newTestAgent(t)
+	provisioner := &TunnelProvisioner{Client: client, Host: "203.0.113.10"}
+
+	peer, err := provisioner.Provision(tunnel.ProvisionRequest{
+		ID:            4,
+		Interface:     "wg4",
+		Port:          51824,
+		ServerAddress: "10.100.5.1/24, fd6d:6f6c:6500:5::1/64",
+		ClientAddress: "10.100.5.2/24, fd6d:6f6c:6500:5::2/64",
+		PublicKey:     testPublicKey,
+	})
+	if err != nil {
+		t.Fatalf("Provision failed: %v", err)
+	}
+	if peer.PublicKey == "" || peer.Endpoint != "203.0.113.10:51824" {
+		t.Errorf("Unexpected peer %+v", peer)
+	}
+
+	// Only this host's addresses are routed to the peer, and only the IPv4 network is NATed
+	data, err := os.ReadFile(filepath.Join(config.WireGuardDir, "wg4.conf"))
+	if err != nil {
+		t.Fatalf("Interface config not written: %v", err)
+	}
+	rule := "POSTROUTING -s 10.100.5.0/24 ! -o wg4 -j MASQUERADE"
+	if !strings.Contains(string(data), "PostUp = iptables -t nat -A "+rule+"\nPostDown = iptables -t nat -D "+rule+"\n") || strings.Count(string(data), "PostUp") != 1 {
+		t.Errorf("Unexpected interface config:\n%s", data)
+	}
+	addPeer := "wg set wg4 peer " + testPublicKey + " allowed-ips 10.100.5.2/32,fd6d:6f6c:6500:5::2/128"
+	if !strings.Contains(strings.Join(runner.history(), "\n"), addPeer) {
+		t.Errorf("Peer not added as expected:\n%s", strings.Join(runner.history(), "\n"))
+	}
+
+	if err := provisioner.Deprovision("wg4"); err != nil {
+		t.Fatalf("Deprovision failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(config.WireGuardDir, "wg4.conf")); !os.IsNotExist(err) {
+		t.Error("Interface config should be removed")
+	}
+
+	// A rejected peer takes the new interface down again
+	_, err = provisioner.Provision(tunnel.ProvisionRequest{Interface: "wg5", Port: 51825, ServerAddress: "10.100.6.1/24", ClientAddress: "10.100.6.2/24", PublicKey: "not-a-key"})
+	if err == nil {
+		t.Fatal("Expected an invalid public key to fail")
+	}
+	if _, err := os.Stat(filepath.Join(config.WireGuardDir, "wg5.conf")); !os.IsNotExist(err) {
+		t.Error("Interface should be removed after the peer is rejected")
+	}
+}
@@ -0,0 +1,442 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/research-computing/mole/internal/tunnel"
+)
+
+// Rotation states reported by GET /v1/interfaces/{name}/rotation
+const (
+	RotationPending    = "pending"     // Waiting for the client to switch keys
+	RotationSwapped    = "swapped"     // New key holds the allowed IPs; waiting for its handshake
+	RotationCompleted  = "completed"   // Handshake seen, old key removed
+	RotationRolledBack = "rolled_back" // No handshake in time; the old key is restored
+)
+
+// DefaultRotationTimeout bounds how long the bastion waits for the new key's handshake
+const DefaultRotationTimeout = 2 * time.Minute
+
+// rotationSwapDelay gives the client time to read the 202 before the allowed IPs move away
+// from the key it is still using
+var rotationSwapDelay = 2 * time.Second
+
+// keyTagPattern limits the instance tags a server key rotation may write to mole's own
+var keyTagPattern = regexp.MustCompile(`^WireGuardPublicKey[0-9]*$`)
+
+// rotationPollInterval is how often the bastion checks for the new key's handshake
+var rotationPollInterval = 2 * time.Second
+
+// RotationRequest replaces a client key on an interface
+type RotationRequest struct {
+	OldPublicKey   string `json:"old_public_key"`
+	NewPublicKey   string `json:"new_public_key"`
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty"` // Default DefaultRotationTimeout
+
+	// NewPresharedKey replaces the peer's PSK along with its key; empty leaves the new peer without one
+	NewPresharedKey string `json:"new_preshared_key,omitempty"`
+}
+
+// ServerRotationRequest replaces the bastion's own key on an interface. Every peer must
+// switch to the new public key, so only an interface with the requesting peer alone qualifies.
+type ServerRotationRequest struct {
+	OldPublicKey   string `json:"old_public_key"`  // The bastion key the client config has
+	PeerPublicKey  string `json:"peer_public_key"` // The client whose handshake confirms the new key
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty"`
+
+	// KeyTag is the instance tag the new public key is published under, as at boot
+	KeyTag string `json:"key_tag,omitempty"`
+}
+
+// RotationStatus tracks the latest key rotation on an interface
+type RotationStatus struct {
+	Interface    string    `json:"interface"`
+	Server       bool      `json:"server,omitempty"` // The bastion's key rather than a client key
+	OldPublicKey string    `json:"old_public_key"`
+	NewPublicKey string    `json:"new_public_key"`
+	State        string    `json:"state"`
+	Error        string    `json:"error,omitempty"`
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at,omitempty"`
+}
+
+// Done reports whether the rotation has finished either way
+func (r RotationStatus) Done() bool {
+	return r.State == RotationCompleted || r.State == RotationRolledBack
+}
+
+// handleStartRotation introduces the new key as a peer and returns immediately. The swap
+// happens in the background because the caller may be talking to us over the rotated peer.
+func (s *Server) handleStartRotation(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !s.knownInterface(w, name) {
+		return
+	}
+
+	var req RotationRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	for _, key := range []string{req.OldPublicKey, req.NewPublicKey} {
+		if err := validatePublicKey(key); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if req.NewPresharedKey != "" {
+		if err := validatePresharedKey(req.NewPresharedKey); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if req.OldPublicKey == req.NewPublicKey {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("new key must differ from the old key"))
+		return
+	}
+	timeout := DefaultRotationTimeout
+	if req.TimeoutSeconds > 0 {
+		timeout = time.Duration(req.TimeoutSeconds) * time.Second
+	}
+
+	old, err := s.findPeer(r.Context(), name, req.OldPublicKey)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if old == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("peer %s not found on %s", req.OldPublicKey, name))
+		return
+	}
+	if len(old.AllowedIPs) == 0 {
+		writeError(w, http.StatusConflict, fmt.Errorf("peer %s has no allowed IPs to move", req.OldPublicKey))
+		return
+	}
+
+	s.mu.Lock()
+	if current, ok := s.rotations[name]; ok && !current.Done() {
+		s.mu.Unlock()
+		writeError(w, http.StatusConflict, fmt.Errorf("a key rotation is already in progress on %s", name))
+		return
+	}
+	status := &RotationStatus{
+		Interface:    name,
+		OldPublicKey: req.OldPublicKey,
+		NewPublicKey: req.NewPublicKey,
+		State:        RotationPending,
+		StartedAt:    time.Now().UTC(),
+	}
+	s.rotations[name] = status
+	snapshot := *status
+	s.mu.Unlock()
+
+	go s.rotate(name, *old, req.NewPublicKey, req.NewPresharedKey, timeout)
+
+	writeJSON(w, http.StatusAccepted, snapshot)
+}
+
+func (s *Server) handleGetRotation(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !s.knownInterface(w, name) {
+		return
+	}
+
+	s.mu.Lock()
+	status, ok := s.rotations[name]
+	var snapshot RotationStatus
+	if ok {
+		snapshot = *status
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no key rotation on %s", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+// rotate moves the old peer's allowed IPs and endpoint to the new key (and PSK), waits for the
+// new key's handshake, then removes the old peer, or restores it if the client never switched.
+func (s *Server) rotate(name string, old PeerStats, newKey, newPSK string, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), rotationSwapDelay+timeout+30*time.Second)
+	defer cancel()
+
+	started := s.rotationStarted(name)
+	time.Sleep(rotationSwapDelay)
+
+	args := []string{"set", name, "peer", newKey, "allowed-ips", strings.Join(old.AllowedIPs, ",")}
+	if old.Endpoint != "" {
+		args = append(args, "endpoint", old.Endpoint)
+	}
+	err := s.withKeyFile(newPSK, func(pskArgs []string) error {
+		_, err := s.runner.Run(ctx, "wg", append(args, pskArgs...)...)
+		return err
+	})
+	if err != nil {
+		s.finishRotation(name, RotationRolledBack, fmt.Errorf("failed to add new key: %w", err))
+		return
+	}
+	s.setRotationState(name, RotationSwapped)
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		peer, err := s.findPeer(ctx, name, newKey)
+		// Handshake times have one-second resolution
+		if err == nil && peer != nil && !peer.LatestHandshake.Before(started.Truncate(time.Second)) {
+			if _, err := s.runner.Run(ctx, "wg", "set", name, "peer", old.PublicKey, "remove"); err != nil {
+				s.finishRotation(name, RotationCompleted, fmt.Errorf("new key is live but the old key could not be removed: %w", err))
+				return
+			}
+			var saveErr error
+			if _, err := s.runner.Run(ctx, "wg-quick", "save", name); err != nil {
+				saveErr = fmt.Errorf("new key is live but not saved: %w", err)
+			}
+			s.finishRotation(name, RotationCompleted, saveErr)
+			return
+		}
+		time.Sleep(rotationPollInterval)
+	}
+
+	// Give the allowed IPs back to the old key before dropping the new one
+	s.runner.Run(ctx, "wg", "set", name, "peer", old.PublicKey, "allowed-ips", strings.Join(old.AllowedIPs, ","))
+	s.runner.Run(ctx, "wg", "set", name, "peer", newKey, "remove")
+	s.finishRotation(name, RotationRolledBack, fmt.Errorf("no handshake from the new key within %s", timeout))
+}
+
+// handleStartServerRotation generates a new key for the interface and returns its public
+// half at once, so the client can switch its peer before the bastion starts using it
+func (s *Server) handleStartServerRotation(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !s.knownInterface(w, name) {
+		return
+	}
+
+	var req ServerRotationRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	for _, key := range []string{req.OldPublicKey, req.PeerPublicKey} {
+		if err := validatePublicKey(key); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if req.KeyTag != "" && !keyTagPattern.MatchString(req.KeyTag) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid key tag %q", req.KeyTag))
+		return
+	}
+	timeout := DefaultRotationTimeout
+	if req.TimeoutSeconds > 0 {
+		timeout = time.Duration(req.TimeoutSeconds) * time.Second
+	}
+
+	iface, oldPrivateKey, err := s.findInterface(r.Context(), name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if oldPrivateKey == "" || oldPrivateKey == "(none)" {
+		writeError(w, http.StatusConflict, fmt.Errorf("%s has no private key to replace", name))
+		return
+	}
+	if iface.PublicKey != req.OldPublicKey {
+		writeError(w, http.StatusConflict, fmt.Errorf("the bastion key on %s is %s, not %s", name, iface.PublicKey, req.OldPublicKey))
+		return
+	}
+	if !slices.ContainsFunc(iface.Peers, func(peer PeerStats) bool { return peer.PublicKey == req.PeerPublicKey }) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("peer %s not found on %s", req.PeerPublicKey, name))
+		return
+	}
+	if others := len(iface.Peers) - 1; others > 0 {
+		writeError(w, http.StatusConflict, fmt.Errorf("%s has %d other peer(s), which would lose the tunnel until their configs have the new bastion key", name, others))
+		return
+	}
+
+	newPrivateKey, newPublicKey, err := tunnel.GenerateWireGuardKeys()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.mu.Lock()
+	if current, ok := s.rotations[name]; ok && !current.Done() {
+		s.mu.Unlock()
+		writeError(w, http.StatusConflict, fmt.Errorf("a key rotation is already in progress on %s", name))
+		return
+	}
+	status := &RotationStatus{
+		Interface:    name,
+		Server:       true,
+		OldPublicKey: iface.PublicKey,
+		NewPublicKey: newPublicKey,
+		State:        RotationPending,
+		StartedAt:    time.Now().UTC(),
+	}
+	s.rotations[name] = status
+	snapshot := *status
+	s.mu.Unlock()
+
+	go s.rotateServer(name, oldPrivateKey, newPrivateKey, req, timeout)
+
+	writeJSON(w, http.StatusAccepted, snapshot)
+}
+
+// rotateServer switches the interface to the new private key, waits for the client's
+// handshake with it, then saves the config and republishes the public key, or puts the old
+// private key back if the client never switched
+func (s *Server) rotateServer(name, oldPrivateKey, newPrivateKey string, req ServerRotationRequest, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), rotationSwapDelay+timeout+30*time.Second)
+	defer cancel()
+
+	time.Sleep(rotationSwapDelay)
+	swapped := time.Now()
+	if err := s.setPrivateKey(ctx, name, newPrivateKey); err != nil {
+		s.finishRotation(name, RotationRolledBack, fmt.Errorf("failed to set the new key: %w", err))
+		return
+	}
+	s.setRotationState(name, RotationSwapped)
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		peer, err := s.findPeer(ctx, name, req.PeerPublicKey)
+		// Handshake times have one-second resolution
+		if err == nil && peer != nil && !peer.LatestHandshake.Before(swapped.Truncate(time.Second)) {
+			var warnings []string
+			if _, err := s.runner.Run(ctx, "wg-quick", "save", name); err != nil {
+				warnings = append(warnings, fmt.Sprintf("new key is live but not saved: %v", err))
+			}
+			if err := s.publishServerKey(ctx, req.KeyTag, s.rotationNewKey(name)); err != nil {
+				warnings = append(warnings, fmt.Sprintf("new key is live but the instance tag was not updated: %v", err))
+			}
+			var warning error
+			if len(warnings) > 0 {
+				warning = errors.New(strings.Join(warnings, "; "))
+			}
+			s.finishRotation(name, RotationCompleted, warning)
+			return
+		}
+		time.Sleep(rotationPollInterval)
+	}
+
+	err := fmt.Errorf("no handshake with the new bastion key within %s", timeout)
+	if restoreErr := s.setPrivateKey(ctx, name, oldPrivateKey); restoreErr != nil {
+		err = fmt.Errorf("%w, and the old key could not be restored: %v", err, restoreErr)
+	}
+	s.finishRotation(name, RotationRolledBack, err)
+}
+
+// setPrivateKey replaces an interface's private key through a private key file
+func (s *Server) setPrivateKey(ctx context.Context, name, privateKey string) error {
+	return s.withSecretFile(".key-*", privateKey, func(path string) error {
+		_, err := s.runner.Run(ctx, "wg", "set", name, "private-key", path)
+		return err
+	})
+}
+
+// publishServerKey updates the instance tag the bastion published its key under at boot.
+// Without the instance ID (an agent started by hand) or a tag there is nothing to update.
+func (s *Server) publishServerKey(ctx context.Context, tag, publicKey string) error {
+	if tag == "" || s.config.InstanceID == "" {
+		return nil
+	}
+	args := []string{"ec2", "create-tags", "--resources", s.config.InstanceID, "--tags", fmt.Sprintf("Key=%s,Value=%s", tag, publicKey)}
+	if s.config.Region != "" {
+		args = append(args, "--region", s.config.Region)
+	}
+	_, err := s.runner.Run(ctx, "aws", args...)
+	return err
+}
+
+// findInterface returns an interface from `wg show all dump` with its private key
+func (s *Server) findInterface(ctx context.Context, name string) (*InterfaceStats, string, error) {
+	output, err := s.runner.Run(ctx, "wg", "show", "all", "dump")
+	if err != nil {
+		return nil, "", err
+	}
+	interfaces, err := parseWireGuardDump(string(output))
+	if err != nil {
+		return nil, "", err
+	}
+	for _, iface := range interfaces {
+		if iface.Name == name {
+			return &iface, dumpPrivateKey(string(output), name), nil
+		}
+	}
+	return nil, "", fmt.Errorf("interface %s is not running", name)
+}
+
+// dumpPrivateKey picks an interface's private key out of `wg show all dump`, which the
+// parsed stats leave out so it never reaches an API response
+func dumpPrivateKey(output, name string) string {
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) == 5 && fields[0] == name {
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+// findPeer returns a peer of an interface from `wg show all dump`, or nil if absent
+func (s *Server) findPeer(ctx context.Context, name, publicKey string) (*PeerStats, error) {
+	output, err := s.runner.Run(ctx, "wg", "show", "all", "dump")
+	if err != nil {
+		return nil, err
+	}
+	interfaces, err := parseWireGuardDump(string(output))
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range interfaces {
+		if iface.Name != name {
+			continue
+		}
+		for _, peer := range iface.Peers {
+			if peer.PublicKey == publicKey {
+				return &peer, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (s *Server) rotationNewKey(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rotations[name].NewPublicKey
+}
+
+func (s *Server) rotationStarted(name string) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rotations[name].StartedAt
+}
+
+func (s *Server) setRotationState(name, state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rotations[name].State = state
+}
+
+func (s *Server) finishRotation(name, state string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.rotations[name]
+	status.State = state
+	status.FinishedAt = time.Now().UTC()
+	if err != nil {
+		status.Error = err.Error()
+	}
+	if s.logger != nil {
+		s.logger.Info("Key rotation finished", "interface", name, "state", state, "error", status.Error)
+	}
+}
@@ -0,0 +1,236 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const newTestPublicKey = "bmV3LWtleS1mb3Itcm90YXRpb24tdGVzdHMtMzJieXQ="
+
+// rotationDump is a `wg show all dump` with the old peer and, if handshake is non-zero, the new one
+func rotationDump(handshake int64) string {
+	dump := "wg0\tcHJpdmF0ZQ==\tc2VydmVy\t51820\toff\n" +
+		"wg0\t" + testPublicKey + "\t(none)\t198.51.100.7:40000\t10.100.1.2/32\t1700000000\t100\t200\t25\n"
+	if handshake > 0 {
+		dump += fmt.Sprintf("wg0\t%s\t(none)\t198.51.100.7:40000\t10.100.1.2/32\t%d\t0\t0\toff\n", newTestPublicKey, handshake)
+	}
+	return dump
+}
+
+func waitForRotation(t *testing.T, client *Client) *RotationStatus {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		status, err := client.Rotation(context.Background(), "wg0")
+		if err != nil {
+			t.Fatalf("Rotation status failed: %v", err)
+		}
+		if status.Done() {
+			return status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Rotation did not finish")
+	return nil
+}
+
+func TestKeyRotation(t *testing.T) {
+	delay, poll := rotationSwapDelay, rotationPollInterval
+	rotationSwapDelay, rotationPollInterval = 0, 10*time.Millisecond
+	t.Cleanup(func() { rotationSwapDelay, rotationPollInterval = delay, poll })
+
+	client, runner, config := newTestAgent(t)
+	os.WriteFile(filepath.Join(config.WireGuardDir, "wg0.conf"), []byte("[Interface]\n"), 0600)
+	ctx := context.Background()
+
+	t.Run("completed", func(t *testing.T) {
+		runner.outputs["wg"] = rotationDump(time.Now().Unix() + 5)
+
+		status, err := client.StartRotation(ctx, "wg0", RotationRequest{OldPublicKey: testPublicKey, NewPublicKey: newTestPublicKey})
+		if err != nil {
+			t.Fatalf("StartRotation failed: %v", err)
+		}
+		if status.State != RotationPending {
+			t.Errorf("Expected pending rotation, got %s", status.State)
+		}
+
+		status = waitForRotation(t, client)
+		if status.State != RotationCompleted || status.Error != "" {
+			t.Fatalf("Expected completed rotation, got %+v", status)
+		}
+		commands := runner.history()
+		for _, expected := range []string{
+			"wg set wg0 peer " + newTestPublicKey + " allowed-ips 10.100.1.2/32 endpoint 198.51.100.7:40000",
+			"wg set wg0 peer " + testPublicKey + " remove",
+			"wg-quick save wg0",
+		} {
+			if !slices.Contains(commands, expected) {
+				t.Errorf("Missing command %q in:\n%s", expected, strings.Join(commands, "\n"))
+			}
+		}
+	})
+
+	t.Run("rolled back", func(t *testing.T) {
+		runner.outputs["wg"] = rotationDump(0)
+
+		if _, err := client.StartRotation(ctx, "wg0", RotationRequest{OldPublicKey: testPublicKey, NewPublicKey: newTestPublicKey, TimeoutSeconds: 1}); err != nil {
+			t.Fatalf("StartRotation failed: %v", err)
+		}
+		if _, err := client.StartRotation(ctx, "wg0", RotationRequest{OldPublicKey: testPublicKey, NewPublicKey: newTestPublicKey}); err == nil || !strings.Contains(err.Error(), "409") {
+			t.Errorf("Expected 409 for a concurrent rotation, got %v", err)
+		}
+
+		status := waitForRotation(t, client)
+		if status.State != RotationRolledBack || !strings.Contains(status.Error, "no handshake") {
+			t.Fatalf("Expected rolled back rotation, got %+v", status)
+		}
+		commands := runner.history()
+		if !slices.Contains(commands, "wg set wg0 peer "+testPublicKey+" allowed-ips 10.100.1.2/32") ||
+			!slices.Contains(commands, "wg set wg0 peer "+newTestPublicKey+" remove") {
+			t.Errorf("Expected the old key to be restored:\n%s", strings.Join(commands, "\n"))
+		}
+	})
+
+	t.Run("preshared key", func(t *testing.T) {
+		runner.outputs["wg"] = rotationDump(time.Now().Unix() + 5)
+		before := len(runner.history())
+
+		if _, err := client.StartRotation(ctx, "wg0", RotationRequest{OldPublicKey: testPublicKey, NewPublicKey: newTestPublicKey, NewPresharedKey: newTestPublicKey}); err != nil {
+			t.Fatalf("StartRotation failed: %v", err)
+		}
+		if status := waitForRotation(t, client); status.State != RotationCompleted {
+			t.Fatalf("Expected completed rotation, got %+v", status)
+		}
+		swap := "wg set wg0 peer " + newTestPublicKey + " allowed-ips 10.100.1.2/32 endpoint 198.51.100.7:40000 preshared-key " + config.WireGuardDir + "/.psk-"
+		if !slices.ContainsFunc(runner.history()[before:], func(command string) bool { return strings.HasPrefix(command, swap) }) {
+			t.Errorf("Expected the new peer to get the PSK:\n%s", strings.Join(runner.history()[before:], "\n"))
+		}
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		if _, err := client.StartRotation(ctx, "wg0", RotationRequest{OldPublicKey: testPublicKey, NewPublicKey: newTestPublicKey, NewPresharedKey: "short"}); err == nil {
+			t.Error("Expected invalid pre-shared key to be rejected")
+		}
+		if _, err := client.StartRotation(ctx, "wg0", RotationRequest{OldPublicKey: testPublicKey, NewPublicKey: testPublicKey}); err == nil {
+			t.Error("Expected identical keys to be rejected")
+		}
+		if _, err := client.StartRotation(ctx, "wg0", RotationRequest{OldPublicKey: newTestPublicKey + "x", NewPublicKey: testPublicKey}); err == nil {
+			t.Error("Expected invalid key to be rejected")
+		}
+		runner.outputs["wg"] = rotationDump(0)
+		if _, err := client.StartRotation(ctx, "wg0", RotationRequest{OldPublicKey: newTestPublicKey, NewPublicKey: testPublicKey}); err == nil || !strings.Contains(err.Error(), "404") {
+			t.Errorf("Expected 404 for an unknown peer, got %v", err)
+		}
+	})
+}
+
+// serverRotationDump is a `wg show all dump` of wg0 under the bastion key newTestPublicKey,
+// with the client's handshake at the given time and any extra peers
+func serverRotationDump(handshake int64, extraPeers ...string) string {
+	dump := "wg0\tcHJpdmF0ZQ==\t" + newTestPublicKey + "\t51820\toff\n" +
+		fmt.Sprintf("wg0\t%s\t(none)\t198.51.100.7:40000\t10.100.1.2/32\t%d\t100\t200\t25\n", testPublicKey, handshake)
+	for _, peer := range extraPeers {
+		dump += "wg0\t" + peer + "\t(none)\t(none)\t10.100.1.3/32\t0\t0\t0\toff\n"
+	}
+	return dump
+}
+
+func TestServerKeyRotation(t *testing.T) {
+	delay, poll := rotationSwapDelay, rotationPollInterval
+	rotationSwapDelay, rotationPollInterval = 0, 10*time.Millisecond
+	t.Cleanup(func() { rotationSwapDelay, rotationPollInterval = delay, poll })
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "wg0.conf"), []byte("[Interface]\n"), 0600)
+	config := Config{Token: "secret", WireGuardDir: dir, InstanceID: "i-0123", Region: "us-west-2"}
+	runner := &fakeRunner{outputs: map[string]string{}}
+	server := httptest.NewServer(NewServer(config, runner).Handler())
+	t.Cleanup(server.Close)
+	client := NewClient(server.URL, "secret")
+	ctx := context.Background()
+	request := ServerRotationRequest{OldPublicKey: newTestPublicKey, PeerPublicKey: testPublicKey, KeyTag: "WireGuardPublicKey"}
+
+	t.Run("completed", func(t *testing.T) {
+		runner.outputs["wg"] = serverRotationDump(time.Now().Unix() + 5)
+
+		status, err := client.StartServerRotation(ctx, "wg0", request)
+		if err != nil {
+			t.Fatalf("StartServerRotation failed: %v", err)
+		}
+		if !status.Server || status.OldPublicKey != newTestPublicKey || validatePublicKey(status.NewPublicKey) != nil {
+			t.Fatalf("Expected a new bastion key in %+v", status)
+		}
+
+		done := waitForRotation(t, client)
+		if done.State != RotationCompleted || done.Error != "" {
+			t.Fatalf("Expected completed rotation, got %+v", done)
+		}
+		commands := runner.history()
+		if !slices.ContainsFunc(commands, func(command string) bool {
+			return strings.HasPrefix(command, "wg set wg0 private-key "+dir+"/.key-")
+		}) {
+			t.Errorf("Expected the private key to be replaced:\n%s", strings.Join(commands, "\n"))
+		}
+		for _, expected := range []string{
+			"wg-quick save wg0",
+			"aws ec2 create-tags --resources i-0123 --tags Key=WireGuardPublicKey,Value=" + status.NewPublicKey + " --region us-west-2",
+		} {
+			if !slices.Contains(commands, expected) {
+				t.Errorf("Missing command %q in:\n%s", expected, strings.Join(commands, "\n"))
+			}
+		}
+	})
+
+	t.Run("rolled back", func(t *testing.T) {
+		runner.outputs["wg"] = serverRotationDump(1700000000)
+		before := len(runner.history())
+
+		timeout := request
+		timeout.TimeoutSeconds = 1
+		if _, err := client.StartServerRotation(ctx, "wg0", timeout); err != nil {
+			t.Fatalf("StartServerRotation failed: %v", err)
+		}
+		status := waitForRotation(t, client)
+		if status.State != RotationRolledBack || !strings.Contains(status.Error, "no handshake") {
+			t.Fatalf("Expected rolled back rotation, got %+v", status)
+		}
+		var keySets int
+		for _, command := range runner.history()[before:] {
+			if strings.HasPrefix(command, "wg set wg0 private-key ") {
+				keySets++
+			}
+			if command == "wg-quick save wg0" {
+				t.Error("A rolled back key must not be saved")
+			}
+		}
+		if keySets != 2 {
+			t.Errorf("Expected the new key and then the old key to be set, got %d key changes", keySets)
+		}
+	})
+
+	t.Run("conflicts", func(t *testing.T) {
+		runner.outputs["wg"] = serverRotationDump(0)
+		stale := request
+		stale.OldPublicKey = testPublicKey
+		if _, err := client.StartServerRotation(ctx, "wg0", stale); err == nil || !strings.Contains(err.Error(), "409") {
+			t.Errorf("Expected 409 for a stale bastion key, got %v", err)
+		}
+
+		runner.outputs["wg"] = serverRotationDump(0, "Y2xpZW50LXR3by1wdWJsaWMta2V5LTMyLWJ5dGVzISE=")
+		if _, err := client.StartServerRotation(ctx, "wg0", request); err == nil || !strings.Contains(err.Error(), "other peer") {
+			t.Errorf("Expected other peers to block the rotation, got %v", err)
+		}
+
+		tagged := request
+		tagged.KeyTag = "Name"
+		if _, err := client.StartServerRotation(ctx, "wg0", tagged); err == nil || !strings.Contains(err.Error(), "400") {
+			t.Errorf("Expected a non-mole tag to be rejected, got %v", err)
+		}
+	})
+}
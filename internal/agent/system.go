@@ -0,0 +1,292 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Only network and VM tunables can be changed remotely
+var (
+	sysctlKeyPattern   = regexp.MustCompile(`^(net|vm)\.[a-z0-9_.\-]+$`)
+	sysctlValuePattern = regexp.MustCompile(`^[a-z0-9_ \t\-]+$`)
+)
+
+// Log sources the agent can return, mapped to the command that reads them
+var logSources = map[string][]string{
+	"cloud-init": {"tail", "-n", "%d", "/var/log/cloud-init-output.log"},
+	"agent":      {"journalctl", "-u", "mole-agent", "-n", "%d", "--no-pager"},
+	"kernel":     {"journalctl", "-k", "-n", "%d", "--no-pager"},
+}
+
+// DefaultTuningFile is where the bastion user data records the kernel tuning it applied
+const DefaultTuningFile = "/etc/mole/tuning.json"
+
+// Limits on returned log lines
+const (
+	defaultLogLines = 200
+	maxLogLines     = 5000
+)
+
+// SystemStats reports bastion load and memory
+type SystemStats struct {
+	CPUs              int     `json:"cpus"`
+	Load1             float64 `json:"load1"`
+	Load5             float64 `json:"load5"`
+	Load15            float64 `json:"load15"`
+	UptimeSeconds     float64 `json:"uptime_seconds"`
+	MemoryTotalKB     int64   `json:"memory_total_kb"`
+	MemoryAvailableKB int64   `json:"memory_available_kb"`
+}
+
+// SysctlRequest applies kernel parameters, e.g. {"net.core.rmem_max": "134217728"}
+type SysctlRequest struct {
+	Settings map[string]string `json:"settings"`
+}
+
+// LogsResponse carries the tail of a log source
+type LogsResponse struct {
+	Source string   `json:"source"`
+	Lines  []string `json:"lines"`
+}
+
+// TuningReport compares the tuning requested at deploy time with the live kernel values
+type TuningReport struct {
+	Sysctls      []SysctlStatus `json:"sysctls"`
+	CPUGovernor  string         `json:"cpu_governor,omitempty"`
+	SpreadQueues bool           `json:"spread_queues"`
+	CPUs         int            `json:"cpus"`
+	RxQueues     int            `json:"rx_queues"`  // Receive queues given RPS masks
+	IRQQueues    int            `json:"irq_queues"` // ENA queue IRQs pinned to a CPU
+}
+
+// SysctlStatus is one requested kernel parameter and its current value
+type SysctlStatus struct {
+	Key     string `json:"key"`
+	Desired string `json:"desired"`
+	Actual  string `json:"actual"`
+	Applied bool   `json:"applied"`
+}
+
+// recordedTuning is the file written by the bastion user data
+type recordedTuning struct {
+	Sysctls      map[string]string `json:"sysctls"`
+	CPUGovernor  string            `json:"cpu_governor"`
+	SpreadQueues bool              `json:"spread_queues"`
+	CPUs         int               `json:"cpus"`
+	RxQueues     int               `json:"rx_queues"`
+	IRQQueues    int               `json:"irq_queues"`
+}
+
+func (s *Server) handleSystem(w http.ResponseWriter, r *http.Request) {
+	stats, err := readSystemStats(s.config.ProcDir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (s *Server) handleSysctl(w http.ResponseWriter, r *http.Request) {
+	var req SysctlRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(req.Settings) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("settings is required"))
+		return
+	}
+	for key, value := range req.Settings {
+		if !sysctlKeyPattern.MatchString(key) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("sysctl %q is not allowed", key))
+			return
+		}
+		if !sysctlValuePattern.MatchString(value) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid value %q for %s", value, key))
+			return
+		}
+	}
+
+	for _, key := range sortedKeys(req.Settings) {
+		if _, err := s.runner.Run(r.Context(), "sysctl", "-w", key+"="+req.Settings[key]); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	if err := persistSysctls(s.config.SysctlFile, req.Settings); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, req)
+}
+
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	source := r.URL.Query().Get("source")
+	if source == "" {
+		source = "cloud-init"
+	}
+	command, ok := logSources[source]
+	if !ok {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown log source %q", source))
+		return
+	}
+
+	lines := defaultLogLines
+	if value := r.URL.Query().Get("lines"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid lines %q", value))
+			return
+		}
+		lines = min(n, maxLogLines)
+	}
+
+	args := make([]string, len(command)-1)
+	for i, arg := range command[1:] {
+		if arg == "%d" {
+			arg = strconv.Itoa(lines)
+		}
+		args[i] = arg
+	}
+
+	output, err := s.runner.Run(r.Context(), command[0], args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, LogsResponse{Source: source, Lines: strings.Split(strings.TrimRight(string(output), "\n"), "\n")})
+}
+
+func (s *Server) handleTuning(w http.ResponseWriter, r *http.Request) {
+	report, err := readTuningReport(s.config.TuningFile, s.config.ProcDir)
+	if errors.Is(err, os.ErrNotExist) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no tuning recorded on this bastion"))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// readTuningReport reads the recorded tuning and checks each sysctl against procfs
+func readTuningReport(tuningFile, procDir string) (*TuningReport, error) {
+	data, err := os.ReadFile(tuningFile)
+	if err != nil {
+		return nil, err
+	}
+	var recorded recordedTuning
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", tuningFile, err)
+	}
+
+	report := &TuningReport{
+		Sysctls:      []SysctlStatus{},
+		CPUGovernor:  recorded.CPUGovernor,
+		SpreadQueues: recorded.SpreadQueues,
+		CPUs:         recorded.CPUs,
+		RxQueues:     recorded.RxQueues,
+		IRQQueues:    recorded.IRQQueues,
+	}
+	for _, key := range sortedKeys(recorded.Sysctls) {
+		status := SysctlStatus{Key: key, Desired: recorded.Sysctls[key]}
+		if value, err := os.ReadFile(filepath.Join(procDir, "sys", strings.ReplaceAll(key, ".", "/"))); err == nil {
+			status.Actual = strings.Join(strings.Fields(string(value)), " ")
+		}
+		status.Applied = status.Actual == strings.Join(strings.Fields(status.Desired), " ")
+		report.Sysctls = append(report.Sysctls, status)
+	}
+	return report, nil
+}
+
+// readSystemStats reads load, uptime and memory from procfs
+func readSystemStats(procDir string) (*SystemStats, error) {
+	stats := &SystemStats{CPUs: runtime.NumCPU()}
+
+	loadavg, err := os.ReadFile(filepath.Join(procDir, "loadavg"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read load average: %w", err)
+	}
+	if _, err := fmt.Sscanf(string(loadavg), "%f %f %f", &stats.Load1, &stats.Load5, &stats.Load15); err != nil {
+		return nil, fmt.Errorf("failed to parse load average: %w", err)
+	}
+
+	if uptime, err := os.ReadFile(filepath.Join(procDir, "uptime")); err == nil {
+		fmt.Sscanf(string(uptime), "%f", &stats.UptimeSeconds)
+	}
+
+	meminfo, err := os.Open(filepath.Join(procDir, "meminfo"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read memory info: %w", err)
+	}
+	defer meminfo.Close()
+
+	scanner := bufio.NewScanner(meminfo)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		value, _ := strconv.ParseInt(fields[1], 10, 64)
+		switch fields[0] {
+		case "MemTotal:":
+			stats.MemoryTotalKB = value
+		case "MemAvailable:":
+			stats.MemoryAvailableKB = value
+		}
+	}
+
+	return stats, nil
+}
+
+// persistSysctls merges settings into the agent's sysctl.d file so they survive reboots
+func persistSysctls(path string, settings map[string]string) error {
+	merged := make(map[string]string)
+	if data, err := os.ReadFile(path); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if key, value, ok := strings.Cut(line, "="); ok && !strings.HasPrefix(line, "#") {
+				merged[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+		}
+	}
+	for key, value := range settings {
+		merged[key] = value
+	}
+
+	var content strings.Builder
+	content.WriteString("# Managed by mole agent\n")
+	for _, key := range sortedKeys(merged) {
+		content.WriteString(fmt.Sprintf("%s = %s\n", key, merged[key]))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(content.String()), 0644); err != nil {
+		return fmt.Errorf("failed to persist sysctls: %w", err)
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
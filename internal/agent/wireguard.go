@@ -0,0 +1,399 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/research-computing/mole/internal/tunnel"
+)
+
+// interfaceNamePattern limits the agent to mole's own wgN interfaces
+var interfaceNamePattern = regexp.MustCompile(`^wg[0-9]{1,3}$`)
+
+// agentInterface carries the agent's listen address and cannot be removed through the API
+const agentInterface = "wg0"
+
+// InterfaceStats describes a WireGuard interface and its peers
+type InterfaceStats struct {
+	Name       string      `json:"name"`
+	PublicKey  string      `json:"public_key"`
+	ListenPort int         `json:"listen_port"`
+	Peers      []PeerStats `json:"peers"`
+}
+
+// PeerStats describes a WireGuard peer as reported by `wg show`
+type PeerStats struct {
+	PublicKey       string    `json:"public_key"`
+	Endpoint        string    `json:"endpoint,omitempty"`
+	AllowedIPs      []string  `json:"allowed_ips"`
+	LatestHandshake time.Time `json:"latest_handshake,omitempty"` // Zero when never connected
+	RxBytes         int64     `json:"rx_bytes"`
+	TxBytes         int64     `json:"tx_bytes"`
+}
+
+// InterfaceRequest creates a WireGuard interface on the bastion
+type InterfaceRequest struct {
+	Name       string `json:"name"`                 // wgN
+	Address    string `json:"address"`              // Comma-separated addresses with prefix lengths
+	ListenPort int    `json:"listen_port"`          // UDP port; must already be open in the security group
+	Masquerade bool   `json:"masquerade,omitempty"` // NAT the interface's IPv4 networks, which the VPC has no route back to
+}
+
+// PeerRequest adds a peer to an interface
+type PeerRequest struct {
+	PublicKey    string   `json:"public_key"`
+	AllowedIPs   []string `json:"allowed_ips"`
+	PresharedKey string   `json:"preshared_key,omitempty"` // Optional PSK for post-quantum hardening
+}
+
+func (s *Server) handleListInterfaces(w http.ResponseWriter, r *http.Request) {
+	output, err := s.runner.Run(r.Context(), "wg", "show", "all", "dump")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	interfaces, err := parseWireGuardDump(string(output))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, interfaces)
+}
+
+func (s *Server) handleAddInterface(w http.ResponseWriter, r *http.Request) {
+	var req InterfaceRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	configPath := s.interfaceConfigPath(req.Name)
+	if _, err := os.Stat(configPath); err == nil {
+		writeError(w, http.StatusConflict, fmt.Errorf("interface %s already exists", req.Name))
+		return
+	}
+
+	privateKey, publicKey, err := tunnel.GenerateWireGuardKeys()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	config := fmt.Sprintf("[Interface]\nPrivateKey = %s\nAddress = %s\nListenPort = %d\n", privateKey, req.Address, req.ListenPort)
+	if req.Masquerade {
+		config += masqueradeHooks(req)
+	}
+	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to write %s: %w", configPath, err))
+		return
+	}
+
+	if _, err := s.runner.Run(r.Context(), "wg-quick", "up", req.Name); err != nil {
+		os.Remove(configPath)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	for _, rule := range firewallRules(req.Name, req.ListenPort) {
+		if _, err := s.runner.Run(r.Context(), "iptables", append([]string{"-A"}, rule...)...); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusCreated, InterfaceStats{Name: req.Name, PublicKey: publicKey, ListenPort: req.ListenPort})
+}
+
+func (s *Server) handleRemoveInterface(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !s.knownInterface(w, name) {
+		return
+	}
+	if name == agentInterface {
+		writeError(w, http.StatusConflict, fmt.Errorf("%s carries the agent and cannot be removed", name))
+		return
+	}
+
+	configPath := s.interfaceConfigPath(name)
+	port := configListenPort(configPath)
+
+	if _, err := s.runner.Run(r.Context(), "wg-quick", "down", name); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	// Rules may already be gone after a reboot, so failures are ignored
+	for _, rule := range firewallRules(name, port) {
+		s.runner.Run(r.Context(), "iptables", append([]string{"-D"}, rule...)...)
+	}
+	if err := os.Remove(configPath); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to remove %s: %w", configPath, err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAddPeer(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !s.knownInterface(w, name) {
+		return
+	}
+
+	var req PeerRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := validatePublicKey(req.PublicKey); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(req.AllowedIPs) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("allowed_ips is required"))
+		return
+	}
+	for _, cidr := range req.AllowedIPs {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid allowed IP %q: %w", cidr, err))
+			return
+		}
+	}
+
+	if req.PresharedKey != "" {
+		if err := validatePresharedKey(req.PresharedKey); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	err := s.withKeyFile(req.PresharedKey, func(pskArgs []string) error {
+		args := append([]string{"set", name, "peer", req.PublicKey, "allowed-ips", strings.Join(req.AllowedIPs, ",")}, pskArgs...)
+		_, err := s.runner.Run(r.Context(), "wg", args...)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := s.saveInterface(r, name); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleRemovePeer(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !s.knownInterface(w, name) {
+		return
+	}
+
+	publicKey := r.URL.Query().Get("public_key")
+	if err := validatePublicKey(publicKey); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := s.runner.Run(r.Context(), "wg", "set", name, "peer", publicKey, "remove"); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := s.saveInterface(r, name); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// saveInterface writes the live peer set back to the wg-quick config so it survives reboots
+func (s *Server) saveInterface(r *http.Request, name string) error {
+	_, err := s.runner.Run(r.Context(), "wg-quick", "save", name)
+	return err
+}
+
+// knownInterface validates the name and writes a 400/404 when the interface is not mole's
+func (s *Server) knownInterface(w http.ResponseWriter, name string) bool {
+	if !interfaceNamePattern.MatchString(name) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid interface name %q", name))
+		return false
+	}
+	if _, err := os.Stat(s.interfaceConfigPath(name)); err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("interface %s not found", name))
+		return false
+	}
+	return true
+}
+
+func (s *Server) interfaceConfigPath(name string) string {
+	return filepath.Join(s.config.WireGuardDir, name+".conf")
+}
+
+// masqueradeHooks NATs traffic from the interface's IPv4 networks as it leaves through any
+// other interface. wg-quick runs the hooks, so the rules come and go with the interface.
+func masqueradeHooks(req InterfaceRequest) string {
+	var hooks strings.Builder
+	for _, address := range strings.Split(req.Address, ",") {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(address))
+		if err != nil || !prefix.Addr().Is4() {
+			continue
+		}
+		rule := fmt.Sprintf("POSTROUTING -s %s ! -o %s -j MASQUERADE", prefix.Masked(), req.Name)
+		fmt.Fprintf(&hooks, "PostUp = iptables -t nat -A %s\nPostDown = iptables -t nat -D %s\n", rule, rule)
+	}
+	return hooks.String()
+}
+
+// validate checks an interface request before anything touches the system
+func (req InterfaceRequest) validate() error {
+	if !interfaceNamePattern.MatchString(req.Name) {
+		return fmt.Errorf("invalid interface name %q", req.Name)
+	}
+	if req.ListenPort < 1024 || req.ListenPort > 65535 {
+		return fmt.Errorf("listen port %d out of range", req.ListenPort)
+	}
+	if strings.TrimSpace(req.Address) == "" {
+		return fmt.Errorf("address is required")
+	}
+	for _, address := range strings.Split(req.Address, ",") {
+		if _, err := netip.ParsePrefix(strings.TrimSpace(address)); err != nil {
+			return fmt.Errorf("invalid address %q: %w", address, err)
+		}
+	}
+	return nil
+}
+
+// validatePublicKey checks for a base64-encoded 32-byte WireGuard key
+func validatePublicKey(key string) error {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decoded) != 32 {
+		return fmt.Errorf("invalid WireGuard public key %q", key)
+	}
+	return nil
+}
+
+// validatePresharedKey checks for a base64-encoded 32-byte pre-shared key without echoing it
+func validatePresharedKey(key string) error {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decoded) != 32 {
+		return fmt.Errorf("invalid WireGuard pre-shared key")
+	}
+	return nil
+}
+
+// withKeyFile passes a pre-shared key to `wg set` through a private temporary file, since wg
+// only reads keys from files. fn gets the extra arguments, or none when psk is empty.
+func (s *Server) withKeyFile(psk string, fn func(pskArgs []string) error) error {
+	if psk == "" {
+		return fn(nil)
+	}
+	return s.withSecretFile(".psk-*", psk, func(path string) error {
+		return fn([]string{"preshared-key", path})
+	})
+}
+
+// withSecretFile writes a key to a private temporary file, named after pattern, for as
+// long as fn runs
+func (s *Server) withSecretFile(pattern, key string, fn func(path string) error) error {
+	file, err := os.CreateTemp(s.config.WireGuardDir, pattern)
+	if err != nil {
+		return fmt.Errorf("failed to create key file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(key + "\n")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	return fn(file.Name())
+}
+
+// firewallRules are the iptables rules (without -A/-D) an interface needs
+func firewallRules(name string, port int) [][]string {
+	rules := [][]string{
+		{"FORWARD", "-i", name, "-j", "ACCEPT"},
+		{"FORWARD", "-o", name, "-j", "ACCEPT"},
+	}
+	if port > 0 {
+		rules = append([][]string{{"INPUT", "-p", "udp", "--dport", strconv.Itoa(port), "-j", "ACCEPT"}}, rules...)
+	}
+	return rules
+}
+
+// configListenPort reads ListenPort from a wg-quick config, or 0
+func configListenPort(path string) int {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if ok && strings.TrimSpace(key) == "ListenPort" {
+			port, _ := strconv.Atoi(strings.TrimSpace(value))
+			return port
+		}
+	}
+	return 0
+}
+
+// parseWireGuardDump parses `wg show all dump`. Interface lines have 5 fields
+// (name, private key, public key, port, fwmark); peer lines have 9.
+func parseWireGuardDump(output string) ([]InterfaceStats, error) {
+	var interfaces []InterfaceStats
+	index := make(map[string]int)
+
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+
+		switch len(fields) {
+		case 5:
+			port, _ := strconv.Atoi(fields[3])
+			index[fields[0]] = len(interfaces)
+			interfaces = append(interfaces, InterfaceStats{Name: fields[0], PublicKey: fields[2], ListenPort: port, Peers: []PeerStats{}})
+		case 9:
+			i, ok := index[fields[0]]
+			if !ok {
+				return nil, fmt.Errorf("peer for unknown interface %s", fields[0])
+			}
+			peer := PeerStats{PublicKey: fields[1]}
+			if fields[3] != "(none)" {
+				peer.Endpoint = fields[3]
+			}
+			if fields[4] != "(none)" {
+				peer.AllowedIPs = strings.Split(fields[4], ",")
+			}
+			if handshake, _ := strconv.ParseInt(fields[5], 10, 64); handshake > 0 {
+				peer.LatestHandshake = time.Unix(handshake, 0).UTC()
+			}
+			peer.RxBytes, _ = strconv.ParseInt(fields[6], 10, 64)
+			peer.TxBytes, _ = strconv.ParseInt(fields[7], 10, 64)
+			interfaces[i].Peers = append(interfaces[i].Peers, peer)
+		default:
+			return nil, fmt.Errorf("unexpected wg dump line with %d fields", len(fields))
+		}
+	}
+
+	return interfaces, nil
+}
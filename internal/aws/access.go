@@ -0,0 +1,233 @@
+package aws
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2instanceconnect"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"golang.org/x/crypto/ssh"
+)
+
+// AccessMode selects how operators reach the bastion for management
+type AccessMode string
+
+const (
+	// AccessModeKeyPair creates an EC2 key pair and opens SSH to AllowedCIDR
+	AccessModeKeyPair AccessMode = "keypair"
+	// AccessModeInstanceConnect opens SSH but only accepts short-lived keys pushed through EC2 Instance Connect
+	AccessModeInstanceConnect AccessMode = "instance-connect"
+	// AccessModeSSM uses Systems Manager Session Manager: no key pair and no SSH ingress
+	AccessModeSSM AccessMode = "ssm"
+)
+
+// ssmManagedPolicyARN grants the SSM agent on the bastion what it needs to register sessions
+const ssmManagedPolicyARN = "arn:aws:iam::aws:policy/AmazonSSMManagedInstanceCore"
+
+// bastionOSUser is the default login user on Amazon Linux 2023
+const bastionOSUser = "ec2-user"
+
+// ParseAccessMode converts a CLI value to an AccessMode
+func ParseAccessMode(mode string) (AccessMode, error) {
+	switch AccessMode(strings.ToLower(mode)) {
+	case "", AccessModeKeyPair:
+		return AccessModeKeyPair, nil
+	case AccessModeInstanceConnect, "eic":
+		return AccessModeInstanceConnect, nil
+	case AccessModeSSM:
+		return AccessModeSSM, nil
+	default:
+		return "", fmt.Errorf("unsupported access mode: %s (supported: keypair, instance-connect, ssm)", mode)
+	}
+}
+
+// usesKeyPair reports whether an EC2 key pair should be created for this mode
+func (m AccessMode) usesKeyPair() bool {
+	return m == "" || m == AccessModeKeyPair
+}
+
+// allowsSSH reports whether the security group should accept SSH from AllowedCIDR
+func (m AccessMode) allowsSSH() bool {
+	return m != AccessModeSSM
+}
+
+// BastionTarget identifies a running bastion for management sessions
+type BastionTarget struct {
+	InstanceID       string
+	PublicIP         string
+	PrivateIP        string
+	AvailabilityZone string
+	VPCID            string
+	SecurityGroupIDs []string
+}
+
+// FindBastion locates a running mole bastion, preferring instanceID when given
+func (a *AWSClient) FindBastion(ctx context.Context, instanceID string) (*BastionTarget, error) {
+	input := &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: []string{"running"},
+			},
+		},
+	}
+	if instanceID != "" {
+		input.InstanceIds = []string{instanceID}
+	} else {
+		input.Filters = append(input.Filters, types.Filter{
+			Name:   aws.String("tag:Name"),
+			Values: []string{"mole-bastion"},
+		})
+	}
+
+	result, err := a.client.DescribeInstances(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe bastion instances: %w", err)
+	}
+
+	// Pick the most recently launched bastion
+	var latest *types.Instance
+	for _, reservation := range result.Reservations {
+		for i := range reservation.Instances {
+			instance := &reservation.Instances[i]
+			if latest == nil || (instance.LaunchTime != nil && latest.LaunchTime != nil &&
+				instance.LaunchTime.After(*latest.LaunchTime)) {
+				latest = instance
+			}
+		}
+	}
+
+	if latest == nil {
+		return nil, fmt.Errorf("no running mole bastion found in %s", a.region)
+	}
+
+	target := &BastionTarget{
+		InstanceID: aws.ToString(latest.InstanceId),
+		PublicIP:   aws.ToString(latest.PublicIpAddress),
+		PrivateIP:  aws.ToString(latest.PrivateIpAddress),
+		VPCID:      aws.ToString(latest.VpcId),
+	}
+	for _, group := range latest.SecurityGroups {
+		target.SecurityGroupIDs = append(target.SecurityGroupIDs, aws.ToString(group.GroupId))
+	}
+	if latest.Placement != nil {
+		target.AvailabilityZone = aws.ToString(latest.Placement.AvailabilityZone)
+	}
+
+	return target, nil
+}
+
+// PushEphemeralSSHKey generates a one-time ed25519 key pair and pushes the public half to
+// the bastion with EC2 Instance Connect. The key is accepted for 60 seconds; the returned
+// cleanup function removes the private key from disk.
+func (a *AWSClient) PushEphemeralSSHKey(ctx context.Context, target *BastionTarget, osUser string) (string, func(), error) {
+	if osUser == "" {
+		osUser = bastionOSUser
+	}
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate ephemeral SSH key: %w", err)
+	}
+
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to encode ephemeral SSH key: %w", err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(privateKey, "mole-ephemeral")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to encode ephemeral SSH key: %w", err)
+	}
+
+	keyDir, err := os.MkdirTemp("", "mole-ssh-")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create key directory: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(keyDir) }
+
+	keyFile := filepath.Join(keyDir, "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to save ephemeral SSH key: %w", err)
+	}
+
+	input := &ec2instanceconnect.SendSSHPublicKeyInput{
+		InstanceId:     aws.String(target.InstanceID),
+		InstanceOSUser: aws.String(osUser),
+		SSHPublicKey:   aws.String(string(ssh.MarshalAuthorizedKey(sshPublicKey))),
+	}
+	if target.AvailabilityZone != "" {
+		input.AvailabilityZone = aws.String(target.AvailabilityZone)
+	}
+
+	if _, err := a.connectClient.SendSSHPublicKey(ctx, input); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to push SSH key with EC2 Instance Connect: %w", err)
+	}
+
+	return keyFile, cleanup, nil
+}
+
+// SSMSessionCommand builds the AWS CLI command that opens a Session Manager shell on the bastion
+func (a *AWSClient) SSMSessionCommand(instanceID string) *exec.Cmd {
+	args := []string{"ssm", "start-session", "--target", instanceID, "--region", a.region}
+	if a.profile != "" {
+		args = append(args, "--profile", a.profile)
+	}
+	return exec.Command("aws", args...)
+}
+
+// attachSSMPolicy grants the instance role the permissions needed for Session Manager
+func (a *AWSClient) attachSSMPolicy(ctx context.Context, roleName string) error {
+	_, err := a.iamClient.AttachRolePolicy(ctx, &iam.AttachRolePolicyInput{
+		RoleName:  aws.String(roleName),
+		PolicyArn: aws.String(ssmManagedPolicyARN),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to attach SSM policy: %w", err)
+	}
+	return nil
+}
+
+// CleanupKeyPairs deletes mole-created EC2 key pairs and their saved private keys
+func (a *AWSClient) CleanupKeyPairs(ctx context.Context) (int, error) {
+	result, err := a.client.DescribeKeyPairs(ctx, &ec2.DescribeKeyPairsInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("key-name"),
+				Values: []string{"mole-key-*"},
+			},
+		},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to describe key pairs: %w", err)
+	}
+
+	keyDir := filepath.Join(os.Getenv("HOME"), ".mole", "keys")
+	deleted := 0
+	for _, keyPair := range result.KeyPairs {
+		keyName := aws.ToString(keyPair.KeyName)
+		if _, err := a.client.DeleteKeyPair(ctx, &ec2.DeleteKeyPairInput{
+			KeyName: keyPair.KeyName,
+		}); err != nil {
+			return deleted, fmt.Errorf("failed to delete key pair %s: %w", keyName, err)
+		}
+
+		// Remove the matching private key saved by createKeyPair
+		os.Remove(filepath.Join(keyDir, keyName+".pem"))
+		deleted++
+	}
+
+	return deleted, nil
+}
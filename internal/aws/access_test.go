@@ -0,0 +1,101 @@
+package aws
+
+import (
+	"testing"
+)
+
+func TestParseAccessMode(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected AccessMode
+		wantErr  bool
+	}{
+		{"", AccessModeKeyPair, false},
+		{"keypair", AccessModeKeyPair, false},
+		{"instance-connect", AccessModeInstanceConnect, false},
+		{"eic", AccessModeInstanceConnect, false},
+		{"SSM", AccessModeSSM, false},
+		{"telnet", "", true},
+	}
+
+	for _, test := range tests {
+		mode, err := ParseAccessMode(test.input)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseAccessMode(%q) should fail", test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseAccessMode(%q) failed: %v", test.input, err)
+			continue
+		}
+		if mode != test.expected {
+			t.Errorf("ParseAccessMode(%q) = %s, expected %s", test.input, mode, test.expected)
+		}
+	}
+}
+
+func TestAccessModeKeyPairAndSSH(t *testing.T) {
+	tests := []struct {
+		mode       AccessMode
+		keyPair    bool
+		sshIngress bool
+	}{
+		{"", true, true}, // Zero value keeps the legacy behaviour
+		{AccessModeKeyPair, true, true},
+		{AccessModeInstanceConnect, false, true},
+		{AccessModeSSM, false, false},
+	}
+
+	for _, test := range tests {
+		if got := test.mode.usesKeyPair(); got != test.keyPair {
+			t.Errorf("%q.usesKeyPair() = %v, expected %v", test.mode, got, test.keyPair)
+		}
+		if got := test.mode.allowsSSH(); got != test.sshIngress {
+			t.Errorf("%q.allowsSSH() = %v, expected %v", test.mode, got, test.sshIngress)
+		}
+	}
+}
+
+func TestBuildIngressRulesSSH(t *testing.T) {
+	hasSSH := func(config *DeploymentConfig) bool {
+		for _, rule := range buildIngressRules(config) {
+			if rule.FromPort != nil && *rule.FromPort == 22 {
+				return true
+			}
+		}
+		return false
+	}
+
+	config := &DeploymentConfig{
+		TunnelCount: 2,
+		AllowedCIDR: "203.0.113.0/24",
+		VPCCidr:     "10.0.0.0/16",
+	}
+
+	if !hasSSH(config) {
+		t.Error("Default access mode should open SSH")
+	}
+
+	config.AccessMode = AccessModeInstanceConnect
+	if !hasSSH(config) {
+		t.Error("Instance Connect access mode should open SSH")
+	}
+
+	config.AccessMode = AccessModeSSM
+	if hasSSH(config) {
+		t.Error("SSM access mode should not open SSH")
+	}
+
+	// WireGuard ports must be present regardless of access mode
+	wireguardRules := 0
+	for _, rule := range buildIngressRules(config) {
+		if rule.IpProtocol != nil && *rule.IpProtocol == "udp" {
+			wireguardRules++
+		}
+	}
+	if wireguardRules != 2 {
+		t.Errorf("Expected 2 WireGuard rules, got %d", wireguardRules)
+	}
+}
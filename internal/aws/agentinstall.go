@@ -0,0 +1,127 @@
+package aws
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/research-computing/mole/internal/version"
+)
+
+// The agent's bearer token reaches the bastion like the pre-shared keys: a SecureString
+// parameter under this path that the bastion reads and deletes at boot, so the token is
+// never in user data
+const agentTokenParameterRoot = "/mole/agent"
+
+// agentChecksumsFile is the GoReleaser checksum file published next to the archives
+const agentChecksumsFile = "checksums.txt"
+
+// agentArchitectures are the bastion architectures the archive URL's ${ARCH} expands to
+var agentArchitectures = []string{"amd64", "arm64"}
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// newAgentTokenParameter returns a fresh per-deployment parameter name for the agent token
+func newAgentTokenParameter() (string, error) {
+	return newParameterPath(agentTokenParameterRoot)
+}
+
+// agentArchiveURL is the agent archive the bastion installs: --agent-url, or the release
+// archive for this version ("" for development builds)
+func agentArchiveURL(config *DeploymentConfig) string {
+	if config.AgentURL != "" {
+		return config.AgentURL
+	}
+	return agentReleaseURL(version.Version)
+}
+
+// agentChecksumsURL is the checksum file published alongside an archive
+func agentChecksumsURL(archiveURL string) string {
+	return archiveURL[:strings.LastIndex(archiveURL, "/")+1] + agentChecksumsFile
+}
+
+// fetchAgentChecksums downloads the checksum file published alongside the agent archive
+// and returns the archive's SHA-256 for each architecture it lists
+func fetchAgentChecksums(ctx context.Context, archiveURL string) (map[string]string, error) {
+	checksumsURL := agentChecksumsURL(archiveURL)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, checksumsURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid agent checksums URL %s: %w", checksumsURL, err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download agent checksums: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download agent checksums from %s: %s", checksumsURL, resp.Status)
+	}
+	return agentChecksums(resp.Body, archiveURL)
+}
+
+// agentChecksums picks each architecture's archive out of a sha256sum-format checksum file
+func agentChecksums(checksums io.Reader, archiveURL string) (map[string]string, error) {
+	files := make(map[string]string)
+	scanner := bufio.NewScanner(checksums)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && sha256Pattern.MatchString(fields[0]) {
+			files[strings.TrimPrefix(fields[1], "*")] = fields[0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read agent checksums: %w", err)
+	}
+
+	sums := make(map[string]string)
+	for _, arch := range agentArchitectures {
+		archive := path.Base(strings.ReplaceAll(archiveURL, "${ARCH}", arch))
+		if sum, ok := files[archive]; ok {
+			sums[arch] = sum
+		}
+	}
+	if len(sums) == 0 {
+		return nil, fmt.Errorf("%s lists no checksum for %s", agentChecksumsFile, path.Base(archiveURL))
+	}
+	return sums, nil
+}
+
+// storeAgentToken writes the agent token as a SecureString for the bastion to fetch
+func (a *AWSClient) storeAgentToken(ctx context.Context, name, token string, userTags map[string]string) error {
+	var tags []ssmtypes.Tag
+	for _, tag := range withUserTags([]types.Tag{
+		{Key: aws.String("Project"), Value: aws.String("aws-cloud-mole")},
+		{Key: aws.String("Purpose"), Value: aws.String("agent-token")},
+	}, userTags) {
+		tags = append(tags, ssmtypes.Tag{Key: tag.Key, Value: tag.Value})
+	}
+
+	if _, err := a.ssmClient.PutParameter(ctx, &ssm.PutParameterInput{
+		Name:        aws.String(name),
+		Value:       aws.String(token),
+		Type:        ssmtypes.ParameterTypeSecureString,
+		Description: aws.String("mole bastion agent token; deleted by the bastion after boot"),
+		Tags:        tags,
+	}); err != nil {
+		return fmt.Errorf("failed to store agent token %s: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteAgentTokens removes any agent token parameters a bastion has not consumed
+func (a *AWSClient) DeleteAgentTokens(ctx context.Context) (int, error) {
+	return a.deleteParameters(ctx, agentTokenParameterRoot, "agent token")
+}
@@ -0,0 +1,157 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// Supported AMI architectures
+const (
+	ArchARM64 = "arm64"
+	ArchX8664 = "x86_64"
+)
+
+// al2023SSMParameter is the public SSM parameter AWS maintains for the latest AL2023 image
+const al2023SSMParameter = "/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-%s"
+
+// AMISelection controls how the bastion image is chosen
+type AMISelection struct {
+	AMIId     string // Pinned AMI ID; skips lookup for reproducible deployments
+	UseSSM    bool   // Resolve the latest AL2023 image via the public SSM parameter
+	StockOnly bool   // Ignore pre-built mole images (see mole image build)
+}
+
+// instanceArchitecture returns the CPU architecture for an instance type, asking EC2 first
+// and falling back to the naming convention when DescribeInstanceTypes is unavailable
+func (a *AWSClient) instanceArchitecture(ctx context.Context, instanceType types.InstanceType) (string, error) {
+	output, err := a.client.DescribeInstanceTypes(ctx, &ec2.DescribeInstanceTypesInput{
+		InstanceTypes: []types.InstanceType{instanceType},
+	})
+	if err != nil || len(output.InstanceTypes) == 0 || output.InstanceTypes[0].ProcessorInfo == nil {
+		return architectureFromInstanceType(instanceType), nil
+	}
+
+	for _, arch := range output.InstanceTypes[0].ProcessorInfo.SupportedArchitectures {
+		switch arch {
+		case types.ArchitectureTypeArm64:
+			return ArchARM64, nil
+		case types.ArchitectureTypeX8664:
+			return ArchX8664, nil
+		}
+	}
+
+	return "", fmt.Errorf("instance type %s has no supported Linux architecture", instanceType)
+}
+
+// architectureFromInstanceType infers the architecture from the family name: Graviton
+// families carry a "g" after the generation (t4g, c6gn, m7gd) and a1 is the original Graviton
+func architectureFromInstanceType(instanceType types.InstanceType) string {
+	family, _, _ := strings.Cut(string(instanceType), ".")
+	if family == "a1" {
+		return ArchARM64
+	}
+
+	for i := 0; i < len(family); i++ {
+		if family[i] >= '0' && family[i] <= '9' {
+			if strings.HasPrefix(family[i+1:], "g") {
+				return ArchARM64
+			}
+			break
+		}
+	}
+
+	return ArchX8664
+}
+
+// resolveAMI picks an AMI for the instance type and validates it before launch. A pinned ID
+// wins, then the newest pre-built mole image, then stock AL2023. The described image is
+// returned so callers can use its root device name for block device mappings.
+func (a *AWSClient) resolveAMI(ctx context.Context, instanceType types.InstanceType, selection AMISelection) (*types.Image, error) {
+	arch, err := a.instanceArchitecture(ctx, instanceType)
+	if err != nil {
+		return nil, err
+	}
+
+	amiID := selection.AMIId
+	if amiID == "" && !selection.StockOnly {
+		// A failed lookup is not fatal; stock AL2023 installs everything at boot
+		if moleImage, err := a.findLatestMoleImage(ctx, arch); err == nil {
+			amiID = moleImage
+		}
+	}
+
+	switch {
+	case amiID != "":
+		// Pinned image, validated below
+	case selection.UseSSM:
+		amiID, err = a.getAmazonLinuxAMIFromSSM(ctx, arch)
+	default:
+		amiID, err = a.getAmazonLinuxAMI(ctx, arch)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return a.validateAMI(ctx, amiID, arch)
+}
+
+// getAmazonLinuxAMIFromSSM reads the latest AL2023 AMI ID from the public SSM parameter
+func (a *AWSClient) getAmazonLinuxAMIFromSSM(ctx context.Context, arch string) (string, error) {
+	name := fmt.Sprintf(al2023SSMParameter, arch)
+	output, err := a.ssmClient.GetParameter(ctx, &ssm.GetParameterInput{
+		Name: aws.String(name),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read SSM parameter %s: %w", name, err)
+	}
+	if output.Parameter == nil || aws.ToString(output.Parameter.Value) == "" {
+		return "", fmt.Errorf("SSM parameter %s is empty", name)
+	}
+
+	return aws.ToString(output.Parameter.Value), nil
+}
+
+// validateAMI checks that an image exists, is available and matches the instance architecture
+func (a *AWSClient) validateAMI(ctx context.Context, amiID, arch string) (*types.Image, error) {
+	output, err := a.client.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		ImageIds: []string{amiID},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe AMI %s: %w", amiID, err)
+	}
+	if len(output.Images) == 0 {
+		return nil, fmt.Errorf("AMI %s not found in %s", amiID, a.region)
+	}
+
+	image := output.Images[0]
+	if err := checkImage(image, arch); err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+// rootDeviceName returns the image's root device, defaulting to the AL2023 name
+func rootDeviceName(image *types.Image) string {
+	if image != nil && image.RootDeviceName != nil {
+		return *image.RootDeviceName
+	}
+	return "/dev/xvda"
+}
+
+// checkImage validates a described image against the expected architecture
+func checkImage(image types.Image, arch string) error {
+	amiID := aws.ToString(image.ImageId)
+	if image.State != types.ImageStateAvailable {
+		return fmt.Errorf("AMI %s is not available (state: %s)", amiID, image.State)
+	}
+	if string(image.Architecture) != arch {
+		return fmt.Errorf("AMI %s is %s but the instance type needs %s", amiID, image.Architecture, arch)
+	}
+	return nil
+}
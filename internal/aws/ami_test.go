@@ -0,0 +1,60 @@
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestArchitectureFromInstanceType(t *testing.T) {
+	tests := []struct {
+		instanceType types.InstanceType
+		expected     string
+	}{
+		{"t4g.small", ArchARM64},
+		{"c6gn.large", ArchARM64},
+		{"m7gd.xlarge", ArchARM64},
+		{"a1.medium", ArchARM64},
+		{"c5n.large", ArchX8664},
+		{"m5.large", ArchX8664},
+		{"t3.micro", ArchX8664},
+		{"c7i.large", ArchX8664},
+	}
+
+	for _, test := range tests {
+		if arch := architectureFromInstanceType(test.instanceType); arch != test.expected {
+			t.Errorf("architectureFromInstanceType(%s) = %s, expected %s", test.instanceType, arch, test.expected)
+		}
+	}
+}
+
+func TestCheckImage(t *testing.T) {
+	image := types.Image{
+		ImageId:      aws.String("ami-12345"),
+		State:        types.ImageStateAvailable,
+		Architecture: types.ArchitectureValuesArm64,
+	}
+
+	if err := checkImage(image, ArchARM64); err != nil {
+		t.Errorf("Matching image rejected: %v", err)
+	}
+	if err := checkImage(image, ArchX8664); err == nil {
+		t.Error("arm64 image should be rejected for an x86_64 instance type")
+	}
+
+	image.State = types.ImageStatePending
+	if err := checkImage(image, ArchARM64); err == nil {
+		t.Error("Pending image should be rejected")
+	}
+}
+
+func TestRootDeviceName(t *testing.T) {
+	if name := rootDeviceName(nil); name != "/dev/xvda" {
+		t.Errorf("Expected /dev/xvda default, got %s", name)
+	}
+	image := &types.Image{RootDeviceName: aws.String("/dev/sda1")}
+	if name := rootDeviceName(image); name != "/dev/sda1" {
+		t.Errorf("Expected /dev/sda1, got %s", name)
+	}
+}
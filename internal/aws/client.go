@@ -4,18 +4,25 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2instanceconnect"
 	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
 // AWSClient manages AWS resources with cost optimization
 type AWSClient struct {
-	profile   string
-	region    string
-	client    *ec2.Client
-	iamClient *iam.Client
+	profile       string
+	region        string
+	client        *ec2.Client
+	iamClient     *iam.Client
+	connectClient *ec2instanceconnect.Client
+	ssmClient     *ssm.Client
+	stsClient     *sts.Client
 }
 
 // BastionConfig defines bastion host configuration
@@ -47,6 +54,16 @@ type InstanceConfig struct {
 
 // NewAWSClient creates a new AWS client
 func NewAWSClient(profile, region string) (*AWSClient, error) {
+	return NewAWSClientWithOptions(profile, region, CredentialOptions{})
+}
+
+// NewAWSClientWithOptions creates an AWS client that optionally assumes a role (with MFA)
+// on top of the shared-config profile, which may itself be an SSO profile
+func NewAWSClientWithOptions(profile, region string, opts CredentialOptions) (*AWSClient, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
 	cfg, err := config.LoadDefaultConfig(context.Background(),
 		config.WithRegion(region),
 		config.WithSharedConfigProfile(profile),
@@ -55,11 +72,22 @@ func NewAWSClient(profile, region string) (*AWSClient, error) {
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
+	provider := cfg.Credentials
+	if opts.RoleARN != "" {
+		provider = assumeRoleProvider(cfg, profile, opts)
+	}
+	if provider != nil {
+		cfg.Credentials = aws.NewCredentialsCache(&explainingProvider{provider: provider, profile: profile})
+	}
+
 	return &AWSClient{
-		profile:   profile,
-		region:    region,
-		client:    ec2.NewFromConfig(cfg),
-		iamClient: iam.NewFromConfig(cfg),
+		profile:       profile,
+		region:        region,
+		client:        ec2.NewFromConfig(cfg),
+		iamClient:     iam.NewFromConfig(cfg),
+		connectClient: ec2instanceconnect.NewFromConfig(cfg),
+		ssmClient:     ssm.NewFromConfig(cfg),
+		stsClient:     sts.NewFromConfig(cfg),
 	}, nil
 }
 
@@ -186,9 +214,15 @@ func InstanceTypeFromString(instanceType string) types.InstanceType {
 		return types.InstanceTypeC6gnLarge
 	case "c6gn.xlarge":
 		return types.InstanceTypeC6gnXlarge
-	default:
-		return types.InstanceTypeT4gSmall // Safe default
 	}
+
+	// Any other type EC2 knows about (x86 included); the AMI follows its architecture
+	for _, known := range types.InstanceType("").Values() {
+		if string(known) == instanceType {
+			return known
+		}
+	}
+	return types.InstanceTypeT4gSmall // Safe default
 }
 
 // VPCInfo contains VPC information for display
@@ -44,6 +44,7 @@ func TestInstanceTypeFromString(t *testing.T) {
 		{"c6gn.medium", types.InstanceTypeC6gnMedium},
 		{"c6gn.large", types.InstanceTypeC6gnLarge},
 		{"c6gn.xlarge", types.InstanceTypeC6gnXlarge},
+		{"c5n.large", types.InstanceTypeC5nLarge}, // x86 types pass through
 		{"invalid-type", types.InstanceTypeT4gSmall}, // Default fallback
 		{"", types.InstanceTypeT4gSmall},              // Default fallback
 	}
@@ -0,0 +1,214 @@
+package aws
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+)
+
+// Assume-role session limits enforced by STS
+const (
+	MinSessionDuration     = 15 * time.Minute
+	MaxSessionDuration     = 12 * time.Hour
+	DefaultSessionDuration = time.Hour
+)
+
+// credentialRefreshWindow is how long before expiry cached credentials are considered stale
+const credentialRefreshWindow = 5 * time.Minute
+
+// CredentialOptions configures role assumption on top of the shared-config profile
+type CredentialOptions struct {
+	RoleARN         string        // Role to assume using the profile's credentials
+	ExternalID      string        // External ID required by the role's trust policy
+	MFASerial       string        // MFA device ARN; the token code is read from stdin
+	SessionDuration time.Duration // Assumed-role session length (default 1h)
+	SessionName     string        // Role session name (default mole-<user>)
+}
+
+// Validate checks the options before any AWS call is made
+func (o CredentialOptions) Validate() error {
+	if o.RoleARN == "" {
+		if o.ExternalID != "" || o.MFASerial != "" {
+			return fmt.Errorf("--external-id and --mfa-serial require --role-arn")
+		}
+		return nil
+	}
+	if o.SessionDuration != 0 && (o.SessionDuration < MinSessionDuration || o.SessionDuration > MaxSessionDuration) {
+		return fmt.Errorf("session duration %s must be between %s and %s", o.SessionDuration, MinSessionDuration, MaxSessionDuration)
+	}
+	return nil
+}
+
+// assumeRoleProvider wraps the profile credentials with an STS AssumeRole provider,
+// cached on disk so MFA is only prompted once per session
+func assumeRoleProvider(cfg aws.Config, profile string, opts CredentialOptions) aws.CredentialsProvider {
+	duration := opts.SessionDuration
+	if duration == 0 {
+		duration = DefaultSessionDuration
+	}
+	sessionName := opts.SessionName
+	if sessionName == "" {
+		sessionName = defaultSessionName()
+	}
+
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), opts.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = sessionName
+		o.Duration = duration
+		if opts.ExternalID != "" {
+			o.ExternalID = aws.String(opts.ExternalID)
+		}
+		if opts.MFASerial != "" {
+			o.SerialNumber = aws.String(opts.MFASerial)
+			o.TokenProvider = stscreds.StdinTokenProvider
+		}
+	})
+
+	return &fileCachedProvider{
+		provider: provider,
+		path:     credentialCachePath(profile, opts),
+	}
+}
+
+// defaultSessionName identifies the local user in CloudTrail
+func defaultSessionName() string {
+	user := os.Getenv("USER")
+	if user == "" {
+		user = "user"
+	}
+	return "mole-" + user
+}
+
+// credentialCachePath returns the cache file for a profile and role combination
+func credentialCachePath(profile string, opts CredentialOptions) string {
+	sum := sha256.Sum256([]byte(profile + "\x00" + opts.RoleARN + "\x00" + opts.ExternalID + "\x00" + opts.MFASerial))
+	return filepath.Join(os.Getenv("HOME"), ".mole", "credentials", hex.EncodeToString(sum[:8])+".json")
+}
+
+// cachedCredentials is the on-disk form of assumed-role credentials
+type cachedCredentials struct {
+	AccessKeyID     string    `json:"access_key_id"`
+	SecretAccessKey string    `json:"secret_access_key"`
+	SessionToken    string    `json:"session_token"`
+	Expires         time.Time `json:"expires"`
+}
+
+// fileCachedProvider serves credentials from ~/.mole/credentials until they near expiry
+type fileCachedProvider struct {
+	provider aws.CredentialsProvider
+	path     string
+}
+
+// Retrieve returns cached credentials when still valid, otherwise assumes the role again
+func (p *fileCachedProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	if creds, ok := readCachedCredentials(p.path, time.Now()); ok {
+		return creds, nil
+	}
+
+	creds, err := p.provider.Retrieve(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	// A failed cache write only costs another MFA prompt next time
+	if err := writeCachedCredentials(p.path, creds); err != nil {
+		fmt.Printf("  ⚠️  Warning: failed to cache credentials: %v\n", err)
+	}
+	return creds, nil
+}
+
+// readCachedCredentials loads credentials that remain valid beyond the refresh window
+func readCachedCredentials(path string, now time.Time) (aws.Credentials, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return aws.Credentials{}, false
+	}
+
+	var cached cachedCredentials
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return aws.Credentials{}, false
+	}
+	if cached.AccessKeyID == "" || now.Add(credentialRefreshWindow).After(cached.Expires) {
+		return aws.Credentials{}, false
+	}
+
+	return aws.Credentials{
+		AccessKeyID:     cached.AccessKeyID,
+		SecretAccessKey: cached.SecretAccessKey,
+		SessionToken:    cached.SessionToken,
+		Source:          "mole-credential-cache",
+		CanExpire:       true,
+		Expires:         cached.Expires,
+	}, true
+}
+
+// writeCachedCredentials stores credentials readable only by the current user
+func writeCachedCredentials(path string, creds aws.Credentials) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(cachedCredentials{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+		Expires:         creds.Expires,
+	})
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// ClearCredentialCache removes all cached assumed-role credentials
+func ClearCredentialCache() error {
+	return os.RemoveAll(filepath.Join(os.Getenv("HOME"), ".mole", "credentials"))
+}
+
+// explainingProvider rewrites expired-session errors into actionable messages
+type explainingProvider struct {
+	provider aws.CredentialsProvider
+	profile  string
+}
+
+// Retrieve delegates to the wrapped provider and explains expired SSO and STS sessions
+func (p *explainingProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.provider.Retrieve(ctx)
+	if err != nil {
+		return aws.Credentials{}, explainCredentialError(p.profile, err)
+	}
+	return creds, nil
+}
+
+// explainCredentialError turns expired SSO tokens and session credentials into errors
+// that say how to recover; other errors are returned unchanged
+func explainCredentialError(profile string, err error) error {
+	var tokenErr *ssocreds.InvalidTokenError
+	if errors.As(err, &tokenErr) {
+		return fmt.Errorf("SSO session for profile %q has expired; run 'aws sso login --profile %s': %w", profile, profile, err)
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "UnauthorizedException":
+			return fmt.Errorf("SSO access token for profile %q was rejected; run 'aws sso login --profile %s': %w", profile, profile, err)
+		case "ExpiredToken", "ExpiredTokenException":
+			return fmt.Errorf("session credentials for profile %q have expired; refresh them or run 'mole logout': %w", profile, err)
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,103 @@
+package aws
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+	"github.com/aws/smithy-go"
+)
+
+func TestCredentialOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    CredentialOptions
+		wantErr bool
+	}{
+		{"profile only", CredentialOptions{}, false},
+		{"role with MFA", CredentialOptions{RoleARN: "arn:aws:iam::123456789012:role/research", MFASerial: "arn:aws:iam::111111111111:mfa/alice"}, false},
+		{"MFA without role", CredentialOptions{MFASerial: "arn:aws:iam::111111111111:mfa/alice"}, true},
+		{"external ID without role", CredentialOptions{ExternalID: "abc"}, true},
+		{"duration too short", CredentialOptions{RoleARN: "arn:aws:iam::123456789012:role/research", SessionDuration: 5 * time.Minute}, true},
+		{"duration too long", CredentialOptions{RoleARN: "arn:aws:iam::123456789012:role/research", SessionDuration: 24 * time.Hour}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.opts.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestCredentialCacheRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials", "cache.json")
+	now := time.Now()
+
+	creds := aws.Credentials{
+		AccessKeyID:     "ASIAEXAMPLE",
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+		Expires:         now.Add(time.Hour),
+	}
+	if err := writeCachedCredentials(path, creds); err != nil {
+		t.Fatalf("writeCachedCredentials failed: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Cache file not written: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected cache mode 0600, got %o", info.Mode().Perm())
+	}
+
+	cached, ok := readCachedCredentials(path, now)
+	if !ok {
+		t.Fatal("Valid cached credentials were not returned")
+	}
+	if cached.AccessKeyID != creds.AccessKeyID || cached.SessionToken != creds.SessionToken {
+		t.Errorf("Cached credentials mismatch: %+v", cached)
+	}
+
+	// Within the refresh window the role must be assumed again
+	if _, ok := readCachedCredentials(path, now.Add(58*time.Minute)); ok {
+		t.Error("Credentials about to expire should not be served from cache")
+	}
+}
+
+func TestCredentialCachePath(t *testing.T) {
+	base := CredentialOptions{RoleARN: "arn:aws:iam::123456789012:role/research"}
+	other := CredentialOptions{RoleARN: "arn:aws:iam::123456789012:role/admin"}
+
+	if credentialCachePath("default", base) == credentialCachePath("default", other) {
+		t.Error("Different roles should use different cache files")
+	}
+	if credentialCachePath("default", base) == credentialCachePath("lab", base) {
+		t.Error("Different profiles should use different cache files")
+	}
+}
+
+func TestExplainCredentialError(t *testing.T) {
+	ssoErr := explainCredentialError("lab", &ssocreds.InvalidTokenError{})
+	if !strings.Contains(ssoErr.Error(), "aws sso login --profile lab") {
+		t.Errorf("Expired SSO token should suggest sso login, got: %v", ssoErr)
+	}
+
+	expired := explainCredentialError("lab", &smithy.GenericAPIError{Code: "ExpiredToken", Message: "expired"})
+	if !strings.Contains(expired.Error(), "have expired") {
+		t.Errorf("Expired session should be explained, got: %v", expired)
+	}
+
+	other := errors.New("network unreachable")
+	if explainCredentialError("lab", other) != other {
+		t.Error("Unrelated errors should be returned unchanged")
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"time"
 
@@ -16,28 +18,52 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/aws/aws-sdk-go-v2/service/iam"
-	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/smithy-go"
+	"github.com/research-computing/mole/internal/agent"
+	"github.com/research-computing/mole/internal/keystore"
 	"golang.org/x/crypto/curve25519"
 )
 
 // DeploymentConfig contains all deployment parameters
 type DeploymentConfig struct {
-	VPCId           string
-	PublicSubnetId  string           // Public subnet for tunnel terminator
-	PrivateSubnetId string           // Private subnet to provide NAT for (optional)
-	VPCCidr         string           // VPC CIDR for routing configuration
-	InstanceType    types.InstanceType
-	TunnelCount     int
-	MTUSize         int
-	AllowedCIDR     string
-	SSHPublicKey    string
-	Profile         string
-	Region          string
-	EnableNAT       bool             // Enable NAT functionality for private subnet
-	DeployTarget    bool             // Deploy test target instance in private subnet
-	TargetInstance  types.InstanceType // Instance type for test target
-	ClientPrivateKey string           // Local WireGuard private key (generated during deployment)
-	ClientPublicKey  string           // Local WireGuard public key (sent to server)
+	VPCId              string
+	PublicSubnetId     string   // Public subnet for tunnel terminator
+	PrivateSubnetId    string   // Private subnet to provide NAT for (optional)
+	PrivateSubnetIds   []string // Additional private subnets routed through the bastion
+	PrivateSubnetCIDRs []string // CIDRs of all private subnets (resolved during deployment)
+	RoutedCIDRs        []string // Extra destinations behind the VPC (peered VPCs, Transit Gateway)
+	VPCCidr            string   // VPC CIDR for routing configuration
+	InstanceType       types.InstanceType
+	TunnelCount        int
+	MTUSize            int
+	AllowedCIDR        string
+	SSHPublicKey       string
+	Profile            string
+	Region             string
+	EnableNAT          bool               // Enable NAT functionality for private subnet
+	DeployTarget       bool               // Deploy test target instance in private subnet
+	TargetInstance     types.InstanceType // Instance type for test target
+	ClientPrivateKey   string             // Local WireGuard private key (generated during deployment)
+	ClientPublicKey    string             // Local WireGuard public key (sent to server)
+	AccessMode         AccessMode         // How operators reach the bastion (keypair, instance-connect, ssm)
+	EnableIPv6         bool               // Dual-stack tunnel; public subnet must have an IPv6 block
+	IPv6Underlay       bool               // Client reaches the bastion over IPv6 instead of IPv4
+	AllowedIPv6CIDR    string             // IPv6 sources allowed to reach WireGuard/SSH (default ::/0)
+	VPCIPv6Cidr        string             // VPC IPv6 block, routed through the tunnel when set
+	AMI                AMISelection       // Bastion image: pinned ID, SSM lookup or latest AL2023
+	Tags               map[string]string  // User cost-allocation tags applied to every resource
+	Tunnels            []TunnelSpec       // Per-tunnel addresses and client keys (generated during deployment)
+	AgentToken         string             // Bearer token for the bastion agent (generated during deployment)
+	AgentURL           string             // Agent download URL; ${ARCH} expands to amd64/arm64 (default: release for this version)
+	AgentSHA256        map[string]string  // Agent archive SHA-256 per architecture from checksums.txt (fetched during deployment)
+	AgentTokenPath     string             // SSM parameter the bastion fetches the agent token from (generated during deployment)
+	Tuning             *KernelTuning      // Bastion kernel tuning from the optimization config (nil: kernel defaults)
+	PresharedKeys      bool               // Per-tunnel WireGuard PSKs for post-quantum hardening
+	PSKParameterPath   string             // SSM path the bastion fetches PSKs from (generated during deployment)
+	KeyStore           *keystore.Store    // Encrypted local store for client private keys and PSKs (required)
+	Userspace          bool               // Keep tunnel configs in the key store for mole proxy instead of running wg-quick
+	TunnelNetworks     []string           // IPv4 network per tunnel allocated by the client; empty uses the default plan
+	MaxTunnels         int                // WireGuard ports to open for tunnels added after deployment (default TunnelCount)
 }
 
 // DeploymentResult contains deployment outputs
@@ -49,11 +75,16 @@ type DeploymentResult struct {
 	KeyPairName       string
 	TunnelPorts       []int
 	CostEstimate      CostEstimate
-	TargetInstanceID  string  // Test target instance ID (if deployed)
-	TargetPrivateIP   string  // Test target private IP (if deployed)
-	ClientPrivateKey  string  // Local WireGuard private key
-	ClientPublicKey   string  // Local WireGuard public key
-	ServerPublicKey   string  // Server WireGuard public key (retrieved from instance)
+	TargetInstanceID  string       // Test target instance ID (if deployed)
+	TargetPrivateIP   string       // Test target private IP (if deployed)
+	ClientPublicKey   string       // Local WireGuard public key
+	ServerPublicKey   string       // Server WireGuard public key (retrieved from instance)
+	BastionPublicIPv6 string       // Bastion IPv6 address (dual-stack only)
+	DualStack         bool         // Tunnel carries IPv6 as well as IPv4
+	IPv6Underlay      bool         // Client endpoint uses BastionPublicIPv6
+	ClientAllowedIPs  []string     // Destinations the client routes through the tunnel
+	Tunnels           []TunnelSpec // Per-tunnel addresses and public keys; private keys are only in the key store
+	AgentEndpoint     string       // Bastion agent API, reachable over the first tunnel
 }
 
 // CostEstimate contains cost information
@@ -67,13 +98,38 @@ type CostEstimate struct {
 func (a *AWSClient) DirectDeploy(ctx context.Context, config *DeploymentConfig) (*DeploymentResult, error) {
 	fmt.Println("🚀 Starting direct AWS deployment...")
 
-	result := &DeploymentResult{
-		TunnelPorts: make([]int, config.TunnelCount),
+	if config.TunnelCount > MaxTunnelCount {
+		return nil, fmt.Errorf("tunnel count %d exceeds the maximum of %d", config.TunnelCount, MaxTunnelCount)
+	}
+	if config.MaxTunnels > MaxTunnelCount {
+		return nil, fmt.Errorf("max tunnels %d exceeds the maximum of %d", config.MaxTunnels, MaxTunnelCount)
+	}
+	// Unlock first so a wrong passphrase fails before anything is created
+	if config.KeyStore == nil {
+		return nil, fmt.Errorf("a key store is required for the client keys")
+	}
+	if err := config.KeyStore.Unlock(); err != nil {
+		return nil, fmt.Errorf("failed to unlock key store: %w", err)
 	}
 
 	// Generate tunnel ports
-	for i := 0; i < config.TunnelCount; i++ {
-		result.TunnelPorts[i] = 51820 + i
+	tunnels, err := planDeployment(config)
+	if err != nil {
+		return nil, err
+	}
+	result := &DeploymentResult{}
+	for _, spec := range tunnels {
+		result.TunnelPorts = append(result.TunnelPorts, spec.Port)
+	}
+
+	// The bastion only installs an agent archive matching the published checksums
+	if url := agentArchiveURL(config); url != "" {
+		if config.AgentSHA256, err = fetchAgentChecksums(ctx, url); err != nil {
+			return nil, fmt.Errorf("cannot verify the bastion agent: %w", err)
+		}
+		if config.AgentTokenPath, err = newAgentTokenParameter(); err != nil {
+			return nil, err
+		}
 	}
 
 	// Step 1: Create Security Group
@@ -85,32 +141,79 @@ func (a *AWSClient) DirectDeploy(ctx context.Context, config *DeploymentConfig)
 	result.SecurityGroupID = sgID
 	fmt.Printf("  ✓ Security group created: %s\n", sgID)
 
-	// Step 2: Create IAM role for EC2 instance
+	// Step 2: Create IAM role for EC2 instance (scoped to the PSK and agent token parameters)
+	if config.PresharedKeys {
+		config.PSKParameterPath, err = newPSKParameterPath()
+		if err != nil {
+			return nil, err
+		}
+	}
 	fmt.Println("🔒 Creating IAM role for instance permissions...")
-	roleName, err := a.createIAMRole(ctx)
+	roleName, err := a.createIAMRole(ctx, config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create IAM role: %w", err)
 	}
 	fmt.Printf("  ✓ IAM role created: %s\n", roleName)
 
 	// Step 3: Create AWS-managed key pair (for emergency access only)
-	fmt.Println("🔑 Setting up emergency access key...")
-	keyName, err := a.createKeyPair(ctx, config.SSHPublicKey)
+	var keyName string
+	if config.AccessMode.usesKeyPair() {
+		fmt.Println("🔑 Setting up emergency access key...")
+		keyName, err = a.createKeyPair(ctx, config.SSHPublicKey, config.Tags)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create key pair: %w", err)
+		}
+		result.KeyPairName = keyName
+		fmt.Printf("  ✓ Emergency key configured: %s\n", keyName)
+	} else {
+		fmt.Printf("🔑 Skipping key pair (access mode: %s)\n", config.AccessMode)
+	}
+
+	// Resolve private subnet CIDRs and route tables before rendering user data
+	privateSubnets, err := a.resolvePrivateSubnets(ctx, config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create key pair: %w", err)
+		return nil, fmt.Errorf("failed to resolve private subnets: %w", err)
+	}
+	config.PrivateSubnetCIDRs = nil
+	for _, subnet := range privateSubnets {
+		config.PrivateSubnetCIDRs = append(config.PrivateSubnetCIDRs, subnet.CidrBlock)
 	}
-	result.KeyPairName = keyName
-	fmt.Printf("  ✓ Emergency key configured: %s\n", keyName)
 
-	// Step 4: Generate local WireGuard client keys
+	// Step 4: Generate local WireGuard client keys, one pair per tunnel
 	fmt.Println("🔑 Generating WireGuard client keys...")
-	clientPrivateKey, clientPublicKey, err := a.generateWireGuardKeys()
+	for i := range tunnels {
+		clientPrivateKey, clientPublicKey, err := a.generateWireGuardKeys()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate WireGuard keys for tunnel %d: %w", i, err)
+		}
+		tunnels[i].ClientPrivateKey = clientPrivateKey
+		tunnels[i].ClientPublicKey = clientPublicKey
+	}
+	config.Tunnels = tunnels
+	config.ClientPrivateKey = tunnels[0].ClientPrivateKey
+	config.ClientPublicKey = tunnels[0].ClientPublicKey
+	fmt.Printf("  ✓ Client keys generated for %d tunnel(s)\n", len(tunnels))
+
+	if config.PresharedKeys {
+		fmt.Println("🔐 Storing per-tunnel pre-shared keys in SSM Parameter Store...")
+		if err := generatePresharedKeys(tunnels); err != nil {
+			return nil, err
+		}
+		if err := a.storePresharedKeys(ctx, config.PSKParameterPath, tunnels, config.Tags); err != nil {
+			return nil, err
+		}
+		fmt.Printf("  ✓ %d pre-shared key(s) under %s (deleted by the bastion after boot)\n", len(tunnels), config.PSKParameterPath)
+	}
+
+	config.AgentToken, err = agent.GenerateToken()
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate WireGuard keys: %w", err)
+		return nil, err
+	}
+	if config.AgentTokenPath != "" {
+		if err := a.storeAgentToken(ctx, config.AgentTokenPath, config.AgentToken, config.Tags); err != nil {
+			return nil, err
+		}
 	}
-	config.ClientPrivateKey = clientPrivateKey
-	config.ClientPublicKey = clientPublicKey
-	fmt.Printf("  ✓ Client keys generated\n")
 
 	// Step 5: Launch Instance with client public key
 	fmt.Println("☁️  Launching bastion instance...")
@@ -121,6 +224,15 @@ func (a *AWSClient) DirectDeploy(ctx context.Context, config *DeploymentConfig)
 	result.BastionInstanceID = instanceID
 	fmt.Printf("  ✓ Instance launched: %s\n", instanceID)
 
+	if err := agent.SaveToken(instanceID, config.AgentToken); err != nil {
+		fmt.Printf("  ⚠️  Warning: %v\n", err)
+	}
+	if err := storeClientKeys(config.KeyStore, instanceID, tunnels); err != nil {
+		return nil, err
+	}
+	fmt.Printf("  ✓ Client keys encrypted in %s (%s backend)\n", keystore.Path(), config.KeyStore.Backend())
+	result.AgentEndpoint = agentEndpoint(tunnels[0])
+
 	// Step 5: Wait for instance to be running
 	fmt.Println("⏳ Waiting for instance to be running...")
 	if err := a.waitForInstanceRunning(ctx, instanceID); err != nil {
@@ -136,14 +248,29 @@ func (a *AWSClient) DirectDeploy(ctx context.Context, config *DeploymentConfig)
 	result.BastionPublicIP = publicIP
 	result.BastionPrivateIP = privateIP
 
-	// Step 7: Retrieve server WireGuard public key from instance tags
-	fmt.Println("🔑 Retrieving server WireGuard public key...")
-	serverPublicKey, err := a.getServerPublicKey(ctx, instanceID)
+	if config.EnableIPv6 {
+		publicIPv6, err := a.getInstanceIPv6(ctx, instanceID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get instance IPv6 address: %w", err)
+		}
+		result.BastionPublicIPv6 = publicIPv6
+		result.DualStack = true
+		result.IPv6Underlay = config.IPv6Underlay
+		fmt.Printf("  ✓ Bastion IPv6: %s\n", publicIPv6)
+	}
+	result.ClientAllowedIPs = clientAllowedIPs(config)
+
+	// Step 7: Retrieve server WireGuard public keys from instance tags
+	fmt.Println("🔑 Retrieving server WireGuard public keys...")
+	serverPublicKeys, err := a.getServerPublicKeys(ctx, instanceID, len(tunnels))
 	if err != nil {
-		return nil, fmt.Errorf("failed to get server public key: %w", err)
+		return nil, fmt.Errorf("failed to get server public keys: %w", err)
 	}
-	result.ServerPublicKey = serverPublicKey
-	result.ClientPrivateKey = config.ClientPrivateKey
+	for i := range tunnels {
+		tunnels[i].ServerPublicKey = serverPublicKeys[i]
+	}
+	result.Tunnels = withoutSecrets(tunnels)
+	result.ServerPublicKey = tunnels[0].ServerPublicKey
 	result.ClientPublicKey = config.ClientPublicKey
 	fmt.Printf("  ✓ Keys exchanged successfully\n")
 
@@ -159,10 +286,10 @@ func (a *AWSClient) DirectDeploy(ctx context.Context, config *DeploymentConfig)
 		fmt.Printf("  ✓ Target instance: %s (%s)\n", targetID, targetIP)
 	}
 
-	// Step 9: Configure routing for private subnet (if specified)
-	if config.PrivateSubnetId != "" {
-		fmt.Println("🗺️  Configuring routes for private subnet access...")
-		err := a.configurePrivateSubnetRouting(ctx, config, instanceID)
+	// Step 9: Configure routing for private subnets (if specified)
+	if len(privateSubnets) > 0 {
+		fmt.Printf("🗺️  Configuring routes for %d private subnet(s)...\n", len(privateSubnets))
+		err := a.configurePrivateSubnetRouting(ctx, config, privateSubnets, instanceID)
 		if err != nil {
 			fmt.Printf("  ⚠️  Warning: Failed to configure private subnet routing: %v\n", err)
 		} else {
@@ -187,15 +314,24 @@ func (a *AWSClient) DirectDeploy(ctx context.Context, config *DeploymentConfig)
 	}
 	fmt.Printf("  Monthly cost: $%.2f\n", result.CostEstimate.MonthlyCost)
 
-	// Step 11: Auto-establish WireGuard tunnel
-	fmt.Println("🔗 Establishing WireGuard tunnel...")
-	err = a.setupLocalTunnel(result)
+	// Step 11: Auto-establish WireGuard tunnels, unless they run in-process
+	if config.Userspace {
+		if err := storeTunnelConfigs(config.KeyStore, result, tunnels); err != nil {
+			return nil, fmt.Errorf("failed to store userspace tunnel configs: %w", err)
+		}
+		fmt.Printf("  ✓ Tunnel configs saved to the key store for userspace mode\n")
+		return result, nil
+	}
+	fmt.Printf("🔗 Establishing %d WireGuard tunnel(s)...\n", len(tunnels))
+	err = a.setupLocalTunnel(result, config.KeyStore)
 	if err != nil {
 		fmt.Printf("  ⚠️  Warning: Failed to establish local tunnel: %v\n", err)
 		fmt.Printf("  💡 You can manually establish the tunnel later using the saved config\n")
 	} else {
-		fmt.Printf("  ✅ WireGuard tunnel established successfully!\n")
-		fmt.Printf("  🎯 Try: ping 10.100.2.8\n")
+		fmt.Printf("  ✅ WireGuard tunnels established successfully!\n")
+		if result.TargetPrivateIP != "" {
+			fmt.Printf("  🎯 Try: ping %s\n", result.TargetPrivateIP)
+		}
 	}
 
 	return result, nil
@@ -210,6 +346,10 @@ func (a *AWSClient) createSecurityGroup(ctx context.Context, config *DeploymentC
 		GroupName:   &groupName,
 		Description: aws.String("AWS Cloud Mole WireGuard Security Group"),
 		VpcId:       &config.VPCId,
+		TagSpecifications: tagSpecifications([]types.Tag{
+			{Key: aws.String("Name"), Value: aws.String(groupName)},
+			{Key: aws.String("CreatedBy"), Value: aws.String("aws-cloud-mole")},
+		}, config.Tags, types.ResourceTypeSecurityGroup),
 	})
 	if err != nil {
 		return "", err
@@ -217,12 +357,55 @@ func (a *AWSClient) createSecurityGroup(ctx context.Context, config *DeploymentC
 
 	sgID := *createOutput.GroupId
 
-	// Create ingress rules
+	// Add ingress rules
+	_, err = a.client.AuthorizeSecurityGroupIngress(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId:       &sgID,
+		IpPermissions: buildIngressRules(config),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return sgID, nil
+}
+
+// buildIngressRules returns the bastion security group ingress rules for a deployment
+func buildIngressRules(config *DeploymentConfig) []types.IpPermission {
 	var ingressRules []types.IpPermission
+	tunnels := deploymentTunnels(config)
+
+	// IPv6 sources for the public-facing ports (dual-stack only)
+	ipv6Ranges := func(description string) []types.Ipv6Range {
+		if !config.EnableIPv6 {
+			return nil
+		}
+		allowed := config.AllowedIPv6CIDR
+		if allowed == "" {
+			allowed = defaultAllowedIPv6
+		}
+		return []types.Ipv6Range{{CidrIpv6: aws.String(allowed), Description: aws.String(description)}}
+	}
+
+	// Every tunnel network, so traffic over any tunnel is allowed
+	tunnelRanges := func(description string) []types.IpRange {
+		var ranges []types.IpRange
+		for _, network := range tunnelNetworks(tunnels) {
+			ranges = append(ranges, types.IpRange{CidrIp: aws.String(network), Description: aws.String(description)})
+		}
+		return ranges
+	}
+
+	tunnelIPv6Ranges := func(description string) []types.Ipv6Range {
+		var ranges []types.Ipv6Range
+		for _, network := range tunnelIPv6Networks(tunnels) {
+			ranges = append(ranges, types.Ipv6Range{CidrIpv6: aws.String(network), Description: aws.String(description)})
+		}
+		return ranges
+	}
 
-	// WireGuard ports
-	for i := 0; i < config.TunnelCount; i++ {
-		port := int32(51820 + i)
+	// WireGuard ports, including those of tunnels the scaler may add later
+	for i := 0; i < max(config.TunnelCount, config.MaxTunnels); i++ {
+		port := int32(tunnelBasePort + i)
 		ingressRules = append(ingressRules, types.IpPermission{
 			IpProtocol: aws.String("udp"),
 			FromPort:   &port,
@@ -233,75 +416,91 @@ func (a *AWSClient) createSecurityGroup(ctx context.Context, config *DeploymentC
 					Description: aws.String(fmt.Sprintf("WireGuard tunnel %d", i)),
 				},
 			},
+			Ipv6Ranges: ipv6Ranges(fmt.Sprintf("WireGuard tunnel %d", i)),
 		})
 	}
 
-	// SSH port
-	sshPort := int32(22)
-	ingressRules = append(ingressRules, types.IpPermission{
-		IpProtocol: aws.String("tcp"),
-		FromPort:   &sshPort,
-		ToPort:     &sshPort,
-		IpRanges: []types.IpRange{
-			{
-				CidrIp:      &config.AllowedCIDR,
-				Description: aws.String("SSH management"),
+	// SSH port (not needed when managing the bastion through SSM)
+	if config.AccessMode.allowsSSH() {
+		sshPort := int32(22)
+		ingressRules = append(ingressRules, types.IpPermission{
+			IpProtocol: aws.String("tcp"),
+			FromPort:   &sshPort,
+			ToPort:     &sshPort,
+			IpRanges: []types.IpRange{
+				{
+					CidrIp:      &config.AllowedCIDR,
+					Description: aws.String("SSH management"),
+				},
 			},
-		},
-	})
+			Ipv6Ranges: ipv6Ranges("SSH management"),
+		})
+	}
 
 	// ICMP (ping) - allow from VPC CIDR and tunnel network
 	ingressRules = append(ingressRules, types.IpPermission{
 		IpProtocol: aws.String("icmp"),
 		FromPort:   aws.Int32(-1),
 		ToPort:     aws.Int32(-1),
-		IpRanges: []types.IpRange{
+		IpRanges: append([]types.IpRange{
 			{
 				CidrIp:      &config.VPCCidr,
 				Description: aws.String("ICMP from VPC"),
 			},
-			{
-				CidrIp:      aws.String("10.100.1.0/24"),
-				Description: aws.String("ICMP from WireGuard tunnel"),
-			},
-		},
+		}, tunnelRanges("ICMP from WireGuard tunnel")...),
 	})
 
 	// HTTP port 8080 for test server (from tunnel network)
-	httpPort := int32(8080)
+	httpPort := int32(testServerPort)
 	ingressRules = append(ingressRules, types.IpPermission{
 		IpProtocol: aws.String("tcp"),
 		FromPort:   &httpPort,
 		ToPort:     &httpPort,
-		IpRanges: []types.IpRange{
-			{
-				CidrIp:      aws.String("10.100.1.0/24"),
-				Description: aws.String("HTTP test server from tunnel"),
-			},
-		},
+		IpRanges:   tunnelRanges("HTTP test server from tunnel"),
+		Ipv6Ranges: tunnelIPv6Ranges("HTTP test server from IPv6 tunnel"),
 	})
 
-	// Add ingress rules
-	_, err = a.client.AuthorizeSecurityGroupIngress(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
-		GroupId:       &sgID,
-		IpPermissions: ingressRules,
-	})
-	if err != nil {
-		return "", err
+	// ICMPv6 (ping and neighbour discovery) from the IPv6 tunnel network and VPC
+	if config.EnableIPv6 {
+		icmpv6Ranges := tunnelIPv6Ranges("ICMPv6 from WireGuard tunnel")
+		if config.VPCIPv6Cidr != "" {
+			icmpv6Ranges = append(icmpv6Ranges, types.Ipv6Range{
+				CidrIpv6:    aws.String(config.VPCIPv6Cidr),
+				Description: aws.String("ICMPv6 from VPC"),
+			})
+		}
+		ingressRules = append(ingressRules, types.IpPermission{
+			IpProtocol: aws.String("icmpv6"),
+			FromPort:   aws.Int32(-1),
+			ToPort:     aws.Int32(-1),
+			Ipv6Ranges: icmpv6Ranges,
+		})
 	}
 
-	return sgID, nil
+	return ingressRules
+}
+
+// optionalString returns nil for empty strings so optional API fields are omitted
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
 }
 
 // createKeyPair creates an AWS-managed SSH key pair
-func (a *AWSClient) createKeyPair(ctx context.Context, publicKey string) (string, error) {
+func (a *AWSClient) createKeyPair(ctx context.Context, publicKey string, userTags map[string]string) (string, error) {
 	keyName := fmt.Sprintf("mole-key-%d", time.Now().Unix())
 
 	// Create new AWS-managed key pair
 	result, err := a.client.CreateKeyPair(ctx, &ec2.CreateKeyPairInput{
-		KeyName: &keyName,
-		KeyType: types.KeyTypeRsa,
+		KeyName:   &keyName,
+		KeyType:   types.KeyTypeRsa,
 		KeyFormat: types.KeyFormatPem,
+		TagSpecifications: tagSpecifications([]types.Tag{
+			{Key: aws.String("Name"), Value: aws.String(keyName)},
+			{Key: aws.String("CreatedBy"), Value: aws.String("aws-cloud-mole")},
+		}, userTags, types.ResourceTypeKeyPair),
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to create key pair: %w", err)
@@ -324,25 +523,30 @@ func (a *AWSClient) createKeyPair(ctx context.Context, publicKey string) (string
 
 // launchBastion launches the bastion EC2 instance
 func (a *AWSClient) launchBastion(ctx context.Context, config *DeploymentConfig, sgID, keyName, iamRole string) (string, error) {
-	// Get Amazon Linux AMI (lightweight & optimized)
-	ami, err := a.getAmazonLinuxAMI(ctx)
+	// Get an Amazon Linux AMI matching the instance architecture (or the pinned image)
+	image, err := a.resolveAMI(ctx, config.InstanceType, config.AMI)
 	if err != nil {
 		return "", err
 	}
+	fmt.Printf("  ✓ AMI: %s (%s)\n", aws.ToString(image.ImageId), image.Architecture)
 
 	// Create user data script with NAT bridge configuration
-	userData := a.generateUserData(ctx, config)
+	userData, err := a.generateUserData(ctx, config)
+	if err != nil {
+		return "", err
+	}
 
 	// Launch instance
 	runResult, err := a.client.RunInstances(ctx, &ec2.RunInstancesInput{
-		ImageId:          &ami,
+		ImageId:          image.ImageId,
 		InstanceType:     config.InstanceType,
-		KeyName:          &keyName,
+		KeyName:          optionalString(keyName),
 		MinCount:         aws.Int32(1),
 		MaxCount:         aws.Int32(1),
 		SecurityGroupIds: []string{sgID},
 		SubnetId:         &config.PublicSubnetId,
 		UserData:         &userData,
+		Ipv6AddressCount: ipv6AddressCount(config),
 		IamInstanceProfile: &types.IamInstanceProfileSpecification{
 			Name: &iamRole,
 		},
@@ -351,7 +555,7 @@ func (a *AWSClient) launchBastion(ctx context.Context, config *DeploymentConfig,
 		},
 		BlockDeviceMappings: []types.BlockDeviceMapping{
 			{
-				DeviceName: aws.String("/dev/sda1"),
+				DeviceName: aws.String(rootDeviceName(image)),
 				Ebs: &types.EbsBlockDevice{
 					VolumeSize:          aws.Int32(20),
 					VolumeType:          types.VolumeTypeGp3,
@@ -360,102 +564,74 @@ func (a *AWSClient) launchBastion(ctx context.Context, config *DeploymentConfig,
 				},
 			},
 		},
-	})
-	if err != nil {
-		return "", err
-	}
-
-	instanceID := *runResult.Instances[0].InstanceId
-
-	// Tag the instance
-	_, err = a.client.CreateTags(ctx, &ec2.CreateTagsInput{
-		Resources: []string{instanceID},
-		Tags: []types.Tag{
+		// Tag at launch so the root volume and ENI carry the cost-allocation tags too
+		TagSpecifications: tagSpecifications([]types.Tag{
 			{Key: aws.String("Name"), Value: aws.String("mole-bastion")},
 			{Key: aws.String("Project"), Value: aws.String("aws-cloud-mole")},
 			{Key: aws.String("ManagedBy"), Value: aws.String("mole-cli")},
-		},
+			{Key: aws.String("CreatedBy"), Value: aws.String("aws-cloud-mole")},
+		}, config.Tags, types.ResourceTypeInstance, types.ResourceTypeVolume, types.ResourceTypeNetworkInterface),
 	})
 	if err != nil {
-		// Non-fatal error
-		fmt.Printf("Warning: failed to tag instance: %v\n", err)
+		return "", err
 	}
 
+	instanceID := *runResult.Instances[0].InstanceId
+
 	return instanceID, nil
 }
 
-// generateUserData creates BULLETPROOF user data - 30 second boot, zero failures
-func (a *AWSClient) generateUserData(ctx context.Context, config *DeploymentConfig) string {
-	// Pre-calculate private subnet CIDR to avoid API calls in user data
-	privateSubnetCidr, err := a.getSubnetCIDR(ctx, config.PrivateSubnetId)
-	if err != nil || privateSubnetCidr == "" {
-		// Use fallback based on VPC CIDR or sensible default
-		if strings.Contains(config.VPCCidr, "10.100.") {
-			privateSubnetCidr = "10.100.2.0/24"
-		} else {
-			privateSubnetCidr = "10.200.2.0/24"
+// generateUserData renders the AL2023 bastion script with pre-calculated values, so boot needs no API lookups
+func (a *AWSClient) generateUserData(ctx context.Context, config *DeploymentConfig) (string, error) {
+	// Pre-calculate private subnet CIDRs to avoid API calls in user data
+	privateSubnetCidrs := config.PrivateSubnetCIDRs
+	if len(privateSubnetCidrs) == 0 {
+		privateSubnetCidr, err := a.getSubnetCIDR(ctx, config.PrivateSubnetId)
+		if err != nil || privateSubnetCidr == "" {
+			// Use fallback based on VPC CIDR or sensible default
+			if strings.Contains(config.VPCCidr, "10.100.") {
+				privateSubnetCidr = "10.100.2.0/24"
+			} else {
+				privateSubnetCidr = "10.200.2.0/24"
+			}
 		}
+		privateSubnetCidrs = []string{privateSubnetCidr}
 	}
 
-	script := fmt.Sprintf(`#!/bin/bash
-set -euo pipefail
-
-# BULLETPROOF: Pre-calculated values, no API calls, minimal operations
-CLIENT_PUBLIC_KEY="%s"
-PRIVATE_SUBNET_CIDR="%s"
-REGION="%s"
-
-# Install only essentials - skip updates for speed
-dnf install -y wireguard-tools --skip-broken
-
-# Enable IP forwarding
-echo 'net.ipv4.ip_forward=1' >> /etc/sysctl.conf
-sysctl -p
-
-# Generate WireGuard keys (fast)
-mkdir -p /etc/mole/keys
-wg genkey | tee /etc/mole/keys/wg0_private.key | wg pubkey > /etc/mole/keys/wg0_public.key
-chmod 600 /etc/mole/keys/wg0_private.key
-
-# Get keys
-SERVER_PRIVATE_KEY=$(cat /etc/mole/keys/wg0_private.key)
-SERVER_PUBLIC_KEY=$(cat /etc/mole/keys/wg0_public.key)
-
-# Create WireGuard config (minimal)
-cat > /etc/wireguard/wg0.conf << EOF
-[Interface]
-PrivateKey = $SERVER_PRIVATE_KEY
-Address = 10.100.1.1/24
-ListenPort = 51820
-
-[Peer]
-PublicKey = $CLIENT_PUBLIC_KEY
-AllowedIPs = 10.100.1.2/32
-EOF
-
-# Start WireGuard
-wg-quick up wg0
-
-# Basic iptables rules (minimal)
-iptables -A INPUT -p udp --dport 51820 -j ACCEPT
-iptables -A FORWARD -i wg0 -j ACCEPT
-iptables -A FORWARD -o wg0 -j ACCEPT
-iptables -t nat -A POSTROUTING -s $PRIVATE_SUBNET_CIDR -j MASQUERADE
+	script, err := RenderBastionUserData(bastionUserData(config, OSAmazonLinux, privateSubnetCidrs))
+	if err != nil {
+		return "", err
+	}
 
-# Get instance ID and tag with server public key (fast)
-TOKEN=$(curl -X PUT "http://169.254.169.254/latest/api/token" -H "X-aws-ec2-metadata-token-ttl-seconds: 21600")
-INSTANCE_ID=$(curl -H "X-aws-ec2-metadata-token: $TOKEN" http://169.254.169.254/latest/meta-data/instance-id)
+	// Base64 encode the script for AWS user data
+	return base64.StdEncoding.EncodeToString([]byte(script)), nil
+}
 
-# Disable source/dest check and tag
-aws ec2 modify-instance-attribute --instance-id $INSTANCE_ID --no-source-dest-check --region $REGION &
-aws ec2 create-tags --resources $INSTANCE_ID --tags Key=WireGuardPublicKey,Value="$SERVER_PUBLIC_KEY" --region $REGION &
+// agentReleaseURL is the GoReleaser archive for a released version, or "" for dev builds
+func agentReleaseURL(v string) string {
+	if v == "" || v == "dev" {
+		return ""
+	}
+	v = strings.TrimPrefix(v, "v")
+	return fmt.Sprintf("https://github.com/research-computing/mole/releases/download/v%[1]s/mole_%[1]s_linux_${ARCH}.tar.gz", v)
+}
 
-# Signal ready - FAST BOOT COMPLETE
-echo "ready" > /etc/mole/status
-`, config.ClientPublicKey, privateSubnetCidr, config.Region)
+// AgentEndpoint is the agent API URL on a deployed bastion: the bastion's address on the
+// first tunnel, whose IPv4 network is given when the client allocated it ("" for the default)
+func AgentEndpoint(network string) string {
+	spec := planTunnels(1, false)[0]
+	if network != "" {
+		if allocated, err := withTunnelNetwork(spec, network); err == nil {
+			spec = allocated
+		}
+	}
+	return agentEndpoint(spec)
+}
 
-	// Base64 encode the script for AWS user data
-	return base64.StdEncoding.EncodeToString([]byte(script))
+// agentEndpoint is the agent API URL on a tunnel's bastion address
+func agentEndpoint(spec TunnelSpec) string {
+	host := strings.Split(spec.ServerAddress, "/")[0]
+	return fmt.Sprintf("http://%s:%d", host, agent.DefaultPort)
 }
 
 // waitForInstanceRunning waits for instance to reach running state
@@ -492,6 +668,60 @@ func (a *AWSClient) getInstanceIPs(ctx context.Context, instanceID string) (publ
 	return publicIP, privateIP, nil
 }
 
+// getInstanceIPv6 retrieves the primary IPv6 address of a dual-stack instance
+func (a *AWSClient) getInstanceIPv6(ctx context.Context, instanceID string) (string, error) {
+	output, err := a.client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(output.Reservations) == 0 || len(output.Reservations[0].Instances) == 0 {
+		return "", fmt.Errorf("instance not found")
+	}
+
+	instance := output.Reservations[0].Instances[0]
+	if instance.Ipv6Address != nil {
+		return *instance.Ipv6Address, nil
+	}
+	for _, eni := range instance.NetworkInterfaces {
+		for _, address := range eni.Ipv6Addresses {
+			if address.Ipv6Address != nil {
+				return *address.Ipv6Address, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("instance %s has no IPv6 address (does the subnet have an IPv6 block?)", instanceID)
+}
+
+// ipv6AddressCount requests one IPv6 address for dual-stack bastions
+func ipv6AddressCount(config *DeploymentConfig) *int32 {
+	if !config.EnableIPv6 {
+		return nil
+	}
+	return aws.Int32(1)
+}
+
+// clientAllowedIPs returns the destinations the client routes through the tunnel: every
+// private subnet plus any peered or Transit Gateway CIDRs, so both ends agree on what is routed
+func clientAllowedIPs(config *DeploymentConfig) []string {
+	var allowed []string
+	allowed = append(allowed, config.PrivateSubnetCIDRs...)
+	allowed = append(allowed, config.RoutedCIDRs...)
+	if len(allowed) == 0 {
+		allowed = []string{"10.100.2.0/24"}
+	}
+	if config.EnableIPv6 {
+		allowed = append(allowed, tunnelIPv6Network)
+		if config.VPCIPv6Cidr != "" {
+			allowed = append(allowed, config.VPCIPv6Cidr)
+		}
+	}
+	return allowed
+}
+
 // calculateCostEstimate calculates cost for the instance
 func (a *AWSClient) calculateCostEstimate(instanceType types.InstanceType) CostEstimate {
 	// Cost per hour for Graviton instances (approximate)
@@ -517,9 +747,8 @@ func (a *AWSClient) calculateCostEstimate(instanceType types.InstanceType) CostE
 	}
 }
 
-// getAmazonLinuxAMI finds the latest Amazon Linux 2023 AMI (lightweight & optimized)
-func (a *AWSClient) getAmazonLinuxAMI(ctx context.Context) (string, error) {
-	// Try ARM64 first (for Graviton instances - better performance/cost)
+// getAmazonLinuxAMI finds the latest Amazon Linux 2023 AMI (lightweight & optimized) for an architecture
+func (a *AWSClient) getAmazonLinuxAMI(ctx context.Context, arch string) (string, error) {
 	output, err := a.client.DescribeImages(ctx, &ec2.DescribeImagesInput{
 		Owners: []string{"amazon"}, // Amazon official AMIs
 		Filters: []types.Filter{
@@ -529,7 +758,7 @@ func (a *AWSClient) getAmazonLinuxAMI(ctx context.Context) (string, error) {
 			},
 			{
 				Name:   aws.String("architecture"),
-				Values: []string{"arm64"},
+				Values: []string{arch},
 			},
 			{
 				Name:   aws.String("state"),
@@ -545,36 +774,8 @@ func (a *AWSClient) getAmazonLinuxAMI(ctx context.Context) (string, error) {
 		return "", err
 	}
 
-	// If ARM64 not available, fallback to x86_64
 	if len(output.Images) == 0 {
-		output, err = a.client.DescribeImages(ctx, &ec2.DescribeImagesInput{
-			Owners: []string{"amazon"},
-			Filters: []types.Filter{
-				{
-					Name:   aws.String("name"),
-					Values: []string{"al2023-ami-*"},
-				},
-				{
-					Name:   aws.String("architecture"),
-					Values: []string{"x86_64"},
-				},
-				{
-					Name:   aws.String("state"),
-					Values: []string{"available"},
-				},
-				{
-					Name:   aws.String("image-type"),
-					Values: []string{"machine"},
-				},
-			},
-		})
-		if err != nil {
-			return "", err
-		}
-	}
-
-	if len(output.Images) == 0 {
-		return "", fmt.Errorf("no suitable Amazon Linux AMI found")
+		return "", fmt.Errorf("no suitable Amazon Linux AMI found for %s", arch)
 	}
 
 	// Return the most recent AMI
@@ -591,98 +792,45 @@ func (a *AWSClient) getAmazonLinuxAMI(ctx context.Context) (string, error) {
 
 // deployTargetInstance deploys a test target instance in the private subnet
 func (a *AWSClient) deployTargetInstance(ctx context.Context, config *DeploymentConfig, sgID, keyName string) (string, string, error) {
-	amiID, err := a.getAmazonLinuxAMI(ctx)
+	// Use the same instance type or default to nano for cost efficiency
+	targetInstanceType := config.TargetInstance
+	if targetInstanceType == "" {
+		targetInstanceType = types.InstanceTypeT4gNano
+	}
+
+	// The target always runs stock AL2023; only the bastion honours a pinned AMI
+	image, err := a.resolveAMI(ctx, targetInstanceType, AMISelection{UseSSM: config.AMI.UseSSM, StockOnly: true})
 	if err != nil {
 		return "", "", fmt.Errorf("failed to get Amazon Linux AMI: %w", err)
 	}
 
 	// Create minimal, fast user data for test target - prioritize speed
-	userData := `#!/bin/bash
-set -euo pipefail
-
-# Skip system updates for speed - install only essentials
-dnf install -y net-tools python3 --skip-broken
-
-# Enable ICMP (ping) responses
-echo 'net.ipv4.icmp_echo_ignore_all = 0' >> /etc/sysctl.conf
-sysctl -p
-
-# Create lightweight test server - starts immediately
-cat > /home/ec2-user/test-server.py << 'EOF'
-#!/usr/bin/env python3
-import http.server
-import socketserver
-import socket
-
-class TestHandler(http.server.SimpleHTTPRequestHandler):
-    def do_GET(self):
-        self.send_response(200)
-        self.send_header('Content-type', 'text/html')
-        self.end_headers()
-
-        hostname = socket.gethostname()
-        try:
-            local_ip = socket.gethostbyname(hostname)
-        except:
-            local_ip = "unknown"
-
-        response = f"""<html><body>
-<h1>AWS Cloud Mole Test Target</h1>
-<p><strong>Hostname:</strong> {hostname}</p>
-<p><strong>Private IP:</strong> {local_ip}</p>
-<p><strong>Status:</strong> ✅ NAT Bridge Working!</p>
-<p>Successfully reached test target through WireGuard tunnel.</p>
-</body></html>"""
-        self.wfile.write(response.encode())
-
-PORT = 8080
-with socketserver.TCPServer(("", PORT), TestHandler) as httpd:
-    print(f"Test server running on port {PORT}")
-    httpd.serve_forever()
-EOF
-
-chown ec2-user:ec2-user /home/ec2-user/test-server.py
-chmod +x /home/ec2-user/test-server.py
-
-# Start test server immediately in background (no systemd delay)
-nohup python3 /home/ec2-user/test-server.py > /home/ec2-user/server.log 2>&1 &
-echo $! > /home/ec2-user/server.pid
-
-echo "test-target-ready" > /home/ec2-user/status
-`
+	userData, err := RenderTargetUserData(TargetUserData{OS: OSAmazonLinux, Port: testServerPort})
+	if err != nil {
+		return "", "", err
+	}
 
 	userDataEncoded := base64.StdEncoding.EncodeToString([]byte(userData))
 
-	// Use the same instance type or default to nano for cost efficiency
-	targetInstanceType := config.TargetInstance
-	if targetInstanceType == "" {
-		targetInstanceType = types.InstanceTypeT4gNano
-	}
-
 	runInput := &ec2.RunInstancesInput{
-		ImageId:                       &amiID,
-		InstanceType:                  targetInstanceType,
-		KeyName:                       &keyName, // Use same SSH key as bastion
-		SecurityGroupIds:              []string{sgID}, // Use same security group
-		SubnetId:                      &config.PrivateSubnetId, // Deploy in private subnet
-		UserData:                      &userDataEncoded,
-		MinCount:                      aws.Int32(1),
-		MaxCount:                      aws.Int32(1),
+		ImageId:          image.ImageId,
+		InstanceType:     targetInstanceType,
+		KeyName:          optionalString(keyName), // Use same SSH key as bastion (if any)
+		SecurityGroupIds: []string{sgID},          // Use same security group
+		SubnetId:         &config.PrivateSubnetId, // Deploy in private subnet
+		UserData:         &userDataEncoded,
+		MinCount:         aws.Int32(1),
+		MaxCount:         aws.Int32(1),
 		// No public IP assignment - private subnet only (handled by subnet config)
 		InstanceInitiatedShutdownBehavior: types.ShutdownBehaviorTerminate,
 		Monitoring: &types.RunInstancesMonitoringEnabled{
 			Enabled: aws.Bool(true),
 		},
-		TagSpecifications: []types.TagSpecification{
-			{
-				ResourceType: types.ResourceTypeInstance,
-				Tags: []types.Tag{
-					{Key: aws.String("Name"), Value: aws.String("mole-test-target")},
-					{Key: aws.String("Purpose"), Value: aws.String("nat-bridge-testing")},
-					{Key: aws.String("CreatedBy"), Value: aws.String("aws-cloud-mole")},
-				},
-			},
-		},
+		TagSpecifications: tagSpecifications([]types.Tag{
+			{Key: aws.String("Name"), Value: aws.String("mole-test-target")},
+			{Key: aws.String("Purpose"), Value: aws.String("nat-bridge-testing")},
+			{Key: aws.String("CreatedBy"), Value: aws.String("aws-cloud-mole")},
+		}, config.Tags, types.ResourceTypeInstance, types.ResourceTypeVolume, types.ResourceTypeNetworkInterface),
 	}
 
 	result, err := a.client.RunInstances(ctx, runInput)
@@ -766,51 +914,16 @@ func (a *AWSClient) FindInstancesByTag(ctx context.Context, tagKey, tagValue str
 	return instances, nil
 }
 
-// configurePrivateSubnetRouting adds routes to the private subnet for WireGuard tunnel access
-func (a *AWSClient) configurePrivateSubnetRouting(ctx context.Context, config *DeploymentConfig, bastionInstanceID string) error {
-	// Find the route table associated with the private subnet
-	routeTablesResult, err := a.client.DescribeRouteTables(ctx, &ec2.DescribeRouteTablesInput{
-		Filters: []types.Filter{
-			{
-				Name:   aws.String("association.subnet-id"),
-				Values: []string{config.PrivateSubnetId},
-			},
-		},
-	})
-	if err != nil {
-		return fmt.Errorf("failed to find route table for private subnet: %w", err)
+// ec2ErrorContains checks if an AWS EC2 error carries a specific error code
+func ec2ErrorContains(err error, code string) bool {
+	if err == nil {
+		return false
 	}
-
-	if len(routeTablesResult.RouteTables) == 0 {
-		return fmt.Errorf("no route table found for private subnet %s", config.PrivateSubnetId)
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode() == code
 	}
-
-	routeTableId := *routeTablesResult.RouteTables[0].RouteTableId
-
-	// Add a route for WireGuard tunnel network (10.100.1.0/24) to the bastion instance
-	// This allows private subnet instances to reach the tunnel network
-	_, err = a.client.CreateRoute(ctx, &ec2.CreateRouteInput{
-		RouteTableId:         &routeTableId,
-		DestinationCidrBlock: aws.String("10.100.1.0/24"), // WireGuard tunnel network
-		InstanceId:           &bastionInstanceID,
-	})
-	if err != nil {
-		// Check if route already exists
-		if ec2ErrorContains(err, "RouteAlreadyExists") {
-			// Route already exists, that's fine
-			return nil
-		}
-		return fmt.Errorf("failed to create route to tunnel network: %w", err)
-	}
-
-	return nil
-}
-
-// ec2ErrorContains checks if an AWS EC2 error contains a specific error code
-func ec2ErrorContains(err error, code string) bool {
-	return err != nil && (
-		err.Error() == code ||
-		(len(err.Error()) > len(code) && err.Error()[len(err.Error())-len(code):] == code))
+	return strings.Contains(err.Error(), code)
 }
 
 // generateWireGuardKeys generates a WireGuard private/public key pair
@@ -830,11 +943,16 @@ func (a *AWSClient) generateWireGuardKeys() (privateKey, publicKey string, err e
 	return privateKeyB64, publicKeyB64, nil
 }
 
-// getServerPublicKey retrieves the server's WireGuard public key from instance tags
-func (a *AWSClient) getServerPublicKey(ctx context.Context, instanceID string) (string, error) {
+// getServerPublicKeys retrieves each tunnel's server WireGuard public key from instance tags
+func (a *AWSClient) getServerPublicKeys(ctx context.Context, instanceID string, count int) ([]string, error) {
 	// Wait a bit for the instance to finish initialization and tag itself
 	time.Sleep(30 * time.Second)
 
+	tagKeys := make([]string, count)
+	for id := range tagKeys {
+		tagKeys[id] = ServerPublicKeyTag(id)
+	}
+
 	for i := 0; i < 12; i++ { // Try for up to 2 minutes
 		result, err := a.client.DescribeTags(ctx, &ec2.DescribeTagsInput{
 			Filters: []types.Filter{
@@ -844,27 +962,71 @@ func (a *AWSClient) getServerPublicKey(ctx context.Context, instanceID string) (
 				},
 				{
 					Name:   aws.String("key"),
-					Values: []string{"WireGuardPublicKey"},
+					Values: tagKeys,
 				},
 			},
 		})
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
-		if len(result.Tags) > 0 && result.Tags[0].Value != nil {
-			return *result.Tags[0].Value, nil
+		if keys, ok := serverKeysFromTags(result.Tags, tagKeys); ok {
+			return keys, nil
 		}
 
 		fmt.Printf("  ⏳ Waiting for server key generation... (%d/12)\n", i+1)
 		time.Sleep(10 * time.Second)
 	}
 
-	return "", fmt.Errorf("server public key not found in instance tags after 2 minutes")
+	return nil, fmt.Errorf("server public keys not found in instance tags after 2 minutes")
+}
+
+// serverKeysFromTags orders the published keys by tunnel, reporting whether all are present
+func serverKeysFromTags(tags []types.TagDescription, tagKeys []string) ([]string, bool) {
+	values := make(map[string]string)
+	for _, tag := range tags {
+		values[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+
+	keys := make([]string, len(tagKeys))
+	for i, tagKey := range tagKeys {
+		if values[tagKey] == "" {
+			return nil, false
+		}
+		keys[i] = values[tagKey]
+	}
+	return keys, true
+}
+
+// storeClientKeys encrypts each tunnel's client private key and PSK under the bastion's instance ID
+func storeClientKeys(store *keystore.Store, instanceID string, tunnels []TunnelSpec) error {
+	for _, spec := range tunnels {
+		if err := store.Put(keystore.ClientKeyName(instanceID, spec.Interface), spec.ClientPrivateKey); err != nil {
+			return fmt.Errorf("failed to store client key for %s: %w", spec.Interface, err)
+		}
+		if spec.PresharedKey == "" {
+			continue
+		}
+		if err := store.Put(keystore.PresharedKeyName(instanceID, spec.Interface), spec.PresharedKey); err != nil {
+			return fmt.Errorf("failed to store pre-shared key for %s: %w", spec.Interface, err)
+		}
+	}
+	return nil
+}
+
+// withoutSecrets copies tunnel specs with the private and pre-shared keys cleared
+func withoutSecrets(tunnels []TunnelSpec) []TunnelSpec {
+	public := make([]TunnelSpec, len(tunnels))
+	for i, spec := range tunnels {
+		spec.ClientPrivateKey, spec.PresharedKey = "", ""
+		public[i] = spec
+	}
+	return public
 }
 
-// setupLocalTunnel creates and establishes the local WireGuard tunnel with platform awareness
-func (a *AWSClient) setupLocalTunnel(result *DeploymentResult) error {
+// setupLocalTunnel creates and establishes the local WireGuard tunnel with platform awareness.
+// Keys come from the key store and exist in plaintext only in the materialised configs.
+func (a *AWSClient) setupLocalTunnel(result *DeploymentResult, store *keystore.Store) error {
 	fmt.Printf("  🖥️  Detected platform: %s/%s\n", runtime.GOOS, runtime.GOARCH)
 
 	// Check privilege level
@@ -881,20 +1043,90 @@ func (a *AWSClient) setupLocalTunnel(result *DeploymentResult) error {
 		return fmt.Errorf("failed to cleanup existing interfaces: %w", err)
 	}
 
-	// Platform-specific tunnel creation
-	switch runtime.GOOS {
-	case "darwin":
-		return a.setupMacOSTunnel(result, env)
-	case "linux":
-		return a.setupLinuxTunnel(result, env)
-	case "windows":
-		return a.setupWindowsTunnel(result, env)
-	case "freebsd", "openbsd", "netbsd", "dragonfly":
-		return a.setupBSDTunnel(result, env)
-	default:
-		fmt.Printf("  ❌ Unsupported platform: %s\n", runtime.GOOS)
-		return fmt.Errorf("unsupported platform: %s. AWS Cloud Mole supports macOS, Linux, Windows, and BSD variants (FreeBSD, OpenBSD, NetBSD, DragonFly)", runtime.GOOS)
+	// Platform-specific tunnel creation, one interface per tunnel
+	for _, spec := range result.Tunnels {
+		var err error
+		if spec.ClientPrivateKey, err = store.Get(keystore.ClientKeyName(result.BastionInstanceID, spec.Interface)); err != nil {
+			return err
+		}
+		if name := keystore.PresharedKeyName(result.BastionInstanceID, spec.Interface); store.Has(name) {
+			if spec.PresharedKey, err = store.Get(name); err != nil {
+				return err
+			}
+		}
+
+		switch runtime.GOOS {
+		case "darwin":
+			err = a.setupMacOSTunnel(result, spec, env)
+		case "linux":
+			err = a.setupLinuxTunnel(result, spec, env)
+		case "windows":
+			err = a.setupWindowsTunnel(result, spec, env)
+		case "freebsd", "openbsd", "netbsd", "dragonfly":
+			err = a.setupBSDTunnel(result, spec, env)
+		default:
+			fmt.Printf("  ❌ Unsupported platform: %s\n", runtime.GOOS)
+			return fmt.Errorf("unsupported platform: %s. AWS Cloud Mole supports macOS, Linux, Windows, and BSD variants (FreeBSD, OpenBSD, NetBSD, DragonFly)", runtime.GOOS)
+		}
+		if err != nil {
+			return fmt.Errorf("tunnel %d (%s): %w", spec.ID, spec.Interface, err)
+		}
 	}
+
+	return nil
+}
+
+// storeTunnelConfigs keeps each tunnel's client config, keys included, encrypted in the key
+// store, where mole proxy reads it from
+func storeTunnelConfigs(store *keystore.Store, result *DeploymentResult, tunnels []TunnelSpec) error {
+	for _, spec := range tunnels {
+		name := keystore.TunnelConfigName(result.BastionInstanceID, spec.Interface)
+		if err := store.Put(name, generateClientConfig(result, spec, "")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generateClientConfig renders the local wg-quick config for one tunnel to the deployed bastion.
+// Only tunnel 0 installs routes; the others use Table = off and are balanced by ECMP.
+func generateClientConfig(result *DeploymentResult, spec TunnelSpec, dns string) string {
+	address := spec.ClientAddress
+	if spec.ClientIPv6 != "" {
+		address += ", " + spec.ClientIPv6
+	}
+
+	allowedIPs := append([]string{}, result.ClientAllowedIPs...)
+	if len(allowedIPs) == 0 {
+		allowedIPs = []string{"10.100.2.0/24"}
+	}
+	// The tunnel's own network, so the bastion end of every tunnel is reachable
+	for _, network := range []string{spec.Network, spec.IPv6Network} {
+		if network != "" && !slices.Contains(allowedIPs, network) {
+			allowedIPs = append(allowedIPs, network)
+		}
+	}
+
+	var config strings.Builder
+	config.WriteString("[Interface]\n")
+	config.WriteString(fmt.Sprintf("PrivateKey = %s\n", spec.ClientPrivateKey))
+	config.WriteString(fmt.Sprintf("Address = %s\n", address))
+	config.WriteString("MTU = 1500\n")
+	if spec.ID > 0 {
+		config.WriteString("Table = off\n")
+	} else if dns != "" {
+		config.WriteString(fmt.Sprintf("DNS = %s\n", dns))
+	}
+	config.WriteString("\n[Peer]\n")
+	config.WriteString(fmt.Sprintf("PublicKey = %s\n", spec.ServerPublicKey))
+	if spec.PresharedKey != "" {
+		config.WriteString(fmt.Sprintf("PresharedKey = %s\n", spec.PresharedKey))
+	}
+	config.WriteString(fmt.Sprintf("Endpoint = %s:%d\n", clientEndpointHost(result), spec.Port))
+	config.WriteString(fmt.Sprintf("AllowedIPs = %s\n", strings.Join(allowedIPs, ", ")))
+	config.WriteString("PersistentKeepalive = 25\n")
+
+	return config.String()
 }
 
 // setupSudoEnvironment configures sudo environment based on platform
@@ -992,30 +1224,11 @@ func (a *AWSClient) isWindowsAdmin() bool {
 }
 
 // setupMacOSTunnel creates WireGuard tunnel on macOS
-func (a *AWSClient) setupMacOSTunnel(result *DeploymentResult, env []string) error {
-	fmt.Printf("  🍎 Setting up macOS WireGuard tunnel...\n")
-
-	// Create tunnel config in user directory (safer on macOS)
-	tunnelDir := filepath.Join(os.Getenv("HOME"), ".mole", "tunnels")
-	if err := os.MkdirAll(tunnelDir, 0755); err != nil {
-		return fmt.Errorf("failed to create tunnel directory: %w", err)
-	}
-
-	configPath := filepath.Join(tunnelDir, "wg0.conf")
-	configContent := fmt.Sprintf(`[Interface]
-PrivateKey = %s
-Address = 10.100.1.2/24
-ListenPort = 51821
-MTU = 1500
-
-[Peer]
-PublicKey = %s
-Endpoint = %s:51820
-AllowedIPs = 10.100.2.0/24
-PersistentKeepalive = 25
-`, result.ClientPrivateKey, result.ServerPublicKey, result.BastionPublicIP)
-
-	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+func (a *AWSClient) setupMacOSTunnel(result *DeploymentResult, spec TunnelSpec, env []string) error {
+	fmt.Printf("  🍎 Setting up macOS WireGuard tunnel %s...\n", spec.Interface)
+
+	configPath, err := keystore.Materialize(spec.Interface, []byte(generateClientConfig(result, spec, "")))
+	if err != nil {
 		return fmt.Errorf("failed to write tunnel config: %w", err)
 	}
 
@@ -1030,7 +1243,7 @@ PersistentKeepalive = 25
 		if strings.Contains(string(output), "already exists") {
 			fmt.Printf("  💡 Interface already exists, attempting cleanup and retry...\n")
 			// Try cleanup one more time and retry
-			a.cleanupMacOSInterfaces([]string{"wg0", "utun8", "utun9", "utun10"}, env)
+			a.cleanupMacOSInterfaces([]string{spec.Interface, "utun8", "utun9", "utun10"}, env)
 
 			// Retry with a slightly different approach
 			upCmd = exec.Command("sudo", "-A", "wg-quick", "up", configPath)
@@ -1048,45 +1261,19 @@ PersistentKeepalive = 25
 }
 
 // setupLinuxTunnel creates WireGuard tunnel on Linux
-func (a *AWSClient) setupLinuxTunnel(result *DeploymentResult, env []string) error {
-	fmt.Printf("  🐧 Setting up Linux WireGuard tunnel...\n")
-
-	// On Linux, use system-wide config directory
-	configDir := "/etc/wireguard"
-	configPath := filepath.Join(configDir, "wg0.conf")
-
-	// Create config directory if it doesn't exist
-	if err := os.MkdirAll(configDir, 0755); err != nil {
-		// Fallback to user directory if system directory fails
-		userConfigDir := filepath.Join(os.Getenv("HOME"), ".config", "wireguard")
-		if err := os.MkdirAll(userConfigDir, 0755); err != nil {
-			return fmt.Errorf("failed to create config directory: %w", err)
-		}
-		configPath = filepath.Join(userConfigDir, "wg0.conf")
-	}
-
-	configContent := fmt.Sprintf(`[Interface]
-PrivateKey = %s
-Address = 10.100.1.2/24
-ListenPort = 51821
-MTU = 1500
-
-[Peer]
-PublicKey = %s
-Endpoint = %s:51820
-AllowedIPs = 10.100.2.0/24
-PersistentKeepalive = 25
-`, result.ClientPrivateKey, result.ServerPublicKey, result.BastionPublicIP)
+func (a *AWSClient) setupLinuxTunnel(result *DeploymentResult, spec TunnelSpec, env []string) error {
+	fmt.Printf("  🐧 Setting up Linux WireGuard tunnel %s...\n", spec.Interface)
 
-	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+	configPath, err := keystore.Materialize(spec.Interface, []byte(generateClientConfig(result, spec, "")))
+	if err != nil {
 		return fmt.Errorf("failed to write tunnel config: %w", err)
 	}
 
 	fmt.Printf("  📄 Config written: %s\n", configPath)
 	fmt.Printf("  🚀 Establishing WireGuard tunnel...\n")
 
-	// On Linux, can use interface name directly
-	upCmd := exec.Command("sudo", "-A", "wg-quick", "up", "wg0")
+	// wg-quick takes the interface name from the config file name
+	upCmd := exec.Command("sudo", "-A", "wg-quick", "up", configPath)
 	upCmd.Env = env
 	if output, err := upCmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("failed to bring up tunnel on Linux: %w\nOutput: %s", err, output)
@@ -1097,30 +1284,11 @@ PersistentKeepalive = 25
 }
 
 // setupGenericTunnel provides generic tunnel setup for unknown platforms
-func (a *AWSClient) setupGenericTunnel(result *DeploymentResult, env []string) error {
+func (a *AWSClient) setupGenericTunnel(result *DeploymentResult, spec TunnelSpec, env []string) error {
 	fmt.Printf("  🖥️  Setting up generic WireGuard tunnel...\n")
 
-	// Use user directory for unknown platforms
-	tunnelDir := filepath.Join(os.Getenv("HOME"), ".mole", "tunnels")
-	if err := os.MkdirAll(tunnelDir, 0755); err != nil {
-		return fmt.Errorf("failed to create tunnel directory: %w", err)
-	}
-
-	configPath := filepath.Join(tunnelDir, "wg0.conf")
-	configContent := fmt.Sprintf(`[Interface]
-PrivateKey = %s
-Address = 10.100.1.2/24
-ListenPort = 51821
-MTU = 1500
-
-[Peer]
-PublicKey = %s
-Endpoint = %s:51820
-AllowedIPs = 10.100.2.0/24
-PersistentKeepalive = 25
-`, result.ClientPrivateKey, result.ServerPublicKey, result.BastionPublicIP)
-
-	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+	configPath, err := keystore.Materialize(spec.Interface, []byte(generateClientConfig(result, spec, "")))
+	if err != nil {
 		return fmt.Errorf("failed to write tunnel config: %w", err)
 	}
 
@@ -1139,46 +1307,17 @@ PersistentKeepalive = 25
 }
 
 // setupWindowsTunnel creates WireGuard tunnel on Windows 11+
-func (a *AWSClient) setupWindowsTunnel(result *DeploymentResult, env []string) error {
-	fmt.Printf("  🪟 Setting up Windows WireGuard tunnel...\n")
-
-	// Windows WireGuard uses %USERPROFILE%\Documents or %PROGRAMFILES%\WireGuard\Data\Configurations
-	userProfile := os.Getenv("USERPROFILE")
-	if userProfile == "" {
-		userProfile = os.Getenv("HOME") // Fallback
-	}
-
-	// Create config directory in user's Documents folder (accessible without admin)
-	configDir := filepath.Join(userProfile, "Documents", "WireGuard")
-	if err := os.MkdirAll(configDir, 0755); err != nil {
-		// Fallback to AppData if Documents fails
-		appData := os.Getenv("APPDATA")
-		if appData != "" {
-			configDir = filepath.Join(appData, "WireGuard")
-			if err := os.MkdirAll(configDir, 0755); err != nil {
-				return fmt.Errorf("failed to create Windows config directory: %w", err)
-			}
-		} else {
-			return fmt.Errorf("failed to create Windows config directory: %w", err)
-		}
-	}
-
-	configPath := filepath.Join(configDir, "mole-tunnel.conf")
-	configContent := fmt.Sprintf(`[Interface]
-PrivateKey = %s
-Address = 10.100.1.2/24
-ListenPort = 51821
-MTU = 1500
-DNS = 1.1.1.1
+func (a *AWSClient) setupWindowsTunnel(result *DeploymentResult, spec TunnelSpec, env []string) error {
+	fmt.Printf("  🪟 Setting up Windows WireGuard tunnel %s...\n", spec.Interface)
 
-[Peer]
-PublicKey = %s
-Endpoint = %s:51820
-AllowedIPs = 10.100.2.0/24
-PersistentKeepalive = 25
-`, result.ClientPrivateKey, result.ServerPublicKey, result.BastionPublicIP)
-
-	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+	// The file name becomes the tunnel service name; WireGuard for Windows keeps its own
+	// encrypted copy once the service is installed
+	configName := "mole-tunnel"
+	if spec.ID > 0 {
+		configName = fmt.Sprintf("mole-tunnel%d", spec.ID)
+	}
+	configPath, err := keystore.Materialize(configName, []byte(generateClientConfig(result, spec, "1.1.1.1")))
+	if err != nil {
 		return fmt.Errorf("failed to write Windows tunnel config: %w", err)
 	}
 
@@ -1235,56 +1374,11 @@ PersistentKeepalive = 25
 }
 
 // setupBSDTunnel creates WireGuard tunnel on BSD variants (FreeBSD, OpenBSD, NetBSD, DragonFly)
-func (a *AWSClient) setupBSDTunnel(result *DeploymentResult, env []string) error {
+func (a *AWSClient) setupBSDTunnel(result *DeploymentResult, spec TunnelSpec, env []string) error {
 	fmt.Printf("  🔱 Setting up BSD WireGuard tunnel (%s)...\n", runtime.GOOS)
 
-	// BSD uses different paths than Linux
-	var configDir, configPath string
-
-	switch runtime.GOOS {
-	case "freebsd":
-		// FreeBSD: /usr/local/etc/wireguard
-		configDir = "/usr/local/etc/wireguard"
-	case "openbsd":
-		// OpenBSD: /etc/wireguard
-		configDir = "/etc/wireguard"
-	case "netbsd":
-		// NetBSD: /usr/pkg/etc/wireguard
-		configDir = "/usr/pkg/etc/wireguard"
-	case "dragonfly":
-		// DragonFly BSD: /usr/local/etc/wireguard
-		configDir = "/usr/local/etc/wireguard"
-	default:
-		// Fallback
-		configDir = "/etc/wireguard"
-	}
-
-	configPath = filepath.Join(configDir, "wg0.conf")
-
-	// Create config directory if it doesn't exist
-	if err := os.MkdirAll(configDir, 0755); err != nil {
-		// Fallback to user directory if system directory fails
-		userConfigDir := filepath.Join(os.Getenv("HOME"), ".config", "wireguard")
-		if err := os.MkdirAll(userConfigDir, 0755); err != nil {
-			return fmt.Errorf("failed to create BSD config directory: %w", err)
-		}
-		configPath = filepath.Join(userConfigDir, "wg0.conf")
-	}
-
-	configContent := fmt.Sprintf(`[Interface]
-PrivateKey = %s
-Address = 10.100.1.2/24
-ListenPort = 51821
-MTU = 1500
-
-[Peer]
-PublicKey = %s
-Endpoint = %s:51820
-AllowedIPs = 10.100.2.0/24
-PersistentKeepalive = 25
-`, result.ClientPrivateKey, result.ServerPublicKey, result.BastionPublicIP)
-
-	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+	configPath, err := keystore.Materialize(spec.Interface, []byte(generateClientConfig(result, spec, "")))
+	if err != nil {
 		return fmt.Errorf("failed to write BSD tunnel config: %w", err)
 	}
 
@@ -1292,7 +1386,7 @@ PersistentKeepalive = 25
 	fmt.Printf("  🚀 Establishing WireGuard tunnel on %s...\n", runtime.GOOS)
 
 	// On BSD, use wg-quick similar to Linux
-	upCmd := exec.Command("sudo", "-A", "wg-quick", "up", "wg0")
+	upCmd := exec.Command("sudo", "-A", "wg-quick", "up", configPath)
 	upCmd.Env = env
 	if output, err := upCmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("failed to bring up tunnel on %s: %w\nOutput: %s", runtime.GOOS, err, output)
@@ -1349,11 +1443,10 @@ func (a *AWSClient) cleanupMacOSInterfaces(interfaces []string, env []string) er
 
 		// On macOS, WireGuard interfaces are often utun devices
 		// First try to find and remove the config file
-		configPaths := []string{
+		configPaths := append(keystore.ConfigPaths(),
 			fmt.Sprintf("/opt/homebrew/etc/wireguard/%s.conf", iface),
 			fmt.Sprintf("/usr/local/etc/wireguard/%s.conf", iface),
-			fmt.Sprintf("%s/.mole/tunnels/wg0.conf", os.Getenv("HOME")),
-		}
+		)
 
 		configFound := false
 		for _, configPath := range configPaths {
@@ -1411,7 +1504,7 @@ func (a *AWSClient) cleanupLinuxInterfaces(interfaces []string, env []string) er
 		fmt.Printf("  ⬇️  Bringing down Linux interface: %s\n", iface)
 
 		// Try wg-quick down first with interface name
-		cleanupCmd := exec.Command("sudo", "-A", "wg-quick", "down", iface)
+		cleanupCmd := exec.Command("sudo", "-A", "wg-quick", "down", keystore.ConfigOrName(iface))
 		cleanupCmd.Env = env
 		if err := cleanupCmd.Run(); err != nil {
 			// If that fails, try removing with ip link (Linux-specific)
@@ -1489,7 +1582,7 @@ func (a *AWSClient) cleanupBSDInterfaces(interfaces []string, env []string) erro
 		fmt.Printf("  ⬇️  Bringing down BSD interface: %s\n", iface)
 
 		// Try wg-quick down first (similar to Linux)
-		cleanupCmd := exec.Command("sudo", "-A", "wg-quick", "down", iface)
+		cleanupCmd := exec.Command("sudo", "-A", "wg-quick", "down", keystore.ConfigOrName(iface))
 		cleanupCmd.Env = env
 		if err := cleanupCmd.Run(); err != nil {
 			// BSD-specific interface removal using ifconfig
@@ -1527,8 +1620,40 @@ func (a *AWSClient) verifyInterfaceCleanup() error {
 	return nil
 }
 
+// instancePolicy is the bastion role's inline policy: tagging and attribute changes at boot,
+// plus reading and deleting this deployment's pre-shared keys
+func instancePolicy(config *DeploymentConfig) PolicyDocument {
+	statements := []PolicyStatement{
+		anyResource("MoleInstanceBoot",
+			"ec2:CreateTags",
+			"ec2:ModifyInstanceAttribute",
+			"ec2:DescribeInstances",
+			"ec2:DescribeTags",
+			"ec2:DescribeVpcs",
+			"ec2:DescribeSubnets",
+		),
+	}
+	if config.PSKParameterPath != "" {
+		statements = append(statements, PolicyStatement{
+			Sid:      "MolePresharedKeys",
+			Effect:   "Allow",
+			Action:   []string{"ssm:GetParameter", "ssm:DeleteParameter"},
+			Resource: []string{pskParameterARN(config.PSKParameterPath)},
+		})
+	}
+	if config.AgentTokenPath != "" {
+		statements = append(statements, PolicyStatement{
+			Sid:      "MoleAgentToken",
+			Effect:   "Allow",
+			Action:   []string{"ssm:GetParameter", "ssm:DeleteParameter"},
+			Resource: []string{"arn:aws:ssm:*:*:parameter" + config.AgentTokenPath},
+		})
+	}
+	return PolicyDocument{Version: "2012-10-17", Statement: statements}
+}
+
 // createIAMRole creates IAM role and instance profile for EC2 instance permissions
-func (a *AWSClient) createIAMRole(ctx context.Context) (string, error) {
+func (a *AWSClient) createIAMRole(ctx context.Context, config *DeploymentConfig) (string, error) {
 	roleName := fmt.Sprintf("mole-instance-role-%d", time.Now().Unix())
 
 	// Define trust policy for EC2
@@ -1545,34 +1670,22 @@ func (a *AWSClient) createIAMRole(ctx context.Context) (string, error) {
 		]
 	}`
 
-	// Define instance policy for tagging and attribute modification
-	instancePolicy := `{
-		"Version": "2012-10-17",
-		"Statement": [
-			{
-				"Effect": "Allow",
-				"Action": [
-					"ec2:CreateTags",
-					"ec2:ModifyInstanceAttribute",
-					"ec2:DescribeInstances",
-					"ec2:DescribeTags",
-					"ec2:DescribeVpcs",
-					"ec2:DescribeSubnets"
-				],
-				"Resource": "*"
-			}
-		]
-	}`
+	policyDocument, err := instancePolicy(config).JSON()
+	if err != nil {
+		return "", err
+	}
+
+	roleTags := iamTags([]types.Tag{
+		{Key: aws.String("Project"), Value: aws.String("aws-cloud-mole")},
+		{Key: aws.String("Purpose"), Value: aws.String("instance-permissions")},
+	}, config.Tags)
 
 	// Create IAM role
-	_, err := a.iamClient.CreateRole(ctx, &iam.CreateRoleInput{
+	_, err = a.iamClient.CreateRole(ctx, &iam.CreateRoleInput{
 		RoleName:                 aws.String(roleName),
 		AssumeRolePolicyDocument: aws.String(trustPolicy),
 		Path:                     aws.String("/mole/"),
-		Tags: []iamtypes.Tag{
-			{Key: aws.String("Project"), Value: aws.String("aws-cloud-mole")},
-			{Key: aws.String("Purpose"), Value: aws.String("instance-permissions")},
-		},
+		Tags:                     roleTags,
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to create IAM role: %w", err)
@@ -1583,16 +1696,24 @@ func (a *AWSClient) createIAMRole(ctx context.Context) (string, error) {
 	_, err = a.iamClient.PutRolePolicy(ctx, &iam.PutRolePolicyInput{
 		RoleName:       aws.String(roleName),
 		PolicyName:     aws.String(policyName),
-		PolicyDocument: aws.String(instancePolicy),
+		PolicyDocument: aws.String(policyDocument),
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to attach policy to role: %w", err)
 	}
 
+	// Session Manager access replaces SSH when running in SSM mode
+	if config.AccessMode == AccessModeSSM {
+		if err := a.attachSSMPolicy(ctx, roleName); err != nil {
+			return "", err
+		}
+	}
+
 	// Create instance profile
 	_, err = a.iamClient.CreateInstanceProfile(ctx, &iam.CreateInstanceProfileInput{
 		InstanceProfileName: aws.String(roleName),
 		Path:                aws.String("/mole/"),
+		Tags:                roleTags,
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to create instance profile: %w", err)
@@ -1601,7 +1722,7 @@ func (a *AWSClient) createIAMRole(ctx context.Context) (string, error) {
 	// Add role to instance profile
 	_, err = a.iamClient.AddRoleToInstanceProfile(ctx, &iam.AddRoleToInstanceProfileInput{
 		InstanceProfileName: aws.String(roleName),
-		RoleName:           aws.String(roleName),
+		RoleName:            aws.String(roleName),
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to add role to instance profile: %w", err)
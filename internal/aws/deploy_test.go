@@ -68,7 +68,7 @@ func TestDeploymentResult(t *testing.T) {
 			MonthlyCost: 12.26,
 			DailyCost:   0.4032,
 		},
-		ClientPrivateKey: "test-private-key",
+		ClientPublicKey:  "test-client-key",
 		ServerPublicKey:  "test-public-key",
 		TargetInstanceID: "i-target123",
 		TargetPrivateIP:  "10.0.2.100",
@@ -86,8 +86,8 @@ func TestDeploymentResult(t *testing.T) {
 		t.Errorf("Expected MonthlyCost=12.26, got %f", result.CostEstimate.MonthlyCost)
 	}
 
-	if result.ClientPrivateKey == "" {
-		t.Error("ClientPrivateKey should not be empty")
+	if result.ClientPublicKey == "" {
+		t.Error("ClientPublicKey should not be empty")
 	}
 
 	if result.ServerPublicKey == "" {
@@ -303,4 +303,78 @@ func TestDeploymentConfigValidation(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+func TestAgentInstallScript(t *testing.T) {
+	first := planTunnels(1, false)[0]
+
+	config := &DeploymentConfig{
+		AgentToken:     "token-value",
+		AgentTokenPath: "/mole/agent/0123456789abcdef",
+		AgentSHA256:    map[string]string{"arm64": strings.Repeat("c", 64)},
+	}
+	if model := agentUserData(config, first); model != nil {
+		t.Errorf("Development builds should skip the agent without --agent-url: %+v", model)
+	}
+
+	config.AgentURL = "https://example.com/mole_linux_${ARCH}.tar.gz"
+	script, err := RenderBastionUserData(BastionUserData{OS: OSAmazonLinux, Tunnels: []TunnelSpec{first}, Agent: agentUserData(config, first)})
+	if err != nil {
+		t.Fatalf("Failed to render user data: %v", err)
+	}
+	if strings.Contains(script, "token-value") {
+		t.Error("The agent token should not be in user data")
+	}
+	checks := []string{
+		"--with-decryption --name /mole/agent/0123456789abcdef --query Parameter.Value --output text > /etc/mole/agent.token",
+		"aws ssm delete-parameter --region $REGION --name /mole/agent/0123456789abcdef",
+		"arm64) AGENT_SHA256=" + strings.Repeat("c", 64) + " ;;",
+		`curl -fsSL -o /tmp/mole-agent.tar.gz "https://example.com/mole_linux_${ARCH}.tar.gz"`,
+		`echo "$AGENT_SHA256  /tmp/mole-agent.tar.gz" | sha256sum -c --quiet -`,
+		"ExecStart=/usr/local/bin/mole agent --listen 10.100.1.1:7800 --token-file /etc/mole/agent.token",
+		"iptables -A INPUT -p tcp --dport 7800 ! -i wg+ -j DROP",
+		"systemctl enable --now mole-agent",
+	}
+	for _, check := range checks {
+		if !strings.Contains(script, check) {
+			t.Errorf("Agent install script missing %q", check)
+		}
+	}
+
+	if url := agentReleaseURL("v1.2.3"); url != "https://github.com/research-computing/mole/releases/download/v1.2.3/mole_1.2.3_linux_${ARCH}.tar.gz" {
+		t.Errorf("Unexpected release URL: %s", url)
+	}
+	if agentEndpoint(first) != "http://10.100.1.1:7800" {
+		t.Errorf("Unexpected agent endpoint: %s", agentEndpoint(first))
+	}
+
+	// Without checksums the agent is not installed
+	config.AgentSHA256 = nil
+	if model := agentUserData(config, first); model != nil {
+		t.Errorf("An unverifiable agent should not be installed: %+v", model)
+	}
+}
+
+func TestAgentChecksums(t *testing.T) {
+	const archiveURL = "https://github.com/research-computing/mole/releases/download/v1.2.3/mole_1.2.3_linux_${ARCH}.tar.gz"
+	if url := agentChecksumsURL(archiveURL); url != "https://github.com/research-computing/mole/releases/download/v1.2.3/checksums.txt" {
+		t.Errorf("Unexpected checksums URL: %s", url)
+	}
+
+	checksums := strings.Join([]string{
+		strings.Repeat("1", 64) + "  mole_1.2.3_darwin_arm64.tar.gz",
+		strings.Repeat("2", 64) + "  mole_1.2.3_linux_amd64.tar.gz",
+		strings.Repeat("3", 64) + " *mole_1.2.3_linux_arm64.tar.gz",
+		"not a checksum  mole_1.2.3_linux_386.tar.gz",
+	}, "\n")
+	sums, err := agentChecksums(strings.NewReader(checksums), archiveURL)
+	if err != nil {
+		t.Fatalf("agentChecksums failed: %v", err)
+	}
+	if len(sums) != 2 || sums["amd64"] != strings.Repeat("2", 64) || sums["arm64"] != strings.Repeat("3", 64) {
+		t.Errorf("Unexpected checksums: %v", sums)
+	}
+
+	if _, err := agentChecksums(strings.NewReader(checksums), "https://example.com/mole_9.9.9_linux_${ARCH}.tar.gz"); err == nil {
+		t.Error("Expected an archive missing from checksums.txt to fail")
+	}
+}
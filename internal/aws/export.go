@@ -2,11 +2,17 @@ package aws
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
 // ExportTerraform generates equivalent Terraform configuration
-func (a *AWSClient) ExportTerraform(config *DeploymentConfig) string {
+func (a *AWSClient) ExportTerraform(config *DeploymentConfig) (string, error) {
+	userData, err := exportUserData(config)
+	if err != nil {
+		return "", err
+	}
+
 	var tf strings.Builder
 
 	tf.WriteString(`# AWS Cloud Mole Infrastructure (Generated)
@@ -23,7 +29,7 @@ terraform {
 provider "aws" {
   region  = "` + config.Region + `"
   profile = "` + config.Profile + `"
-}
+` + terraformDefaultTags(config.Tags) + `}
 
 # Security Group
 resource "aws_security_group" "mole_wireguard" {
@@ -97,30 +103,10 @@ data "aws_ami" "ubuntu" {
   }
 }
 
-# User Data
+# User Data (rendered from mole's bastion template)
 locals {
-  user_data = base64encode(<<-EOF
-#!/bin/bash
-set -euo pipefail
-
-TUNNEL_COUNT=` + fmt.Sprintf("%d", config.TunnelCount) + `
-MTU_SIZE=` + fmt.Sprintf("%d", config.MTUSize) + `
-
-apt-get update -y
-apt-get install -y wireguard wireguard-tools iptables-persistent awscli
-
-echo 'net.ipv4.ip_forward=1' >> /etc/sysctl.conf
-sysctl -p
-
-mkdir -p /etc/mole/keys
-
-for i in $(seq 0 $((TUNNEL_COUNT - 1))); do
-    wg genkey | tee /etc/mole/keys/wg$${i}_private.key | wg pubkey > /etc/mole/keys/wg$${i}_public.key
-    chmod 600 /etc/mole/keys/wg$${i}_private.key
-done
-
-echo "ready" > /etc/mole/status
-EOF
+  user_data = base64encode(<<USERDATA
+` + escapeTerraformTemplate(userData) + `USERDATA
   )
 }
 
@@ -141,7 +127,7 @@ resource "aws_instance" "mole_bastion" {
     volume_size = 20
     encrypted   = true
   }
-
+` + terraformVolumeTags(config.Tags) + `
   tags = {
     Name      = "mole-bastion"
     Project   = "aws-cloud-mole"
@@ -166,11 +152,16 @@ output "ssh_command" {
 }
 `)
 
-	return tf.String()
+	return tf.String(), nil
 }
 
 // ExportCloudFormation generates equivalent CloudFormation template
-func (a *AWSClient) ExportCloudFormation(config *DeploymentConfig) string {
+func (a *AWSClient) ExportCloudFormation(config *DeploymentConfig) (string, error) {
+	userData, err := exportUserData(config)
+	if err != nil {
+		return "", err
+	}
+
 	var cf strings.Builder
 
 	cf.WriteString(`AWSTemplateFormatVersion: '2010-09-09'
@@ -232,7 +223,7 @@ Resources:
           Value: mole-wireguard-sg
         - Key: Project
           Value: aws-cloud-mole
-
+` + cloudFormationTags(config.Tags, "        ") + `
   # Key Pair
   MoleKeyPair:
     Type: AWS::EC2::KeyPair
@@ -244,7 +235,7 @@ Resources:
           Value: mole-keypair
         - Key: Project
           Value: aws-cloud-mole
-
+` + cloudFormationTags(config.Tags, "        ") + `
   # Launch Template
   BastionLaunchTemplate:
     Type: AWS::EC2::LaunchTemplate
@@ -266,29 +257,13 @@ Resources:
               Encrypted: true
               DeleteOnTermination: true
         UserData:
-          Fn::Base64: !Sub |
-            #!/bin/bash
-            set -euo pipefail
-
-            TUNNEL_COUNT=` + fmt.Sprintf("%d", config.TunnelCount) + `
-            MTU_SIZE=` + fmt.Sprintf("%d", config.MTUSize) + `
-
-            apt-get update -y
-            apt-get install -y wireguard wireguard-tools iptables-persistent awscli
-
-            echo 'net.ipv4.ip_forward=1' >> /etc/sysctl.conf
-            sysctl -p
-
-            mkdir -p /etc/mole/keys
-
-            for i in $(seq 0 $((TUNNEL_COUNT - 1))); do
-                wg genkey | tee /etc/mole/keys/wg${i}_private.key | wg pubkey > /etc/mole/keys/wg${i}_public.key
-                chmod 600 /etc/mole/keys/wg${i}_private.key
-            done
+          Fn::Base64: |
+` + indentLines(userData, "            ") + `        TagSpecifications:
+`)
 
-            echo "ready" > /etc/mole/status
-        TagSpecifications:
-          - ResourceType: instance
+	// Tag the instance, root volume and ENI alike
+	for _, resourceType := range []string{"instance", "volume", "network-interface"} {
+		cf.WriteString(`          - ResourceType: ` + resourceType + `
             Tags:
               - Key: Name
                 Value: mole-bastion
@@ -296,7 +271,10 @@ Resources:
                 Value: aws-cloud-mole
               - Key: ManagedBy
                 Value: cloudformation
+` + cloudFormationTags(config.Tags, "              "))
+	}
 
+	cf.WriteString(`
   # EC2 Instance
   BastionInstance:
     Type: AWS::EC2::Instance
@@ -308,7 +286,7 @@ Resources:
       Tags:
         - Key: Name
           Value: mole-bastion
-
+` + cloudFormationTags(config.Tags, "        ") + `
 Outputs:
   BastionPublicIP:
     Description: Public IP address of the bastion
@@ -333,11 +311,16 @@ Outputs:
       Name: !Sub "${AWS::StackName}-SecurityGroupId"
 `)
 
-	return cf.String()
+	return cf.String(), nil
 }
 
 // ExportPulumi generates Pulumi configuration in Go
-func (a *AWSClient) ExportPulumi(config *DeploymentConfig) string {
+func (a *AWSClient) ExportPulumi(config *DeploymentConfig) (string, error) {
+	userData, err := exportUserData(config)
+	if err != nil {
+		return "", err
+	}
+
 	var pulumi strings.Builder
 
 	pulumi.WriteString(`package main
@@ -388,7 +371,7 @@ func main() {
 			Tags: pulumi.StringMap{
 				"Name":    pulumi.String("mole-wireguard-sg"),
 				"Project": pulumi.String("aws-cloud-mole"),
-			},
+` + pulumiTags(config.Tags) + `			},
 		})
 		if err != nil {
 			return err
@@ -397,6 +380,8 @@ func main() {
 		// Key Pair
 		keyPair, err := ec2.NewKeyPair(ctx, "mole-keypair", &ec2.KeyPairArgs{
 			PublicKey: pulumi.String("` + config.SSHPublicKey + `"),
+			Tags: pulumi.StringMap{
+` + pulumiTags(config.Tags) + `			},
 		})
 		if err != nil {
 			return err
@@ -430,31 +415,13 @@ func main() {
 			SubnetId:                   pulumi.String("` + config.PublicSubnetId + `"),
 			AssociatePublicIpAddress:   pulumi.Bool(true),
 			Monitoring:                 pulumi.Bool(true),
-			UserData: pulumi.String(` + "`" + `#!/bin/bash
-set -euo pipefail
-
-TUNNEL_COUNT=` + fmt.Sprintf("%d", config.TunnelCount) + `
-MTU_SIZE=` + fmt.Sprintf("%d", config.MTUSize) + `
-
-apt-get update -y
-apt-get install -y wireguard wireguard-tools iptables-persistent awscli
-
-echo 'net.ipv4.ip_forward=1' >> /etc/sysctl.conf
-sysctl -p
-
-mkdir -p /etc/mole/keys
-
-for i in $(seq 0 $((TUNNEL_COUNT - 1))); do
-    wg genkey | tee /etc/mole/keys/wg${i}_private.key | wg pubkey > /etc/mole/keys/wg${i}_public.key
-    chmod 600 /etc/mole/keys/wg${i}_private.key
-done
-
-echo "ready" > /etc/mole/status
-` + "`" + `),
+			UserData:                   pulumi.String(` + goRawString(userData) + `),
 			Tags: pulumi.StringMap{
 				"Name":    pulumi.String("mole-bastion"),
 				"Project": pulumi.String("aws-cloud-mole"),
-			},
+` + pulumiTags(config.Tags) + `			},
+			VolumeTags: pulumi.StringMap{
+` + pulumiTags(config.Tags) + `			},
 		})
 		if err != nil {
 			return err
@@ -470,5 +437,64 @@ echo "ready" > /etc/mole/status
 }
 `)
 
-	return pulumi.String()
+	return pulumi.String(), nil
+}
+
+// exportUserData renders the Ubuntu bastion script the exported templates launch with.
+// Client keys are not known at export time, so peers are added later through the agent API.
+func exportUserData(config *DeploymentConfig) (string, error) {
+	return RenderBastionUserData(bastionUserData(config, OSUbuntu, config.PrivateSubnetCIDRs))
+}
+
+// terraformDefaultTags renders a provider default_tags block so every resource carries the user tags
+func terraformDefaultTags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("\n  default_tags {\n    tags = {\n")
+	for _, key := range sortedTagKeys(tags) {
+		fmt.Fprintf(&b, "      %s = %s\n", strconv.Quote(key), strconv.Quote(tags[key]))
+	}
+	b.WriteString("    }\n  }\n")
+	return b.String()
+}
+
+// terraformVolumeTags renders volume_tags; provider default_tags do not reach root volumes
+func terraformVolumeTags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("\n  volume_tags = {\n")
+	for _, key := range sortedTagKeys(tags) {
+		fmt.Fprintf(&b, "    %s = %s\n", strconv.Quote(key), strconv.Quote(tags[key]))
+	}
+	b.WriteString("  }\n")
+	return b.String()
+}
+
+// cloudFormationTags renders user tags as Key/Value list items at the given indent
+func cloudFormationTags(tags map[string]string, indent string) string {
+	var b strings.Builder
+	for _, key := range sortedTagKeys(tags) {
+		fmt.Fprintf(&b, "%s- Key: %s\n%s  Value: %s\n", indent, yamlQuote(key), indent, yamlQuote(tags[key]))
+	}
+	return b.String()
+}
+
+// pulumiTags renders user tags as pulumi.StringMap entries
+func pulumiTags(tags map[string]string) string {
+	var b strings.Builder
+	for _, key := range sortedTagKeys(tags) {
+		fmt.Fprintf(&b, "\t\t\t\t%s: pulumi.String(%s),\n", strconv.Quote(key), strconv.Quote(tags[key]))
+	}
+	return b.String()
+}
+
+// yamlQuote single-quotes a YAML scalar so tag values like "yes" or "123" stay strings
+func yamlQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
 }
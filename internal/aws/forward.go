@@ -0,0 +1,76 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// ClientTunnelIP returns this host's address on a tunnel, where reverse forwards listen.
+// network is the tunnel's allocated IPv4 network, or "" for the default plan.
+func ClientTunnelIP(id int, network string) string {
+	spec := planTunnels(id+1, false)[id]
+	if network != "" {
+		if allocated, err := withTunnelNetwork(spec, network); err == nil {
+			spec = allocated
+		}
+	}
+	return strings.Split(spec.ClientAddress, "/")[0]
+}
+
+// OpenForwardPort lets hosts in the bastion's VPC connect through the bastion to a TCP port
+// on the tunnel side, which the security group otherwise only allows from the tunnel. The
+// returned function revokes the rule; it does nothing if the rule already existed.
+func (a *AWSClient) OpenForwardPort(ctx context.Context, target *BastionTarget, port int) (func(context.Context) error, error) {
+	if len(target.SecurityGroupIDs) == 0 {
+		return nil, fmt.Errorf("bastion %s has no security group", target.InstanceID)
+	}
+	vpcs, err := a.client.DescribeVpcs(ctx, &ec2.DescribeVpcsInput{VpcIds: []string{target.VPCID}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe VPC %s: %w", target.VPCID, err)
+	}
+	if len(vpcs.Vpcs) == 0 {
+		return nil, fmt.Errorf("VPC %s not found", target.VPCID)
+	}
+
+	groupID := target.SecurityGroupIDs[0]
+	rule := forwardIngressRule(port, aws.ToString(vpcs.Vpcs[0].CidrBlock))
+	_, err = a.client.AuthorizeSecurityGroupIngress(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId:       aws.String(groupID),
+		IpPermissions: []types.IpPermission{rule},
+	})
+	if ec2ErrorContains(err, "InvalidPermission.Duplicate") {
+		return func(context.Context) error { return nil }, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open port %d on %s: %w", port, groupID, err)
+	}
+
+	return func(ctx context.Context) error {
+		_, err := a.client.RevokeSecurityGroupIngress(ctx, &ec2.RevokeSecurityGroupIngressInput{
+			GroupId:       aws.String(groupID),
+			IpPermissions: []types.IpPermission{rule},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to close port %d on %s: %w", port, groupID, err)
+		}
+		return nil
+	}, nil
+}
+
+// forwardIngressRule admits TCP to port from the VPC
+func forwardIngressRule(port int, vpcCIDR string) types.IpPermission {
+	return types.IpPermission{
+		IpProtocol: aws.String("tcp"),
+		FromPort:   aws.Int32(int32(port)),
+		ToPort:     aws.Int32(int32(port)),
+		IpRanges: []types.IpRange{{
+			CidrIp:      aws.String(vpcCIDR),
+			Description: aws.String(fmt.Sprintf("mole forward -R %d from VPC", port)),
+		}},
+	}
+}
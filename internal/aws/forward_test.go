@@ -0,0 +1,29 @@
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestClientTunnelIP(t *testing.T) {
+	if ip := ClientTunnelIP(0, ""); ip != "10.100.1.2" {
+		t.Errorf("ClientTunnelIP(0) = %s", ip)
+	}
+	if ip := ClientTunnelIP(3, ""); ip != "10.100.4.2" {
+		t.Errorf("ClientTunnelIP(3) = %s", ip)
+	}
+	if ip := ClientTunnelIP(0, "172.29.4.0/30"); ip != "172.29.4.2" {
+		t.Errorf("ClientTunnelIP with an allocated network = %s", ip)
+	}
+}
+
+func TestForwardIngressRule(t *testing.T) {
+	rule := forwardIngressRule(9000, "10.0.0.0/16")
+	if aws.ToString(rule.IpProtocol) != "tcp" || aws.ToInt32(rule.FromPort) != 9000 || aws.ToInt32(rule.ToPort) != 9000 {
+		t.Errorf("Unexpected rule %+v", rule)
+	}
+	if len(rule.IpRanges) != 1 || aws.ToString(rule.IpRanges[0].CidrIp) != "10.0.0.0/16" {
+		t.Errorf("Rule should only admit the VPC, got %+v", rule.IpRanges)
+	}
+}
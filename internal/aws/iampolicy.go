@@ -0,0 +1,407 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// Resources mole creates in IAM live under this path so operator permissions can be scoped to it
+const (
+	moleIAMRoleARN            = "arn:aws:iam::*:role/mole/*"
+	moleIAMInstanceProfileARN = "arn:aws:iam::*:instance-profile/mole/*"
+)
+
+// PolicyFeatures selects which parts of mole the operator policy must cover
+type PolicyFeatures struct {
+	CreateVPC  bool       // mole up --create-vpc
+	NAT        bool       // Route private subnets through the bastion
+	TestTarget bool       // mole up --deploy-target (reuses the bastion launch permissions)
+	ElasticIP  bool       // Static bastion address
+	ImageBuild bool       // mole image build
+	AccessMode AccessMode // keypair, instance-connect or ssm
+
+	PresharedKeys bool // mole up --psk (SecureString parameters under /mole/psk)
+}
+
+// PolicyStatement is a single IAM policy statement
+type PolicyStatement struct {
+	Sid       string                       `json:"Sid"`
+	Effect    string                       `json:"Effect"`
+	Action    []string                     `json:"Action"`
+	Resource  []string                     `json:"Resource"`
+	Condition map[string]map[string]string `json:"Condition,omitempty"`
+}
+
+// PolicyDocument is an IAM policy document
+type PolicyDocument struct {
+	Version   string            `json:"Version"`
+	Statement []PolicyStatement `json:"Statement"`
+}
+
+// anyResource is a statement on all resources; most EC2 APIs mole calls either
+// do not support resource-level permissions or create the resource
+func anyResource(sid string, actions ...string) PolicyStatement {
+	return PolicyStatement{Sid: sid, Effect: "Allow", Action: actions, Resource: []string{"*"}}
+}
+
+// OperatorPolicy returns the least-privilege policy for the API calls the selected
+// features make during deployment, status and teardown
+func OperatorPolicy(features PolicyFeatures) PolicyDocument {
+	statements := []PolicyStatement{
+		// VPC and subnet analysis, AMI and instance type lookup, status and teardown discovery
+		anyResource("MoleDescribe",
+			"ec2:DescribeVpcs",
+			"ec2:DescribeSubnets",
+			"ec2:DescribeRouteTables",
+			"ec2:DescribeImages",
+			"ec2:DescribeInstanceTypes",
+			"ec2:DescribeInstances",
+			"ec2:DescribeTags",
+			"ec2:DescribeKeyPairs",
+			"ec2:DescribeSecurityGroups", // mole scale
+		),
+		anyResource("MoleBastion",
+			"ec2:CreateSecurityGroup",
+			"ec2:AuthorizeSecurityGroupIngress",
+			"ec2:RevokeSecurityGroupIngress", // mole forward -R, mole scale
+			"ec2:RunInstances",
+			"ec2:CreateTags",
+			"ec2:TerminateInstances",
+		),
+		{
+			// The permission preflight in mole up: simulate the caller's own policies, and
+			// look up an assumed role's path
+			Sid:      "MolePreflight",
+			Effect:   "Allow",
+			Action:   []string{"iam:SimulatePrincipalPolicy", "iam:GetRole"},
+			Resource: []string{"arn:aws:iam::*:user/*", "arn:aws:iam::*:role/*"},
+		},
+		{
+			Sid:    "MoleInstanceRole",
+			Effect: "Allow",
+			Action: []string{
+				"iam:CreateRole",
+				"iam:TagRole",
+				"iam:PutRolePolicy",
+				"iam:CreateInstanceProfile",
+				"iam:TagInstanceProfile",
+				"iam:AddRoleToInstanceProfile",
+			},
+			Resource: []string{moleIAMRoleARN, moleIAMInstanceProfileARN},
+		},
+		{
+			Sid:       "MolePassInstanceRole",
+			Effect:    "Allow",
+			Action:    []string{"iam:PassRole"},
+			Resource:  []string{moleIAMRoleARN},
+			Condition: map[string]map[string]string{"StringEquals": {"iam:PassedToService": "ec2.amazonaws.com"}},
+		},
+	}
+
+	switch features.AccessMode {
+	case AccessModeSSM:
+		statements = append(statements,
+			PolicyStatement{
+				Sid:       "MoleSessionManagerRole",
+				Effect:    "Allow",
+				Action:    []string{"iam:AttachRolePolicy"},
+				Resource:  []string{moleIAMRoleARN},
+				Condition: map[string]map[string]string{"ArnEquals": {"iam:PolicyARN": ssmManagedPolicyARN}},
+			},
+			anyResource("MoleSessionManager", "ssm:StartSession", "ssm:TerminateSession"),
+		)
+	case AccessModeInstanceConnect:
+		statements = append(statements, anyResource("MoleInstanceConnect", "ec2-instance-connect:SendSSHPublicKey"))
+	default:
+		statements = append(statements, anyResource("MoleKeyPair", "ec2:CreateKeyPair", "ec2:DeleteKeyPair"))
+	}
+
+	if features.CreateVPC {
+		statements = append(statements, anyResource("MoleCreateVPC",
+			"ec2:CreateVpc",
+			"ec2:CreateSubnet",
+			"ec2:ModifySubnetAttribute",
+			"ec2:CreateInternetGateway",
+			"ec2:AttachInternetGateway",
+			"ec2:CreateRouteTable",
+			"ec2:AssociateRouteTable",
+			"ec2:CreateRoute",
+		))
+	}
+
+	if features.NAT {
+		statements = append(statements, anyResource("MolePrivateRouting", "ec2:CreateRoute"))
+	}
+
+	if features.ElasticIP {
+		statements = append(statements, anyResource("MoleElasticIP",
+			"ec2:AllocateAddress",
+			"ec2:AssociateAddress",
+			"ec2:DisassociateAddress",
+			"ec2:ReleaseAddress",
+			"ec2:DescribeAddresses",
+		))
+	}
+
+	if features.ImageBuild {
+		statements = append(statements, anyResource("MoleImageBuild", "ec2:CreateImage"))
+	}
+
+	if features.PresharedKeys {
+		statements = append(statements, PolicyStatement{
+			Sid:    "MolePresharedKeys",
+			Effect: "Allow",
+			Action: []string{
+				"ssm:PutParameter",
+				"ssm:AddTagsToResource",
+				"ssm:GetParametersByPath",
+				"ssm:DeleteParameters",
+			},
+			Resource: []string{
+				"arn:aws:ssm:*:*:parameter" + pskParameterRoot,
+				"arn:aws:ssm:*:*:parameter" + pskParameterRoot + "/*",
+			},
+		})
+	}
+
+	// The bastion agent's token, for release builds and --agent-url
+	statements = append(statements, PolicyStatement{
+		Sid:    "MoleAgentToken",
+		Effect: "Allow",
+		Action: []string{
+			"ssm:PutParameter",
+			"ssm:AddTagsToResource",
+			"ssm:GetParametersByPath",
+			"ssm:DeleteParameters",
+		},
+		Resource: []string{
+			"arn:aws:ssm:*:*:parameter" + agentTokenParameterRoot,
+			"arn:aws:ssm:*:*:parameter" + agentTokenParameterRoot + "/*",
+		},
+	})
+
+	// Only needed for --ami-ssm; harmless otherwise since it is limited to public AMI parameters
+	statements = append(statements, PolicyStatement{
+		Sid:      "MoleAMIParameter",
+		Effect:   "Allow",
+		Action:   []string{"ssm:GetParameter"},
+		Resource: []string{"arn:aws:ssm:*::parameter/aws/service/ami-amazon-linux-latest/*"},
+	})
+
+	return PolicyDocument{Version: "2012-10-17", Statement: statements}
+}
+
+// Actions returns the distinct actions in the policy, sorted
+func (d PolicyDocument) Actions() []string {
+	seen := make(map[string]bool)
+	var actions []string
+	for _, statement := range d.Statement {
+		for _, action := range statement.Action {
+			if !seen[action] {
+				seen[action] = true
+				actions = append(actions, action)
+			}
+		}
+	}
+	sort.Strings(actions)
+	return actions
+}
+
+// JSON renders the policy for iam create-policy or the console editor
+func (d PolicyDocument) JSON() (string, error) {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode policy: %w", err)
+	}
+	return string(data), nil
+}
+
+// PreflightResult reports which required actions the caller is not allowed to perform
+type PreflightResult struct {
+	PrincipalARN string
+	Checked      int
+	Denied       []string
+
+	// Inconclusive actions were only denied for want of context values the simulator
+	// could not be given, such as conditions in the caller's own policies
+	Inconclusive []string
+}
+
+// Allowed reports whether every required action was allowed
+func (r *PreflightResult) Allowed() bool {
+	return len(r.Denied) == 0
+}
+
+// preflightResourceName stands in for the names mole gives resources under a wildcard
+const preflightResourceName = "mole-preflight"
+
+// CheckPermissions simulates the operator policy's actions against the calling identity
+func (a *AWSClient) CheckPermissions(ctx context.Context, features PolicyFeatures) (*PreflightResult, error) {
+	identity, err := a.stsClient.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller identity: %w", err)
+	}
+
+	principal := a.simulationPrincipal(ctx, aws.ToString(identity.Arn))
+	return simulatePolicy(ctx, a.iamClient, principal, a.region, OperatorPolicy(features))
+}
+
+// simulatePolicy simulates each statement of the policy against the principal, on the
+// statement's own resources and with the context values its conditions test, so scoped
+// and conditional permissions are judged as mole will use them
+func simulatePolicy(ctx context.Context, simulator iam.SimulatePrincipalPolicyAPIClient, principal, region string, policy PolicyDocument) (*PreflightResult, error) {
+	result := &PreflightResult{PrincipalARN: principal, Checked: len(policy.Actions())}
+	partition, account := "aws", ""
+	if parts := strings.SplitN(principal, ":", 6); len(parts) == 6 {
+		partition, account = parts[1], parts[4]
+	}
+
+	denied := make(map[string]bool)
+	inconclusive := make(map[string]bool)
+	for _, statement := range policy.Statement {
+		var resources []string
+		for _, resource := range statement.Resource {
+			if resource != "*" {
+				resources = append(resources, simulationResource(resource, partition, region, account))
+			}
+		}
+
+		paginator := iam.NewSimulatePrincipalPolicyPaginator(simulator, &iam.SimulatePrincipalPolicyInput{
+			PolicySourceArn: aws.String(principal),
+			ActionNames:     statement.Action,
+			ResourceArns:    resources,
+			ContextEntries:  conditionContext(statement.Condition),
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to simulate permissions for %s: %w", principal, err)
+			}
+			for _, evaluation := range page.EvaluationResults {
+				action := aws.ToString(evaluation.EvalActionName)
+				isDenied, isInconclusive := evaluationOutcome(evaluation)
+				if isDenied {
+					denied[action] = true
+				} else if isInconclusive {
+					inconclusive[action] = true
+				}
+			}
+		}
+	}
+
+	for action := range denied {
+		result.Denied = append(result.Denied, action)
+	}
+	for action := range inconclusive {
+		if !denied[action] {
+			result.Inconclusive = append(result.Inconclusive, action)
+		}
+	}
+	sort.Strings(result.Denied)
+	sort.Strings(result.Inconclusive)
+	return result, nil
+}
+
+// evaluationOutcome combines the decisions on each simulated resource. An action is
+// denied when any resource is denied outright, and inconclusive when the only denials
+// are implicit ones for want of context values.
+func evaluationOutcome(evaluation types.EvaluationResult) (denied, inconclusive bool) {
+	decide := func(decision types.PolicyEvaluationDecisionType, missing []string) {
+		switch {
+		case decision == types.PolicyEvaluationDecisionTypeAllowed:
+		case decision == types.PolicyEvaluationDecisionTypeImplicitDeny && len(missing) > 0:
+			inconclusive = true
+		default:
+			denied = true
+		}
+	}
+
+	if len(evaluation.ResourceSpecificResults) == 0 {
+		decide(evaluation.EvalDecision, evaluation.MissingContextValues)
+	}
+	for _, resource := range evaluation.ResourceSpecificResults {
+		decide(resource.EvalResourceDecision, resource.MissingContextValues)
+	}
+	return denied, inconclusive && !denied
+}
+
+// simulationResource turns a policy resource pattern into an ARN the simulator can
+// evaluate, in the caller's partition, region and account, with wildcard names replaced
+func simulationResource(pattern, partition, region, account string) string {
+	parts := strings.SplitN(pattern, ":", 6)
+	if len(parts) != 6 {
+		return pattern
+	}
+	parts[1] = partition
+	if parts[3] == "*" {
+		parts[3] = region
+	}
+	if parts[4] == "*" {
+		parts[4] = account
+	}
+	parts[5] = strings.ReplaceAll(parts[5], "*", preflightResourceName)
+	return strings.Join(parts, ":")
+}
+
+// conditionContext supplies the values a statement's conditions test, as mole's own
+// calls will (iam:PassedToService for PassRole, iam:PolicyARN for AttachRolePolicy)
+func conditionContext(condition map[string]map[string]string) []types.ContextEntry {
+	var keys []string
+	values := make(map[string]string)
+	for _, tests := range condition {
+		for key, value := range tests {
+			keys = append(keys, key)
+			values[key] = value
+		}
+	}
+	sort.Strings(keys)
+
+	var entries []types.ContextEntry
+	for _, key := range keys {
+		entries = append(entries, types.ContextEntry{
+			ContextKeyName:   aws.String(key),
+			ContextKeyType:   types.ContextKeyTypeEnumString,
+			ContextKeyValues: []string{values[key]},
+		})
+	}
+	return entries
+}
+
+// simulationPrincipal resolves the IAM ARN the simulator accepts for the caller. Assumed-role
+// sessions are mapped back to their role, looking up the role path when allowed.
+func (a *AWSClient) simulationPrincipal(ctx context.Context, callerARN string) string {
+	roleARN, roleName, ok := roleFromAssumedRoleARN(callerARN)
+	if !ok {
+		return callerARN
+	}
+
+	if output, err := a.iamClient.GetRole(ctx, &iam.GetRoleInput{RoleName: aws.String(roleName)}); err == nil && output.Role != nil {
+		return aws.ToString(output.Role.Arn)
+	}
+	return roleARN
+}
+
+// roleFromAssumedRoleARN converts arn:aws:sts::ACCOUNT:assumed-role/NAME/SESSION to the
+// role ARN (without path) and role name
+func roleFromAssumedRoleARN(callerARN string) (string, string, bool) {
+	parts := strings.SplitN(callerARN, ":", 6)
+	if len(parts) != 6 || parts[2] != "sts" || !strings.HasPrefix(parts[5], "assumed-role/") {
+		return "", "", false
+	}
+
+	nameAndSession := strings.TrimPrefix(parts[5], "assumed-role/")
+	roleName, _, _ := strings.Cut(nameAndSession, "/")
+	if roleName == "" {
+		return "", "", false
+	}
+
+	return fmt.Sprintf("arn:%s:iam::%s:role/%s", parts[1], parts[4], roleName), roleName, true
+}
@@ -0,0 +1,252 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+func hasAction(actions []string, action string) bool {
+	for _, a := range actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+func TestOperatorPolicyFeatures(t *testing.T) {
+	minimal := OperatorPolicy(PolicyFeatures{AccessMode: AccessModeSSM}).Actions()
+	// The preflight must be able to run with exactly this policy
+	for _, action := range []string{"ec2:RunInstances", "iam:PassRole", "iam:AttachRolePolicy", "ec2:TerminateInstances", "iam:SimulatePrincipalPolicy", "iam:GetRole"} {
+		if !hasAction(minimal, action) {
+			t.Errorf("Minimal SSM policy missing %s", action)
+		}
+	}
+	for _, action := range []string{"ec2:CreateVpc", "ec2:CreateKeyPair", "ec2:CreateRoute", "ec2:AllocateAddress"} {
+		if hasAction(minimal, action) {
+			t.Errorf("Minimal SSM policy should not grant %s", action)
+		}
+	}
+	for _, statement := range OperatorPolicy(PolicyFeatures{AccessMode: AccessModeSSM}).Statement {
+		if statement.Sid == "MolePresharedKeys" {
+			t.Error("Minimal SSM policy should not manage pre-shared keys")
+		}
+		if statement.Sid == "MoleAgentToken" && !strings.HasSuffix(statement.Resource[1], ":parameter/mole/agent/*") {
+			t.Errorf("Agent token permissions should be scoped to /mole/agent, got %v", statement.Resource)
+		}
+	}
+
+	full := OperatorPolicy(PolicyFeatures{
+		CreateVPC:     true,
+		NAT:           true,
+		ElasticIP:     true,
+		ImageBuild:    true,
+		AccessMode:    AccessModeKeyPair,
+		PresharedKeys: true,
+	}).Actions()
+	for _, action := range []string{"ec2:CreateVpc", "ec2:CreateInternetGateway", "ec2:CreateKeyPair", "ec2:CreateRoute", "ec2:AllocateAddress", "ec2:CreateImage", "ssm:PutParameter"} {
+		if !hasAction(full, action) {
+			t.Errorf("Full policy missing %s", action)
+		}
+	}
+	if hasAction(full, "iam:AttachRolePolicy") {
+		t.Error("Key pair policy should not attach managed policies")
+	}
+
+	// Actions are de-duplicated (CreateRoute appears in both VPC and NAT statements)
+	count := 0
+	for _, action := range full {
+		if action == "ec2:CreateRoute" {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("Expected ec2:CreateRoute once, got %d", count)
+	}
+}
+
+func TestOperatorPolicyJSON(t *testing.T) {
+	policy, err := OperatorPolicy(PolicyFeatures{}).JSON()
+	if err != nil {
+		t.Fatalf("JSON failed: %v", err)
+	}
+
+	var decoded PolicyDocument
+	if err := json.Unmarshal([]byte(policy), &decoded); err != nil {
+		t.Fatalf("Policy is not valid JSON: %v", err)
+	}
+	if decoded.Version != "2012-10-17" {
+		t.Errorf("Unexpected policy version %s", decoded.Version)
+	}
+
+	for _, statement := range decoded.Statement {
+		if statement.Sid == "MoleInstanceRole" {
+			for _, resource := range statement.Resource {
+				if resource == "*" {
+					t.Error("IAM role permissions should be scoped to the /mole/ path")
+				}
+			}
+		}
+	}
+}
+
+func TestRoleFromAssumedRoleARN(t *testing.T) {
+	roleARN, name, ok := roleFromAssumedRoleARN("arn:aws:sts::123456789012:assumed-role/ResearchOps/alice")
+	if !ok {
+		t.Fatal("Assumed-role ARN not recognised")
+	}
+	if roleARN != "arn:aws:iam::123456789012:role/ResearchOps" || name != "ResearchOps" {
+		t.Errorf("Unexpected conversion: %s %s", roleARN, name)
+	}
+
+	if _, _, ok := roleFromAssumedRoleARN("arn:aws:iam::123456789012:user/alice"); ok {
+		t.Error("IAM user ARN should be used as-is")
+	}
+}
+
+// policySimulator evaluates actions against the caller's granted policy as IAM does:
+// resource patterns must match and every condition key must be present in the context
+type policySimulator struct {
+	granted PolicyDocument
+	inputs  []*iam.SimulatePrincipalPolicyInput
+}
+
+func (f *policySimulator) SimulatePrincipalPolicy(_ context.Context, input *iam.SimulatePrincipalPolicyInput, _ ...func(*iam.Options)) (*iam.SimulatePrincipalPolicyOutput, error) {
+	f.inputs = append(f.inputs, input)
+	values := make(map[string]string)
+	for _, entry := range input.ContextEntries {
+		values[aws.ToString(entry.ContextKeyName)] = entry.ContextKeyValues[0]
+	}
+
+	output := &iam.SimulatePrincipalPolicyOutput{}
+	for _, action := range input.ActionNames {
+		evaluation := types.EvaluationResult{EvalActionName: aws.String(action)}
+		if len(input.ResourceArns) == 0 {
+			evaluation.EvalDecision, evaluation.MissingContextValues = f.evaluate(action, "*", values)
+		}
+		for _, resource := range input.ResourceArns {
+			decision, missing := f.evaluate(action, resource, values)
+			evaluation.ResourceSpecificResults = append(evaluation.ResourceSpecificResults, types.ResourceSpecificResult{
+				EvalResourceName:     aws.String(resource),
+				EvalResourceDecision: decision,
+				MissingContextValues: missing,
+			})
+		}
+		output.EvaluationResults = append(output.EvaluationResults, evaluation)
+	}
+	return output, nil
+}
+
+func (f *policySimulator) evaluate(action, resource string, values map[string]string) (types.PolicyEvaluationDecisionType, []string) {
+	var missing []string
+	for _, statement := range f.granted.Statement {
+		if !hasAction(statement.Action, action) || !matchesAny(statement.Resource, resource) {
+			continue
+		}
+		satisfied := true
+		for _, tests := range statement.Condition {
+			for key, value := range tests {
+				got, ok := values[key]
+				if !ok {
+					missing = append(missing, key)
+				}
+				satisfied = satisfied && ok && got == value
+			}
+		}
+		if satisfied {
+			return types.PolicyEvaluationDecisionTypeAllowed, nil
+		}
+	}
+	return types.PolicyEvaluationDecisionTypeImplicitDeny, missing
+}
+
+func matchesAny(patterns []string, resource string) bool {
+	for _, pattern := range patterns {
+		expression := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$"
+		if regexp.MustCompile(expression).MatchString(resource) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSimulatePolicy(t *testing.T) {
+	const principal = "arn:aws:iam::123456789012:role/ResearchOps"
+	features := PolicyFeatures{AccessMode: AccessModeSSM, PresharedKeys: true}
+	policy := OperatorPolicy(features)
+
+	// A caller granted exactly the operator policy passes, scoped and conditional
+	// statements included
+	simulator := &policySimulator{granted: policy}
+	result, err := simulatePolicy(context.Background(), simulator, principal, "us-west-2", policy)
+	if err != nil {
+		t.Fatalf("simulatePolicy failed: %v", err)
+	}
+	if !result.Allowed() || len(result.Inconclusive) != 0 || result.Checked != len(policy.Actions()) {
+		t.Fatalf("Expected the operator policy to be allowed, got %+v", result)
+	}
+
+	var passRole *iam.SimulatePrincipalPolicyInput
+	for _, input := range simulator.inputs {
+		if hasAction(input.ActionNames, "iam:PassRole") {
+			passRole = input
+		}
+	}
+	if passRole == nil || len(passRole.ResourceArns) != 1 || passRole.ResourceArns[0] != "arn:aws:iam::123456789012:role/mole/mole-preflight" {
+		t.Fatalf("Expected PassRole simulated on a role under /mole/, got %+v", passRole)
+	}
+	if len(passRole.ContextEntries) != 1 || aws.ToString(passRole.ContextEntries[0].ContextKeyName) != "iam:PassedToService" {
+		t.Errorf("Expected the iam:PassedToService context, got %+v", passRole.ContextEntries)
+	}
+
+	// Missing statements are denied
+	granted := PolicyDocument{Version: policy.Version}
+	for _, statement := range policy.Statement {
+		if statement.Sid != "MoleSessionManagerRole" {
+			granted.Statement = append(granted.Statement, statement)
+		}
+	}
+	result, err = simulatePolicy(context.Background(), &policySimulator{granted: granted}, principal, "us-west-2", policy)
+	if err != nil {
+		t.Fatalf("simulatePolicy failed: %v", err)
+	}
+	if result.Allowed() || len(result.Denied) != 1 || result.Denied[0] != "iam:AttachRolePolicy" {
+		t.Errorf("Expected only iam:AttachRolePolicy denied, got %+v", result)
+	}
+
+	// Conditions mole cannot supply values for leave the action unconfirmed, not denied
+	granted = PolicyDocument{Version: policy.Version}
+	for _, statement := range policy.Statement {
+		if statement.Sid == "MoleBastion" {
+			statement.Condition = map[string]map[string]string{"StringEquals": {"aws:ResourceTag/team": "research"}}
+		}
+		granted.Statement = append(granted.Statement, statement)
+	}
+	result, err = simulatePolicy(context.Background(), &policySimulator{granted: granted}, principal, "us-west-2", policy)
+	if err != nil {
+		t.Fatalf("simulatePolicy failed: %v", err)
+	}
+	if !result.Allowed() || !hasAction(result.Inconclusive, "ec2:RunInstances") || hasAction(result.Inconclusive, "ec2:DescribeVpcs") {
+		t.Errorf("Expected the conditional bastion actions inconclusive, got %+v", result)
+	}
+}
+
+func TestSimulationResource(t *testing.T) {
+	tests := map[string]string{
+		moleIAMRoleARN:                         "arn:aws-us-gov:iam::123456789012:role/mole/mole-preflight",
+		"arn:aws:ssm:*:*:parameter/mole/psk/*": "arn:aws-us-gov:ssm:us-gov-west-1:123456789012:parameter/mole/psk/mole-preflight",
+		"arn:aws:ssm:*::parameter/aws/service/ami-amazon-linux-latest/*": "arn:aws-us-gov:ssm:us-gov-west-1::parameter/aws/service/ami-amazon-linux-latest/mole-preflight",
+	}
+	for pattern, want := range tests {
+		if got := simulationResource(pattern, "aws-us-gov", "us-gov-west-1", "123456789012"); got != want {
+			t.Errorf("simulationResource(%s) = %s, want %s", pattern, got, want)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package aws
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Tags identifying pre-built mole bastion images
+const (
+	moleImageTag        = "mole:image"
+	moleImageRole       = "bastion"
+	moleImageVersionTag = "mole:image-version"
+)
+
+// ImageBuildConfig contains parameters for building a bastion AMI
+type ImageBuildConfig struct {
+	InstanceType types.InstanceType // Builder type; its architecture determines the image architecture
+	SubnetId     string             // Subnet with internet access (default VPC when empty)
+	Version      string             // Image version (timestamp when empty)
+	UseSSM       bool               // Resolve the base AL2023 image from the public SSM parameter
+	Tags         map[string]string  // User cost-allocation tags for the builder and image
+}
+
+// ImageBuildResult describes a built bastion AMI
+type ImageBuildResult struct {
+	ImageId      string
+	Name         string
+	Version      string
+	Architecture string
+	BaseImageId  string
+}
+
+// MoleImage is a pre-built bastion AMI owned by this account
+type MoleImage struct {
+	ImageId      string
+	Name         string
+	Version      string
+	Architecture string
+	CreationDate string
+}
+
+// BuildBastionImage launches a builder instance, installs and tunes the bastion packages,
+// and snapshots it into a private AMI tagged for mole
+func (a *AWSClient) BuildBastionImage(ctx context.Context, config *ImageBuildConfig) (*ImageBuildResult, error) {
+	version := config.Version
+	if version == "" {
+		version = time.Now().UTC().Format("20060102-150405")
+	}
+
+	// Always start from stock AL2023 so images never stack on each other
+	baseImage, err := a.resolveAMI(ctx, config.InstanceType, AMISelection{UseSSM: config.UseSSM, StockOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve base image: %w", err)
+	}
+
+	result := &ImageBuildResult{
+		Version:      version,
+		Architecture: string(baseImage.Architecture),
+		BaseImageId:  aws.ToString(baseImage.ImageId),
+	}
+	result.Name = fmt.Sprintf("mole-bastion-%s-%s", result.Architecture, version)
+
+	fmt.Printf("  🧱 Base image: %s (%s)\n", result.BaseImageId, result.Architecture)
+
+	userData := base64.StdEncoding.EncodeToString([]byte(generateImageBuildScript(version)))
+	runResult, err := a.client.RunInstances(ctx, &ec2.RunInstancesInput{
+		ImageId:                           baseImage.ImageId,
+		InstanceType:                      config.InstanceType,
+		MinCount:                          aws.Int32(1),
+		MaxCount:                          aws.Int32(1),
+		SubnetId:                          optionalString(config.SubnetId),
+		UserData:                          &userData,
+		InstanceInitiatedShutdownBehavior: types.ShutdownBehaviorStop,
+		TagSpecifications: tagSpecifications([]types.Tag{
+			{Key: aws.String("Name"), Value: aws.String("mole-image-builder")},
+			{Key: aws.String("CreatedBy"), Value: aws.String("aws-cloud-mole")},
+		}, config.Tags, types.ResourceTypeInstance, types.ResourceTypeVolume),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to launch builder instance: %w", err)
+	}
+	builderID := aws.ToString(runResult.Instances[0].InstanceId)
+	fmt.Printf("  ☁️  Builder instance: %s\n", builderID)
+
+	// The builder is always terminated, whether the build succeeds or not
+	defer func() {
+		if _, err := a.client.TerminateInstances(context.Background(), &ec2.TerminateInstancesInput{
+			InstanceIds: []string{builderID},
+		}); err != nil {
+			fmt.Printf("  ⚠️  Warning: failed to terminate builder %s: %v\n", builderID, err)
+		}
+	}()
+
+	// The build script powers the instance off when provisioning completes
+	fmt.Println("  ⏳ Installing packages (the builder stops itself when done)...")
+	stoppedWaiter := ec2.NewInstanceStoppedWaiter(a.client)
+	if err := stoppedWaiter.Wait(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{builderID},
+	}, 20*time.Minute); err != nil {
+		return nil, fmt.Errorf("builder did not finish provisioning: %w", err)
+	}
+
+	fmt.Println("  📸 Creating image...")
+	tags := withUserTags([]types.Tag{
+		{Key: aws.String("Name"), Value: aws.String(result.Name)},
+		{Key: aws.String(moleImageTag), Value: aws.String(moleImageRole)},
+		{Key: aws.String(moleImageVersionTag), Value: aws.String(version)},
+		{Key: aws.String("BaseImage"), Value: aws.String(result.BaseImageId)},
+		{Key: aws.String("CreatedBy"), Value: aws.String("aws-cloud-mole")},
+	}, config.Tags)
+	imageResult, err := a.client.CreateImage(ctx, &ec2.CreateImageInput{
+		InstanceId:  aws.String(builderID),
+		Name:        aws.String(result.Name),
+		Description: aws.String(fmt.Sprintf("AWS Cloud Mole bastion %s (%s)", version, result.Architecture)),
+		TagSpecifications: []types.TagSpecification{
+			{ResourceType: types.ResourceTypeImage, Tags: tags},
+			{ResourceType: types.ResourceTypeSnapshot, Tags: tags},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create image: %w", err)
+	}
+	result.ImageId = aws.ToString(imageResult.ImageId)
+
+	fmt.Printf("  ⏳ Waiting for %s to become available...\n", result.ImageId)
+	imageWaiter := ec2.NewImageAvailableWaiter(a.client)
+	if err := imageWaiter.Wait(ctx, &ec2.DescribeImagesInput{
+		ImageIds: []string{result.ImageId},
+	}, 30*time.Minute); err != nil {
+		return nil, fmt.Errorf("image %s did not become available: %w", result.ImageId, err)
+	}
+
+	return result, nil
+}
+
+// ListMoleImages returns the pre-built bastion images owned by this account, newest first
+func (a *AWSClient) ListMoleImages(ctx context.Context, arch string) ([]MoleImage, error) {
+	filters := []types.Filter{
+		{
+			Name:   aws.String("tag:" + moleImageTag),
+			Values: []string{moleImageRole},
+		},
+		{
+			Name:   aws.String("state"),
+			Values: []string{"available"},
+		},
+	}
+	if arch != "" {
+		filters = append(filters, types.Filter{
+			Name:   aws.String("architecture"),
+			Values: []string{arch},
+		})
+	}
+
+	output, err := a.client.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		Owners:  []string{"self"},
+		Filters: filters,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe mole images: %w", err)
+	}
+
+	return sortMoleImages(output.Images), nil
+}
+
+// findLatestMoleImage returns the newest pre-built bastion image ID for an architecture, or ""
+func (a *AWSClient) findLatestMoleImage(ctx context.Context, arch string) (string, error) {
+	images, err := a.ListMoleImages(ctx, arch)
+	if err != nil || len(images) == 0 {
+		return "", err
+	}
+	return images[0].ImageId, nil
+}
+
+// sortMoleImages converts described images and orders them newest first
+func sortMoleImages(images []types.Image) []MoleImage {
+	var moleImages []MoleImage
+	for _, image := range images {
+		moleImages = append(moleImages, MoleImage{
+			ImageId:      aws.ToString(image.ImageId),
+			Name:         aws.ToString(image.Name),
+			Version:      tagValue(image.Tags, moleImageVersionTag),
+			Architecture: string(image.Architecture),
+			CreationDate: aws.ToString(image.CreationDate),
+		})
+	}
+
+	// CreationDate is ISO 8601, so string order is chronological
+	sort.Slice(moleImages, func(i, j int) bool {
+		return moleImages[i].CreationDate > moleImages[j].CreationDate
+	})
+
+	return moleImages
+}
+
+// generateImageBuildScript returns the builder user data: install everything the bastion
+// needs at boot so launch only has to write the WireGuard config
+func generateImageBuildScript(version string) string {
+	return fmt.Sprintf(`#!/bin/bash
+set -euo pipefail
+
+# Packages the bastion user data would otherwise install at boot
+dnf install -y wireguard-tools iptables-nft conntrack-tools ethtool
+dnf clean all
+
+# Forwarding and queueing defaults baked into the image
+cat > /etc/sysctl.d/90-mole.conf << 'EOF'
+net.ipv4.ip_forward = 1
+net.ipv6.conf.all.forwarding = 1
+net.core.default_qdisc = fq
+net.ipv4.tcp_congestion_control = bbr
+net.core.netdev_max_backlog = 5000
+EOF
+
+# Load WireGuard at boot so wg-quick never waits on module loading
+echo wireguard > /etc/modules-load.d/wireguard.conf
+
+mkdir -p /etc/mole /etc/wireguard
+chmod 700 /etc/wireguard
+echo "%s" > /etc/mole/image-version
+
+# Drop per-instance state so every bastion boots fresh
+rm -f /etc/mole/status
+cloud-init clean --logs
+
+poweroff
+`, version)
+}
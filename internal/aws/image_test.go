@@ -0,0 +1,66 @@
+package aws
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestSortMoleImages(t *testing.T) {
+	images := []types.Image{
+		{
+			ImageId:      aws.String("ami-old"),
+			CreationDate: aws.String("2025-01-10T08:00:00.000Z"),
+			Architecture: types.ArchitectureValuesArm64,
+		},
+		{
+			ImageId:      aws.String("ami-new"),
+			CreationDate: aws.String("2025-03-02T12:30:00.000Z"),
+			Architecture: types.ArchitectureValuesArm64,
+			Tags: []types.Tag{
+				{Key: aws.String(moleImageVersionTag), Value: aws.String("v2")},
+			},
+		},
+		{
+			ImageId:      aws.String("ami-mid"),
+			CreationDate: aws.String("2025-02-14T00:00:00.000Z"),
+			Architecture: types.ArchitectureValuesArm64,
+		},
+	}
+
+	sorted := sortMoleImages(images)
+	if len(sorted) != 3 {
+		t.Fatalf("Expected 3 images, got %d", len(sorted))
+	}
+
+	expected := []string{"ami-new", "ami-mid", "ami-old"}
+	for i, id := range expected {
+		if sorted[i].ImageId != id {
+			t.Errorf("Position %d: expected %s, got %s", i, id, sorted[i].ImageId)
+		}
+	}
+
+	if sorted[0].Version != "v2" {
+		t.Errorf("Expected version v2 from tags, got %q", sorted[0].Version)
+	}
+	if sorted[0].Architecture != ArchARM64 {
+		t.Errorf("Expected arm64, got %s", sorted[0].Architecture)
+	}
+}
+
+func TestGenerateImageBuildScript(t *testing.T) {
+	script := generateImageBuildScript("20250301-120000")
+
+	for _, want := range []string{
+		"dnf install -y wireguard-tools",
+		"net.ipv4.ip_forward = 1",
+		"20250301-120000",
+		"poweroff",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("Build script missing %q", want)
+		}
+	}
+}
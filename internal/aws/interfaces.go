@@ -13,6 +13,7 @@ type AWSClientInterface interface {
 	SelectOptimalInstance(throughput int64, budget float64) *InstanceConfig
 	GetInstanceStatus(ctx context.Context, instanceID string) (string, error)
 	ListVPCs(ctx context.Context) ([]VPCInfo, error)
+	DescribeVPC(ctx context.Context, vpcID string) (*VPCDetails, error)
 	DeployInfrastructure(ctx context.Context, terraformConfig interface{}) error
 	TerminateBastion(ctx context.Context, instanceID string) error
 }
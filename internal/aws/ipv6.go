@@ -0,0 +1,106 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Dual-stack tunnel addressing. The ULA prefix matches tunnel.DefaultIPv6CIDR so the
+// bastion and client agree on the first tunnel's /64 (see planTunnels for the others).
+const (
+	tunnelIPv6Network  = "fd6d:6f6c:6500:1::/64"
+	defaultAllowedIPv6 = "::/0"
+)
+
+// ipv6SubnetCIDR carves the index'th /64 out of a VPC IPv6 block (Amazon hands out /56s)
+func ipv6SubnetCIDR(vpcBlock string, index int) (string, error) {
+	prefix, err := netip.ParsePrefix(vpcBlock)
+	if err != nil {
+		return "", fmt.Errorf("invalid IPv6 block %s: %w", vpcBlock, err)
+	}
+	if !prefix.Addr().Is6() || prefix.Bits() > 64 {
+		return "", fmt.Errorf("IPv6 block %s is too small to hold /64 subnets", vpcBlock)
+	}
+
+	available := 1 << (64 - prefix.Bits())
+	if index < 0 || index >= available {
+		return "", fmt.Errorf("subnet index %d out of range for %s", index, vpcBlock)
+	}
+
+	// The /64 subnet number lives in bytes 6-7 of the address
+	addr := prefix.Masked().Addr().As16()
+	subnet := uint16(addr[6])<<8 | uint16(addr[7]) | uint16(index)
+	addr[6] = byte(subnet >> 8)
+	addr[7] = byte(subnet)
+
+	return netip.PrefixFrom(netip.AddrFrom16(addr), 64).String(), nil
+}
+
+// waitForVPCIPv6Block waits for the Amazon-provided IPv6 block to be associated and returns it
+func (a *AWSClient) waitForVPCIPv6Block(ctx context.Context, vpcID string) (string, error) {
+	for i := 0; i < 30; i++ {
+		result, err := a.client.DescribeVpcs(ctx, &ec2.DescribeVpcsInput{
+			VpcIds: []string{vpcID},
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to describe VPC: %w", err)
+		}
+
+		if len(result.Vpcs) > 0 {
+			if block := associatedVPCIPv6Block(result.Vpcs[0]); block != "" {
+				return block, nil
+			}
+		}
+
+		time.Sleep(2 * time.Second)
+	}
+
+	return "", fmt.Errorf("IPv6 block was not associated with VPC %s after 60 seconds", vpcID)
+}
+
+// associatedVPCIPv6Block returns the first associated IPv6 CIDR of a VPC
+func associatedVPCIPv6Block(vpc types.Vpc) string {
+	for _, association := range vpc.Ipv6CidrBlockAssociationSet {
+		if association.Ipv6CidrBlockState != nil &&
+			association.Ipv6CidrBlockState.State == types.VpcCidrBlockStateCodeAssociated {
+			return aws.ToString(association.Ipv6CidrBlock)
+		}
+	}
+	return ""
+}
+
+// associatedSubnetIPv6Block returns the first associated IPv6 CIDR of a subnet
+func associatedSubnetIPv6Block(subnet types.Subnet) string {
+	for _, association := range subnet.Ipv6CidrBlockAssociationSet {
+		if association.Ipv6CidrBlockState != nil &&
+			association.Ipv6CidrBlockState.State == types.SubnetCidrBlockStateCodeAssociated {
+			return aws.ToString(association.Ipv6CidrBlock)
+		}
+	}
+	return ""
+}
+
+// createIPv6Subnet creates a dual-stack subnet using the index'th /64 of the VPC block
+func (a *AWSClient) createIPv6Subnet(ctx context.Context, input *ec2.CreateSubnetInput, vpcIPv6Block string, index int) (*ec2.CreateSubnetOutput, error) {
+	subnetBlock, err := ipv6SubnetCIDR(vpcIPv6Block, index)
+	if err != nil {
+		return nil, err
+	}
+	input.Ipv6CidrBlock = &subnetBlock
+	return a.client.CreateSubnet(ctx, input)
+}
+
+// clientEndpointHost returns the address the client should use to reach the bastion,
+// bracketing IPv6 literals for use in host:port strings
+func clientEndpointHost(result *DeploymentResult) string {
+	if result.IPv6Underlay && result.BastionPublicIPv6 != "" {
+		return "[" + result.BastionPublicIPv6 + "]"
+	}
+	return result.BastionPublicIP
+}
@@ -0,0 +1,107 @@
+package aws
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIPv6SubnetCIDR(t *testing.T) {
+	tests := []struct {
+		block    string
+		index    int
+		expected string
+		wantErr  bool
+	}{
+		{"2600:1f14:abc:de00::/56", 0, "2600:1f14:abc:de00::/64", false},
+		{"2600:1f14:abc:de00::/56", 1, "2600:1f14:abc:de01::/64", false},
+		{"2600:1f14:abc:de00::/56", 255, "2600:1f14:abc:deff::/64", false},
+		{"2600:1f14:abc:de00::/56", 256, "", true},
+		{"2600:1f14:abc:de00::/64", 0, "2600:1f14:abc:de00::/64", false},
+		{"10.0.0.0/16", 0, "", true},
+		{"not-a-cidr", 0, "", true},
+	}
+
+	for _, test := range tests {
+		result, err := ipv6SubnetCIDR(test.block, test.index)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ipv6SubnetCIDR(%s, %d) should fail", test.block, test.index)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ipv6SubnetCIDR(%s, %d) failed: %v", test.block, test.index, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("ipv6SubnetCIDR(%s, %d) = %s, expected %s", test.block, test.index, result, test.expected)
+		}
+	}
+}
+
+func TestGenerateClientConfigDualStack(t *testing.T) {
+	config := &DeploymentConfig{
+		EnableIPv6:  true,
+		VPCIPv6Cidr: "2600:1f14:abc:de00::/56",
+	}
+	result := &DeploymentResult{
+		BastionPublicIP:   "203.0.113.10",
+		BastionPublicIPv6: "2600:1f14:abc:de00::10",
+		DualStack:         true,
+		ClientAllowedIPs:  clientAllowedIPs(config),
+	}
+
+	spec := planTunnels(1, true)[0]
+	content := generateClientConfig(result, spec, "")
+	if !strings.Contains(content, "Address = 10.100.1.2/24, fd6d:6f6c:6500:1::2/64") {
+		t.Errorf("Dual-stack config should carry both addresses:\n%s", content)
+	}
+	if !strings.Contains(content, "AllowedIPs = 10.100.2.0/24, fd6d:6f6c:6500:1::/64, 2600:1f14:abc:de00::/56") {
+		t.Errorf("Dual-stack config should route the tunnel and VPC IPv6 networks:\n%s", content)
+	}
+	if !strings.Contains(content, "Endpoint = 203.0.113.10:51820") {
+		t.Errorf("Expected IPv4 underlay endpoint:\n%s", content)
+	}
+
+	result.IPv6Underlay = true
+	content = generateClientConfig(result, spec, "1.1.1.1")
+	if !strings.Contains(content, "Endpoint = [2600:1f14:abc:de00::10]:51820") {
+		t.Errorf("Expected bracketed IPv6 underlay endpoint:\n%s", content)
+	}
+	if !strings.Contains(content, "DNS = 1.1.1.1") {
+		t.Errorf("Expected DNS line:\n%s", content)
+	}
+}
+
+func TestBuildIngressRulesIPv6(t *testing.T) {
+	config := &DeploymentConfig{
+		TunnelCount: 1,
+		AllowedCIDR: "0.0.0.0/0",
+		VPCCidr:     "10.0.0.0/16",
+	}
+
+	for _, rule := range buildIngressRules(config) {
+		if len(rule.Ipv6Ranges) > 0 {
+			t.Fatalf("IPv4-only deployment should not have IPv6 ranges")
+		}
+	}
+
+	config.EnableIPv6 = true
+	config.AllowedIPv6CIDR = "2001:db8::/32"
+
+	var wireguardV6, icmpv6 bool
+	for _, rule := range buildIngressRules(config) {
+		switch *rule.IpProtocol {
+		case "udp":
+			wireguardV6 = len(rule.Ipv6Ranges) == 1 && *rule.Ipv6Ranges[0].CidrIpv6 == "2001:db8::/32"
+		case "icmpv6":
+			icmpv6 = true
+		}
+	}
+	if !wireguardV6 {
+		t.Error("WireGuard rule should allow the configured IPv6 source")
+	}
+	if !icmpv6 {
+		t.Error("Dual-stack deployment should allow ICMPv6")
+	}
+}
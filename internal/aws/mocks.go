@@ -22,12 +22,14 @@ type MockAWSClient struct {
 	MockPrivateIP        string
 	MockInstanceStatus   string
 	MockVPCs            []VPCInfo
+	MockSubnets         []SubnetInfo
 
 	// Track calls for verification
 	CreateBastionCalls    []BastionConfig
 	CreateSGCalls         []CreateSGCall
 	GetInstanceStatusCalls []string
 	ListVPCsCalls         int
+	DescribeVPCCalls      []string
 	DeployCalls           []interface{}
 	TerminateCalls        []string
 	OptimalInstanceCalls  []OptimalInstanceCall
@@ -60,6 +62,22 @@ func NewMockAWSClient() *MockAWSClient {
 				Name:      "Mock VPC",
 			},
 		},
+		MockSubnets: []SubnetInfo{
+			{
+				SubnetId:         "subnet-mockpublic",
+				CidrBlock:        "10.0.1.0/24",
+				AvailabilityZone: "us-west-2a",
+				AvailableIPs:     250,
+				Public:           true,
+				MapPublicIP:      true,
+			},
+			{
+				SubnetId:         "subnet-mockprivate",
+				CidrBlock:        "10.0.2.0/24",
+				AvailabilityZone: "us-west-2a",
+				AvailableIPs:     250,
+			},
+		},
 	}
 }
 
@@ -122,6 +140,22 @@ func (m *MockAWSClient) ListVPCs(ctx context.Context) ([]VPCInfo, error) {
 	return m.MockVPCs, nil
 }
 
+func (m *MockAWSClient) DescribeVPC(ctx context.Context, vpcID string) (*VPCDetails, error) {
+	m.DescribeVPCCalls = append(m.DescribeVPCCalls, vpcID)
+
+	if m.ShouldFailListVPCs {
+		return nil, fmt.Errorf("mock error: failed to describe VPC")
+	}
+
+	for _, vpc := range m.MockVPCs {
+		if vpc.VpcId == vpcID {
+			return &VPCDetails{VPCInfo: vpc, Subnets: m.MockSubnets}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("VPC %s not found", vpcID)
+}
+
 func (m *MockAWSClient) DeployInfrastructure(ctx context.Context, terraformConfig interface{}) error {
 	m.DeployCalls = append(m.DeployCalls, terraformConfig)
 
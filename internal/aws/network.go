@@ -17,16 +17,19 @@ type NetworkConfig struct {
 	PrivateSubnetCidr string
 	Region            string
 	EnableNAT         bool
+	EnableIPv6        bool              // Request an Amazon-provided IPv6 block and make subnets dual-stack
+	Tags              map[string]string // User cost-allocation tags applied to every resource
 }
 
 // NetworkResult contains created network infrastructure IDs
 type NetworkResult struct {
-	VPCId           string
-	PublicSubnetId  string
-	PrivateSubnetId string
-	InternetGatewayId string
-	PublicRouteTableId string
+	VPCId               string
+	PublicSubnetId      string
+	PrivateSubnetId     string
+	InternetGatewayId   string
+	PublicRouteTableId  string
 	PrivateRouteTableId string
+	VPCIPv6Cidr         string // Amazon-provided IPv6 block (dual-stack only)
 }
 
 // CreateNetworkInfrastructure creates a complete VPC with public/private subnets
@@ -36,34 +39,33 @@ func (a *AWSClient) CreateNetworkInfrastructure(ctx context.Context, config *Net
 	// Step 1: Create VPC
 	fmt.Println("   🏗️  Creating VPC...")
 	vpcResult, err := a.client.CreateVpc(ctx, &ec2.CreateVpcInput{
-		CidrBlock: &config.VPCCidr,
-		TagSpecifications: []types.TagSpecification{
-			{
-				ResourceType: types.ResourceTypeVpc,
-				Tags: []types.Tag{
-					{Key: aws.String("Name"), Value: aws.String("mole-vpc")},
-					{Key: aws.String("Purpose"), Value: aws.String("wireguard-tunnel-terminator")},
-					{Key: aws.String("CreatedBy"), Value: aws.String("aws-cloud-mole")},
-				},
-			},
-		},
+		CidrBlock:                   &config.VPCCidr,
+		AmazonProvidedIpv6CidrBlock: aws.Bool(config.EnableIPv6),
+		TagSpecifications: tagSpecifications([]types.Tag{
+			{Key: aws.String("Name"), Value: aws.String("mole-vpc")},
+			{Key: aws.String("Purpose"), Value: aws.String("wireguard-tunnel-terminator")},
+			{Key: aws.String("CreatedBy"), Value: aws.String("aws-cloud-mole")},
+		}, config.Tags, types.ResourceTypeVpc),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create VPC: %w", err)
 	}
 	result.VPCId = *vpcResult.Vpc.VpcId
 
+	if config.EnableIPv6 {
+		fmt.Println("   🌐 Waiting for Amazon-provided IPv6 block...")
+		result.VPCIPv6Cidr, err = a.waitForVPCIPv6Block(ctx, result.VPCId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Step 2: Create Internet Gateway
 	fmt.Println("   🌐 Creating Internet Gateway...")
 	igwResult, err := a.client.CreateInternetGateway(ctx, &ec2.CreateInternetGatewayInput{
-		TagSpecifications: []types.TagSpecification{
-			{
-				ResourceType: types.ResourceTypeInternetGateway,
-				Tags: []types.Tag{
-					{Key: aws.String("Name"), Value: aws.String("mole-igw")},
-				},
-			},
-		},
+		TagSpecifications: tagSpecifications([]types.Tag{
+			{Key: aws.String("Name"), Value: aws.String("mole-igw")},
+		}, config.Tags, types.ResourceTypeInternetGateway),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Internet Gateway: %w", err)
@@ -81,19 +83,20 @@ func (a *AWSClient) CreateNetworkInfrastructure(ctx context.Context, config *Net
 
 	// Step 4: Create Public Subnet
 	fmt.Println("   🌐 Creating public subnet...")
-	publicSubnetResult, err := a.client.CreateSubnet(ctx, &ec2.CreateSubnetInput{
+	publicSubnetInput := &ec2.CreateSubnetInput{
 		VpcId:     &result.VPCId,
 		CidrBlock: &config.PublicSubnetCidr,
-		TagSpecifications: []types.TagSpecification{
-			{
-				ResourceType: types.ResourceTypeSubnet,
-				Tags: []types.Tag{
-					{Key: aws.String("Name"), Value: aws.String("mole-public-subnet")},
-					{Key: aws.String("Type"), Value: aws.String("public")},
-				},
-			},
-		},
-	})
+		TagSpecifications: tagSpecifications([]types.Tag{
+			{Key: aws.String("Name"), Value: aws.String("mole-public-subnet")},
+			{Key: aws.String("Type"), Value: aws.String("public")},
+		}, config.Tags, types.ResourceTypeSubnet),
+	}
+	var publicSubnetResult *ec2.CreateSubnetOutput
+	if config.EnableIPv6 {
+		publicSubnetResult, err = a.createIPv6Subnet(ctx, publicSubnetInput, result.VPCIPv6Cidr, 0)
+	} else {
+		publicSubnetResult, err = a.client.CreateSubnet(ctx, publicSubnetInput)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create public subnet: %w", err)
 	}
@@ -108,18 +111,23 @@ func (a *AWSClient) CreateNetworkInfrastructure(ctx context.Context, config *Net
 		return nil, fmt.Errorf("failed to enable public IP assignment: %w", err)
 	}
 
+	if config.EnableIPv6 {
+		_, err = a.client.ModifySubnetAttribute(ctx, &ec2.ModifySubnetAttributeInput{
+			SubnetId:                    &result.PublicSubnetId,
+			AssignIpv6AddressOnCreation: &types.AttributeBooleanValue{Value: aws.Bool(true)},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to enable IPv6 address assignment: %w", err)
+		}
+	}
+
 	// Step 5: Create Public Route Table
 	fmt.Println("   🗺️  Creating public route table...")
 	publicRtResult, err := a.client.CreateRouteTable(ctx, &ec2.CreateRouteTableInput{
 		VpcId: &result.VPCId,
-		TagSpecifications: []types.TagSpecification{
-			{
-				ResourceType: types.ResourceTypeRouteTable,
-				Tags: []types.Tag{
-					{Key: aws.String("Name"), Value: aws.String("mole-public-rt")},
-				},
-			},
-		},
+		TagSpecifications: tagSpecifications([]types.Tag{
+			{Key: aws.String("Name"), Value: aws.String("mole-public-rt")},
+		}, config.Tags, types.ResourceTypeRouteTable),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create public route table: %w", err)
@@ -136,6 +144,17 @@ func (a *AWSClient) CreateNetworkInfrastructure(ctx context.Context, config *Net
 		return nil, fmt.Errorf("failed to create route to Internet Gateway: %w", err)
 	}
 
+	if config.EnableIPv6 {
+		_, err = a.client.CreateRoute(ctx, &ec2.CreateRouteInput{
+			RouteTableId:             &result.PublicRouteTableId,
+			DestinationIpv6CidrBlock: aws.String("::/0"),
+			GatewayId:                &result.InternetGatewayId,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create IPv6 route to Internet Gateway: %w", err)
+		}
+	}
+
 	// Step 7: Associate public subnet with public route table
 	_, err = a.client.AssociateRouteTable(ctx, &ec2.AssociateRouteTableInput{
 		RouteTableId: &result.PublicRouteTableId,
@@ -148,19 +167,20 @@ func (a *AWSClient) CreateNetworkInfrastructure(ctx context.Context, config *Net
 	// Step 8: Create Private Subnet (if specified)
 	if config.PrivateSubnetCidr != "" {
 		fmt.Println("   🔒 Creating private subnet...")
-		privateSubnetResult, err := a.client.CreateSubnet(ctx, &ec2.CreateSubnetInput{
+		privateSubnetInput := &ec2.CreateSubnetInput{
 			VpcId:     &result.VPCId,
 			CidrBlock: &config.PrivateSubnetCidr,
-			TagSpecifications: []types.TagSpecification{
-				{
-					ResourceType: types.ResourceTypeSubnet,
-					Tags: []types.Tag{
-						{Key: aws.String("Name"), Value: aws.String("mole-private-subnet")},
-						{Key: aws.String("Type"), Value: aws.String("private")},
-					},
-				},
-			},
-		})
+			TagSpecifications: tagSpecifications([]types.Tag{
+				{Key: aws.String("Name"), Value: aws.String("mole-private-subnet")},
+				{Key: aws.String("Type"), Value: aws.String("private")},
+			}, config.Tags, types.ResourceTypeSubnet),
+		}
+		var privateSubnetResult *ec2.CreateSubnetOutput
+		if config.EnableIPv6 {
+			privateSubnetResult, err = a.createIPv6Subnet(ctx, privateSubnetInput, result.VPCIPv6Cidr, 1)
+		} else {
+			privateSubnetResult, err = a.client.CreateSubnet(ctx, privateSubnetInput)
+		}
 		if err != nil {
 			return nil, fmt.Errorf("failed to create private subnet: %w", err)
 		}
@@ -170,14 +190,9 @@ func (a *AWSClient) CreateNetworkInfrastructure(ctx context.Context, config *Net
 		fmt.Println("   🗺️  Creating private route table...")
 		privateRtResult, err := a.client.CreateRouteTable(ctx, &ec2.CreateRouteTableInput{
 			VpcId: &result.VPCId,
-			TagSpecifications: []types.TagSpecification{
-				{
-					ResourceType: types.ResourceTypeRouteTable,
-					Tags: []types.Tag{
-						{Key: aws.String("Name"), Value: aws.String("mole-private-rt")},
-					},
-				},
-			},
+			TagSpecifications: tagSpecifications([]types.Tag{
+				{Key: aws.String("Name"), Value: aws.String("mole-private-rt")},
+			}, config.Tags, types.ResourceTypeRouteTable),
 		})
 		if err != nil {
 			return nil, fmt.Errorf("failed to create private route table: %w", err)
@@ -206,4 +221,4 @@ func (a *AWSClient) CreateNetworkInfrastructure(ctx context.Context, config *Net
 
 	fmt.Printf("   ✅ Network infrastructure ready!\n")
 	return result, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,115 @@
+package aws
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/research-computing/mole/internal/tunnel"
+)
+
+// Pre-shared keys reach the bastion as SecureString parameters under this path, encrypted with
+// the account's aws/ssm KMS key. The bastion reads and deletes its own at boot, so the keys are
+// never in user data, instance tags or the tunnel they protect.
+const pskParameterRoot = "/mole/psk"
+
+// newPSKParameterPath returns a fresh per-deployment parameter path
+func newPSKParameterPath() (string, error) {
+	return newParameterPath(pskParameterRoot)
+}
+
+// newParameterPath returns a random parameter path under root
+func newParameterPath(root string) (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate parameter path under %s: %w", root, err)
+	}
+	return root + "/" + hex.EncodeToString(buf), nil
+}
+
+// pskParameterName is the parameter holding one tunnel's pre-shared key
+func pskParameterName(path, iface string) string {
+	return path + "/" + iface
+}
+
+// pskParameterARN is the resource the bastion's role may read and delete
+func pskParameterARN(path string) string {
+	return "arn:aws:ssm:*:*:parameter" + path + "/*"
+}
+
+// generatePresharedKeys gives every tunnel its own pre-shared key
+func generatePresharedKeys(tunnels []TunnelSpec) error {
+	for i := range tunnels {
+		psk, err := tunnel.GeneratePresharedKey()
+		if err != nil {
+			return fmt.Errorf("tunnel %d: %w", i, err)
+		}
+		tunnels[i].PresharedKey = psk
+	}
+	return nil
+}
+
+// storePresharedKeys writes each tunnel's pre-shared key as a SecureString for the bastion to fetch
+func (a *AWSClient) storePresharedKeys(ctx context.Context, path string, tunnels []TunnelSpec, userTags map[string]string) error {
+	var tags []ssmtypes.Tag
+	for _, tag := range withUserTags([]types.Tag{
+		{Key: aws.String("Project"), Value: aws.String("aws-cloud-mole")},
+		{Key: aws.String("Purpose"), Value: aws.String("wireguard-psk")},
+	}, userTags) {
+		tags = append(tags, ssmtypes.Tag{Key: tag.Key, Value: tag.Value})
+	}
+
+	for _, spec := range tunnels {
+		name := pskParameterName(path, spec.Interface)
+		if _, err := a.ssmClient.PutParameter(ctx, &ssm.PutParameterInput{
+			Name:        aws.String(name),
+			Value:       aws.String(spec.PresharedKey),
+			Type:        ssmtypes.ParameterTypeSecureString,
+			Description: aws.String("mole WireGuard pre-shared key; deleted by the bastion after boot"),
+			Tags:        tags,
+		}); err != nil {
+			return fmt.Errorf("failed to store pre-shared key %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// DeletePresharedKeys removes any pre-shared key parameters a bastion has not consumed
+func (a *AWSClient) DeletePresharedKeys(ctx context.Context) (int, error) {
+	return a.deleteParameters(ctx, pskParameterRoot, "pre-shared key")
+}
+
+// deleteParameters removes every parameter under root; what names them in errors
+func (a *AWSClient) deleteParameters(ctx context.Context, root, what string) (int, error) {
+	var names []string
+	paginator := ssm.NewGetParametersByPathPaginator(a.ssmClient, &ssm.GetParametersByPathInput{
+		Path:      aws.String(root),
+		Recursive: aws.Bool(true),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to list %s parameters: %w", what, err)
+		}
+		for _, parameter := range page.Parameters {
+			names = append(names, aws.ToString(parameter.Name))
+		}
+	}
+
+	deleted := 0
+	// DeleteParameters accepts at most 10 names per call
+	for start := 0; start < len(names); start += 10 {
+		batch := names[start:min(start+10, len(names))]
+		output, err := a.ssmClient.DeleteParameters(ctx, &ssm.DeleteParametersInput{Names: batch})
+		if err != nil {
+			return deleted, fmt.Errorf("failed to delete %s parameters: %w", what, err)
+		}
+		deleted += len(output.DeletedParameters)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,87 @@
+package aws
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGeneratePresharedKeys(t *testing.T) {
+	tunnels := planTunnels(3, false)
+	if err := generatePresharedKeys(tunnels); err != nil {
+		t.Fatalf("generatePresharedKeys failed: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, spec := range tunnels {
+		if len(spec.PresharedKey) != 44 {
+			t.Errorf("Tunnel %d: unexpected PSK %q", spec.ID, spec.PresharedKey)
+		}
+		if seen[spec.PresharedKey] {
+			t.Errorf("Tunnel %d reuses another tunnel's PSK", spec.ID)
+		}
+		seen[spec.PresharedKey] = true
+	}
+
+	path, err := newPSKParameterPath()
+	if err != nil {
+		t.Fatalf("newPSKParameterPath failed: %v", err)
+	}
+	if !strings.HasPrefix(path, pskParameterRoot+"/") || pskParameterName(path, "wg1") != path+"/wg1" {
+		t.Errorf("Unexpected parameter path %s", path)
+	}
+}
+
+func TestInstancePolicyPresharedKeys(t *testing.T) {
+	policy := instancePolicy(&DeploymentConfig{})
+	if hasAction(policy.Actions(), "ssm:GetParameter") {
+		t.Error("Instance policy without PSKs should not read SSM parameters")
+	}
+
+	policy = instancePolicy(&DeploymentConfig{PSKParameterPath: "/mole/psk/0123456789abcdef"})
+	statement := policy.Statement[len(policy.Statement)-1]
+	if statement.Sid != "MolePresharedKeys" {
+		t.Fatalf("Expected PSK statement, got %+v", statement)
+	}
+	if len(statement.Resource) != 1 || statement.Resource[0] != "arn:aws:ssm:*:*:parameter/mole/psk/0123456789abcdef/*" {
+		t.Errorf("PSK access must be scoped to the deployment's path, got %v", statement.Resource)
+	}
+	if !hasAction(statement.Action, "ssm:DeleteParameter") {
+		t.Error("Bastion must be able to delete its PSK parameters after reading them")
+	}
+}
+
+func TestPresharedKeyDelivery(t *testing.T) {
+	tunnels := planTunnels(1, false)
+	tunnels[0].ClientPublicKey = "Y2xpZW50LWtleS0wAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+	tunnels[0].ServerPublicKey = "c2VydmVyLWtleS0wAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+	if err := generatePresharedKeys(tunnels); err != nil {
+		t.Fatal(err)
+	}
+
+	// The client config carries the key itself
+	client := generateClientConfig(&DeploymentResult{BastionPublicIP: "203.0.113.10"}, tunnels[0], "")
+	if !strings.Contains(client, "PresharedKey = "+tunnels[0].PresharedKey+"\n") {
+		t.Errorf("Client config missing PSK:\n%s", client)
+	}
+
+	// The bastion script only references the SSM parameter
+	script, err := RenderBastionUserData(BastionUserData{
+		OS:               OSAmazonLinux,
+		Region:           "us-west-2",
+		Tunnels:          tunnels,
+		PSKParameterPath: "/mole/psk/0123456789abcdef",
+	})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if strings.Contains(script, tunnels[0].PresharedKey) {
+		t.Error("PSK must never appear in user data")
+	}
+	if !strings.Contains(script, "--with-decryption --name /mole/psk/0123456789abcdef/wg0") {
+		t.Error("Bastion script should fetch the PSK from SSM")
+	}
+
+	if _, err := RenderBastionUserData(BastionUserData{OS: OSUbuntu, Tunnels: tunnels, PSKParameterPath: "/mole/psk/x"}); err == nil {
+		t.Error("Expected PSKs without a region to be rejected")
+	}
+}
@@ -0,0 +1,120 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// AllPrivateSubnets is the --private-subnet value that selects every private subnet in the VPC
+const AllPrivateSubnets = "all"
+
+// privateSubnetIDs returns every private subnet to route, primary first, without duplicates
+func privateSubnetIDs(config *DeploymentConfig) []string {
+	seen := make(map[string]bool)
+	var ids []string
+	for _, id := range append([]string{config.PrivateSubnetId}, config.PrivateSubnetIds...) {
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// resolvePrivateSubnets looks up the CIDRs and effective route tables of the private subnets
+func (a *AWSClient) resolvePrivateSubnets(ctx context.Context, config *DeploymentConfig) ([]SubnetInfo, error) {
+	ids := privateSubnetIDs(config)
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	vpcSubnets, err := a.DescribeVPCSubnets(ctx, config.VPCId)
+	if err != nil {
+		return nil, err
+	}
+
+	details := VPCDetails{Subnets: vpcSubnets}
+	var subnets []SubnetInfo
+	for _, id := range ids {
+		subnet, ok := details.FindSubnet(id)
+		if !ok {
+			return nil, fmt.Errorf("private subnet %s not found in VPC %s", id, config.VPCId)
+		}
+		subnets = append(subnets, subnet)
+	}
+
+	return subnets, nil
+}
+
+// uniqueRouteTables returns the distinct route tables used by the given subnets. Subnets
+// without an explicit association share the main table, so it is only listed once.
+func uniqueRouteTables(subnets []SubnetInfo) []string {
+	seen := make(map[string]bool)
+	var tables []string
+	for _, subnet := range subnets {
+		if subnet.RouteTableId == "" || seen[subnet.RouteTableId] {
+			continue
+		}
+		seen[subnet.RouteTableId] = true
+		tables = append(tables, subnet.RouteTableId)
+	}
+	return tables
+}
+
+// ValidateRoutedCIDRs checks extra destination CIDRs (peered VPCs, Transit Gateway attachments).
+// Overlaps with the tunnel networks are left to the IPAM, which knows the actual allocations.
+func ValidateRoutedCIDRs(cidrs []string) error {
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid route CIDR %q: %w", cidr, err)
+		}
+		if prefix.Bits() == 0 {
+			return fmt.Errorf("route CIDR %s would send all traffic through the tunnel", cidr)
+		}
+	}
+	return nil
+}
+
+// configurePrivateSubnetRouting sends traffic for every tunnel network from each private route table back to the bastion
+func (a *AWSClient) configurePrivateSubnetRouting(ctx context.Context, config *DeploymentConfig, subnets []SubnetInfo, bastionInstanceID string) error {
+	routeTables := uniqueRouteTables(subnets)
+	if len(routeTables) == 0 {
+		return fmt.Errorf("no route table found for private subnets %v", privateSubnetIDs(config))
+	}
+
+	tunnels := deploymentTunnels(config)
+	for _, routeTableId := range routeTables {
+		for _, network := range tunnelNetworks(tunnels) {
+			_, err := a.client.CreateRoute(ctx, &ec2.CreateRouteInput{
+				RouteTableId:         aws.String(routeTableId),
+				DestinationCidrBlock: aws.String(network),
+				InstanceId:           &bastionInstanceID,
+			})
+			// An existing route is fine
+			if err != nil && !ec2ErrorContains(err, "RouteAlreadyExists") {
+				return fmt.Errorf("failed to create route to tunnel network %s in %s: %w", network, routeTableId, err)
+			}
+		}
+
+		for _, network := range tunnelIPv6Networks(tunnels) {
+			_, err := a.client.CreateRoute(ctx, &ec2.CreateRouteInput{
+				RouteTableId:             aws.String(routeTableId),
+				DestinationIpv6CidrBlock: aws.String(network),
+				InstanceId:               &bastionInstanceID,
+			})
+			if err != nil && !ec2ErrorContains(err, "RouteAlreadyExists") {
+				return fmt.Errorf("failed to create IPv6 route to tunnel network %s in %s: %w", network, routeTableId, err)
+			}
+		}
+
+		fmt.Printf("  ✓ Route table %s → bastion\n", routeTableId)
+	}
+
+	return nil
+}
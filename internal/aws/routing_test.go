@@ -0,0 +1,153 @@
+package aws
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/aws/smithy-go"
+	"github.com/research-computing/mole/internal/tunnel"
+)
+
+func TestPrivateSubnetIDs(t *testing.T) {
+	config := &DeploymentConfig{
+		PrivateSubnetId:  "subnet-a",
+		PrivateSubnetIds: []string{"subnet-a", "subnet-b", "", "subnet-c", "subnet-b"},
+	}
+
+	ids := privateSubnetIDs(config)
+	expected := []string{"subnet-a", "subnet-b", "subnet-c"}
+	if strings.Join(ids, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %v, got %v", expected, ids)
+	}
+
+	if ids := privateSubnetIDs(&DeploymentConfig{}); len(ids) != 0 {
+		t.Errorf("Expected no private subnets, got %v", ids)
+	}
+}
+
+func TestUniqueRouteTables(t *testing.T) {
+	subnets := []SubnetInfo{
+		{SubnetId: "subnet-a", RouteTableId: "rtb-main"},
+		{SubnetId: "subnet-b", RouteTableId: "rtb-private"},
+		{SubnetId: "subnet-c", RouteTableId: "rtb-main"},
+		{SubnetId: "subnet-d"},
+	}
+
+	tables := uniqueRouteTables(subnets)
+	if strings.Join(tables, ",") != "rtb-main,rtb-private" {
+		t.Errorf("Expected [rtb-main rtb-private], got %v", tables)
+	}
+}
+
+func TestValidateRoutedCIDRs(t *testing.T) {
+	if err := ValidateRoutedCIDRs([]string{"172.31.0.0/16", "10.50.0.0/16"}); err != nil {
+		t.Errorf("Valid CIDRs rejected: %v", err)
+	}
+
+	invalid := [][]string{
+		{"not-a-cidr"},
+		{"0.0.0.0/0"},
+	}
+	for _, cidrs := range invalid {
+		if err := ValidateRoutedCIDRs(cidrs); err == nil {
+			t.Errorf("Expected %v to be rejected", cidrs)
+		}
+	}
+}
+
+func TestRoutedCIDRsWithCustomBase(t *testing.T) {
+	// The old default tunnel range is free when tunnel.base_cidr points elsewhere
+	routed := []string{"10.100.0.0/16", "172.20.1.0/24"}
+	if err := ValidateRoutedCIDRs(routed); err != nil {
+		t.Fatalf("Route CIDRs outside the tunnel base rejected: %v", err)
+	}
+
+	// As mole up does: the IPAM keeps the tunnels clear of the routed networks
+	ipam, err := tunnel.NewIPAM("172.20.0.0/16", 0)
+	if err != nil {
+		t.Fatalf("NewIPAM failed: %v", err)
+	}
+	for _, cidr := range routed {
+		if err := ipam.Reserve("route CIDR", netip.MustParsePrefix(cidr)); err != nil {
+			t.Fatalf("Reserve(%s) failed: %v", cidr, err)
+		}
+	}
+	allocation, err := ipam.Allocate(0)
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+	// Tunnel 0 would default to 172.20.1.0/24, which is routed elsewhere
+	if allocation.Network.String() != "172.20.2.0/24" {
+		t.Errorf("Expected tunnel 0 to move off the routed 172.20.1.0/24, got %s", allocation.Network)
+	}
+
+	// A routed network covering the whole base is reported
+	if err := ipam.Reserve("route CIDR", netip.MustParsePrefix("172.16.0.0/12")); err == nil {
+		t.Error("Expected a route CIDR covering tunnel.base_cidr to be rejected")
+	}
+}
+
+func TestClientAllowedIPsMultipleSubnets(t *testing.T) {
+	config := &DeploymentConfig{
+		PrivateSubnetCIDRs: []string{"10.0.2.0/24", "10.0.3.0/24"},
+		RoutedCIDRs:        []string{"172.31.0.0/16"},
+	}
+
+	allowed := strings.Join(clientAllowedIPs(config), ", ")
+	if allowed != "10.0.2.0/24, 10.0.3.0/24, 172.31.0.0/16" {
+		t.Errorf("Unexpected AllowedIPs: %s", allowed)
+	}
+}
+
+func TestGenerateUserDataRouting(t *testing.T) {
+	client := &AWSClient{}
+	config := &DeploymentConfig{
+		ClientPublicKey:    "client-key",
+		Region:             "us-west-2",
+		PrivateSubnetCIDRs: []string{"10.0.2.0/24", "10.0.3.0/24"},
+		RoutedCIDRs:        []string{"172.31.0.0/16"},
+	}
+
+	encoded, err := client.generateUserData(context.Background(), config)
+	if err != nil {
+		t.Fatalf("Failed to render user data: %v", err)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("User data is not valid base64: %v", err)
+	}
+	script := string(decoded)
+
+	checks := []string{
+		`PRIVATE_SUBNET_CIDRS="10.0.2.0/24 10.0.3.0/24"`,
+		`ROUTED_CIDRS="172.31.0.0/16"`,
+		`TUNNEL_NETWORKS="10.100.1.0/24"`,
+		"iptables -t nat -A POSTROUTING -s $tunnel -d $cidr -j MASQUERADE",
+		"Address = 10.100.1.1/24\n",
+		"AllowedIPs = 10.100.1.2/32\n",
+	}
+	for _, check := range checks {
+		if !strings.Contains(script, check) {
+			t.Errorf("User data missing %q", check)
+		}
+	}
+}
+
+func TestEC2ErrorContains(t *testing.T) {
+	apiErr := &smithy.GenericAPIError{Code: "RouteAlreadyExists", Message: "route exists"}
+	wrapped := errors.Join(errors.New("operation error EC2: CreateRoute"), apiErr)
+
+	if !ec2ErrorContains(wrapped, "RouteAlreadyExists") {
+		t.Error("Wrapped API error code should match")
+	}
+	if ec2ErrorContains(wrapped, "InvalidRouteTableID.NotFound") {
+		t.Error("Different API error code should not match")
+	}
+	if ec2ErrorContains(nil, "RouteAlreadyExists") {
+		t.Error("nil error should not match")
+	}
+}
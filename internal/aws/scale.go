@@ -0,0 +1,103 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// OpenTunnelPort admits WireGuard to a tunnel's bastion port from the sources tunnel 0's
+// port admits, which every deployment opens. A port that is already open is left alone.
+func (a *AWSClient) OpenTunnelPort(ctx context.Context, target *BastionTarget, id int) error {
+	groupID, permissions, err := a.bastionIngress(ctx, target)
+	if err != nil {
+		return err
+	}
+	if _, ok := tunnelPortPermission(permissions, id); ok {
+		return nil
+	}
+	template, ok := tunnelPortPermission(permissions, 0)
+	if !ok {
+		return fmt.Errorf("security group %s has no WireGuard rule for tunnel 0 to follow", groupID)
+	}
+
+	_, err = a.client.AuthorizeSecurityGroupIngress(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId:       aws.String(groupID),
+		IpPermissions: []types.IpPermission{tunnelPortRule(template, id)},
+	})
+	if err != nil && !ec2ErrorContains(err, "InvalidPermission.Duplicate") {
+		return fmt.Errorf("failed to open port %d on %s: %w", tunnelBasePort+id, groupID, err)
+	}
+	return nil
+}
+
+// CloseTunnelPort revokes the WireGuard rule of a tunnel's bastion port; a port that is
+// already closed is fine
+func (a *AWSClient) CloseTunnelPort(ctx context.Context, target *BastionTarget, id int) error {
+	groupID, permissions, err := a.bastionIngress(ctx, target)
+	if err != nil {
+		return err
+	}
+	rule, ok := tunnelPortPermission(permissions, id)
+	if !ok {
+		return nil
+	}
+
+	_, err = a.client.RevokeSecurityGroupIngress(ctx, &ec2.RevokeSecurityGroupIngressInput{
+		GroupId:       aws.String(groupID),
+		IpPermissions: []types.IpPermission{rule},
+	})
+	if err != nil && !ec2ErrorContains(err, "InvalidPermission.NotFound") {
+		return fmt.Errorf("failed to close port %d on %s: %w", tunnelBasePort+id, groupID, err)
+	}
+	return nil
+}
+
+// bastionIngress returns the bastion's security group and its ingress rules
+func (a *AWSClient) bastionIngress(ctx context.Context, target *BastionTarget) (string, []types.IpPermission, error) {
+	if len(target.SecurityGroupIDs) == 0 {
+		return "", nil, fmt.Errorf("bastion %s has no security group", target.InstanceID)
+	}
+	groupID := target.SecurityGroupIDs[0]
+	groups, err := a.client.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{GroupIds: []string{groupID}})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to describe security group %s: %w", groupID, err)
+	}
+	if len(groups.SecurityGroups) == 0 {
+		return "", nil, fmt.Errorf("security group %s not found", groupID)
+	}
+	return groupID, groups.SecurityGroups[0].IpPermissions, nil
+}
+
+// tunnelPortPermission finds the UDP rule for exactly one tunnel's port
+func tunnelPortPermission(permissions []types.IpPermission, id int) (types.IpPermission, bool) {
+	port := int32(tunnelBasePort + id)
+	for _, permission := range permissions {
+		if aws.ToString(permission.IpProtocol) == "udp" &&
+			aws.ToInt32(permission.FromPort) == port && aws.ToInt32(permission.ToPort) == port {
+			return permission, true
+		}
+	}
+	return types.IpPermission{}, false
+}
+
+// tunnelPortRule admits a tunnel's port from the sources of another tunnel's rule
+func tunnelPortRule(template types.IpPermission, id int) types.IpPermission {
+	port := int32(tunnelBasePort + id)
+	description := aws.String(fmt.Sprintf("WireGuard tunnel %d", id))
+	rule := types.IpPermission{
+		IpProtocol: aws.String("udp"),
+		FromPort:   aws.Int32(port),
+		ToPort:     aws.Int32(port),
+	}
+	for _, source := range template.IpRanges {
+		rule.IpRanges = append(rule.IpRanges, types.IpRange{CidrIp: source.CidrIp, Description: description})
+	}
+	for _, source := range template.Ipv6Ranges {
+		rule.Ipv6Ranges = append(rule.Ipv6Ranges, types.Ipv6Range{CidrIpv6: source.CidrIpv6, Description: description})
+	}
+	return rule
+}
@@ -0,0 +1,40 @@
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestTunnelPortRule(t *testing.T) {
+	// The deployment's own rules, dual-stack and with a scaler's headroom
+	permissions := buildIngressRules(&DeploymentConfig{
+		TunnelCount: 2,
+		MaxTunnels:  4,
+		AllowedCIDR: "198.51.100.0/24",
+		EnableIPv6:  true,
+		VPCCidr:     "10.200.0.0/16",
+		AccessMode:  AccessModeSSM,
+	})
+
+	rule, ok := tunnelPortPermission(permissions, 3)
+	if !ok || aws.ToString(rule.IpRanges[0].Description) != "WireGuard tunnel 3" {
+		t.Fatalf("Expected tunnel 3's rule, got %+v", rule)
+	}
+	if _, ok := tunnelPortPermission(permissions, 4); ok {
+		t.Error("Tunnel 4's port should not be open")
+	}
+
+	template, _ := tunnelPortPermission(permissions, 0)
+	added := tunnelPortRule(template, 5)
+	if aws.ToString(added.IpProtocol) != "udp" || aws.ToInt32(added.FromPort) != 51825 || aws.ToInt32(added.ToPort) != 51825 {
+		t.Errorf("Unexpected port rule %+v", added)
+	}
+	if len(added.IpRanges) != 1 || aws.ToString(added.IpRanges[0].CidrIp) != "198.51.100.0/24" ||
+		aws.ToString(added.IpRanges[0].Description) != "WireGuard tunnel 5" {
+		t.Errorf("Expected tunnel 0's IPv4 sources, got %+v", added.IpRanges)
+	}
+	if len(added.Ipv6Ranges) != 1 || aws.ToString(added.Ipv6Ranges[0].CidrIpv6) != defaultAllowedIPv6 {
+		t.Errorf("Expected tunnel 0's IPv6 sources, got %+v", added.Ipv6Ranges)
+	}
+}
@@ -0,0 +1,279 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// SubnetInfo describes a subnet and whether it can host the bastion
+type SubnetInfo struct {
+	SubnetId         string
+	CidrBlock        string
+	Ipv6CidrBlock    string // Empty unless the subnet is dual-stack
+	AvailabilityZone string
+	AvailableIPs     int32
+	Name             string
+	RouteTableId     string
+	Public           bool // Route table has a default route to an Internet Gateway
+	MapPublicIP      bool // Instances get a public IPv4 address automatically
+}
+
+// SuitableForBastion reports whether the bastion can be launched here with a public IP
+func (s SubnetInfo) SuitableForBastion() bool {
+	return s.Public && s.MapPublicIP && s.AvailableIPs > 0
+}
+
+// VPCDetails combines a VPC with its analysed subnets
+type VPCDetails struct {
+	VPCInfo
+	CidrBlocks    []string // Every associated IPv4 block, the primary first
+	Ipv6CidrBlock string
+	Subnets       []SubnetInfo
+}
+
+// PublicSubnets returns the subnets with a route to an Internet Gateway
+func (v VPCDetails) PublicSubnets() []SubnetInfo {
+	var public []SubnetInfo
+	for _, subnet := range v.Subnets {
+		if subnet.Public {
+			public = append(public, subnet)
+		}
+	}
+	return public
+}
+
+// PrivateSubnets returns the subnets without a route to an Internet Gateway
+func (v VPCDetails) PrivateSubnets() []SubnetInfo {
+	var private []SubnetInfo
+	for _, subnet := range v.Subnets {
+		if !subnet.Public {
+			private = append(private, subnet)
+		}
+	}
+	return private
+}
+
+// DescribeVPC returns a VPC with its subnets classified as public or private
+func (a *AWSClient) DescribeVPC(ctx context.Context, vpcID string) (*VPCDetails, error) {
+	vpcResult, err := a.client.DescribeVpcs(ctx, &ec2.DescribeVpcsInput{
+		VpcIds: []string{vpcID},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe VPC %s: %w", vpcID, err)
+	}
+	if len(vpcResult.Vpcs) == 0 {
+		return nil, fmt.Errorf("VPC %s not found", vpcID)
+	}
+
+	vpc := vpcResult.Vpcs[0]
+	details := &VPCDetails{
+		VPCInfo: VPCInfo{
+			VpcId:     aws.ToString(vpc.VpcId),
+			CidrBlock: aws.ToString(vpc.CidrBlock),
+			State:     string(vpc.State),
+			IsDefault: aws.ToBool(vpc.IsDefault),
+			Name:      tagValue(vpc.Tags, "Name"),
+		},
+		CidrBlocks:    associatedVPCIPv4Blocks(vpc),
+		Ipv6CidrBlock: associatedVPCIPv6Block(vpc),
+	}
+
+	details.Subnets, err = a.DescribeVPCSubnets(ctx, vpcID)
+	if err != nil {
+		return nil, err
+	}
+
+	return details, nil
+}
+
+// associatedVPCIPv4Blocks returns the primary CIDR block of a VPC followed by its other
+// associated IPv4 blocks
+func associatedVPCIPv4Blocks(vpc types.Vpc) []string {
+	blocks := []string{aws.ToString(vpc.CidrBlock)}
+	for _, association := range vpc.CidrBlockAssociationSet {
+		block := aws.ToString(association.CidrBlock)
+		if association.CidrBlockState != nil &&
+			association.CidrBlockState.State == types.VpcCidrBlockStateCodeAssociated &&
+			block != blocks[0] {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+// subnetDescriber is the part of the EC2 API DescribeVPCSubnets reads
+type subnetDescriber interface {
+	ec2.DescribeSubnetsAPIClient
+	ec2.DescribeRouteTablesAPIClient
+}
+
+// DescribeVPCSubnets lists the subnets of a VPC and classifies them using their route tables
+func (a *AWSClient) DescribeVPCSubnets(ctx context.Context, vpcID string) ([]SubnetInfo, error) {
+	return describeVPCSubnets(ctx, a.client, vpcID)
+}
+
+// describeVPCSubnets reads every page of the VPC's subnets and route tables; a VPC with
+// many subnets returns them over several pages
+func describeVPCSubnets(ctx context.Context, client subnetDescriber, vpcID string) ([]SubnetInfo, error) {
+	vpcFilter := []types.Filter{
+		{
+			Name:   aws.String("vpc-id"),
+			Values: []string{vpcID},
+		},
+	}
+
+	var subnets []types.Subnet
+	subnetPaginator := ec2.NewDescribeSubnetsPaginator(client, &ec2.DescribeSubnetsInput{
+		Filters: vpcFilter,
+	})
+	for subnetPaginator.HasMorePages() {
+		page, err := subnetPaginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe subnets: %w", err)
+		}
+		subnets = append(subnets, page.Subnets...)
+	}
+
+	var routeTables []types.RouteTable
+	routeTablePaginator := ec2.NewDescribeRouteTablesPaginator(client, &ec2.DescribeRouteTablesInput{
+		Filters: vpcFilter,
+	})
+	for routeTablePaginator.HasMorePages() {
+		page, err := routeTablePaginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe route tables: %w", err)
+		}
+		routeTables = append(routeTables, page.RouteTables...)
+	}
+
+	return classifySubnets(subnets, routeTables), nil
+}
+
+// classifySubnets resolves each subnet's effective route table (explicit association or the
+// VPC main table) and marks it public when that table routes to an Internet Gateway
+func classifySubnets(subnets []types.Subnet, routeTables []types.RouteTable) []SubnetInfo {
+	var mainTable *types.RouteTable
+	associated := make(map[string]*types.RouteTable)
+
+	for i := range routeTables {
+		table := &routeTables[i]
+		for _, association := range table.Associations {
+			if aws.ToBool(association.Main) {
+				mainTable = table
+			}
+			if association.SubnetId != nil {
+				associated[*association.SubnetId] = table
+			}
+		}
+	}
+
+	var infos []SubnetInfo
+	for _, subnet := range subnets {
+		info := SubnetInfo{
+			SubnetId:         aws.ToString(subnet.SubnetId),
+			CidrBlock:        aws.ToString(subnet.CidrBlock),
+			Ipv6CidrBlock:    associatedSubnetIPv6Block(subnet),
+			AvailabilityZone: aws.ToString(subnet.AvailabilityZone),
+			AvailableIPs:     aws.ToInt32(subnet.AvailableIpAddressCount),
+			Name:             tagValue(subnet.Tags, "Name"),
+			MapPublicIP:      aws.ToBool(subnet.MapPublicIpOnLaunch),
+		}
+
+		table, ok := associated[info.SubnetId]
+		if !ok {
+			table = mainTable
+		}
+		if table != nil {
+			info.RouteTableId = aws.ToString(table.RouteTableId)
+			info.Public = routesToInternetGateway(table)
+		}
+
+		infos = append(infos, info)
+	}
+
+	// Stable output: by AZ, then CIDR
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].AvailabilityZone != infos[j].AvailabilityZone {
+			return infos[i].AvailabilityZone < infos[j].AvailabilityZone
+		}
+		return infos[i].CidrBlock < infos[j].CidrBlock
+	})
+
+	return infos
+}
+
+// routesToInternetGateway reports whether a route table has an active route to an IGW
+func routesToInternetGateway(table *types.RouteTable) bool {
+	for _, route := range table.Routes {
+		if route.State == types.RouteStateBlackhole {
+			continue
+		}
+		if strings.HasPrefix(aws.ToString(route.GatewayId), "igw-") {
+			return true
+		}
+	}
+	return false
+}
+
+// FindSubnet returns the analysed subnet with the given ID
+func (v VPCDetails) FindSubnet(subnetID string) (SubnetInfo, bool) {
+	for _, subnet := range v.Subnets {
+		if subnet.SubnetId == subnetID {
+			return subnet, true
+		}
+	}
+	return SubnetInfo{}, false
+}
+
+// SelectDeploymentSubnets picks the public subnet for the bastion and the private subnets
+// to route through it. The public subnet with the most free addresses wins; private
+// subnets in the same AZ are listed first to avoid cross-AZ data transfer charges.
+// With requireIPv6 only dual-stack public subnets are considered for the bastion.
+func SelectDeploymentSubnets(subnets []SubnetInfo, requireIPv6 bool) (SubnetInfo, []SubnetInfo, error) {
+	var best *SubnetInfo
+	for i := range subnets {
+		subnet := &subnets[i]
+		if !subnet.SuitableForBastion() || (requireIPv6 && subnet.Ipv6CidrBlock == "") {
+			continue
+		}
+		if best == nil || subnet.AvailableIPs > best.AvailableIPs {
+			best = subnet
+		}
+	}
+
+	if best == nil {
+		if requireIPv6 {
+			return SubnetInfo{}, nil, fmt.Errorf("no dual-stack public subnet with automatic public IP assignment and free addresses found")
+		}
+		return SubnetInfo{}, nil, fmt.Errorf("no public subnet with automatic public IP assignment and free addresses found")
+	}
+
+	var private []SubnetInfo
+	for _, subnet := range subnets {
+		if !subnet.Public {
+			private = append(private, subnet)
+		}
+	}
+	sort.SliceStable(private, func(i, j int) bool {
+		return private[i].AvailabilityZone == best.AvailabilityZone &&
+			private[j].AvailabilityZone != best.AvailabilityZone
+	})
+
+	return *best, private, nil
+}
+
+// tagValue returns the value of an EC2 tag, or an empty string when absent
+func tagValue(tags []types.Tag, key string) string {
+	for _, tag := range tags {
+		if aws.ToString(tag.Key) == key {
+			return aws.ToString(tag.Value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,209 @@
+package aws
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestClassifySubnets(t *testing.T) {
+	subnets := []types.Subnet{
+		{
+			SubnetId:                aws.String("subnet-public"),
+			CidrBlock:               aws.String("10.0.1.0/24"),
+			AvailabilityZone:        aws.String("us-west-2a"),
+			AvailableIpAddressCount: aws.Int32(200),
+			MapPublicIpOnLaunch:     aws.Bool(true),
+		},
+		{
+			SubnetId:                aws.String("subnet-private"),
+			CidrBlock:               aws.String("10.0.2.0/24"),
+			AvailabilityZone:        aws.String("us-west-2a"),
+			AvailableIpAddressCount: aws.Int32(100),
+		},
+		{
+			// No explicit association: inherits the main route table
+			SubnetId:                aws.String("subnet-main"),
+			CidrBlock:               aws.String("10.0.3.0/24"),
+			AvailabilityZone:        aws.String("us-west-2b"),
+			AvailableIpAddressCount: aws.Int32(50),
+			Tags:                    []types.Tag{{Key: aws.String("Name"), Value: aws.String("legacy")}},
+		},
+	}
+
+	routeTables := []types.RouteTable{
+		{
+			RouteTableId: aws.String("rtb-main"),
+			Associations: []types.RouteTableAssociation{{Main: aws.Bool(true)}},
+			Routes: []types.Route{
+				{DestinationCidrBlock: aws.String("0.0.0.0/0"), GatewayId: aws.String("igw-123")},
+			},
+		},
+		{
+			RouteTableId: aws.String("rtb-public"),
+			Associations: []types.RouteTableAssociation{{SubnetId: aws.String("subnet-public")}},
+			Routes: []types.Route{
+				{DestinationCidrBlock: aws.String("10.0.0.0/16"), GatewayId: aws.String("local")},
+				{DestinationCidrBlock: aws.String("0.0.0.0/0"), GatewayId: aws.String("igw-123")},
+			},
+		},
+		{
+			RouteTableId: aws.String("rtb-private"),
+			Associations: []types.RouteTableAssociation{{SubnetId: aws.String("subnet-private")}},
+			Routes: []types.Route{
+				{DestinationCidrBlock: aws.String("10.0.0.0/16"), GatewayId: aws.String("local")},
+				{DestinationCidrBlock: aws.String("0.0.0.0/0"), NatGatewayId: aws.String("nat-123")},
+			},
+		},
+	}
+
+	infos := classifySubnets(subnets, routeTables)
+	if len(infos) != 3 {
+		t.Fatalf("Expected 3 subnets, got %d", len(infos))
+	}
+
+	byID := make(map[string]SubnetInfo)
+	for _, info := range infos {
+		byID[info.SubnetId] = info
+	}
+
+	if !byID["subnet-public"].Public || byID["subnet-public"].RouteTableId != "rtb-public" {
+		t.Errorf("subnet-public should be public via rtb-public: %+v", byID["subnet-public"])
+	}
+	if byID["subnet-private"].Public {
+		t.Error("subnet-private routes through a NAT gateway and should be private")
+	}
+	if !byID["subnet-main"].Public || byID["subnet-main"].RouteTableId != "rtb-main" {
+		t.Errorf("subnet-main should inherit the main route table: %+v", byID["subnet-main"])
+	}
+	if byID["subnet-main"].Name != "legacy" {
+		t.Errorf("Expected Name tag 'legacy', got %q", byID["subnet-main"].Name)
+	}
+	if byID["subnet-private"].AvailableIPs != 100 {
+		t.Errorf("Expected 100 free IPs, got %d", byID["subnet-private"].AvailableIPs)
+	}
+}
+
+// pagedSubnets returns one subnet and one route table per page, as a large VPC would
+type pagedSubnets struct {
+	subnets     []types.Subnet
+	routeTables []types.RouteTable
+}
+
+// pageIndex returns the item a page token points at and the token of the page after it
+func pageIndex(token *string, count int) (int, *string) {
+	index, _ := strconv.Atoi(aws.ToString(token))
+	if index+1 < count {
+		return index, aws.String(strconv.Itoa(index + 1))
+	}
+	return index, nil
+}
+
+func (p *pagedSubnets) DescribeSubnets(_ context.Context, input *ec2.DescribeSubnetsInput, _ ...func(*ec2.Options)) (*ec2.DescribeSubnetsOutput, error) {
+	index, next := pageIndex(input.NextToken, len(p.subnets))
+	return &ec2.DescribeSubnetsOutput{Subnets: p.subnets[index : index+1], NextToken: next}, nil
+}
+
+func (p *pagedSubnets) DescribeRouteTables(_ context.Context, input *ec2.DescribeRouteTablesInput, _ ...func(*ec2.Options)) (*ec2.DescribeRouteTablesOutput, error) {
+	index, next := pageIndex(input.NextToken, len(p.routeTables))
+	return &ec2.DescribeRouteTablesOutput{RouteTables: p.routeTables[index : index+1], NextToken: next}, nil
+}
+
+func TestDescribeVPCSubnetsPages(t *testing.T) {
+	client := &pagedSubnets{
+		subnets: []types.Subnet{
+			{SubnetId: aws.String("subnet-a"), CidrBlock: aws.String("10.0.1.0/24")},
+			{SubnetId: aws.String("subnet-b"), CidrBlock: aws.String("10.0.2.0/24")},
+			{SubnetId: aws.String("subnet-c"), CidrBlock: aws.String("10.0.3.0/24")},
+		},
+		routeTables: []types.RouteTable{
+			{
+				RouteTableId: aws.String("rtb-main"),
+				Associations: []types.RouteTableAssociation{{Main: aws.Bool(true)}},
+			},
+			{
+				// Only on the last page: subnet-c is public through it
+				RouteTableId: aws.String("rtb-public"),
+				Associations: []types.RouteTableAssociation{{SubnetId: aws.String("subnet-c")}},
+				Routes: []types.Route{
+					{DestinationCidrBlock: aws.String("0.0.0.0/0"), GatewayId: aws.String("igw-123")},
+				},
+			},
+		},
+	}
+
+	infos, err := describeVPCSubnets(context.Background(), client, "vpc-123")
+	if err != nil {
+		t.Fatalf("describeVPCSubnets failed: %v", err)
+	}
+	if len(infos) != 3 {
+		t.Fatalf("Expected the subnets from all 3 pages, got %d", len(infos))
+	}
+	if last := infos[2]; last.SubnetId != "subnet-c" || !last.Public || last.RouteTableId != "rtb-public" {
+		t.Errorf("subnet-c should be public via rtb-public from the second page: %+v", last)
+	}
+}
+
+func TestSelectDeploymentSubnets(t *testing.T) {
+	subnets := []SubnetInfo{
+		{SubnetId: "subnet-pub-a", AvailabilityZone: "us-west-2a", AvailableIPs: 10, Public: true, MapPublicIP: true},
+		{SubnetId: "subnet-pub-b", AvailabilityZone: "us-west-2b", AvailableIPs: 200, Public: true, MapPublicIP: true},
+		{SubnetId: "subnet-pub-noip", AvailabilityZone: "us-west-2c", AvailableIPs: 4000, Public: true},
+		{SubnetId: "subnet-priv-a", AvailabilityZone: "us-west-2a", AvailableIPs: 100},
+		{SubnetId: "subnet-priv-b", AvailabilityZone: "us-west-2b", AvailableIPs: 100},
+	}
+
+	public, private, err := SelectDeploymentSubnets(subnets, false)
+	if err != nil {
+		t.Fatalf("SelectDeploymentSubnets failed: %v", err)
+	}
+
+	// Subnets without automatic public IPs are skipped even if larger
+	if public.SubnetId != "subnet-pub-b" {
+		t.Errorf("Expected subnet-pub-b, got %s", public.SubnetId)
+	}
+
+	if len(private) != 2 {
+		t.Fatalf("Expected 2 private subnets, got %d", len(private))
+	}
+	if private[0].SubnetId != "subnet-priv-b" {
+		t.Errorf("Private subnet in the bastion's AZ should come first, got %s", private[0].SubnetId)
+	}
+
+	_, _, err = SelectDeploymentSubnets([]SubnetInfo{{SubnetId: "subnet-priv", AvailableIPs: 10}}, false)
+	if err == nil {
+		t.Error("Expected error when no public subnet is available")
+	}
+
+	// Dual-stack deployments need a public subnet with an IPv6 block
+	subnets[0].Ipv6CidrBlock = "2600:1f14:abc:de00::/64"
+	public, _, err = SelectDeploymentSubnets(subnets, true)
+	if err != nil {
+		t.Fatalf("SelectDeploymentSubnets with IPv6 failed: %v", err)
+	}
+	if public.SubnetId != "subnet-pub-a" {
+		t.Errorf("Expected dual-stack subnet-pub-a, got %s", public.SubnetId)
+	}
+}
+
+func TestAssociatedVPCIPv4Blocks(t *testing.T) {
+	associated := &types.VpcCidrBlockState{State: types.VpcCidrBlockStateCodeAssociated}
+	vpc := types.Vpc{
+		CidrBlock: aws.String("10.0.0.0/16"),
+		CidrBlockAssociationSet: []types.VpcCidrBlockAssociation{
+			{CidrBlock: aws.String("10.0.0.0/16"), CidrBlockState: associated},
+			{CidrBlock: aws.String("100.64.0.0/16"), CidrBlockState: associated},
+			{CidrBlock: aws.String("10.1.0.0/16"), CidrBlockState: &types.VpcCidrBlockState{State: types.VpcCidrBlockStateCodeDisassociated}},
+			{CidrBlock: aws.String("10.100.0.0/16"), CidrBlockState: associated},
+		},
+	}
+
+	blocks := associatedVPCIPv4Blocks(vpc)
+	if len(blocks) != 3 || blocks[0] != "10.0.0.0/16" || blocks[1] != "100.64.0.0/16" || blocks[2] != "10.100.0.0/16" {
+		t.Errorf("Expected the primary and both secondary blocks, got %v", blocks)
+	}
+}
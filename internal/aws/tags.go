@@ -0,0 +1,101 @@
+package aws
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+// EC2 and IAM tag limits
+const (
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+	maxUserTags       = 40 // Leaves room for mole's own tags under the 50-tag limit
+)
+
+// ParseTags parses repeated key=value flags into a tag map
+func ParseTags(pairs []string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid tag %q: expected key=value", pair)
+		}
+		tags[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	if err := ValidateTags(tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// ValidateTags checks user tags against the EC2/IAM limits and reserved prefixes
+func ValidateTags(tags map[string]string) error {
+	if len(tags) > maxUserTags {
+		return fmt.Errorf("too many tags: %d (maximum %d)", len(tags), maxUserTags)
+	}
+	for key, value := range tags {
+		switch {
+		case key == "":
+			return fmt.Errorf("tag key must not be empty")
+		case len(key) > maxTagKeyLength:
+			return fmt.Errorf("tag key %q is longer than %d characters", key, maxTagKeyLength)
+		case len(value) > maxTagValueLength:
+			return fmt.Errorf("value of tag %q is longer than %d characters", key, maxTagValueLength)
+		case strings.HasPrefix(strings.ToLower(key), "aws:"):
+			return fmt.Errorf("tag key %q uses the reserved aws: prefix", key)
+		}
+	}
+	return nil
+}
+
+// withUserTags appends user tags to mole's fixed tags. Fixed tags win on conflict because
+// teardown and discovery rely on them; user tags are sorted for stable output.
+func withUserTags(fixed []types.Tag, user map[string]string) []types.Tag {
+	present := make(map[string]bool)
+	tags := append([]types.Tag{}, fixed...)
+	for _, tag := range fixed {
+		present[aws.ToString(tag.Key)] = true
+	}
+
+	for _, key := range sortedTagKeys(user) {
+		if present[key] {
+			continue
+		}
+		tags = append(tags, types.Tag{Key: aws.String(key), Value: aws.String(user[key])})
+	}
+	return tags
+}
+
+// tagSpecifications tags each resource type with the same fixed and user tags
+func tagSpecifications(fixed []types.Tag, user map[string]string, resourceTypes ...types.ResourceType) []types.TagSpecification {
+	tags := withUserTags(fixed, user)
+	var specs []types.TagSpecification
+	for _, resourceType := range resourceTypes {
+		specs = append(specs, types.TagSpecification{ResourceType: resourceType, Tags: tags})
+	}
+	return specs
+}
+
+// iamTags converts fixed and user tags for IAM roles
+func iamTags(fixed []types.Tag, user map[string]string) []iamtypes.Tag {
+	var tags []iamtypes.Tag
+	for _, tag := range withUserTags(fixed, user) {
+		tags = append(tags, iamtypes.Tag{Key: tag.Key, Value: tag.Value})
+	}
+	return tags
+}
+
+// sortedTagKeys returns tag keys in a stable order for API calls and exports
+func sortedTagKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
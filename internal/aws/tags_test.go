@@ -0,0 +1,93 @@
+package aws
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestParseTags(t *testing.T) {
+	tags, err := ParseTags([]string{"Project=genomics", "PI=Dr. Smith", "GrantID=NSF-123=A"})
+	if err != nil {
+		t.Fatalf("ParseTags failed: %v", err)
+	}
+	if tags["PI"] != "Dr. Smith" {
+		t.Errorf("Expected PI=Dr. Smith, got %q", tags["PI"])
+	}
+	if tags["GrantID"] != "NSF-123=A" {
+		t.Errorf("Value should keep everything after the first '=', got %q", tags["GrantID"])
+	}
+
+	for _, invalid := range []string{"novalue", "=empty-key", "aws:reserved=x"} {
+		if _, err := ParseTags([]string{invalid}); err == nil {
+			t.Errorf("Expected error for tag %q", invalid)
+		}
+	}
+}
+
+func TestValidateTagsLimits(t *testing.T) {
+	if err := ValidateTags(map[string]string{strings.Repeat("k", 129): "v"}); err == nil {
+		t.Error("Expected error for over-long key")
+	}
+	if err := ValidateTags(map[string]string{"k": strings.Repeat("v", 257)}); err == nil {
+		t.Error("Expected error for over-long value")
+	}
+}
+
+func TestWithUserTags(t *testing.T) {
+	fixed := []types.Tag{
+		{Key: aws.String("Name"), Value: aws.String("mole-bastion")},
+		{Key: aws.String("CreatedBy"), Value: aws.String("aws-cloud-mole")},
+	}
+	user := map[string]string{"Project": "genomics", "CreatedBy": "someone-else", "GrantID": "NSF-123"}
+
+	tags := withUserTags(fixed, user)
+	if len(tags) != 4 {
+		t.Fatalf("Expected 4 tags, got %d", len(tags))
+	}
+	if tagValue(tags, "CreatedBy") != "aws-cloud-mole" {
+		t.Error("Fixed tags must not be overridden; teardown depends on them")
+	}
+
+	// User tags are appended in key order
+	if aws.ToString(tags[2].Key) != "GrantID" || aws.ToString(tags[3].Key) != "Project" {
+		t.Errorf("User tags not sorted: %s, %s", aws.ToString(tags[2].Key), aws.ToString(tags[3].Key))
+	}
+
+	specs := tagSpecifications(fixed, user, types.ResourceTypeInstance, types.ResourceTypeVolume)
+	if len(specs) != 2 || specs[1].ResourceType != types.ResourceTypeVolume {
+		t.Errorf("Unexpected tag specifications: %+v", specs)
+	}
+}
+
+func TestExportsIncludeUserTags(t *testing.T) {
+	client := &AWSClient{}
+	config := &DeploymentConfig{
+		VPCId:          "vpc-12345",
+		PublicSubnetId: "subnet-12345",
+		InstanceType:   "c6gn.medium",
+		TunnelCount:    1,
+		Tags:           map[string]string{"PI": "Smith", "GrantID": "yes"},
+	}
+
+	terraform, err := client.ExportTerraform(config)
+	if err != nil {
+		t.Fatalf("Terraform export failed: %v", err)
+	}
+	if !strings.Contains(terraform, "default_tags") || !strings.Contains(terraform, `"PI" = "Smith"`) {
+		t.Error("Terraform export missing default_tags with user tags")
+	}
+
+	cloudFormation, err := client.ExportCloudFormation(config)
+	if err != nil {
+		t.Fatalf("CloudFormation export failed: %v", err)
+	}
+	if !strings.Contains(cloudFormation, "Value: 'yes'") {
+		t.Error("CloudFormation export should quote tag values")
+	}
+	if !strings.Contains(cloudFormation, "ResourceType: volume") {
+		t.Error("CloudFormation launch template should tag volumes")
+	}
+}
@@ -0,0 +1,200 @@
+#!/bin/bash
+set -euo pipefail
+
+# Pre-calculated values, no API calls, minimal operations
+PRIVATE_SUBNET_CIDRS="10.0.2.0/24 10.0.3.0/24"
+ROUTED_CIDRS="172.31.0.0/16"
+TUNNEL_NETWORKS="10.100.1.0/24 10.100.2.0/24"
+REGION="us-west-2"
+
+# Install only essentials - skip updates for speed (pre-built mole images already have them)
+if ! command -v wg >/dev/null 2>&1; then
+    dnf install -y wireguard-tools --skip-broken
+fi
+
+# Enable IP forwarding
+echo 'net.ipv4.ip_forward=1' >> /etc/sysctl.conf
+sysctl -p
+
+# Enable IPv6 forwarding (dual-stack tunnel)
+echo 'net.ipv6.conf.all.forwarding=1' >> /etc/sysctl.conf
+sysctl -p
+
+mkdir -p /etc/mole/keys /etc/wireguard
+PRIMARY_INTERFACE=$(ip route show default | awk '{print $5; exit}')
+
+# Kernel tuning from the optimization config
+modprobe tcp_bbr 2>/dev/null || true
+cat > /etc/sysctl.d/90-mole-tuning.conf << EOF
+net.core.rmem_max = 134217728
+net.core.wmem_max = 134217728
+net.ipv4.tcp_rmem = 4096 87380 134217728
+net.ipv4.tcp_wmem = 4096 65536 134217728
+net.core.netdev_max_backlog = 5000
+net.ipv4.tcp_window_scaling = 1
+net.core.default_qdisc = fq
+net.ipv4.tcp_congestion_control = bbr
+net.core.rps_sock_flow_entries = 32768
+EOF
+sysctl -p /etc/sysctl.d/90-mole-tuning.conf || echo "some tunables were rejected; see 'mole status'" >&2
+
+CPUS=$(nproc)
+RX_QUEUES=0
+IRQ_QUEUES=0
+
+# Spread the ENA queues: RPS/XPS masks (first 32 CPUs) and one CPU per queue IRQ
+QUEUE_DIR=/sys/class/net/$PRIMARY_INTERFACE/queues
+CPU_MASK=$(printf '%x' $(( (1 << (CPUS < 32 ? CPUS : 32)) - 1 )))
+RX_QUEUE_COUNT=$(find $QUEUE_DIR -maxdepth 1 -name 'rx-*' 2>/dev/null | wc -l || true)
+if [ "$RX_QUEUE_COUNT" -gt 0 ]; then
+  for queue in $QUEUE_DIR/rx-*; do
+    echo $CPU_MASK > $queue/rps_cpus || true
+    echo $(( 32768 / RX_QUEUE_COUNT )) > $queue/rps_flow_cnt || true
+    RX_QUEUES=$((RX_QUEUES + 1))
+  done
+  TX_QUEUE=0
+  for queue in $QUEUE_DIR/tx-*; do
+    printf '%x' $(( 1 << (TX_QUEUE % CPUS % 32) )) > $queue/xps_cpus || true
+    TX_QUEUE=$((TX_QUEUE + 1))
+  done
+fi
+systemctl disable --now irqbalance 2>/dev/null || true
+for irq in $(grep -E "$PRIMARY_INTERFACE-Tx-Rx-[0-9]+" /proc/interrupts | cut -d: -f1 || true); do
+  echo $((IRQ_QUEUES % CPUS)) > /proc/irq/$irq/smp_affinity_list || true
+  IRQ_QUEUES=$((IRQ_QUEUES + 1))
+done
+
+for governor in /sys/devices/system/cpu/cpu*/cpufreq/scaling_governor; do
+  if [ -w "$governor" ]; then
+    echo performance > "$governor"
+  fi
+done
+
+# Record what was requested; the agent compares it with the live values for 'mole status'
+cat > /etc/mole/tuning.json << EOF
+{
+  "sysctls": {
+    "net.core.rmem_max": "134217728",
+    "net.core.wmem_max": "134217728",
+    "net.ipv4.tcp_rmem": "4096 87380 134217728",
+    "net.ipv4.tcp_wmem": "4096 65536 134217728",
+    "net.core.netdev_max_backlog": "5000",
+    "net.ipv4.tcp_window_scaling": "1",
+    "net.core.default_qdisc": "fq",
+    "net.ipv4.tcp_congestion_control": "bbr",
+    "net.core.rps_sock_flow_entries": "32768"
+  },
+  "cpu_governor": "performance",
+  "spread_queues": true,
+  "cpus": $CPUS,
+  "rx_queues": $RX_QUEUES,
+  "irq_queues": $IRQ_QUEUES
+}
+EOF
+
+# Tunnel 0: wg0 on port 51820
+wg genkey | tee /etc/mole/keys/wg0_private.key | wg pubkey > /etc/mole/keys/wg0_public.key
+chmod 600 /etc/mole/keys/wg0_private.key
+
+cat > /etc/wireguard/wg0.conf << EOF
+[Interface]
+PrivateKey = $(cat /etc/mole/keys/wg0_private.key)
+Address = 10.100.1.1/24, fd6d:6f6c:6500:1::1/64
+ListenPort = 51820
+MTU = 1420
+
+[Peer]
+PublicKey = Y2xpZW50LWtleS0wAAAAAAAAAAAAAAAAAAAAAAAAAAA=
+AllowedIPs = 10.100.1.2/32, fd6d:6f6c:6500:1::2/128
+EOF
+
+wg-quick up wg0
+iptables -A INPUT -p udp --dport 51820 -j ACCEPT
+iptables -A FORWARD -i wg0 -j ACCEPT
+iptables -A FORWARD -o wg0 -j ACCEPT
+ip6tables -A INPUT -p udp --dport 51820 -j ACCEPT
+ip6tables -A FORWARD -i wg0 -j ACCEPT
+ip6tables -A FORWARD -o wg0 -j ACCEPT
+
+# Tunnel 1: wg1 on port 51821
+wg genkey | tee /etc/mole/keys/wg1_private.key | wg pubkey > /etc/mole/keys/wg1_public.key
+chmod 600 /etc/mole/keys/wg1_private.key
+
+cat > /etc/wireguard/wg1.conf << EOF
+[Interface]
+PrivateKey = $(cat /etc/mole/keys/wg1_private.key)
+Address = 10.100.2.1/24, fd6d:6f6c:6500:2::1/64
+ListenPort = 51821
+MTU = 1420
+
+[Peer]
+PublicKey = Y2xpZW50LWtleS0xAAAAAAAAAAAAAAAAAAAAAAAAAAA=
+AllowedIPs = 10.100.2.2/32, fd6d:6f6c:6500:2::2/128
+EOF
+
+wg-quick up wg1
+iptables -A INPUT -p udp --dport 51821 -j ACCEPT
+iptables -A FORWARD -i wg1 -j ACCEPT
+iptables -A FORWARD -o wg1 -j ACCEPT
+ip6tables -A INPUT -p udp --dport 51821 -j ACCEPT
+ip6tables -A FORWARD -i wg1 -j ACCEPT
+ip6tables -A FORWARD -o wg1 -j ACCEPT
+
+# NAT for private subnets (minimal)
+for cidr in $PRIVATE_SUBNET_CIDRS; do
+  iptables -t nat -A POSTROUTING -s $cidr -j MASQUERADE
+done
+
+# Peered VPCs / Transit Gateway cannot route the tunnel networks back, so NAT towards them
+for cidr in $ROUTED_CIDRS; do
+  for tunnel in $TUNNEL_NETWORKS; do
+    iptables -t nat -A POSTROUTING -s $tunnel -d $cidr -j MASQUERADE
+  done
+done
+
+# Get instance ID and tag with server public keys (fast)
+TOKEN=$(curl -X PUT "http://169.254.169.254/latest/api/token" -H "X-aws-ec2-metadata-token-ttl-seconds: 21600")
+INSTANCE_ID=$(curl -H "X-aws-ec2-metadata-token: $TOKEN" http://169.254.169.254/latest/meta-data/instance-id)
+
+# Disable source/dest check and tag
+aws ec2 modify-instance-attribute --instance-id $INSTANCE_ID --no-source-dest-check --region $REGION &
+aws ec2 create-tags --resources $INSTANCE_ID --tags Key=WireGuardPublicKey,Value="$(cat /etc/mole/keys/wg0_public.key)" Key=WireGuardPublicKey1,Value="$(cat /etc/mole/keys/wg1_public.key)" --region $REGION &
+
+# mole agent: control API reachable only over the tunnel
+ARCH=$(uname -m | sed 's/x86_64/amd64/; s/aarch64/arm64/')
+case $ARCH in
+  amd64) AGENT_SHA256=aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa ;;
+  arm64) AGENT_SHA256=bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb ;;
+  *) AGENT_SHA256= ;;
+esac
+install -m 600 /dev/null /etc/mole/agent.token
+# The archive is only unpacked once it matches the release checksum
+if aws ssm get-parameter --region $REGION --with-decryption --name /mole/agent/0123456789abcdef --query Parameter.Value --output text > /etc/mole/agent.token &&
+  [ -n "$AGENT_SHA256" ] &&
+  curl -fsSL -o /tmp/mole-agent.tar.gz "https://example.com/mole_linux_${ARCH}.tar.gz" &&
+  echo "$AGENT_SHA256  /tmp/mole-agent.tar.gz" | sha256sum -c --quiet - &&
+  tar -xzf /tmp/mole-agent.tar.gz -C /usr/local/bin mole; then
+  cat > /etc/systemd/system/mole-agent.service << EOF
+[Unit]
+Description=mole bastion agent
+After=network-online.target
+
+[Service]
+ExecStart=/usr/local/bin/mole agent --listen 10.100.1.1:7800 --token-file /etc/mole/agent.token --instance-id $INSTANCE_ID --region $REGION
+Restart=always
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+EOF
+  iptables -A INPUT -p tcp --dport 7800 ! -i wg+ -j DROP
+  systemctl daemon-reload
+  systemctl enable --now mole-agent
+else
+  echo "mole agent token, download or checksum failed; continuing without it" >&2
+fi
+rm -f /tmp/mole-agent.tar.gz
+aws ssm delete-parameter --region $REGION --name /mole/agent/0123456789abcdef || echo "failed to delete /mole/agent/0123456789abcdef; mole down removes it" >&2
+
+# Signal ready - fast boot complete
+echo "ready" > /etc/mole/status
@@ -0,0 +1,72 @@
+#!/bin/bash
+set -euo pipefail
+
+# Pre-calculated values, no API calls, minimal operations
+PRIVATE_SUBNET_CIDRS="10.0.2.0/24"
+ROUTED_CIDRS=""
+TUNNEL_NETWORKS="10.100.1.0/24"
+REGION="us-west-2"
+
+# Install only essentials - skip updates for speed (pre-built mole images already have them)
+if ! command -v wg >/dev/null 2>&1; then
+    dnf install -y wireguard-tools --skip-broken
+fi
+
+# Enable IP forwarding
+echo 'net.ipv4.ip_forward=1' >> /etc/sysctl.conf
+sysctl -p
+
+mkdir -p /etc/mole/keys /etc/wireguard
+PRIMARY_INTERFACE=$(ip route show default | awk '{print $5; exit}')
+
+# Tunnel 0: wg0 on port 51820
+wg genkey | tee /etc/mole/keys/wg0_private.key | wg pubkey > /etc/mole/keys/wg0_public.key
+chmod 600 /etc/mole/keys/wg0_private.key
+install -m 600 /dev/null /etc/mole/keys/wg0_psk.key
+aws ssm get-parameter --region $REGION --with-decryption --name /mole/psk/0123456789abcdef/wg0 --query Parameter.Value --output text > /etc/mole/keys/wg0_psk.key
+aws ssm delete-parameter --region $REGION --name /mole/psk/0123456789abcdef/wg0 || echo "failed to delete /mole/psk/0123456789abcdef/wg0; mole down removes it" >&2
+
+cat > /etc/wireguard/wg0.conf << EOF
+[Interface]
+PrivateKey = $(cat /etc/mole/keys/wg0_private.key)
+Address = 10.100.1.1/24, fd6d:6f6c:6500:1::1/64
+ListenPort = 51820
+
+[Peer]
+PublicKey = Y2xpZW50LWtleS0wAAAAAAAAAAAAAAAAAAAAAAAAAAA=
+PresharedKey = $(cat /etc/mole/keys/wg0_psk.key)
+AllowedIPs = 10.100.1.2/32, fd6d:6f6c:6500:1::2/128
+EOF
+
+wg-quick up wg0
+iptables -A INPUT -p udp --dport 51820 -j ACCEPT
+iptables -A FORWARD -i wg0 -j ACCEPT
+iptables -A FORWARD -o wg0 -j ACCEPT
+ip6tables -A INPUT -p udp --dport 51820 -j ACCEPT
+ip6tables -A FORWARD -i wg0 -j ACCEPT
+ip6tables -A FORWARD -o wg0 -j ACCEPT
+
+# NAT for private subnets (minimal)
+for cidr in $PRIVATE_SUBNET_CIDRS; do
+  iptables -t nat -A POSTROUTING -s $cidr -j MASQUERADE
+done
+
+# Peered VPCs / Transit Gateway cannot route the tunnel networks back, so NAT towards them
+for cidr in $ROUTED_CIDRS; do
+  for tunnel in $TUNNEL_NETWORKS; do
+    iptables -t nat -A POSTROUTING -s $tunnel -d $cidr -j MASQUERADE
+  done
+done
+
+# Get instance ID and tag with server public keys (fast)
+TOKEN=$(curl -X PUT "http://169.254.169.254/latest/api/token" -H "X-aws-ec2-metadata-token-ttl-seconds: 21600")
+INSTANCE_ID=$(curl -H "X-aws-ec2-metadata-token: $TOKEN" http://169.254.169.254/latest/meta-data/instance-id)
+
+# Disable source/dest check and tag
+aws ec2 modify-instance-attribute --instance-id $INSTANCE_ID --no-source-dest-check --region $REGION &
+aws ec2 create-tags --resources $INSTANCE_ID --tags Key=WireGuardPublicKey,Value="$(cat /etc/mole/keys/wg0_public.key)" --region $REGION &
+
+# mole agent not installed (no release download or agent token)
+
+# Signal ready - fast boot complete
+echo "ready" > /etc/mole/status
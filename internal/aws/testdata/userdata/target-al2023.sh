@@ -0,0 +1,52 @@
+#!/bin/bash
+set -euo pipefail
+
+# Skip system updates for speed - install only essentials
+dnf install -y net-tools python3 --skip-broken
+
+# Enable ICMP (ping) responses
+echo 'net.ipv4.icmp_echo_ignore_all = 0' >> /etc/sysctl.conf
+sysctl -p
+
+# Create lightweight test server - starts immediately
+cat > /home/ec2-user/test-server.py << 'EOF'
+#!/usr/bin/env python3
+import http.server
+import socketserver
+import socket
+
+class TestHandler(http.server.SimpleHTTPRequestHandler):
+    def do_GET(self):
+        self.send_response(200)
+        self.send_header('Content-type', 'text/html')
+        self.end_headers()
+
+        hostname = socket.gethostname()
+        try:
+            local_ip = socket.gethostbyname(hostname)
+        except:
+            local_ip = "unknown"
+
+        response = f"""<html><body>
+<h1>AWS Cloud Mole Test Target</h1>
+<p><strong>Hostname:</strong> {hostname}</p>
+<p><strong>Private IP:</strong> {local_ip}</p>
+<p><strong>Status:</strong> ✅ NAT Bridge Working!</p>
+<p>Successfully reached test target through WireGuard tunnel.</p>
+</body></html>"""
+        self.wfile.write(response.encode())
+
+PORT = 8080
+with socketserver.TCPServer(("", PORT), TestHandler) as httpd:
+    print(f"Test server running on port {PORT}")
+    httpd.serve_forever()
+EOF
+
+chown ec2-user:ec2-user /home/ec2-user/test-server.py
+chmod +x /home/ec2-user/test-server.py
+
+# Start test server immediately in background (no systemd delay)
+nohup python3 /home/ec2-user/test-server.py > /home/ec2-user/server.log 2>&1 &
+echo $! > /home/ec2-user/server.pid
+
+echo "test-target-ready" > /home/ec2-user/status
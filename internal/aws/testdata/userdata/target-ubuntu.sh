@@ -0,0 +1,52 @@
+#!/bin/bash
+set -euo pipefail
+
+# Skip system updates for speed - install only essentials
+apt-get update -y && DEBIAN_FRONTEND=noninteractive apt-get install -y net-tools python3
+
+# Enable ICMP (ping) responses
+echo 'net.ipv4.icmp_echo_ignore_all = 0' >> /etc/sysctl.conf
+sysctl -p
+
+# Create lightweight test server - starts immediately
+cat > /home/ubuntu/test-server.py << 'EOF'
+#!/usr/bin/env python3
+import http.server
+import socketserver
+import socket
+
+class TestHandler(http.server.SimpleHTTPRequestHandler):
+    def do_GET(self):
+        self.send_response(200)
+        self.send_header('Content-type', 'text/html')
+        self.end_headers()
+
+        hostname = socket.gethostname()
+        try:
+            local_ip = socket.gethostbyname(hostname)
+        except:
+            local_ip = "unknown"
+
+        response = f"""<html><body>
+<h1>AWS Cloud Mole Test Target</h1>
+<p><strong>Hostname:</strong> {hostname}</p>
+<p><strong>Private IP:</strong> {local_ip}</p>
+<p><strong>Status:</strong> ✅ NAT Bridge Working!</p>
+<p>Successfully reached test target through WireGuard tunnel.</p>
+</body></html>"""
+        self.wfile.write(response.encode())
+
+PORT = 8080
+with socketserver.TCPServer(("", PORT), TestHandler) as httpd:
+    print(f"Test server running on port {PORT}")
+    httpd.serve_forever()
+EOF
+
+chown ubuntu:ubuntu /home/ubuntu/test-server.py
+chmod +x /home/ubuntu/test-server.py
+
+# Start test server immediately in background (no systemd delay)
+nohup python3 /home/ubuntu/test-server.py > /home/ubuntu/server.log 2>&1 &
+echo $! > /home/ubuntu/server.pid
+
+echo "test-target-ready" > /home/ubuntu/status
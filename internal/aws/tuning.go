@@ -0,0 +1,84 @@
+package aws
+
+import (
+	"strconv"
+
+	"github.com/research-computing/mole/internal/config"
+)
+
+// rpsFlowEntries sizes the RPS flow tables (global and per receive queue)
+const rpsFlowEntries = 32768
+
+// KernelTuning is the bastion's network stack tuning, rendered into the user data
+type KernelTuning struct {
+	Sysctls      []Sysctl // Written to /etc/sysctl.d/90-mole-tuning.conf in this order
+	SpreadQueues bool     // RPS/XPS on every ENA queue and IRQ affinity across CPUs
+	CPUGovernor  string   // Applied where the instance exposes cpufreq; empty leaves it alone
+}
+
+// Sysctl is a single kernel parameter
+type Sysctl struct {
+	Key   string
+	Value string
+}
+
+// KernelTuningFromConfig derives the bastion tuning from the loaded optimization config
+func KernelTuningFromConfig(opt config.OptimizationConfig) *KernelTuning {
+	tuning := &KernelTuning{SpreadQueues: opt.MultiQueueNIC, CPUGovernor: opt.CPUGovernor}
+
+	add := func(key, value string) {
+		tuning.Sysctls = append(tuning.Sysctls, Sysctl{Key: key, Value: value})
+	}
+
+	buffers := opt.BufferSizes
+	if buffers.RmemMax > 0 {
+		add("net.core.rmem_max", strconv.FormatInt(buffers.RmemMax, 10))
+	}
+	if buffers.WmemMax > 0 {
+		add("net.core.wmem_max", strconv.FormatInt(buffers.WmemMax, 10))
+	}
+	if buffers.TCPRmem != "" {
+		add("net.ipv4.tcp_rmem", buffers.TCPRmem)
+	}
+	if buffers.TCPWmem != "" {
+		add("net.ipv4.tcp_wmem", buffers.TCPWmem)
+	}
+	if buffers.NetdevMaxBacklog > 0 {
+		add("net.core.netdev_max_backlog", strconv.Itoa(buffers.NetdevMaxBacklog))
+	}
+
+	if opt.TCPWindowScaling {
+		add("net.ipv4.tcp_window_scaling", "1")
+	} else {
+		add("net.ipv4.tcp_window_scaling", "0")
+	}
+
+	if opt.TCPCongestion != "" {
+		// BBR paces packets and relies on the fq qdisc
+		if opt.TCPCongestion == "bbr" {
+			add("net.core.default_qdisc", "fq")
+		}
+		add("net.ipv4.tcp_congestion_control", opt.TCPCongestion)
+	}
+
+	if opt.MultiQueueNIC {
+		add("net.core.rps_sock_flow_entries", strconv.Itoa(rpsFlowEntries))
+	}
+
+	return tuning
+}
+
+// CongestionControl returns the configured congestion control module, if any
+func (k *KernelTuning) CongestionControl() string {
+	for _, sysctl := range k.Sysctls {
+		if sysctl.Key == "net.ipv4.tcp_congestion_control" {
+			return sysctl.Value
+		}
+	}
+	return ""
+}
+
+// RPSFlowEntries is the per-queue RPS flow count used by the template
+func (k *KernelTuning) RPSFlowEntries() int {
+	return rpsFlowEntries
+}
@@ -0,0 +1,50 @@
+package aws
+
+import (
+	"testing"
+
+	"github.com/research-computing/mole/internal/config"
+)
+
+func TestKernelTuningFromConfig(t *testing.T) {
+	tuning := KernelTuningFromConfig(config.DefaultOptimization())
+
+	values := make(map[string]string)
+	for _, sysctl := range tuning.Sysctls {
+		values[sysctl.Key] = sysctl.Value
+	}
+	expected := map[string]string{
+		"net.core.rmem_max":               "134217728",
+		"net.core.wmem_max":               "134217728",
+		"net.ipv4.tcp_rmem":               "4096 87380 134217728",
+		"net.core.netdev_max_backlog":     "5000",
+		"net.ipv4.tcp_window_scaling":     "1",
+		"net.core.default_qdisc":          "fq",
+		"net.ipv4.tcp_congestion_control": "bbr",
+		"net.core.rps_sock_flow_entries":  "32768",
+	}
+	for key, value := range expected {
+		if values[key] != value {
+			t.Errorf("%s = %q, expected %q", key, values[key], value)
+		}
+	}
+	if !tuning.SpreadQueues || tuning.CongestionControl() != "bbr" {
+		t.Errorf("Unexpected tuning: %+v", tuning)
+	}
+
+	// cubic needs no fq qdisc, and a single-queue setup skips RPS
+	opt := config.DefaultOptimization()
+	opt.TCPCongestion = "cubic"
+	opt.MultiQueueNIC = false
+	opt.BufferSizes = config.BufferSizes{}
+	tuning = KernelTuningFromConfig(opt)
+	for _, sysctl := range tuning.Sysctls {
+		switch sysctl.Key {
+		case "net.core.default_qdisc", "net.core.rps_sock_flow_entries", "net.core.rmem_max":
+			t.Errorf("Unexpected sysctl %s", sysctl.Key)
+		}
+	}
+	if tuning.SpreadQueues {
+		t.Error("multi_queue_nic=false should not spread queues")
+	}
+}
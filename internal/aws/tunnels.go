@@ -0,0 +1,169 @@
+package aws
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// Tunnel address plan shared by the bastion and the client. Tunnel N is wgN on both ends,
+// listens on tunnelBasePort+N on the bastion and uses 10.100.(N+1).0/24 and the (N+1)th /64
+// of tunnel.DefaultIPv6CIDR, with the bastion on .1/::1 and the client on .2/::2. The client
+// may allocate other IPv4 networks from its tunnel.base_cidr (DeploymentConfig.TunnelNetworks).
+const (
+	tunnelBasePort = 51820
+	MaxTunnelCount = 8 // Keeps the security group under the 60-rule limit when dual-stack
+)
+
+// TunnelSpec describes one bastion/client WireGuard tunnel
+type TunnelSpec struct {
+	ID               int
+	Interface        string // wg<ID> on both ends
+	Port             int    // Bastion listen port
+	Network          string // IPv4 tunnel network
+	ServerAddress    string // Bastion address with prefix length
+	ClientAddress    string // Client address with prefix length
+	IPv6Network      string // Empty unless dual-stack
+	ServerIPv6       string
+	ClientIPv6       string
+	ClientPrivateKey string
+	ClientPublicKey  string
+	ServerPublicKey  string // Read back from the bastion's tags after boot
+	PresharedKey     string // Optional; delivered to the bastion through SSM, never user data
+}
+
+// planTunnels returns the address plan for count tunnels, without keys
+func planTunnels(count int, dualStack bool) []TunnelSpec {
+	tunnels := make([]TunnelSpec, count)
+	for id := range tunnels {
+		subnet := id + 1
+		tunnels[id] = TunnelSpec{
+			ID:            id,
+			Interface:     fmt.Sprintf("wg%d", id),
+			Port:          tunnelBasePort + id,
+			Network:       tunnelIPv4Subnet(id),
+			ServerAddress: fmt.Sprintf("10.100.%d.1/24", subnet),
+			ClientAddress: fmt.Sprintf("10.100.%d.2/24", subnet),
+		}
+		if dualStack {
+			tunnels[id].IPv6Network = tunnelIPv6Subnet(id)
+			tunnels[id].ServerIPv6 = fmt.Sprintf("fd6d:6f6c:6500:%x::1/64", subnet)
+			tunnels[id].ClientIPv6 = fmt.Sprintf("fd6d:6f6c:6500:%x::2/64", subnet)
+		}
+	}
+	return tunnels
+}
+
+// tunnelIPv4Subnet returns the IPv4 network of a tunnel
+func tunnelIPv4Subnet(id int) string {
+	return fmt.Sprintf("10.100.%d.0/24", id+1)
+}
+
+// tunnelIPv6Subnet returns the ULA /64 of a tunnel
+func tunnelIPv6Subnet(id int) string {
+	return fmt.Sprintf("fd6d:6f6c:6500:%x::/64", id+1)
+}
+
+// tunnelCount returns the number of tunnels to deploy, at least one
+func tunnelCount(config *DeploymentConfig) int {
+	if config.TunnelCount < 1 {
+		return 1
+	}
+	return config.TunnelCount
+}
+
+// planDeployment returns the address plan for a deployment, with the IPv4 networks the
+// client allocated (TunnelNetworks) in place of the default ones
+func planDeployment(config *DeploymentConfig) ([]TunnelSpec, error) {
+	tunnels := planTunnels(tunnelCount(config), config.EnableIPv6)
+	if len(config.TunnelNetworks) == 0 {
+		return tunnels, nil
+	}
+	if len(config.TunnelNetworks) != len(tunnels) {
+		return nil, fmt.Errorf("%d tunnel networks given for %d tunnels", len(config.TunnelNetworks), len(tunnels))
+	}
+	for id, network := range config.TunnelNetworks {
+		spec, err := withTunnelNetwork(tunnels[id], network)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range tunnels[:id] {
+			if netip.MustParsePrefix(other.Network).Overlaps(netip.MustParsePrefix(spec.Network)) {
+				return nil, fmt.Errorf("tunnel networks %s and %s overlap", other.Network, spec.Network)
+			}
+		}
+		tunnels[id] = spec
+	}
+	return tunnels, nil
+}
+
+// withTunnelNetwork moves a tunnel's IPv4 addresses into network, keeping the bastion on
+// the first host and the client on the second
+func withTunnelNetwork(spec TunnelSpec, network string) (TunnelSpec, error) {
+	prefix, err := netip.ParsePrefix(network)
+	if err != nil {
+		return spec, fmt.Errorf("invalid tunnel network %q: %w", network, err)
+	}
+	if !prefix.Addr().Is4() || prefix.Bits() > 30 {
+		return spec, fmt.Errorf("tunnel network %s must be an IPv4 prefix of /30 or shorter", network)
+	}
+	prefix = prefix.Masked()
+	spec.Network = prefix.String()
+	spec.ServerAddress = netip.PrefixFrom(prefix.Addr().Next(), prefix.Bits()).String()
+	spec.ClientAddress = netip.PrefixFrom(prefix.Addr().Next().Next(), prefix.Bits()).String()
+	return spec, nil
+}
+
+// deploymentTunnels returns the keyed tunnels for a deployment. Configs built without
+// DirectDeploy fall back to a plan whose first tunnel uses ClientPublicKey; DirectDeploy
+// has already rejected bad TunnelNetworks, so those fall back to the default plan.
+func deploymentTunnels(config *DeploymentConfig) []TunnelSpec {
+	if len(config.Tunnels) > 0 {
+		return config.Tunnels
+	}
+	tunnels, err := planDeployment(config)
+	if err != nil {
+		tunnels = planTunnels(tunnelCount(config), config.EnableIPv6)
+	}
+	tunnels[0].ClientPrivateKey = config.ClientPrivateKey
+	tunnels[0].ClientPublicKey = config.ClientPublicKey
+	return tunnels
+}
+
+// tunnelNetworks returns the IPv4 networks of every tunnel
+func tunnelNetworks(tunnels []TunnelSpec) []string {
+	networks := make([]string, len(tunnels))
+	for i, spec := range tunnels {
+		networks[i] = spec.Network
+	}
+	return networks
+}
+
+// tunnelIPv6Networks returns the IPv6 networks of every dual-stack tunnel
+func tunnelIPv6Networks(tunnels []TunnelSpec) []string {
+	var networks []string
+	for _, spec := range tunnels {
+		if spec.IPv6Network != "" {
+			networks = append(networks, spec.IPv6Network)
+		}
+	}
+	return networks
+}
+
+// hostPrefix turns an interface address such as 10.100.1.2/24 into its host route (/32 or /128)
+func hostPrefix(address string) string {
+	prefix, err := netip.ParsePrefix(address)
+	if err != nil {
+		return address
+	}
+	return netip.PrefixFrom(prefix.Addr(), prefix.Addr().BitLen()).String()
+}
+
+// ServerPublicKeyTag is the instance tag the bastion publishes a tunnel's public key under,
+// and republishes it under after a server key rotation.
+// Tunnel 0 keeps the original WireGuardPublicKey tag.
+func ServerPublicKeyTag(id int) string {
+	if id == 0 {
+		return "WireGuardPublicKey"
+	}
+	return fmt.Sprintf("WireGuardPublicKey%d", id)
+}
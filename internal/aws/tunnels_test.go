@@ -0,0 +1,315 @@
+package aws
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/research-computing/mole/internal/keystore"
+)
+
+func TestPlanTunnels(t *testing.T) {
+	tunnels := planTunnels(3, true)
+	if len(tunnels) != 3 {
+		t.Fatalf("Expected 3 tunnels, got %d", len(tunnels))
+	}
+
+	last := tunnels[2]
+	if last.Interface != "wg2" || last.Port != 51822 {
+		t.Errorf("Unexpected interface/port: %s %d", last.Interface, last.Port)
+	}
+	if last.Network != "10.100.3.0/24" || last.ServerAddress != "10.100.3.1/24" || last.ClientAddress != "10.100.3.2/24" {
+		t.Errorf("Unexpected IPv4 plan: %+v", last)
+	}
+	if last.IPv6Network != "fd6d:6f6c:6500:3::/64" || last.ServerIPv6 != "fd6d:6f6c:6500:3::1/64" || last.ClientIPv6 != "fd6d:6f6c:6500:3::2/64" {
+		t.Errorf("Unexpected IPv6 plan: %+v", last)
+	}
+	if tunnels[0].IPv6Network != tunnelIPv6Network {
+		t.Errorf("Tunnel 0 should keep the original IPv6 network, got %s", tunnels[0].IPv6Network)
+	}
+
+	if planTunnels(1, false)[0].IPv6Network != "" {
+		t.Error("IPv4-only plan should not carry IPv6 addresses")
+	}
+}
+
+func TestPlanDeploymentAllocatedNetworks(t *testing.T) {
+	config := &DeploymentConfig{
+		TunnelCount:    2,
+		TunnelNetworks: []string{"172.29.4.0/30", "172.29.4.4/30"},
+		AgentURL:       "https://example.com/mole.tar.gz",
+		AgentSHA256:    map[string]string{"amd64": strings.Repeat("a", 64)},
+		AgentTokenPath: "/mole/agent/0123456789abcdef",
+	}
+	tunnels, err := planDeployment(config)
+	if err != nil {
+		t.Fatalf("planDeployment failed: %v", err)
+	}
+	second := tunnels[1]
+	if second.Network != "172.29.4.4/30" || second.ServerAddress != "172.29.4.5/30" || second.ClientAddress != "172.29.4.6/30" {
+		t.Errorf("Unexpected allocated plan: %+v", second)
+	}
+	if second.Interface != "wg1" || second.Port != 51821 {
+		t.Errorf("Allocated networks should keep interfaces and ports: %+v", second)
+	}
+
+	// The bastion and its agent follow the allocated networks
+	script, err := RenderBastionUserData(bastionUserData(config, OSAmazonLinux, nil))
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	for _, check := range []string{
+		`TUNNEL_NETWORKS="172.29.4.0/30 172.29.4.4/30"`,
+		"Address = 172.29.4.5/30\n",
+		"--listen 172.29.4.1:7800 --token-file /etc/mole/agent.token --allow 172.29.4.0/30,172.29.4.4/30,fd6d:6f6c:6500::/48 --instance-id $INSTANCE_ID --region $REGION\n",
+	} {
+		if !strings.Contains(script, check) {
+			t.Errorf("User data missing %q", check)
+		}
+	}
+	if endpoint := AgentEndpoint(config.TunnelNetworks[0]); endpoint != "http://172.29.4.1:7800" {
+		t.Errorf("AgentEndpoint = %s", endpoint)
+	}
+
+	invalid := [][]string{
+		{"172.29.4.0/30"},                     // One network for two tunnels
+		{"172.29.4.0/30", "172.29.4.0/29"},    // Overlapping
+		{"172.29.4.0/30", "172.29.4.4/31"},    // No room for both peers
+		{"172.29.4.0/30", "fd00::/64"},        // IPv6
+		{"172.29.4.0/30", "not-a-network/30"}, // Unparseable
+	}
+	for _, networks := range invalid {
+		config.TunnelNetworks = networks
+		if _, err := planDeployment(config); err == nil {
+			t.Errorf("Expected tunnel networks %v to be rejected", networks)
+		}
+	}
+}
+
+func TestGenerateUserDataMultipleTunnels(t *testing.T) {
+	tunnels := planTunnels(MaxTunnelCount, false)
+	for i := range tunnels {
+		tunnels[i].ClientPublicKey = fmt.Sprintf("client-key-%d", i)
+	}
+	config := &DeploymentConfig{
+		TunnelCount:        MaxTunnelCount,
+		Region:             "us-west-2",
+		PrivateSubnetCIDRs: []string{"10.0.2.0/24"},
+		Tunnels:            tunnels,
+	}
+
+	client := &AWSClient{}
+	encoded, err := client.generateUserData(context.Background(), config)
+	if err != nil {
+		t.Fatalf("Failed to render user data: %v", err)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("User data is not valid base64: %v", err)
+	}
+	script := string(decoded)
+
+	for i := 0; i < MaxTunnelCount; i++ {
+		checks := []string{
+			fmt.Sprintf("cat > /etc/wireguard/wg%d.conf", i),
+			fmt.Sprintf("Address = 10.100.%d.1/24\nListenPort = %d\n", i+1, 51820+i),
+			fmt.Sprintf("PublicKey = client-key-%d\nAllowedIPs = 10.100.%d.2/32\n", i, i+1),
+			fmt.Sprintf("wg-quick up wg%d\n", i),
+			fmt.Sprintf("iptables -A INPUT -p udp --dport %d -j ACCEPT", 51820+i),
+			fmt.Sprintf(`Key=%s,Value="$(cat /etc/mole/keys/wg%d_public.key)"`, ServerPublicKeyTag(i), i),
+		}
+		for _, check := range checks {
+			if !strings.Contains(script, check) {
+				t.Errorf("User data missing %q", check)
+			}
+		}
+	}
+
+	if strings.Count(script, "[Interface]") != MaxTunnelCount {
+		t.Errorf("Expected %d interfaces, got %d", MaxTunnelCount, strings.Count(script, "[Interface]"))
+	}
+	if !strings.Contains(script, `TUNNEL_NETWORKS="10.100.1.0/24 10.100.2.0/24`) {
+		t.Error("User data should NAT every tunnel network")
+	}
+}
+
+func TestGenerateUserDataDualStackTunnels(t *testing.T) {
+	tunnels := planTunnels(2, true)
+	tunnels[1].ClientPublicKey = "client-key-1"
+	config := &DeploymentConfig{
+		TunnelCount: 2,
+		EnableIPv6:  true,
+		Region:      "us-west-2",
+		Tunnels:     tunnels,
+	}
+
+	client := &AWSClient{}
+	encoded, err := client.generateUserData(context.Background(), config)
+	if err != nil {
+		t.Fatalf("Failed to render user data: %v", err)
+	}
+	decoded, _ := base64.StdEncoding.DecodeString(encoded)
+	script := string(decoded)
+
+	for _, check := range []string{
+		"Address = 10.100.2.1/24, fd6d:6f6c:6500:2::1/64\n",
+		"AllowedIPs = 10.100.2.2/32, fd6d:6f6c:6500:2::2/128\n",
+		"ip6tables -A INPUT -p udp --dport 51821 -j ACCEPT",
+		"ip6tables -A FORWARD -i wg1 -j ACCEPT",
+	} {
+		if !strings.Contains(script, check) {
+			t.Errorf("User data missing %q", check)
+		}
+	}
+}
+
+func TestGenerateClientConfigSecondTunnel(t *testing.T) {
+	spec := planTunnels(2, false)[1]
+	spec.ClientPrivateKey = "client-private"
+	spec.ServerPublicKey = "server-public"
+	result := &DeploymentResult{
+		BastionPublicIP:  "203.0.113.10",
+		ClientAllowedIPs: []string{"10.0.2.0/24"},
+	}
+
+	content := generateClientConfig(result, spec, "1.1.1.1")
+	for _, check := range []string{
+		"PrivateKey = client-private\n",
+		"Address = 10.100.2.2/24\n",
+		"Table = off\n",
+		"PublicKey = server-public\n",
+		"Endpoint = 203.0.113.10:51821\n",
+		"AllowedIPs = 10.0.2.0/24, 10.100.2.0/24\n",
+	} {
+		if !strings.Contains(content, check) {
+			t.Errorf("Client config missing %q:\n%s", check, content)
+		}
+	}
+	if strings.Contains(content, "DNS =") {
+		t.Error("Only the first tunnel should set DNS")
+	}
+	if len(result.ClientAllowedIPs) != 1 {
+		t.Error("Rendering a tunnel must not modify the shared AllowedIPs")
+	}
+}
+
+func TestServerKeysFromTags(t *testing.T) {
+	tagKeys := []string{ServerPublicKeyTag(0), ServerPublicKeyTag(1)}
+	tags := []types.TagDescription{
+		{Key: aws.String("WireGuardPublicKey1"), Value: aws.String("key-1")},
+		{Key: aws.String("WireGuardPublicKey"), Value: aws.String("key-0")},
+	}
+
+	keys, ok := serverKeysFromTags(tags, tagKeys)
+	if !ok || keys[0] != "key-0" || keys[1] != "key-1" {
+		t.Errorf("Expected keys ordered by tunnel, got %v (ok=%v)", keys, ok)
+	}
+
+	if _, ok := serverKeysFromTags(tags[:1], tagKeys); ok {
+		t.Error("Missing tunnel keys should not be reported complete")
+	}
+}
+
+func TestBuildIngressRulesMultipleTunnels(t *testing.T) {
+	config := &DeploymentConfig{
+		TunnelCount: 4,
+		AllowedCIDR: "0.0.0.0/0",
+		VPCCidr:     "10.0.0.0/16",
+	}
+
+	var wireguardPorts []int32
+	var icmpRanges int
+	for _, rule := range buildIngressRules(config) {
+		switch *rule.IpProtocol {
+		case "udp":
+			wireguardPorts = append(wireguardPorts, *rule.FromPort)
+		case "icmp":
+			icmpRanges = len(rule.IpRanges)
+		}
+	}
+	if fmt.Sprint(wireguardPorts) != "[51820 51821 51822 51823]" {
+		t.Errorf("Unexpected WireGuard ports: %v", wireguardPorts)
+	}
+	if icmpRanges != 5 {
+		t.Errorf("ICMP should be allowed from the VPC and all 4 tunnel networks, got %d ranges", icmpRanges)
+	}
+
+	// Autoscaling opens the ports of tunnels added later
+	config.MaxTunnels = 6
+	wireguardPorts = nil
+	for _, rule := range buildIngressRules(config) {
+		if *rule.IpProtocol == "udp" {
+			wireguardPorts = append(wireguardPorts, *rule.FromPort)
+		}
+	}
+	if fmt.Sprint(wireguardPorts) != "[51820 51821 51822 51823 51824 51825]" {
+		t.Errorf("Unexpected WireGuard ports with max tunnels: %v", wireguardPorts)
+	}
+}
+
+func TestStoreClientKeys(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(keystore.PassphraseEnv, "test passphrase")
+	store, err := keystore.Open(keystore.BackendFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tunnels := planTunnels(2, false)
+	tunnels[0].ClientPrivateKey, tunnels[0].PresharedKey = "client-private-0", "psk-0"
+	tunnels[1].ClientPrivateKey = "client-private-1"
+	if err := storeClientKeys(store, "i-0123", tunnels); err != nil {
+		t.Fatalf("storeClientKeys failed: %v", err)
+	}
+	if key, err := store.Get(keystore.ClientKeyName("i-0123", "wg1")); err != nil || key != "client-private-1" {
+		t.Errorf("Get = %q, %v", key, err)
+	}
+	if psk, err := store.Get(keystore.PresharedKeyName("i-0123", "wg0")); err != nil || psk != "psk-0" {
+		t.Errorf("Get PSK = %q, %v", psk, err)
+	}
+	if store.Has(keystore.PresharedKeyName("i-0123", "wg1")) {
+		t.Error("No PSK should be stored for a tunnel without one")
+	}
+
+	public := withoutSecrets(tunnels)
+	if public[0].ClientPrivateKey != "" || public[0].PresharedKey != "" || public[0].ClientPublicKey != tunnels[0].ClientPublicKey {
+		t.Errorf("Unexpected result tunnel %+v", public[0])
+	}
+	if tunnels[0].ClientPrivateKey == "" {
+		t.Error("withoutSecrets must not modify its input")
+	}
+}
+
+func TestStoreTunnelConfigs(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(keystore.PassphraseEnv, "test passphrase")
+	store, err := keystore.Open(keystore.BackendFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tunnels := planTunnels(2, false)
+	tunnels[0].ClientPrivateKey, tunnels[0].PresharedKey = "client-private-0", "psk-0"
+	tunnels[1].ClientPrivateKey = "client-private-1"
+	result := &DeploymentResult{BastionInstanceID: "i-0123", BastionPublicIP: "198.51.100.7"}
+	if err := storeTunnelConfigs(store, result, tunnels); err != nil {
+		t.Fatalf("storeTunnelConfigs failed: %v", err)
+	}
+
+	config, err := store.Get(keystore.TunnelConfigName("i-0123", "wg0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"PrivateKey = client-private-0", "PresharedKey = psk-0", "Endpoint = 198.51.100.7:"} {
+		if !strings.Contains(config, want) {
+			t.Errorf("Stored config missing %q:\n%s", want, config)
+		}
+	}
+	if !store.Has(keystore.TunnelConfigName("i-0123", "wg1")) {
+		t.Error("Expected a config for every tunnel")
+	}
+}
@@ -0,0 +1,227 @@
+package aws
+
+import (
+	"embed"
+	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/research-computing/mole/internal/agent"
+	"github.com/research-computing/mole/internal/config"
+)
+
+// Bastion and test-target bootstrapping is rendered from these templates for every
+// deployment path: direct deploy (AL2023), the IaC exports and pkg/scripts/bastion-init.sh (Ubuntu).
+//
+//go:embed templates/*.sh.tmpl
+var userDataFS embed.FS
+
+var userDataTemplates = template.Must(template.New("userdata").Funcs(template.FuncMap{
+	"join":         strings.Join,
+	"hostPrefix":   hostPrefix,
+	"keyTag":       ServerPublicKeyTag,
+	"pskParameter": pskParameterName,
+	"reportPath":   func() string { return agent.DefaultTuningFile },
+	"guard":        func(int) string { return "" },
+	"endGuard":     func(int) string { return "" },
+}).ParseFS(userDataFS, "templates/*.sh.tmpl"))
+
+// testServerPort is the HTTP port of the test target's server
+const testServerPort = 8080
+
+// OSFamily selects package management and the default login user in the templates
+type OSFamily string
+
+const (
+	OSAmazonLinux OSFamily = "al2023"
+	OSUbuntu      OSFamily = "ubuntu"
+)
+
+// Install returns the command that installs packages on this OS family
+func (f OSFamily) Install(packages ...string) string {
+	list := strings.Join(packages, " ")
+	if f == OSUbuntu {
+		return "apt-get update -y && DEBIAN_FRONTEND=noninteractive apt-get install -y " + list
+	}
+	return "dnf install -y " + list + " --skip-broken"
+}
+
+// User is the AMI's default login user
+func (f OSFamily) User() string {
+	if f == OSUbuntu {
+		return "ubuntu"
+	}
+	return "ec2-user"
+}
+
+// BastionUserData is the model rendered into templates/bastion.sh.tmpl
+type BastionUserData struct {
+	OS                 OSFamily
+	Region             string // Empty reads the region from instance metadata
+	MTU                int    // Zero leaves the MTU to wg-quick
+	PrivateSubnetCIDRs []string
+	RoutedCIDRs        []string
+	Tunnels            []TunnelSpec // Peers are only configured for tunnels with a ClientPublicKey
+	DualStack          bool
+	Tuning             *KernelTuning  // Nil leaves the kernel defaults
+	Agent              *AgentUserData // Nil skips the agent
+	PSKParameterPath   string         // SSM path of per-tunnel pre-shared keys; empty disables PSKs
+}
+
+// AgentUserData installs `mole agent` as a systemd service
+type AgentUserData struct {
+	TokenParameter string            // SecureString the bastion reads the token from, then deletes
+	URL            string            // Release archive; may reference ${ARCH}
+	SHA256         map[string]string // Archive checksum per ${ARCH}; other architectures are not installed
+	Listen         string            // host:port on the first tunnel's bastion address
+	Port           int
+	Allow          []string // Source networks for --allow; empty keeps the agent's defaults
+}
+
+// TargetUserData is the model rendered into templates/target.sh.tmpl
+type TargetUserData struct {
+	OS   OSFamily
+	Port int
+}
+
+// Home is the default user's home directory, where the test server lives
+func (t TargetUserData) Home() string {
+	return "/home/" + t.OS.User()
+}
+
+// RenderBastionUserData renders the bastion bootstrap script
+func RenderBastionUserData(model BastionUserData) (string, error) {
+	if model.PSKParameterPath != "" && model.Region == "" {
+		return "", fmt.Errorf("pre-shared keys need the region to reach SSM before the tunnels start")
+	}
+	return renderUserData(userDataTemplates, "bastion.sh.tmpl", model)
+}
+
+// RenderTargetUserData renders the test target bootstrap script
+func RenderTargetUserData(model TargetUserData) (string, error) {
+	return renderUserData(userDataTemplates, "target.sh.tmpl", model)
+}
+
+func renderUserData(tmpl *template.Template, name string, model any) (string, error) {
+	var script strings.Builder
+	if err := tmpl.ExecuteTemplate(&script, name, model); err != nil {
+		return "", fmt.Errorf("failed to render %s: %w", name, err)
+	}
+	return script.String(), nil
+}
+
+// bastionUserData builds the template model for a deployment
+func bastionUserData(config *DeploymentConfig, osFamily OSFamily, privateSubnetCIDRs []string) BastionUserData {
+	tunnels := deploymentTunnels(config)
+	return BastionUserData{
+		OS:                 osFamily,
+		Region:             config.Region,
+		MTU:                config.MTUSize,
+		PrivateSubnetCIDRs: privateSubnetCIDRs,
+		RoutedCIDRs:        config.RoutedCIDRs,
+		Tunnels:            tunnels,
+		DualStack:          config.EnableIPv6,
+		Tuning:             config.Tuning,
+		Agent:              agentUserData(config, tunnels[0]),
+		PSKParameterPath:   config.PSKParameterPath,
+	}
+}
+
+// agentUserData binds the agent to the first tunnel's bastion address. It returns nil for
+// development builds without --agent-url, or without a token parameter and checksums
+// (IaC exports).
+func agentUserData(config *DeploymentConfig, first TunnelSpec) *AgentUserData {
+	url := agentArchiveURL(config)
+	if url == "" || config.AgentTokenPath == "" || len(config.AgentSHA256) == 0 {
+		return nil
+	}
+
+	data := &AgentUserData{
+		TokenParameter: config.AgentTokenPath,
+		URL:            url,
+		SHA256:         config.AgentSHA256,
+		Listen:         strings.TrimPrefix(agentEndpoint(first), "http://"),
+		Port:           agent.DefaultPort,
+	}
+	// The agent's default IPv4 source only covers the default plan
+	if len(config.TunnelNetworks) > 0 {
+		data.Allow = slices.Clone(config.TunnelNetworks)
+		for _, source := range agent.DefaultAllowedSources {
+			if strings.Contains(source, ":") {
+				data.Allow = append(data.Allow, source)
+			}
+		}
+	}
+	return data
+}
+
+// terraformMTU stands in for ${mtu_size} while rendering the Terraform module's script
+const terraformMTU = -1
+
+// TerraformModuleUserData renders pkg/scripts/bastion-init.sh: the Ubuntu bastion script as a
+// Terraform templatefile. Every tunnel up to MaxTunnelCount is rendered and wrapped in a
+// %{ if tunnel_count > N } directive; shell ${...} and %{...} are escaped.
+func TerraformModuleUserData() (string, error) {
+	model := BastionUserData{
+		OS:      OSUbuntu,
+		MTU:     terraformMTU,
+		Tunnels: planTunnels(MaxTunnelCount, false),
+		Tuning:  KernelTuningFromConfig(config.DefaultOptimization()),
+	}
+
+	// Directives are emitted as placeholders so escaping leaves them alone
+	tmpl := template.Must(userDataTemplates.Clone()).Funcs(template.FuncMap{
+		"guard": func(id int) string {
+			if id == 0 {
+				return ""
+			}
+			return fmt.Sprintf("@@MOLE_IF_%d@@", id)
+		},
+		"endGuard": func(id int) string {
+			if id == 0 {
+				return ""
+			}
+			return "@@MOLE_ENDIF@@"
+		},
+	})
+
+	script, err := renderUserData(tmpl, "bastion.sh.tmpl", model)
+	if err != nil {
+		return "", err
+	}
+
+	script = escapeTerraformTemplate(script)
+	script = strings.ReplaceAll(script, fmt.Sprintf("MTU = %d\n", terraformMTU), "MTU = ${mtu_size}\n")
+	for id := 1; id < MaxTunnelCount; id++ {
+		script = strings.ReplaceAll(script, fmt.Sprintf("@@MOLE_IF_%d@@", id), fmt.Sprintf("%%{ if tunnel_count > %d }", id))
+	}
+	script = strings.ReplaceAll(script, "@@MOLE_ENDIF@@", "%{ endif }")
+
+	header := "# Generated from internal/aws/templates/bastion.sh.tmpl - do not edit.\n" +
+		"# Regenerate with: go test ./internal/aws -run TestTerraformModuleUserData -update\n"
+	shebang, rest, _ := strings.Cut(script, "\n")
+	return shebang + "\n" + header + rest, nil
+}
+
+// escapeTerraformTemplate escapes shell interpolation for Terraform heredocs and templatefile
+func escapeTerraformTemplate(script string) string {
+	script = strings.ReplaceAll(script, "${", "$${")
+	return strings.ReplaceAll(script, "%{", "%%{")
+}
+
+// indentLines prefixes every non-empty line, for embedding scripts in YAML block scalars
+func indentLines(script, indent string) string {
+	lines := strings.Split(strings.TrimRight(script, "\n"), "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = indent + line
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// goRawString quotes a script as a Go raw string literal, splicing in any backticks
+func goRawString(script string) string {
+	return "`" + strings.ReplaceAll(script, "`", "` + \"`\" + `") + "`"
+}
@@ -0,0 +1,199 @@
+package aws
+
+import (
+	"flag"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/research-computing/mole/internal/config"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files")
+
+// checkGolden compares output with a golden file, rewriting it with -update
+func checkGolden(t *testing.T, path, got string) {
+	t.Helper()
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read golden file (run with -update): %v", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s is out of date; run go test ./internal/aws -update and review the diff\n--- got ---\n%s", path, got)
+	}
+}
+
+func TestBastionUserDataGolden(t *testing.T) {
+	deployTunnels := planTunnels(2, true)
+	deployTunnels[0].ClientPublicKey = "Y2xpZW50LWtleS0wAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+	deployTunnels[1].ClientPublicKey = "Y2xpZW50LWtleS0xAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+
+	tests := []struct {
+		name  string
+		model BastionUserData
+	}{
+		{
+			// Direct deploy: everything known up front
+			name: "bastion-al2023",
+			model: BastionUserData{
+				OS:                 OSAmazonLinux,
+				Region:             "us-west-2",
+				MTU:                1420,
+				PrivateSubnetCIDRs: []string{"10.0.2.0/24", "10.0.3.0/24"},
+				RoutedCIDRs:        []string{"172.31.0.0/16"},
+				Tunnels:            deployTunnels,
+				DualStack:          true,
+				Tuning:             KernelTuningFromConfig(config.DefaultOptimization()),
+				Agent: &AgentUserData{
+					TokenParameter: "/mole/agent/0123456789abcdef",
+					URL:            "https://example.com/mole_linux_${ARCH}.tar.gz",
+					SHA256: map[string]string{
+						"amd64": strings.Repeat("a", 64),
+						"arm64": strings.Repeat("b", 64),
+					},
+					Listen: "10.100.1.1:7800",
+					Port:   7800,
+				},
+			},
+		},
+		{
+			// Pre-shared keys fetched from SSM; only the parameter path is in the script
+			name: "bastion-psk",
+			model: BastionUserData{
+				OS:                 OSAmazonLinux,
+				Region:             "us-west-2",
+				PrivateSubnetCIDRs: []string{"10.0.2.0/24"},
+				Tunnels:            deployTunnels[:1],
+				PSKParameterPath:   "/mole/psk/0123456789abcdef",
+			},
+		},
+		{
+			// IaC export: no client keys, subnets or agent token
+			name: "bastion-ubuntu",
+			model: BastionUserData{
+				OS:      OSUbuntu,
+				MTU:     1420,
+				Tunnels: planTunnels(1, false),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script, err := RenderBastionUserData(test.model)
+			if err != nil {
+				t.Fatalf("Render failed: %v", err)
+			}
+			checkGolden(t, filepath.Join("testdata", "userdata", test.name+".sh"), script)
+		})
+	}
+}
+
+func TestTargetUserDataGolden(t *testing.T) {
+	for _, osFamily := range []OSFamily{OSAmazonLinux, OSUbuntu} {
+		t.Run(string(osFamily), func(t *testing.T) {
+			script, err := RenderTargetUserData(TargetUserData{OS: osFamily, Port: testServerPort})
+			if err != nil {
+				t.Fatalf("Render failed: %v", err)
+			}
+			checkGolden(t, filepath.Join("testdata", "userdata", "target-"+string(osFamily)+".sh"), script)
+		})
+	}
+}
+
+func TestTerraformModuleUserData(t *testing.T) {
+	script, err := TerraformModuleUserData()
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	for _, check := range []string{
+		"MTU = ${mtu_size}\n",
+		"%{ if tunnel_count > 7 }# Tunnel 7: wg7 on port 51827",
+		`TUNNEL_NETWORKS="10.100.1.0/24%{ if tunnel_count > 1 } 10.100.2.0/24%{ endif }`,
+		"%{ endif }",
+	} {
+		if !strings.Contains(script, check) {
+			t.Errorf("Terraform template missing %q", check)
+		}
+	}
+	if strings.Contains(script, "@@MOLE_") {
+		t.Error("Terraform template contains unreplaced guard placeholders")
+	}
+
+	checkGolden(t, filepath.Join("..", "..", "pkg", "scripts", "bastion-init.sh"), script)
+}
+
+func TestExportsUseBastionTemplate(t *testing.T) {
+	client := &AWSClient{}
+	config := &DeploymentConfig{
+		VPCId:          "vpc-12345",
+		PublicSubnetId: "subnet-12345",
+		InstanceType:   "c6gn.medium",
+		TunnelCount:    2,
+		MTUSize:        1420,
+		Region:         "us-west-2",
+	}
+
+	terraform, err := client.ExportTerraform(config)
+	if err != nil {
+		t.Fatalf("Terraform export failed: %v", err)
+	}
+	for _, check := range []string{
+		"base64encode(<<USERDATA\n#!/bin/bash\n",
+		"apt-get install -y wireguard-tools",
+		"cat > /etc/wireguard/wg1.conf",
+		`PrivateKey = $(cat /etc/mole/keys/wg1_private.key)`,
+		"\nUSERDATA\n  )",
+	} {
+		if !strings.Contains(terraform, check) {
+			t.Errorf("Terraform export missing %q", check)
+		}
+	}
+
+	cloudFormation, err := client.ExportCloudFormation(config)
+	if err != nil {
+		t.Fatalf("CloudFormation export failed: %v", err)
+	}
+	if strings.Contains(cloudFormation, "Fn::Base64: !Sub") {
+		t.Error("CloudFormation user data must not go through !Sub, which would expand shell ${...}")
+	}
+	if !strings.Contains(cloudFormation, "            cat > /etc/wireguard/wg1.conf << EOF\n") {
+		t.Error("CloudFormation export should embed the indented bastion script")
+	}
+
+	pulumi, err := client.ExportPulumi(config)
+	if err != nil {
+		t.Fatalf("Pulumi export failed: %v", err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "main.go", pulumi, 0); err != nil {
+		t.Errorf("Pulumi export is not valid Go: %v", err)
+	}
+	if !strings.Contains(pulumi, "wg-quick up wg1") {
+		t.Error("Pulumi export should embed the bastion script")
+	}
+}
+
+func TestEscapeTerraformTemplate(t *testing.T) {
+	if got := escapeTerraformTemplate(`echo ${ARCH} %{x} $(date)`); got != `echo $${ARCH} %%{x} $(date)` {
+		t.Errorf("Unexpected escaping: %s", got)
+	}
+}
+
+func TestGoRawString(t *testing.T) {
+	if got := goRawString("echo `date`"); got != "`echo ` + \"`\" + `date` + \"`\" + ``" {
+		t.Errorf("Unexpected raw string: %s", got)
+	}
+}
@@ -2,11 +2,17 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
 )
 
 // Config represents the complete application configuration
@@ -26,6 +32,21 @@ type TunnelConfig struct {
 	MaxTunnels int    `yaml:"max_tunnels"`
 	BaseCIDR   string `yaml:"base_cidr"`
 	MTU        int    `yaml:"mtu"`
+
+	// TunnelPrefixLen is the size of the network each tunnel gets from BaseCIDR (default: 24)
+	TunnelPrefixLen int `yaml:"tunnel_prefix_len"`
+
+	// BaseIPv6CIDR enables dual-stack tunnels when set to a ULA prefix (e.g. fd6d:6f6c:6500::/48)
+	BaseIPv6CIDR string `yaml:"base_ipv6_cidr"`
+
+	// PresharedKeys adds a per-peer WireGuard PSK to every tunnel (same as mole up --psk)
+	PresharedKeys bool `yaml:"preshared_keys"`
+
+	// KeyStore protects client private keys at rest: auto, keyring or file (passphrase)
+	KeyStore string `yaml:"key_store"`
+
+	// Backend configures client interfaces: wg-quick, netlink (Linux kernel, no wireguard-tools) or memory
+	Backend string `yaml:"backend"`
 }
 
 // ScalingConfig defines scaling behavior
@@ -37,6 +58,10 @@ type ScalingConfig struct {
 	ScaleDownCooldown     time.Duration `yaml:"scale_down_cooldown"`
 	ElephantFlowThreshold int64         `yaml:"elephant_flow_threshold"`
 	BurstDetectionWindow  time.Duration `yaml:"burst_detection_window"`
+
+	// TunnelCapacity is the throughput one tunnel is expected to carry, in Mbps; utilisation
+	// is measured against it
+	TunnelCapacity float64 `yaml:"tunnel_capacity_mbps"`
 }
 
 // ProbingConfig defines network probing parameters
@@ -55,6 +80,39 @@ type OptimizationConfig struct {
 	ReserveCores         int    `yaml:"reserve_cores"`
 	CPUGovernor          string `yaml:"cpu_governor"`
 	TCPCongestion        string `yaml:"tcp_congestion"`
+
+	// Bastion kernel tuning
+	MultiQueueNIC    bool        `yaml:"multi_queue_nic"` // Spread ENA queues over CPUs (RPS/XPS and IRQ affinity)
+	TCPWindowScaling bool        `yaml:"tcp_window_scaling"`
+	BufferSizes      BufferSizes `yaml:"buffer_sizes"`
+}
+
+// BufferSizes are socket buffer limits; TCP values are "min default max" in bytes
+type BufferSizes struct {
+	RmemMax          int64  `yaml:"rmem_max"`
+	WmemMax          int64  `yaml:"wmem_max"`
+	TCPRmem          string `yaml:"tcp_rmem"`
+	TCPWmem          string `yaml:"tcp_wmem"`
+	NetdevMaxBacklog int    `yaml:"netdev_max_backlog"`
+}
+
+// DefaultOptimization is the tuning applied when the config file does not override it
+func DefaultOptimization() OptimizationConfig {
+	return OptimizationConfig{
+		EnableProcessPinning: true,
+		ReserveCores:         2,
+		CPUGovernor:          "performance",
+		TCPCongestion:        "bbr",
+		MultiQueueNIC:        true,
+		TCPWindowScaling:     true,
+		BufferSizes: BufferSizes{
+			RmemMax:          134217728, // 128MB
+			WmemMax:          134217728,
+			TCPRmem:          "4096 87380 134217728",
+			TCPWmem:          "4096 65536 134217728",
+			NetdevMaxBacklog: 5000,
+		},
+	}
 }
 
 // AWSConfig defines AWS-specific settings
@@ -63,6 +121,9 @@ type AWSConfig struct {
 	MaxInstances      int      `yaml:"max_instances"`
 	AvailabilityZones []string `yaml:"availability_zones"`
 	BudgetLimit       float64  `yaml:"budget_limit"`
+
+	// Tags are cost-allocation tags (e.g. Project, PI, GrantID) applied to every resource
+	Tags map[string]string `yaml:"tags"`
 }
 
 // MPTCPConfig defines MPTCP settings
@@ -112,10 +173,21 @@ func LoadConfig(configFile string) (*Config, error) {
 
 	// Unmarshal into struct
 	var config Config
-	if err := viper.Unmarshal(&config); err != nil {
+	// Decode using the yaml field names so config files and SaveConfig output round-trip;
+	// the structs have no mapstructure tags, so the default would miss every snake_case key
+	if err := viper.Unmarshal(&config, func(dc *mapstructure.DecoderConfig) { dc.TagName = "yaml" }); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
+	// Viper lower-cases map keys, but AWS tag keys are case-sensitive
+	if path := viper.ConfigFileUsed(); path != "" && len(config.AWS.Tags) > 0 {
+		tags, err := loadAWSTags(path)
+		if err != nil {
+			return nil, err
+		}
+		config.AWS.Tags = tags
+	}
+
 	// Validate configuration
 	if err := validateConfig(&config); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
@@ -131,6 +203,9 @@ func setDefaults() {
 	viper.SetDefault("tunnel.max_tunnels", 8)
 	viper.SetDefault("tunnel.base_cidr", "10.100.0.0/16")
 	viper.SetDefault("tunnel.mtu", 1420)
+	viper.SetDefault("tunnel.tunnel_prefix_len", 24)
+	viper.SetDefault("tunnel.key_store", "auto")
+	viper.SetDefault("tunnel.backend", "wg-quick")
 
 	// Scaling defaults
 	viper.SetDefault("scaling.scale_up_threshold", 0.80)
@@ -140,6 +215,7 @@ func setDefaults() {
 	viper.SetDefault("scaling.scale_down_cooldown", "10m")
 	viper.SetDefault("scaling.elephant_flow_threshold", 104857600) // 100MB
 	viper.SetDefault("scaling.burst_detection_window", "5m")
+	viper.SetDefault("scaling.tunnel_capacity_mbps", 1500)
 
 	// Probing defaults
 	viper.SetDefault("probing.test_duration", "30s")
@@ -150,10 +226,18 @@ func setDefaults() {
 	viper.SetDefault("probing.latency_test_count", 100)
 
 	// Optimization defaults
-	viper.SetDefault("optimization.enable_process_pinning", true)
-	viper.SetDefault("optimization.reserve_cores", 2)
-	viper.SetDefault("optimization.cpu_governor", "performance")
-	viper.SetDefault("optimization.tcp_congestion", "bbr")
+	optimization := DefaultOptimization()
+	viper.SetDefault("optimization.enable_process_pinning", optimization.EnableProcessPinning)
+	viper.SetDefault("optimization.reserve_cores", optimization.ReserveCores)
+	viper.SetDefault("optimization.cpu_governor", optimization.CPUGovernor)
+	viper.SetDefault("optimization.tcp_congestion", optimization.TCPCongestion)
+	viper.SetDefault("optimization.multi_queue_nic", optimization.MultiQueueNIC)
+	viper.SetDefault("optimization.tcp_window_scaling", optimization.TCPWindowScaling)
+	viper.SetDefault("optimization.buffer_sizes.rmem_max", optimization.BufferSizes.RmemMax)
+	viper.SetDefault("optimization.buffer_sizes.wmem_max", optimization.BufferSizes.WmemMax)
+	viper.SetDefault("optimization.buffer_sizes.tcp_rmem", optimization.BufferSizes.TCPRmem)
+	viper.SetDefault("optimization.buffer_sizes.tcp_wmem", optimization.BufferSizes.TCPWmem)
+	viper.SetDefault("optimization.buffer_sizes.netdev_max_backlog", optimization.BufferSizes.NetdevMaxBacklog)
 
 	// AWS defaults
 	viper.SetDefault("aws.instance_types", []string{"t4g.nano", "t4g.small", "c6gn.medium", "c6gn.large"})
@@ -185,12 +269,39 @@ func validateConfig(config *Config) error {
 	if config.Tunnel.MTU < 1200 || config.Tunnel.MTU > 9000 {
 		return fmt.Errorf("MTU must be between 1200 and 9000")
 	}
+	if config.Tunnel.BaseCIDR != "" {
+		if err := validateBaseCIDR(&config.Tunnel); err != nil {
+			return err
+		}
+	}
+	if config.Tunnel.BaseIPv6CIDR != "" {
+		prefix, err := netip.ParsePrefix(config.Tunnel.BaseIPv6CIDR)
+		if err != nil || !prefix.Addr().Is6() || prefix.Bits() > 48 {
+			return fmt.Errorf("base_ipv6_cidr must be an IPv6 prefix of /48 or shorter")
+		}
+	}
+
+	switch config.Tunnel.KeyStore {
+	case "", "auto", "keyring", "file":
+	default:
+		return fmt.Errorf("key_store must be auto, keyring or file")
+	}
+	switch config.Tunnel.Backend {
+	case "", "wg-quick", "netlink", "memory":
+	default:
+		return fmt.Errorf("backend must be wg-quick, netlink or memory")
+	}
 
 	// Validate scaling config
 	if config.Scaling.ScaleUpThreshold <= config.Scaling.ScaleDownThreshold {
 		return fmt.Errorf("scale_up_threshold must be > scale_down_threshold")
 	}
 
+	// Validate optimization config; these values end up in the bastion's sysctl.d
+	if err := validateOptimization(&config.Optimization); err != nil {
+		return err
+	}
+
 	// Validate AWS config
 	if config.AWS.BudgetLimit <= 0 {
 		return fmt.Errorf("budget_limit must be positive")
@@ -199,6 +310,86 @@ func validateConfig(config *Config) error {
 	return nil
 }
 
+// validateBaseCIDR checks BaseCIDR has room for a network per tunnel, each holding the
+// bastion and client addresses
+func validateBaseCIDR(tunnel *TunnelConfig) error {
+	prefix, err := netip.ParsePrefix(tunnel.BaseCIDR)
+	if err != nil || !prefix.Addr().Is4() {
+		return fmt.Errorf("base_cidr must be an IPv4 prefix")
+	}
+	prefixLen := tunnel.TunnelPrefixLen
+	if prefixLen == 0 {
+		prefixLen = 24
+	}
+	if prefixLen <= prefix.Bits() || prefixLen > 30 {
+		return fmt.Errorf("tunnel_prefix_len must be longer than base_cidr and at most 30")
+	}
+	if prefixLen-prefix.Bits() < 31 && 1<<(prefixLen-prefix.Bits()) < tunnel.MaxTunnels {
+		return fmt.Errorf("base_cidr has room for fewer than max_tunnels tunnel networks")
+	}
+	return nil
+}
+
+var (
+	congestionControlPattern = regexp.MustCompile(`^[a-z0-9_]*$`)
+	cpuGovernorPattern       = regexp.MustCompile(`^[a-z]*$`)
+)
+
+// validateOptimization checks tunables before they are rendered into the bastion user data
+func validateOptimization(opt *OptimizationConfig) error {
+	if !congestionControlPattern.MatchString(opt.TCPCongestion) {
+		return fmt.Errorf("invalid tcp_congestion %q", opt.TCPCongestion)
+	}
+	if !cpuGovernorPattern.MatchString(opt.CPUGovernor) {
+		return fmt.Errorf("invalid cpu_governor %q", opt.CPUGovernor)
+	}
+
+	buffers := opt.BufferSizes
+	if buffers.RmemMax < 0 || buffers.WmemMax < 0 || buffers.NetdevMaxBacklog < 0 {
+		return fmt.Errorf("buffer_sizes must not be negative")
+	}
+	for name, value := range map[string]string{"tcp_rmem": buffers.TCPRmem, "tcp_wmem": buffers.TCPWmem} {
+		if value == "" {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) != 3 {
+			return fmt.Errorf("%s must be \"min default max\", got %q", name, value)
+		}
+		var sizes [3]int64
+		for i, field := range fields {
+			size, err := strconv.ParseInt(field, 10, 64)
+			if err != nil || size <= 0 {
+				return fmt.Errorf("invalid %s %q", name, value)
+			}
+			sizes[i] = size
+		}
+		if sizes[0] > sizes[1] || sizes[1] > sizes[2] {
+			return fmt.Errorf("%s must be ordered min <= default <= max, got %q", name, value)
+		}
+	}
+
+	return nil
+}
+
+// loadAWSTags reads aws.tags straight from the YAML file to preserve key case
+func loadAWSTags(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var raw struct {
+		AWS struct {
+			Tags map[string]string `yaml:"tags"`
+		} `yaml:"aws"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse aws.tags: %w", err)
+	}
+	return raw.AWS.Tags, nil
+}
+
 // SaveConfig saves the current configuration to file
 func SaveConfig(config *Config, filename string) error {
 	// Create config directory if it doesn't exist
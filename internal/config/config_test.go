@@ -3,9 +3,13 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
 )
 
 func TestConfigDefaults(t *testing.T) {
@@ -167,6 +171,68 @@ func TestConfigValidation(t *testing.T) {
 			wantErr: true,
 			errMsg:  "MTU must be between 1200 and 9000",
 		},
+		{
+			name: "unknown tunnel backend",
+			config: &Config{
+				Tunnel: TunnelConfig{
+					MinTunnels: 1,
+					MaxTunnels: 8,
+					MTU:        1420,
+					Backend:    "boringtun",
+				},
+				Scaling: ScalingConfig{
+					ScaleUpThreshold:   0.80,
+					ScaleDownThreshold: 0.30,
+				},
+				AWS: AWSConfig{
+					BudgetLimit: 100.0,
+				},
+			},
+			wantErr: true,
+			errMsg:  "backend must be wg-quick, netlink or memory",
+		},
+		{
+			name: "base CIDR too small for max tunnels",
+			config: &Config{
+				Tunnel: TunnelConfig{
+					MinTunnels:      1,
+					MaxTunnels:      8,
+					MTU:             1420,
+					BaseCIDR:        "10.100.0.0/22",
+					TunnelPrefixLen: 24,
+				},
+				Scaling: ScalingConfig{
+					ScaleUpThreshold:   0.80,
+					ScaleDownThreshold: 0.30,
+				},
+				AWS: AWSConfig{
+					BudgetLimit: 100.0,
+				},
+			},
+			wantErr: true,
+			errMsg:  "base_cidr has room for fewer than max_tunnels tunnel networks",
+		},
+		{
+			name: "tunnel prefix too long",
+			config: &Config{
+				Tunnel: TunnelConfig{
+					MinTunnels:      1,
+					MaxTunnels:      8,
+					MTU:             1420,
+					BaseCIDR:        "10.100.0.0/16",
+					TunnelPrefixLen: 31,
+				},
+				Scaling: ScalingConfig{
+					ScaleUpThreshold:   0.80,
+					ScaleDownThreshold: 0.30,
+				},
+				AWS: AWSConfig{
+					BudgetLimit: 100.0,
+				},
+			},
+			wantErr: true,
+			errMsg:  "tunnel_prefix_len must be longer than base_cidr and at most 30",
+		},
 		{
 			name: "invalid scaling thresholds",
 			config: &Config{
@@ -413,6 +479,112 @@ func TestAWSConfig(t *testing.T) {
 	}
 }
 
+func TestLoadConfigAWSTags(t *testing.T) {
+	// Earlier tests leave values in the global viper instance
+	viper.Reset()
+
+	tmpFile := filepath.Join(t.TempDir(), "config.yaml")
+	content := `tunnel:
+  min_tunnels: 1
+  max_tunnels: 4
+aws:
+  tags:
+    Project: genomics
+    PI: Dr. Smith
+    GrantID: NSF-123
+`
+	if err := os.WriteFile(tmpFile, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	config, err := LoadConfig(tmpFile)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	if config.Tunnel.MaxTunnels != 4 {
+		t.Errorf("Expected max_tunnels=4, got %d", config.Tunnel.MaxTunnels)
+	}
+	// Tag keys are case-sensitive in AWS and must survive loading unchanged
+	if config.AWS.Tags["PI"] != "Dr. Smith" || config.AWS.Tags["GrantID"] != "NSF-123" {
+		t.Errorf("Tags not loaded with original key case: %v", config.AWS.Tags)
+	}
+}
+
+func TestLoadShippedConfigs(t *testing.T) {
+	files, err := filepath.Glob("../../configs/*.yaml")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("No shipped configs found: %v", err)
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", file, err)
+		}
+		// The file decoded on its own is what every key it sets should load as
+		var raw map[string]any
+		var expected Config
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			t.Fatalf("Failed to parse %s: %v", file, err)
+		}
+		if err := yaml.Unmarshal(data, &expected); err != nil {
+			t.Fatalf("Failed to decode %s: %v", file, err)
+		}
+
+		viper.Reset()
+		loaded, err := LoadConfig(file)
+		if err != nil {
+			t.Fatalf("LoadConfig(%s) failed: %v", file, err)
+		}
+
+		want, got := configLeaves(t, &expected), configLeaves(t, loaded)
+		checked := 0
+		for key := range flattenYAML("", raw) {
+			value, ok := want[key]
+			if !ok {
+				continue // A key the Config struct does not have, e.g. extends in dtn.yaml
+			}
+			checked++
+			if !reflect.DeepEqual(got[key], value) {
+				t.Errorf("%s: %s loaded as %v, file sets %v", filepath.Base(file), key, got[key], value)
+			}
+		}
+		if checked == 0 {
+			t.Errorf("%s: no keys were checked", filepath.Base(file))
+		}
+	}
+}
+
+// configLeaves flattens a Config through its YAML form so values compare like the file's
+func configLeaves(t *testing.T, config *Config) map[string]any {
+	t.Helper()
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+	var tree map[string]any
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		t.Fatalf("Failed to parse marshalled config: %v", err)
+	}
+	return flattenYAML("", tree)
+}
+
+// flattenYAML maps dotted key paths (e.g. tunnel.max_tunnels) to leaf values
+func flattenYAML(prefix string, tree map[string]any) map[string]any {
+	leaves := make(map[string]any)
+	for key, value := range tree {
+		if sub, ok := value.(map[string]any); ok {
+			for subKey, leaf := range flattenYAML(prefix+key+".", sub) {
+				leaves[subKey] = leaf
+			}
+			continue
+		}
+		leaves[prefix+key] = value
+	}
+	return leaves
+}
+
 func TestMonitoringConfig(t *testing.T) {
 	config := MonitoringConfig{
 		EnablePrometheus:    true,
@@ -433,4 +605,24 @@ func TestMonitoringConfig(t *testing.T) {
 	if config.LogLevel != "debug" {
 		t.Errorf("Expected LogLevel=debug, got %s", config.LogLevel)
 	}
-}
\ No newline at end of file
+}
+func TestValidateOptimization(t *testing.T) {
+	valid := DefaultOptimization()
+	if err := validateOptimization(&valid); err != nil {
+		t.Fatalf("Defaults should validate: %v", err)
+	}
+
+	tests := map[string]func(*OptimizationConfig){
+		"shell in congestion control": func(o *OptimizationConfig) { o.TCPCongestion = "bbr; reboot" },
+		"two tcp_rmem values":         func(o *OptimizationConfig) { o.BufferSizes.TCPRmem = "4096 87380" },
+		"unordered tcp_wmem":          func(o *OptimizationConfig) { o.BufferSizes.TCPWmem = "65536 4096 134217728" },
+		"negative rmem_max":           func(o *OptimizationConfig) { o.BufferSizes.RmemMax = -1 },
+	}
+	for name, mutate := range tests {
+		opt := DefaultOptimization()
+		mutate(&opt)
+		if err := validateOptimization(&opt); err == nil {
+			t.Errorf("%s: expected validation error", name)
+		}
+	}
+}
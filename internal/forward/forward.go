@@ -0,0 +1,217 @@
+// Package forward relays single TCP ports across the tunnel. A local forward exposes a
+// private service on this host; a remote forward exposes an on-premises service to the
+// private subnet.
+package forward
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Direction tells local forwards from remote (reverse) forwards
+type Direction string
+
+const (
+	// Local listens on this host and connects to a host behind the bastion (ssh -L)
+	Local Direction = "L"
+	// Remote listens on the tunnel for the private subnet and connects to an on-premises host (ssh -R)
+	Remote Direction = "R"
+)
+
+// Dialer opens the connection to a forward's target
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Spec is one parsed forward
+type Spec struct {
+	Direction Direction
+	Listen    string // host:port to accept connections on
+	Target    string // host:port to connect them to
+}
+
+// ParseSpec parses an ssh-style [BIND:]PORT:HOST:HOSTPORT forward. bind is used when the
+// value names no bind address. IPv6 addresses go in brackets.
+func ParseSpec(value string, direction Direction, bind string) (Spec, error) {
+	fields, err := splitSpec(value)
+	if err != nil {
+		return Spec{}, fmt.Errorf("invalid forward %q: %w", value, err)
+	}
+	switch len(fields) {
+	case 3:
+		fields = append([]string{bind}, fields...)
+	case 4:
+	default:
+		return Spec{}, fmt.Errorf("invalid forward %q: expected [BIND:]PORT:HOST:HOSTPORT", value)
+	}
+
+	for _, port := range []string{fields[1], fields[3]} {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return Spec{}, fmt.Errorf("invalid forward %q: bad port %q", value, port)
+		}
+	}
+	if fields[2] == "" {
+		return Spec{}, fmt.Errorf("invalid forward %q: missing host", value)
+	}
+
+	return Spec{
+		Direction: direction,
+		Listen:    net.JoinHostPort(fields[0], fields[1]),
+		Target:    net.JoinHostPort(fields[2], fields[3]),
+	}, nil
+}
+
+// splitSpec splits on colons outside brackets and strips the brackets
+func splitSpec(value string) ([]string, error) {
+	var fields []string
+	for value != "" {
+		var field string
+		if strings.HasPrefix(value, "[") {
+			end := strings.Index(value, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated bracket")
+			}
+			field, value = value[1:end], value[end+1:]
+			if value != "" && !strings.HasPrefix(value, ":") {
+				return nil, fmt.Errorf("expected ':' after ']'")
+			}
+		} else if i := strings.Index(value, ":"); i >= 0 {
+			field, value = value[:i], value[i:]
+		} else {
+			field, value = value, ""
+		}
+		fields = append(fields, field)
+		if value != "" {
+			value = value[1:]
+			if value == "" {
+				fields = append(fields, "")
+			}
+		}
+	}
+	return fields, nil
+}
+
+func (s Spec) String() string {
+	return fmt.Sprintf("-%s %s → %s", s.Direction, s.Listen, s.Target)
+}
+
+// Stats are a forward's counters. Sent counts bytes towards the target.
+type Stats struct {
+	Connections int64
+	Active      int64
+	Sent        int64
+	Received    int64
+}
+
+// Forward relays every connection accepted for a Spec to its target
+type Forward struct {
+	Spec   Spec
+	Dialer Dialer
+
+	connections atomic.Int64
+	active      atomic.Int64
+	sent        atomic.Int64
+	received    atomic.Int64
+}
+
+// Stats returns the forward's counters so far
+func (f *Forward) Stats() Stats {
+	return Stats{
+		Connections: f.connections.Load(),
+		Active:      f.active.Load(),
+		Sent:        f.sent.Load(),
+		Received:    f.received.Load(),
+	}
+}
+
+// Serve accepts connections until ctx is done or the listener fails
+func (f *Forward) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("forward %s failed: %w", f.Spec, err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.handle(ctx, conn)
+		}()
+	}
+}
+
+func (f *Forward) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	f.connections.Add(1)
+
+	upstream, err := f.Dialer.DialContext(ctx, "tcp", f.Spec.Target)
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+	f.active.Add(1)
+	defer f.active.Add(-1)
+
+	Relay(ctx, conn, conn, upstream, &f.sent, &f.received)
+}
+
+// Relay copies both ways between a client and its upstream until each side has finished
+// sending. clientReader stands in for reads from client, e.g. a buffered reader that may
+// already hold the start of the stream. sent and received, when not nil, count the bytes
+// written to upstream and to client. Ending ctx closes both connections, so relays
+// waiting on idle peers return.
+func Relay(ctx context.Context, client net.Conn, clientReader io.Reader, upstream net.Conn, sent, received *atomic.Int64) {
+	stop := context.AfterFunc(ctx, func() {
+		client.Close()
+		upstream.Close()
+	})
+	defer stop()
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(&counter{upstream, sent}, clientReader)
+		closeWrite(upstream)
+		close(done)
+	}()
+	io.Copy(&counter{client, received}, upstream)
+	closeWrite(client)
+	<-done
+}
+
+// counter counts bytes as they are written, so long transfers show up in Stats
+type counter struct {
+	io.Writer
+	n *atomic.Int64
+}
+
+func (c *counter) Write(p []byte) (int, error) {
+	n, err := c.Writer.Write(p)
+	if c.n != nil {
+		c.n.Add(int64(n))
+	}
+	return n, err
+}
+
+// closeWrite half-closes a connection so the peer sees EOF while replies still flow back
+func closeWrite(conn net.Conn) {
+	if half, ok := conn.(interface{ CloseWrite() error }); ok {
+		half.CloseWrite()
+		return
+	}
+	conn.Close()
+}
@@ -0,0 +1,131 @@
+package forward
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSpec(t *testing.T) {
+	tests := []struct {
+		value     string
+		direction Direction
+		listen    string
+		target    string
+	}{
+		{"8888:10.0.1.5:8888", Local, "127.0.0.1:8888", "10.0.1.5:8888"},
+		{"0.0.0.0:5432:db.internal:5432", Local, "0.0.0.0:5432", "db.internal:5432"},
+		{"9000:localhost:9000", Remote, "127.0.0.1:9000", "localhost:9000"},
+		{"[::1]:8080:[fd00::5]:80", Local, "[::1]:8080", "[fd00::5]:80"},
+	}
+	for _, tt := range tests {
+		spec, err := ParseSpec(tt.value, tt.direction, "127.0.0.1")
+		if err != nil {
+			t.Errorf("ParseSpec(%q) failed: %v", tt.value, err)
+			continue
+		}
+		if spec.Listen != tt.listen || spec.Target != tt.target || spec.Direction != tt.direction {
+			t.Errorf("ParseSpec(%q) = %+v", tt.value, spec)
+		}
+	}
+
+	for _, value := range []string{"8888", "8888:host", "0:host:80", "80:host:99999", "80::80", "a:b:c:d:e", "[::1:80:h:80", "x:80:h:80:"} {
+		if _, err := ParseSpec(value, Local, "127.0.0.1"); err == nil {
+			t.Errorf("Expected ParseSpec(%q) to fail", value)
+		}
+	}
+}
+
+func TestForward(t *testing.T) {
+	// An upper-casing service stands in for the target
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				data, _ := io.ReadAll(conn)
+				conn.Write([]byte(strings.ToUpper(string(data)) + "!"))
+			}()
+		}
+	}()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forward := &Forward{
+		Spec:   Spec{Direction: Local, Listen: listener.Addr().String(), Target: target.Addr().String()},
+		Dialer: &net.Dialer{},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- forward.Serve(ctx, listener) }()
+
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte("hello"))
+		conn.(*net.TCPConn).CloseWrite()
+		reply, err := io.ReadAll(conn)
+		conn.Close()
+		if err != nil || string(reply) != "HELLO!" {
+			t.Fatalf("Reply = %q, %v", reply, err)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Serve returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not stop after cancellation")
+	}
+
+	stats := forward.Stats()
+	if stats.Connections != 3 || stats.Active != 0 || stats.Sent != 15 || stats.Received != 18 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestForwardUnreachableTarget(t *testing.T) {
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forward := &Forward{Spec: Spec{Target: closed.Addr().String()}, Dialer: &net.Dialer{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go forward.Serve(ctx, listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected the client connection to be closed")
+	}
+	if stats := forward.Stats(); stats.Connections != 1 || stats.Active != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
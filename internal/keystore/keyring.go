@@ -0,0 +1,78 @@
+package keystore
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Keyring holds the key store's master key
+type Keyring interface {
+	Available() bool
+	Get() (string, error)
+	Set(secret string) error
+}
+
+// Keyring item identifying the master key
+const (
+	keyringService = "mole"
+	keyringAccount = "keystore"
+)
+
+// systemKeyring uses the macOS keychain through security(1) and the freedesktop Secret
+// Service through secret-tool(1). Secrets go over stdin so they never appear in argv.
+type systemKeyring struct{}
+
+func (systemKeyring) Available() bool {
+	switch runtime.GOOS {
+	case "darwin":
+		_, err := exec.LookPath("security")
+		return err == nil
+	case "linux", "freebsd", "openbsd", "netbsd", "dragonfly":
+		// secret-tool needs a session bus; headless hosts fall back to the file backend
+		_, err := exec.LookPath("secret-tool")
+		return err == nil && os.Getenv("DBUS_SESSION_BUS_ADDRESS") != ""
+	default:
+		return false
+	}
+}
+
+func (systemKeyring) Get() (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", keyringAccount, "-w")
+	} else {
+		cmd = exec.Command("secret-tool", "lookup", "service", keyringService, "account", keyringAccount)
+	}
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", cmd.Args[0], err)
+	}
+	secret := strings.TrimSpace(string(output))
+	if secret == "" {
+		return "", fmt.Errorf("no master key in the keyring")
+	}
+	return secret, nil
+}
+
+func (systemKeyring) Set(secret string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		// security -i reads commands from stdin
+		cmd = exec.Command("security", "-i")
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -l \"mole key store\" -w %s\n",
+			keyringService, keyringAccount, secret))
+	} else {
+		cmd = exec.Command("secret-tool", "store", "--label=mole key store", "service", keyringService, "account", keyringAccount)
+		cmd.Stdin = strings.NewReader(secret)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w: %s", cmd.Args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,376 @@
+// Package keystore keeps client WireGuard private keys encrypted at rest under ~/.mole and
+// materialises wg-quick configs only in a private runtime directory while a tunnel is up.
+//
+// Entries are sealed with AES-256-GCM under a master key that either lives in the OS keyring
+// or is derived from a passphrase with scrypt, so the file backend also works headless.
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// Backends that provide the master key
+const (
+	BackendAuto    = "auto"    // The store's existing backend, else keyring when available, else file
+	BackendKeyring = "keyring" // Random master key in the macOS keychain or the Secret Service
+	BackendFile    = "file"    // Master key derived from a passphrase
+)
+
+// PassphraseEnv supplies the file backend's passphrase without a prompt
+const PassphraseEnv = "MOLE_KEYSTORE_PASSPHRASE"
+
+// scrypt cost for passphrase-derived master keys
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// checkValue is sealed with the master key so a wrong passphrase is reported as such
+const checkValue = "mole-keystore"
+
+// storeFile is the on-disk format; Keys maps entry names to base64(nonce || ciphertext)
+type storeFile struct {
+	Version int               `json:"version"`
+	Backend string            `json:"backend"`
+	Salt    string            `json:"salt,omitempty"` // scrypt salt, file backend only
+	Check   string            `json:"check,omitempty"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// Store is an encrypted key store. Opening it does not need the master key; reading or
+// adding keys unlocks it on first use.
+type Store struct {
+	path    string
+	file    storeFile
+	master  []byte
+	keyring Keyring
+}
+
+// Path is where the encrypted key store lives
+func Path() string {
+	return filepath.Join(os.Getenv("HOME"), ".mole", "keystore.json")
+}
+
+// ClientKeyName is the entry holding a tunnel's client private key
+func ClientKeyName(instanceID, iface string) string {
+	return instanceID + "/" + iface
+}
+
+// PresharedKeyName is the entry holding a tunnel's pre-shared key
+func PresharedKeyName(instanceID, iface string) string {
+	return instanceID + "/" + iface + ".psk"
+}
+
+// TunnelConfigName is the entry holding a tunnel's complete client config, kept for
+// userspace mode, which has no wg-quick config to materialise
+func TunnelConfigName(instanceID, iface string) string {
+	return instanceID + "/" + iface + ".conf"
+}
+
+// Open loads the key store with the requested backend; an empty backend means auto
+func Open(backend string) (*Store, error) {
+	return open(Path(), backend, systemKeyring{})
+}
+
+func open(path, backend string, keyring Keyring) (*Store, error) {
+	s := &Store{path: path, keyring: keyring, file: storeFile{Version: 1, Keys: map[string]string{}}}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("failed to read key store: %w", err)
+	default:
+		if err := json.Unmarshal(data, &s.file); err != nil {
+			return nil, fmt.Errorf("corrupt key store %s: %w", path, err)
+		}
+		if s.file.Keys == nil {
+			s.file.Keys = map[string]string{}
+		}
+	}
+
+	switch backend {
+	case "", BackendAuto:
+		if s.file.Backend == "" {
+			s.file.Backend = BackendFile
+			if keyring.Available() {
+				s.file.Backend = BackendKeyring
+			}
+		}
+	case BackendKeyring, BackendFile:
+		if s.file.Backend != "" && s.file.Backend != backend && len(s.file.Keys) > 0 {
+			return nil, fmt.Errorf("key store %s uses the %s backend; remove its keys with 'mole down' before switching", path, s.file.Backend)
+		}
+		if s.file.Backend != backend {
+			s.file.Backend, s.file.Salt, s.file.Check = backend, "", ""
+		}
+	default:
+		return nil, fmt.Errorf("unknown key store backend %q (want auto, keyring or file)", backend)
+	}
+	return s, nil
+}
+
+// Backend reports which backend protects the master key
+func (s *Store) Backend() string {
+	return s.file.Backend
+}
+
+// Unlock obtains the master key, creating it for a new store. It prompts for the file
+// backend's passphrase unless MOLE_KEYSTORE_PASSPHRASE is set.
+func (s *Store) Unlock() error {
+	if s.master != nil {
+		return nil
+	}
+	creating := s.file.Check == ""
+
+	var master []byte
+	switch s.file.Backend {
+	case BackendKeyring:
+		if !s.keyring.Available() {
+			return fmt.Errorf("no OS keyring available (install secret-tool or use the file backend)")
+		}
+		secret, err := s.keyring.Get()
+		if err != nil && !creating {
+			return fmt.Errorf("failed to read the key store master key from the keyring: %w", err)
+		}
+		if err == nil {
+			master, err = base64.StdEncoding.DecodeString(secret)
+			if err != nil || len(master) != 32 {
+				return fmt.Errorf("keyring holds an invalid key store master key")
+			}
+		} else {
+			master = make([]byte, 32)
+			if _, err := rand.Read(master); err != nil {
+				return fmt.Errorf("failed to generate master key: %w", err)
+			}
+			if err := s.keyring.Set(base64.StdEncoding.EncodeToString(master)); err != nil {
+				return fmt.Errorf("failed to save the master key in the keyring: %w", err)
+			}
+		}
+
+	case BackendFile:
+		if creating {
+			salt := make([]byte, 16)
+			if _, err := rand.Read(salt); err != nil {
+				return fmt.Errorf("failed to generate salt: %w", err)
+			}
+			s.file.Salt = base64.StdEncoding.EncodeToString(salt)
+		}
+		salt, err := base64.StdEncoding.DecodeString(s.file.Salt)
+		if err != nil || len(salt) == 0 {
+			return fmt.Errorf("key store %s has an invalid salt", s.path)
+		}
+		passphrase, err := readPassphrase(creating)
+		if err != nil {
+			return err
+		}
+		master, err = scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, 32)
+		if err != nil {
+			return fmt.Errorf("failed to derive master key: %w", err)
+		}
+
+	default:
+		return fmt.Errorf("unknown key store backend %q", s.file.Backend)
+	}
+
+	if creating {
+		check, err := seal(master, "check", checkValue)
+		if err != nil {
+			return err
+		}
+		s.file.Check = check
+		s.master = master
+		return s.save()
+	}
+	if value, err := unseal(master, "check", s.file.Check); err != nil || value != checkValue {
+		if s.file.Backend == BackendFile {
+			return fmt.Errorf("wrong key store passphrase")
+		}
+		return fmt.Errorf("the keyring's master key does not open %s", s.path)
+	}
+	s.master = master
+	return nil
+}
+
+// Put encrypts and saves a key
+func (s *Store) Put(name, key string) error {
+	if err := s.Unlock(); err != nil {
+		return err
+	}
+	sealed, err := seal(s.master, name, key)
+	if err != nil {
+		return err
+	}
+	s.file.Keys[name] = sealed
+	return s.save()
+}
+
+// Get decrypts a key
+func (s *Store) Get(name string) (string, error) {
+	sealed, ok := s.file.Keys[name]
+	if !ok {
+		return "", fmt.Errorf("no key %s in the key store", name)
+	}
+	if err := s.Unlock(); err != nil {
+		return "", err
+	}
+	key, err := unseal(s.master, name, sealed)
+	if err != nil {
+		return "", fmt.Errorf("key %s cannot be decrypted: %w", name, err)
+	}
+	return key, nil
+}
+
+// Has reports whether a key is stored, without unlocking
+func (s *Store) Has(name string) bool {
+	_, ok := s.file.Keys[name]
+	return ok
+}
+
+// Names lists the stored entries
+func (s *Store) Names() []string {
+	names := make([]string, 0, len(s.file.Keys))
+	for name := range s.file.Keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DeleteInstance removes every key of a bastion; it needs no master key
+func (s *Store) DeleteInstance(instanceID string) (int, error) {
+	deleted := 0
+	for name := range s.file.Keys {
+		if strings.HasPrefix(name, instanceID+"/") {
+			delete(s.file.Keys, name)
+			deleted++
+		}
+	}
+	if deleted == 0 {
+		return 0, nil
+	}
+	return deleted, s.save()
+}
+
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(s.file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode key store: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create key store directory: %w", err)
+	}
+	if err := WriteFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("failed to save key store: %w", err)
+	}
+	return nil
+}
+
+// seal encrypts a value; the entry name is authenticated so entries cannot be swapped
+func seal(master []byte, name, value string) (string, error) {
+	aead, err := newAEAD(master)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(name))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func unseal(master []byte, name, value string) (string, error) {
+	aead, err := newAEAD(master)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("malformed entry")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return "", fmt.Errorf("authentication failed")
+	}
+	return string(plaintext), nil
+}
+
+func newAEAD(master []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(master)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// readPassphrase takes the passphrase from the environment or the terminal; a new store asks twice
+func readPassphrase(confirm bool) (string, error) {
+	if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("key store is locked: set %s or run interactively", PassphraseEnv)
+	}
+
+	fmt.Fprint(os.Stderr, "🔐 Key store passphrase: ")
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	if len(passphrase) == 0 {
+		return "", fmt.Errorf("empty key store passphrase")
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "🔐 Repeat passphrase: ")
+		again, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("failed to read passphrase: %w", err)
+		}
+		if string(again) != string(passphrase) {
+			return "", fmt.Errorf("passphrases do not match")
+		}
+	}
+	return string(passphrase), nil
+}
+
+// WriteFileAtomic replaces a file through a private (0600) temporary file in the same
+// directory, so readers never see a partial file
+func WriteFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,161 @@
+package keystore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeKeyring keeps the master key in memory
+type fakeKeyring struct {
+	available bool
+	secret    string
+}
+
+func (f *fakeKeyring) Available() bool { return f.available }
+
+func (f *fakeKeyring) Get() (string, error) {
+	if f.secret == "" {
+		return "", os.ErrNotExist
+	}
+	return f.secret, nil
+}
+
+func (f *fakeKeyring) Set(secret string) error {
+	f.secret = secret
+	return nil
+}
+
+const testKey = "bmV3LWtleS1mb3Itcm90YXRpb24tdGVzdHMtMzJieXQ="
+
+func TestFileBackend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	t.Setenv(PassphraseEnv, "correct horse")
+
+	store, err := open(path, BackendAuto, &fakeKeyring{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Backend() != BackendFile {
+		t.Fatalf("Expected file backend without a keyring, got %s", store.Backend())
+	}
+	if err := store.Put(ClientKeyName("i-0123", "wg0"), testKey); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), testKey) {
+		t.Fatal("Key stored in plaintext")
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected 0600 key store, got %v", info.Mode().Perm())
+	}
+
+	reopened, err := open(path, "", &fakeKeyring{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, err := reopened.Get(ClientKeyName("i-0123", "wg0")); err != nil || key != testKey {
+		t.Errorf("Get = %q, %v", key, err)
+	}
+
+	t.Setenv(PassphraseEnv, "wrong")
+	locked, _ := open(path, "", &fakeKeyring{})
+	if _, err := locked.Get(ClientKeyName("i-0123", "wg0")); err == nil || !strings.Contains(err.Error(), "wrong key store passphrase") {
+		t.Errorf("Expected wrong passphrase error, got %v", err)
+	}
+
+	// Deleting needs no passphrase
+	if deleted, err := locked.DeleteInstance("i-0123"); err != nil || deleted != 1 {
+		t.Errorf("DeleteInstance = %d, %v", deleted, err)
+	}
+	if reopened, _ := open(path, "", &fakeKeyring{}); len(reopened.Names()) != 0 {
+		t.Errorf("Expected an empty store, got %v", reopened.Names())
+	}
+}
+
+func TestKeyringBackend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	keyring := &fakeKeyring{available: true}
+
+	store, err := open(path, BackendAuto, keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Backend() != BackendKeyring {
+		t.Fatalf("Expected keyring backend, got %s", store.Backend())
+	}
+	if err := store.Put(PresharedKeyName("i-0123", "wg1"), testKey); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if keyring.secret == "" {
+		t.Fatal("Master key not saved in the keyring")
+	}
+
+	reopened, _ := open(path, "", keyring)
+	if key, err := reopened.Get(PresharedKeyName("i-0123", "wg1")); err != nil || key != testKey {
+		t.Errorf("Get = %q, %v", key, err)
+	}
+
+	other, _ := open(path, "", &fakeKeyring{available: true, secret: "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="})
+	if _, err := other.Get(PresharedKeyName("i-0123", "wg1")); err == nil {
+		t.Error("Expected a different master key to be rejected")
+	}
+
+	if _, err := open(path, BackendFile, keyring); err == nil {
+		t.Error("Expected switching backends of a non-empty store to fail")
+	}
+	if _, err := open(path, "vault", keyring); err == nil {
+		t.Error("Expected an unknown backend to fail")
+	}
+}
+
+func TestEntriesCannotBeSwapped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	store, _ := open(path, BackendKeyring, &fakeKeyring{available: true})
+	if err := store.Put("i-0123/wg0", testKey); err != nil {
+		t.Fatal(err)
+	}
+	store.file.Keys["i-0123/wg1"] = store.file.Keys["i-0123/wg0"]
+	if _, err := store.Get("i-0123/wg1"); err == nil {
+		t.Error("Expected an entry copied under another name to fail authentication")
+	}
+}
+
+func TestRuntimeDir(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+
+	path, err := Materialize("wg0", []byte("[Interface]\nPrivateKey = "+testKey+"\n"))
+	if err != nil {
+		t.Fatalf("Materialize failed: %v", err)
+	}
+	if filepath.Base(path) != "wg0.conf" {
+		t.Errorf("wg-quick needs the interface name as file name, got %s", path)
+	}
+	dirInfo, _ := os.Stat(filepath.Dir(path))
+	fileInfo, _ := os.Stat(path)
+	if dirInfo.Mode().Perm() != 0700 || fileInfo.Mode().Perm() != 0600 {
+		t.Errorf("Expected 0700 directory and 0600 config, got %v and %v", dirInfo.Mode().Perm(), fileInfo.Mode().Perm())
+	}
+
+	Materialize("wg1", []byte("[Interface]\n"))
+	if paths := ConfigPaths(); len(paths) != 2 {
+		t.Errorf("Expected two materialised configs, got %v", paths)
+	}
+	if ConfigOrName("wg0") != path || ConfigOrName("wg5") != "wg5" {
+		t.Error("ConfigOrName should prefer the materialised config")
+	}
+	if err := Wipe("wg0"); err != nil {
+		t.Fatalf("Wipe failed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Config should be removed")
+	}
+	if err := Wipe("wg0"); err != nil {
+		t.Errorf("Wiping a missing config should succeed: %v", err)
+	}
+	if wiped, err := WipeAll(); err != nil || wiped != 1 {
+		t.Errorf("WipeAll = %d, %v", wiped, err)
+	}
+}
@@ -0,0 +1,128 @@
+package keystore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// RuntimeDir is the private directory holding plaintext configs of running tunnels:
+// $XDG_RUNTIME_DIR/mole (tmpfs on most Linux desktops), else a per-user temp directory.
+// It is created 0700 and refused if it cannot be made private.
+func RuntimeDir() (string, error) {
+	var dir string
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		dir = filepath.Join(runtimeDir, "mole")
+	} else {
+		dir = filepath.Join(os.TempDir(), fmt.Sprintf("mole-%d", os.Getuid()))
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create runtime directory: %w", err)
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to check runtime directory: %w", err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("runtime directory %s is not a directory", dir)
+	}
+	// A directory someone else created in a shared temp dir cannot be chmodded by us
+	if info.Mode().Perm() != 0700 {
+		if err := os.Chmod(dir, 0700); err != nil {
+			return "", fmt.Errorf("runtime directory %s is not private: %w", dir, err)
+		}
+	}
+	return dir, nil
+}
+
+// ConfigPath is where an interface's config is materialised; wg-quick derives the
+// interface name from the file name
+func ConfigPath(iface string) (string, error) {
+	dir, err := RuntimeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, iface+".conf"), nil
+}
+
+// Materialize writes an interface config, with its private key, into the runtime directory
+func Materialize(iface string, config []byte) (string, error) {
+	path, err := ConfigPath(iface)
+	if err != nil {
+		return "", err
+	}
+	if err := WriteFileAtomic(path, config); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return path, nil
+}
+
+// Wipe overwrites and removes an interface's materialised config; a missing one is fine
+func Wipe(iface string) error {
+	path, err := ConfigPath(iface)
+	if err != nil {
+		return err
+	}
+	return wipeFile(path)
+}
+
+// WipeAll wipes every materialised config and returns how many there were
+func WipeAll() (int, error) {
+	if _, err := RuntimeDir(); err != nil {
+		return 0, err
+	}
+	paths := ConfigPaths()
+	for _, path := range paths {
+		if err := wipeFile(path); err != nil {
+			return 0, err
+		}
+	}
+	return len(paths), nil
+}
+
+// ConfigPaths lists the materialised configs. On macOS the running device is a utun, so
+// teardown tries each of them rather than looking one up by interface name.
+func ConfigPaths() []string {
+	dir, err := RuntimeDir()
+	if err != nil {
+		return nil
+	}
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.conf"))
+	return paths
+}
+
+// wipeFile zeroes a file before unlinking it, so the key does not linger in freed blocks
+// of non-tmpfs runtime directories
+func wipeFile(path string) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err == nil {
+		file.Write(make([]byte, info.Size()))
+		file.Sync()
+		file.Close()
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+	return nil
+}
+
+// ConfigOrName is what to pass to `wg-quick down`: the materialised config when there is
+// one, otherwise the interface name for wg-quick's own search path
+func ConfigOrName(iface string) string {
+	if path, err := ConfigPath(iface); err == nil {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return iface
+}
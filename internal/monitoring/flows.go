@@ -0,0 +1,310 @@
+package monitoring
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FlowKey identifies a TCP or UDP connection by its original-direction 5-tuple
+type FlowKey struct {
+	Protocol        string // tcp or udp
+	Source          netip.Addr
+	Destination     netip.Addr
+	SourcePort      uint16
+	DestinationPort uint16
+}
+
+func (k FlowKey) String() string {
+	return fmt.Sprintf("%s %s → %s", k.Protocol,
+		netip.AddrPortFrom(k.Source, k.SourcePort), netip.AddrPortFrom(k.Destination, k.DestinationPort))
+}
+
+// FlowCounters is one connection tracking entry with its byte counts in both directions
+type FlowCounters struct {
+	Key   FlowKey
+	Bytes uint64
+}
+
+// Flow is a tracked connection and its traffic within the detection window
+type Flow struct {
+	Key         FlowKey
+	Bytes       uint64    // Both directions since the connection was first seen
+	WindowBytes uint64    // Both directions within the detection window
+	Rate        float64   // Mbps over the part of the window the flow was seen in
+	FirstSeen   time.Time // When the tracker first saw the connection
+	Elephant    bool      // WindowBytes reached the threshold
+}
+
+// flowSample is a flow's byte count at one sample
+type flowSample struct {
+	at    time.Time
+	bytes uint64
+}
+
+// flowHistory is a flow's samples, oldest first, reaching back to the window start
+type flowHistory struct {
+	firstSeen time.Time
+	samples   []flowSample
+}
+
+// FlowTracker finds elephant flows: connections that move at least threshold bytes within
+// the detection window. It reads connection tracking counters on every Sample.
+type FlowTracker struct {
+	// Destinations limits tracking to flows towards these networks; empty tracks every flow
+	Destinations []netip.Prefix
+
+	threshold  int64
+	window     time.Duration
+	read       func() ([]FlowCounters, error)
+	now        func() time.Time
+	flows      map[FlowKey]*flowHistory
+	lastSample time.Time
+	current    []Flow
+	mu         sync.Mutex
+}
+
+// NewFlowTracker creates a tracker reading the kernel's connection tracking table
+func NewFlowTracker(threshold int64, window time.Duration) *FlowTracker {
+	return &FlowTracker{
+		threshold: threshold,
+		window:    window,
+		read:      readConntrack,
+		now:       time.Now,
+		flows:     make(map[FlowKey]*flowHistory),
+	}
+}
+
+// Sample reads the connection counters and returns the tracked flows, busiest within the
+// window first. Connections that have closed are dropped.
+func (t *FlowTracker) Sample() ([]Flow, error) {
+	counters, err := t.read()
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	seen := make(map[FlowKey]bool)
+	var flows []Flow
+	for _, counter := range counters {
+		if !t.tracked(counter.Key) {
+			continue
+		}
+		seen[counter.Key] = true
+
+		history, exists := t.flows[counter.Key]
+		if !exists {
+			history = &flowHistory{firstSeen: now}
+			// A connection opened since the last sample has carried all its bytes since then
+			if !t.lastSample.IsZero() {
+				history.firstSeen = t.lastSample
+				history.samples = []flowSample{{at: t.lastSample}}
+			}
+			t.flows[counter.Key] = history
+		}
+		history.samples = append(history.samples, flowSample{at: now, bytes: counter.Bytes})
+		// Keep the newest sample at or before the window start as the baseline
+		for len(history.samples) > 1 && !history.samples[1].at.After(now.Add(-t.window)) {
+			history.samples = history.samples[1:]
+		}
+
+		flows = append(flows, t.flow(counter.Key, history))
+	}
+	for key := range t.flows {
+		if !seen[key] {
+			delete(t.flows, key)
+		}
+	}
+	t.lastSample = now
+
+	sort.Slice(flows, func(i, j int) bool { return flows[i].WindowBytes > flows[j].WindowBytes })
+	t.current = flows
+	return flows, nil
+}
+
+// Elephants returns the elephant flows of the last sample
+func (t *FlowTracker) Elephants() []Flow {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var elephants []Flow
+	for _, flow := range t.current {
+		if flow.Elephant {
+			elephants = append(elephants, flow)
+		}
+	}
+	return elephants
+}
+
+// tracked reports whether a flow goes to one of the destinations
+func (t *FlowTracker) tracked(key FlowKey) bool {
+	if len(t.Destinations) == 0 {
+		return true
+	}
+	for _, destination := range t.Destinations {
+		if destination.Contains(key.Destination) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *FlowTracker) flow(key FlowKey, history *flowHistory) Flow {
+	first, last := history.samples[0], history.samples[len(history.samples)-1]
+	flow := Flow{Key: key, Bytes: last.bytes, FirstSeen: history.firstSeen}
+	// Counters only go down when conntrack reuses an entry; start again from this sample
+	if last.bytes < first.bytes {
+		history.samples = history.samples[len(history.samples)-1:]
+		return flow
+	}
+	flow.WindowBytes = last.bytes - first.bytes
+	if elapsed := last.at.Sub(first.at).Seconds(); elapsed > 0 {
+		flow.Rate = float64(flow.WindowBytes) * 8 / elapsed / 1e6
+	}
+	flow.Elephant = t.threshold > 0 && flow.WindowBytes >= uint64(t.threshold)
+	return flow
+}
+
+// parseConntrack reads TCP and UDP entries in /proc/net/nf_conntrack format. Byte counts
+// need connection tracking accounting (net.netfilter.nf_conntrack_acct=1).
+func parseConntrack(data string) ([]FlowCounters, error) {
+	var counters []FlowCounters
+	var unaccounted int
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		entry, ok, err := parseConntrackEntry(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if !entry.accounted {
+			unaccounted++
+			continue
+		}
+		counters = append(counters, FlowCounters{Key: entry.key, Bytes: entry.bytes})
+	}
+	if len(counters) == 0 && unaccounted > 0 {
+		return nil, fmt.Errorf("connection tracking has no byte counts; enable them with 'sysctl -w net.netfilter.nf_conntrack_acct=1'")
+	}
+	return counters, scanner.Err()
+}
+
+// CountConnections returns how many open TCP and UDP connections come from any of sources.
+// Unlike a FlowTracker it does not need byte accounting.
+func CountConnections(sources []netip.Addr) (int, error) {
+	data, err := readConntrackTable()
+	if err != nil {
+		return 0, err
+	}
+	return countConnections(data, sources)
+}
+
+// countConnections counts the entries from sources, leaving out closed TCP connections
+// that conntrack keeps for a while
+func countConnections(data string, sources []netip.Addr) (int, error) {
+	count := 0
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		entry, ok, err := parseConntrackEntry(scanner.Text())
+		if err != nil {
+			return 0, err
+		}
+		if ok && !entry.closed && slices.Contains(sources, entry.key.Source) {
+			count++
+		}
+	}
+	return count, scanner.Err()
+}
+
+// conntrackEntry is one parsed line of the connection tracking table
+type conntrackEntry struct {
+	key       FlowKey
+	bytes     uint64
+	accounted bool // The entry carries byte counts
+	closed    bool // A TCP connection in TIME_WAIT or CLOSE
+}
+
+// parseConntrackEntry parses a line, reporting false for other protocols and entries
+// without addresses
+func parseConntrackEntry(line string) (conntrackEntry, bool, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || (fields[2] != "tcp" && fields[2] != "udp") {
+		return conntrackEntry{}, false, nil
+	}
+
+	entry := conntrackEntry{key: FlowKey{Protocol: fields[2]}}
+	// The first src/dst/sport/dport are the original direction, the second the reply;
+	// each direction has its own bytes=
+	var source, destination, sourcePort, destinationPort bool
+	for _, field := range fields[3:] {
+		name, value, ok := strings.Cut(field, "=")
+		if !ok {
+			if field == "TIME_WAIT" || field == "CLOSE" {
+				entry.closed = true
+			}
+			continue
+		}
+		switch {
+		case name == "src" && !source:
+			entry.key.Source, _ = netip.ParseAddr(value)
+			source = true
+		case name == "dst" && !destination:
+			entry.key.Destination, _ = netip.ParseAddr(value)
+			destination = true
+		case name == "sport" && !sourcePort:
+			port, _ := strconv.ParseUint(value, 10, 16)
+			entry.key.SourcePort = uint16(port)
+			sourcePort = true
+		case name == "dport" && !destinationPort:
+			port, _ := strconv.ParseUint(value, 10, 16)
+			entry.key.DestinationPort = uint16(port)
+			destinationPort = true
+		case name == "bytes":
+			count, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return conntrackEntry{}, false, fmt.Errorf("invalid conntrack byte count %q", value)
+			}
+			entry.bytes += count
+			entry.accounted = true
+		}
+	}
+	if !entry.key.Source.IsValid() || !entry.key.Destination.IsValid() {
+		return conntrackEntry{}, false, nil
+	}
+	return entry, true, nil
+}
+
+// PrintFlows prints the busiest flows of a sample, marking the elephants
+func PrintFlows(flows []Flow, window time.Duration, limit int) {
+	fmt.Printf("\n=== Flows (last %s) ===\n", window)
+	if len(flows) == 0 {
+		fmt.Println("No tracked flows")
+		return
+	}
+	for i, flow := range flows {
+		if i == limit {
+			fmt.Printf("... and %d more\n", len(flows)-limit)
+			break
+		}
+		marker := "  "
+		if flow.Elephant {
+			marker = "🐘"
+		}
+		fmt.Printf("%s %-52s | Window: %9.1f MB | %8.1f Mbps | Total: %9.1f MB\n",
+			marker, flow.Key,
+			float64(flow.WindowBytes)/1024/1024,
+			flow.Rate,
+			float64(flow.Bytes)/1024/1024)
+	}
+}
@@ -0,0 +1,36 @@
+//go:build linux
+
+package monitoring
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// conntrackPath is the kernel's connection tracking table
+const conntrackPath = "/proc/net/nf_conntrack"
+
+// readConntrack reads the kernel's TCP and UDP connections with their byte counts
+func readConntrack() ([]FlowCounters, error) {
+	data, err := readConntrackTable()
+	if err != nil {
+		return nil, err
+	}
+	return parseConntrack(data)
+}
+
+// readConntrackTable reads the connection tracking table
+func readConntrackTable() (string, error) {
+	data, err := os.ReadFile(conntrackPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("connection tracking is not loaded (%s missing); load it with 'modprobe nf_conntrack'", conntrackPath)
+	}
+	if errors.Is(err, os.ErrPermission) {
+		return "", fmt.Errorf("reading %s needs root", conntrackPath)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", conntrackPath, err)
+	}
+	return string(data), nil
+}
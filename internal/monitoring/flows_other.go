@@ -0,0 +1,15 @@
+//go:build !linux
+
+package monitoring
+
+import "fmt"
+
+// readConntrack fails outside Linux, where mole has no connection tracking source
+func readConntrack() ([]FlowCounters, error) {
+	return nil, fmt.Errorf("flow tracking is only supported on Linux")
+}
+
+// readConntrackTable fails outside Linux for the same reason
+func readConntrackTable() (string, error) {
+	return "", fmt.Errorf("connection tracking is only supported on Linux")
+}
@@ -0,0 +1,107 @@
+package monitoring
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+const conntrackSample = `ipv4     2 tcp      6 431999 ESTABLISHED src=192.0.2.10 dst=10.200.2.15 sport=50000 dport=2811 packets=1000 bytes=1500000 src=10.200.2.15 dst=192.0.2.10 sport=2811 dport=50000 packets=500 bytes=26000 [ASSURED] mark=0 zone=0 use=2
+ipv4     2 udp      17 29 src=192.0.2.10 dst=192.0.2.1 sport=40000 dport=53 packets=1 bytes=60 src=192.0.2.1 dst=192.0.2.10 sport=53 dport=40000 packets=1 bytes=120 mark=0 zone=0 use=2
+ipv4     2 icmp     1 29 src=192.0.2.10 dst=10.200.2.15 type=8 code=0 id=7 packets=1 bytes=84 src=10.200.2.15 dst=192.0.2.10 type=0 code=0 id=7 packets=1 bytes=84 mark=0 use=2
+ipv6     10 tcp      6 300 ESTABLISHED src=2001:db8::10 dst=fd6d:6f6c:6500:1::1 sport=41000 dport=443 packets=3 bytes=300 src=fd6d:6f6c:6500:1::1 dst=2001:db8::10 sport=443 dport=41000 packets=3 bytes=400 [ASSURED] mark=0 zone=0 use=2
+`
+
+func TestParseConntrack(t *testing.T) {
+	counters, err := parseConntrack(conntrackSample)
+	if err != nil {
+		t.Fatalf("parseConntrack failed: %v", err)
+	}
+	if len(counters) != 3 {
+		t.Fatalf("Expected the TCP and UDP entries only, got %+v", counters)
+	}
+
+	// Original direction addresses, both directions' bytes
+	first := counters[0]
+	if first.Key.String() != "tcp 192.0.2.10:50000 → 10.200.2.15:2811" || first.Bytes != 1526000 {
+		t.Errorf("Unexpected first entry %s with %d bytes", first.Key, first.Bytes)
+	}
+	if counters[2].Key.Destination != netip.MustParseAddr("fd6d:6f6c:6500:1::1") || counters[2].Key.DestinationPort != 443 {
+		t.Errorf("Unexpected IPv6 entry %s", counters[2].Key)
+	}
+
+	// Without accounting there are no counts to measure
+	unaccounted := "ipv4     2 tcp      6 431999 ESTABLISHED src=192.0.2.10 dst=10.200.2.15 sport=50000 dport=2811 src=10.200.2.15 dst=192.0.2.10 sport=2811 dport=50000 [ASSURED] mark=0 use=2\n"
+	if _, err := parseConntrack(unaccounted); err == nil || !strings.Contains(err.Error(), "nf_conntrack_acct") {
+		t.Errorf("Expected an accounting error, got %v", err)
+	}
+}
+
+func TestCountConnections(t *testing.T) {
+	closed := "ipv4     2 tcp      6 110 TIME_WAIT src=192.0.2.10 dst=10.200.2.15 sport=50002 dport=2811 src=10.200.2.15 dst=192.0.2.10 sport=2811 dport=50002 [ASSURED] mark=0 use=2\n"
+	sources := []netip.Addr{netip.MustParseAddr("192.0.2.10")}
+
+	// TCP and UDP from the source, without the ICMP entry or the closed connection; counting
+	// needs no accounting
+	count, err := countConnections(conntrackSample+closed, sources)
+	if err != nil || count != 2 {
+		t.Errorf("Expected 2 open connections, got %d (%v)", count, err)
+	}
+	if count, _ := countConnections(conntrackSample, []netip.Addr{netip.MustParseAddr("2001:db8::10")}); count != 1 {
+		t.Errorf("Expected the IPv6 connection, got %d", count)
+	}
+	if count, _ := countConnections(conntrackSample, nil); count != 0 {
+		t.Errorf("Expected no connections without sources, got %d", count)
+	}
+}
+
+func TestFlowTrackerElephants(t *testing.T) {
+	elephant := FlowKey{Protocol: "tcp", Source: netip.MustParseAddr("192.0.2.10"), Destination: netip.MustParseAddr("10.200.2.15"), SourcePort: 50000, DestinationPort: 2811}
+	mouse := FlowKey{Protocol: "tcp", Source: netip.MustParseAddr("192.0.2.10"), Destination: netip.MustParseAddr("10.200.2.16"), SourcePort: 50001, DestinationPort: 22}
+	local := FlowKey{Protocol: "udp", Source: netip.MustParseAddr("192.0.2.10"), Destination: netip.MustParseAddr("192.0.2.1"), SourcePort: 40000, DestinationPort: 53}
+
+	clock := time.Unix(1700000000, 0)
+	var counters []FlowCounters
+	tracker := NewFlowTracker(100<<20, time.Minute)
+	tracker.Destinations = []netip.Prefix{netip.MustParsePrefix("10.200.0.0/16")}
+	tracker.read = func() ([]FlowCounters, error) { return counters, nil }
+	tracker.now = func() time.Time { return clock }
+
+	// Long-running connections have no baseline on the first sample
+	counters = []FlowCounters{{Key: mouse, Bytes: 500 << 20}, {Key: local, Bytes: 1 << 30}}
+	flows, _ := tracker.Sample()
+	if len(flows) != 1 || flows[0].WindowBytes != 0 {
+		t.Fatalf("Expected only the tunnel flow with no window bytes, got %+v", flows)
+	}
+
+	// A new connection counts from the previous sample and the busiest comes first
+	clock = clock.Add(20 * time.Second)
+	counters = []FlowCounters{{Key: mouse, Bytes: 501 << 20}, {Key: elephant, Bytes: 80 << 20}}
+	flows, _ = tracker.Sample()
+	if flows[0].Key != elephant || flows[0].WindowBytes != 80<<20 || flows[0].Elephant {
+		t.Errorf("Expected the new flow first below the threshold, got %+v", flows[0])
+	}
+	if rate := flows[0].Rate; rate < 33.5 || rate > 33.6 {
+		t.Errorf("Expected about 33.5 Mbps, got %.2f", rate)
+	}
+
+	clock = clock.Add(20 * time.Second)
+	counters = []FlowCounters{{Key: mouse, Bytes: 502 << 20}, {Key: elephant, Bytes: 160 << 20}}
+	tracker.Sample()
+	elephants := tracker.Elephants()
+	if len(elephants) != 1 || elephants[0].Key != elephant || elephants[0].WindowBytes != 160<<20 {
+		t.Fatalf("Expected the transfer to be an elephant, got %+v", elephants)
+	}
+
+	// Bytes older than the window no longer count
+	clock = clock.Add(50 * time.Second)
+	counters = []FlowCounters{{Key: elephant, Bytes: 170 << 20}}
+	flows, _ = tracker.Sample()
+	if flows[0].WindowBytes != 90<<20 || flows[0].Elephant {
+		t.Errorf("Expected 90 MB within the window, got %+v", flows[0])
+	}
+	if len(flows) != 1 {
+		t.Errorf("Closed connections should be dropped, got %+v", flows)
+	}
+}
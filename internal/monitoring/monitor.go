@@ -169,6 +169,26 @@ func (m *Monitor) RemoveTunnel(interfaceName string) {
 	m.logger.Info("Removed tunnel from monitoring", "interface", interfaceName)
 }
 
+// RecordTunnelCounters updates a tunnel's byte counters from another source, such as the
+// tunnel backend, and derives its throughput from the previous sample. It returns the
+// throughput in Mbps and false when there is no earlier sample to compare with.
+func (m *Monitor) RecordTunnelCounters(interfaceName string, bytesIn, bytesOut uint64, at time.Time) (float64, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	metrics := &TunnelMetrics{Interface: interfaceName, Timestamp: at, BytesIn: bytesIn, BytesOut: bytesOut}
+	prev, exists := m.tunnelMetrics[interfaceName]
+	m.tunnelMetrics[interfaceName] = metrics
+
+	// Counters reset when an interface is recreated; start again from this sample
+	if !exists || prev.Timestamp.IsZero() || !at.After(prev.Timestamp) || bytesIn < prev.BytesIn || bytesOut < prev.BytesOut {
+		return 0, false
+	}
+	bytes := (bytesIn - prev.BytesIn) + (bytesOut - prev.BytesOut)
+	metrics.Throughput = float64(bytes) * 8 / at.Sub(prev.Timestamp).Seconds() / 1e6
+	return metrics.Throughput, true
+}
+
 // monitorLoop is the main monitoring loop
 func (m *Monitor) monitorLoop(ctx context.Context) {
 	ticker := time.NewTicker(m.updateInterval)
@@ -0,0 +1,168 @@
+package peer
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/research-computing/mole/internal/keystore"
+)
+
+// ClientConfig is the subset of a wg-quick client config a new peer inherits
+type ClientConfig struct {
+	Address         []netip.Prefix // The deploying client's addresses, which give the tunnel networks
+	DNS             string
+	MTU             string
+	ServerPublicKey string
+	Endpoint        string
+	AllowedIPs      []string
+	PresharedKey    bool // The tunnel uses a pre-shared key, so new peers get one too
+}
+
+// ConfigSearchPath lists where an interface's client config may be: the runtime directory
+// of a running tunnel first, then the locations older releases of mole up wrote to
+func ConfigSearchPath(iface string) []string {
+	home := os.Getenv("HOME")
+	var paths []string
+	if path, err := keystore.ConfigPath(iface); err == nil {
+		paths = append(paths, path)
+	}
+	return append(paths,
+		filepath.Join(home, ".mole", "tunnels", iface+".conf"),
+		filepath.Join(home, ".config", "wireguard", iface+".conf"),
+		filepath.Join("/etc", "wireguard", iface+".conf"),
+	)
+}
+
+// FindClientConfig reads the first readable client config for an interface
+func FindClientConfig(iface string) (*ClientConfig, string, error) {
+	for _, path := range ConfigSearchPath(iface) {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		config, err := ReadClientConfig(path)
+		if err != nil {
+			return nil, "", err
+		}
+		return config, path, nil
+	}
+	return nil, "", fmt.Errorf("no client config for %s (looked in %s); run 'mole up' or pass --config",
+		iface, strings.Join(ConfigSearchPath(iface), ", "))
+}
+
+// ReadClientConfig parses a wg-quick client config with a single [Peer]
+func ReadClientConfig(path string) (*ClientConfig, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client config: %w", err)
+	}
+	defer file.Close()
+
+	config := &ClientConfig{}
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			section = strings.ToLower(strings.Trim(line, "[]"))
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		switch section + "." + key {
+		case "interface.Address":
+			for _, address := range splitList(value) {
+				prefix, err := netip.ParsePrefix(address)
+				if err != nil {
+					return nil, fmt.Errorf("invalid address %q in %s: %w", address, path, err)
+				}
+				config.Address = append(config.Address, prefix)
+			}
+		case "interface.DNS":
+			config.DNS = value
+		case "interface.MTU":
+			config.MTU = value
+		case "peer.PublicKey":
+			config.ServerPublicKey = value
+		case "peer.Endpoint":
+			config.Endpoint = value
+		case "peer.AllowedIPs":
+			config.AllowedIPs = splitList(value)
+		case "peer.PresharedKey":
+			config.PresharedKey = value != ""
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read client config: %w", err)
+	}
+
+	if config.ServerPublicKey == "" || config.Endpoint == "" {
+		return nil, fmt.Errorf("%s has no bastion [Peer] with PublicKey and Endpoint", path)
+	}
+	if len(config.Address) == 0 {
+		return nil, fmt.Errorf("%s has no interface Address", path)
+	}
+	return config, nil
+}
+
+// Network returns the tunnel network of the given address family
+func (c *ClientConfig) Network(ipv6 bool) (netip.Prefix, bool) {
+	for _, address := range c.Address {
+		if address.Addr().Is6() == ipv6 {
+			return address.Masked(), true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// Render builds the peer's client config: its own key and addresses, the bastion as [Peer].
+// An empty presharedKey leaves the PresharedKey line out.
+func (c *ClientConfig) Render(peer Peer, privateKey, presharedKey string) string {
+	address := peer.Address
+	if peer.IPv6Address != "" {
+		address += ", " + peer.IPv6Address
+	}
+
+	var config strings.Builder
+	config.WriteString(fmt.Sprintf("# mole peer %s\n", peer.Name))
+	config.WriteString("[Interface]\n")
+	config.WriteString(fmt.Sprintf("PrivateKey = %s\n", privateKey))
+	config.WriteString(fmt.Sprintf("Address = %s\n", address))
+	if c.MTU != "" {
+		config.WriteString(fmt.Sprintf("MTU = %s\n", c.MTU))
+	}
+	if c.DNS != "" {
+		config.WriteString(fmt.Sprintf("DNS = %s\n", c.DNS))
+	}
+	config.WriteString("\n[Peer]\n")
+	config.WriteString(fmt.Sprintf("PublicKey = %s\n", c.ServerPublicKey))
+	if presharedKey != "" {
+		config.WriteString(fmt.Sprintf("PresharedKey = %s\n", presharedKey))
+	}
+	config.WriteString(fmt.Sprintf("Endpoint = %s\n", c.Endpoint))
+	config.WriteString(fmt.Sprintf("AllowedIPs = %s\n", strings.Join(c.AllowedIPs, ", ")))
+	config.WriteString("PersistentKeepalive = 25\n")
+
+	return config.String()
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
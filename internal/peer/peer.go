@@ -0,0 +1,175 @@
+package peer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"time"
+)
+
+// namePattern keeps peer names usable as file names and WireGuard comments
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// firstPeerHost skips the bastion (.1) and the deploying client (.2) of every tunnel network
+const firstPeerHost = 3
+
+// Peer is an additional client of a bastion tunnel
+type Peer struct {
+	Name        string    `json:"name"`
+	Tunnel      int       `json:"tunnel"`
+	Interface   string    `json:"interface"`
+	Address     string    `json:"address"`                // IPv4 with the tunnel prefix length
+	IPv6Address string    `json:"ipv6_address,omitempty"` // Set on dual-stack tunnels
+	PublicKey   string    `json:"public_key"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// AllowedIPs are the host routes the bastion installs for the peer
+func (p Peer) AllowedIPs() []string {
+	var allowed []string
+	for _, address := range []string{p.Address, p.IPv6Address} {
+		if prefix, err := netip.ParsePrefix(address); err == nil {
+			allowed = append(allowed, netip.PrefixFrom(prefix.Addr(), prefix.Addr().BitLen()).String())
+		}
+	}
+	return allowed
+}
+
+// Registry is the set of peers added to one bastion, persisted under ~/.mole/peers
+type Registry struct {
+	InstanceID string `json:"instance_id"`
+	Peers      []Peer `json:"peers"`
+
+	path string
+}
+
+// Dir is where peer registries and generated client configs are kept
+func Dir() string {
+	return filepath.Join(os.Getenv("HOME"), ".mole", "peers")
+}
+
+// ConfigPath is the default location of a peer's generated client config
+func ConfigPath(instanceID, name string) string {
+	return filepath.Join(Dir(), instanceID, name+".conf")
+}
+
+// ValidateName checks a peer name
+func ValidateName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid peer name %q: use up to 32 lowercase letters, digits and dashes", name)
+	}
+	return nil
+}
+
+// Load reads a bastion's registry; a missing file is an empty registry
+func Load(instanceID string) (*Registry, error) {
+	registry := &Registry{InstanceID: instanceID, path: filepath.Join(Dir(), instanceID+".json")}
+
+	data, err := os.ReadFile(registry.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return registry, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read peer registry: %w", err)
+	}
+	if err := json.Unmarshal(data, registry); err != nil {
+		return nil, fmt.Errorf("failed to parse peer registry %s: %w", registry.path, err)
+	}
+	return registry, nil
+}
+
+// Save writes the registry readable only by the current user
+func (r *Registry) Save() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0700); err != nil {
+		return fmt.Errorf("failed to create peer directory: %w", err)
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode peer registry: %w", err)
+	}
+	if err := os.WriteFile(r.path, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to save peer registry: %w", err)
+	}
+	return nil
+}
+
+// Get returns the named peer
+func (r *Registry) Get(name string) (Peer, bool) {
+	for _, peer := range r.Peers {
+		if peer.Name == name {
+			return peer, true
+		}
+	}
+	return Peer{}, false
+}
+
+// Add records a peer, rejecting duplicate names and keys
+func (r *Registry) Add(peer Peer) error {
+	if err := ValidateName(peer.Name); err != nil {
+		return err
+	}
+	for _, existing := range r.Peers {
+		if existing.Name == peer.Name {
+			return fmt.Errorf("peer %q already exists", peer.Name)
+		}
+		if existing.PublicKey == peer.PublicKey {
+			return fmt.Errorf("public key already belongs to peer %q", existing.Name)
+		}
+	}
+	r.Peers = append(r.Peers, peer)
+	return nil
+}
+
+// Remove drops the named peer and returns it
+func (r *Registry) Remove(name string) (Peer, error) {
+	for i, peer := range r.Peers {
+		if peer.Name == name {
+			r.Peers = slices.Delete(r.Peers, i, i+1)
+			return peer, nil
+		}
+	}
+	return Peer{}, fmt.Errorf("peer %q not found", name)
+}
+
+// Addresses returns the host addresses already assigned on a tunnel
+func (r *Registry) Addresses(tunnel int) []netip.Addr {
+	var used []netip.Addr
+	for _, peer := range r.Peers {
+		if peer.Tunnel != tunnel {
+			continue
+		}
+		for _, address := range []string{peer.Address, peer.IPv6Address} {
+			if prefix, err := netip.ParsePrefix(address); err == nil {
+				used = append(used, prefix.Addr())
+			}
+		}
+	}
+	return used
+}
+
+// Allocate returns the lowest free host address in network, as an address with the network's
+// prefix length. The bastion and deploying client addresses are never handed out.
+func Allocate(network netip.Prefix, used []netip.Addr) (netip.Prefix, error) {
+	network = network.Masked()
+
+	addr := network.Addr()
+	for i := 0; i < firstPeerHost; i++ {
+		addr = addr.Next()
+	}
+
+	for ; addr.IsValid() && network.Contains(addr); addr = addr.Next() {
+		// The IPv4 broadcast address is the last in the network
+		if addr.Is4() && !network.Contains(addr.Next()) {
+			break
+		}
+		if !slices.Contains(used, addr) {
+			return netip.PrefixFrom(addr, network.Bits()), nil
+		}
+	}
+	return netip.Prefix{}, fmt.Errorf("no free peer addresses in %s", network)
+}
@@ -0,0 +1,172 @@
+package peer
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAllocate(t *testing.T) {
+	network := netip.MustParsePrefix("10.100.1.0/24")
+
+	address, err := Allocate(network, nil)
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+	if address.String() != "10.100.1.3/24" {
+		t.Errorf("Expected first peer at 10.100.1.3/24, got %s", address)
+	}
+
+	used := []netip.Addr{netip.MustParseAddr("10.100.1.3"), netip.MustParseAddr("10.100.1.5")}
+	address, _ = Allocate(network, used)
+	if address.String() != "10.100.1.4/24" {
+		t.Errorf("Expected lowest free 10.100.1.4/24, got %s", address)
+	}
+
+	address, err = Allocate(netip.MustParsePrefix("fd6d:6f6c:6500:1::/64"), nil)
+	if err != nil || address.String() != "fd6d:6f6c:6500:1::3/64" {
+		t.Errorf("Expected fd6d:6f6c:6500:1::3/64, got %s (%v)", address, err)
+	}
+
+	// A /29 has hosts .1-.6: bastion, client, then four peers; the broadcast is never used
+	small := netip.MustParsePrefix("10.0.0.0/29")
+	used = nil
+	for i := 0; i < 4; i++ {
+		address, err := Allocate(small, used)
+		if err != nil {
+			t.Fatalf("Allocate %d failed: %v", i, err)
+		}
+		used = append(used, address.Addr())
+	}
+	if _, err := Allocate(small, used); err == nil {
+		t.Error("Expected exhausted network to fail")
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	registry, err := Load("i-0123")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(registry.Peers) != 0 {
+		t.Fatalf("Expected empty registry, got %v", registry.Peers)
+	}
+
+	dtn := Peer{Name: "dtn-1", Interface: "wg0", Address: "10.100.1.3/24", IPv6Address: "fd6d:6f6c:6500:1::3/64", PublicKey: "key-1"}
+	if err := registry.Add(dtn); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := registry.Add(Peer{Name: "dtn-1", PublicKey: "key-2"}); err == nil {
+		t.Error("Expected duplicate name to be rejected")
+	}
+	if err := registry.Add(Peer{Name: "dtn-2", PublicKey: "key-1"}); err == nil {
+		t.Error("Expected duplicate key to be rejected")
+	}
+	if err := registry.Add(Peer{Name: "Bad Name", PublicKey: "key-3"}); err == nil {
+		t.Error("Expected invalid name to be rejected")
+	}
+	if err := registry.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(Dir(), "i-0123.json"))
+	if err != nil {
+		t.Fatalf("Registry not written: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected registry mode 0600, got %o", info.Mode().Perm())
+	}
+
+	loaded, err := Load("i-0123")
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if got, ok := loaded.Get("dtn-1"); !ok || got.PublicKey != "key-1" {
+		t.Errorf("Expected dtn-1 after reload, got %+v", loaded.Peers)
+	}
+	if used := loaded.Addresses(0); len(used) != 2 {
+		t.Errorf("Expected IPv4 and IPv6 addresses in use, got %v", used)
+	}
+	if allowed := dtn.AllowedIPs(); strings.Join(allowed, ",") != "10.100.1.3/32,fd6d:6f6c:6500:1::3/128" {
+		t.Errorf("Unexpected allowed IPs: %v", allowed)
+	}
+
+	if _, err := loaded.Remove("dtn-1"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if _, err := loaded.Remove("dtn-1"); err == nil {
+		t.Error("Expected removing a missing peer to fail")
+	}
+}
+
+func TestClientConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wg0.conf")
+	primary := `[Interface]
+PrivateKey = cHJpdmF0ZQ==
+Address = 10.100.1.2/24, fd6d:6f6c:6500:1::2/64
+MTU = 1500
+DNS = 10.0.0.2
+
+[Peer]
+PublicKey = c2VydmVy
+Endpoint = 203.0.113.10:51820
+AllowedIPs = 10.0.2.0/24, 10.100.1.0/24
+PersistentKeepalive = 25
+`
+	if err := os.WriteFile(path, []byte(primary), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ReadClientConfig(path)
+	if err != nil {
+		t.Fatalf("ReadClientConfig failed: %v", err)
+	}
+	if network, ok := config.Network(false); !ok || network.String() != "10.100.1.0/24" {
+		t.Errorf("Unexpected IPv4 network: %s", network)
+	}
+	if network, ok := config.Network(true); !ok || network.String() != "fd6d:6f6c:6500:1::/64" {
+		t.Errorf("Unexpected IPv6 network: %s", network)
+	}
+
+	if config.PresharedKey {
+		t.Error("Config without a PresharedKey reported one")
+	}
+	rendered := config.Render(Peer{Name: "dtn-1", Address: "10.100.1.3/24", IPv6Address: "fd6d:6f6c:6500:1::3/64"}, "bmV3", "")
+	if strings.Contains(rendered, "PresharedKey") {
+		t.Errorf("Unexpected PresharedKey without a PSK:\n%s", rendered)
+	}
+	for _, check := range []string{
+		"PrivateKey = bmV3\n",
+		"Address = 10.100.1.3/24, fd6d:6f6c:6500:1::3/64\n",
+		"DNS = 10.0.0.2\n",
+		"PublicKey = c2VydmVy\n",
+		"Endpoint = 203.0.113.10:51820\n",
+		"AllowedIPs = 10.0.2.0/24, 10.100.1.0/24\n",
+	} {
+		if !strings.Contains(rendered, check) {
+			t.Errorf("Peer config missing %q:\n%s", check, rendered)
+		}
+	}
+
+	withPSK := strings.Replace(primary, "PublicKey = c2VydmVy\n", "PublicKey = c2VydmVy\nPresharedKey = cHNr\n", 1)
+	if err := os.WriteFile(path, []byte(withPSK), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if config, err = ReadClientConfig(path); err != nil || !config.PresharedKey {
+		t.Fatalf("Expected a PresharedKey tunnel, got %+v (%v)", config, err)
+	}
+	if rendered := config.Render(Peer{Name: "dtn-2", Address: "10.100.1.4/24"}, "bmV3", "bmV3LXBzaw=="); !strings.Contains(rendered, "PresharedKey = bmV3LXBzaw==\n") {
+		t.Errorf("Peer config missing its PSK:\n%s", rendered)
+	}
+
+	if err := os.WriteFile(path, []byte("[Interface]\nAddress = 10.100.1.2/24\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadClientConfig(path); err == nil {
+		t.Error("Expected config without a bastion peer to fail")
+	}
+}
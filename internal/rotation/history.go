@@ -0,0 +1,97 @@
+package rotation
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Record is one line of the rotation history
+type Record struct {
+	Time         time.Time `json:"time"`
+	InstanceID   string    `json:"instance_id"`
+	Interface    string    `json:"interface"`
+	Key          string    `json:"key,omitempty"` // KeyServer for the bastion's key; empty for the client key
+	OldPublicKey string    `json:"old_public_key"`
+	NewPublicKey string    `json:"new_public_key,omitempty"`
+	Result       string    `json:"result"` // completed, rolled_back or failed
+	Error        string    `json:"error,omitempty"`
+}
+
+// KeyServer marks records of the bastion's key; older records only cover client keys
+const KeyServer = "server"
+
+// Results recorded in the history
+const (
+	ResultCompleted  = "completed"
+	ResultRolledBack = "rolled_back"
+	ResultFailed     = "failed"
+)
+
+// HistoryPath is the append-only rotation log, one JSON record per line
+func HistoryPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".mole", "rotations.log")
+}
+
+// AppendRecord adds a record to the history
+func AppendRecord(record Record) error {
+	path := HistoryPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open rotation history: %w", err)
+	}
+	defer file.Close()
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode rotation record: %w", err)
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write rotation history: %w", err)
+	}
+	return nil
+}
+
+// ReadHistory returns every record, oldest first; a missing log is empty
+func ReadHistory() ([]Record, error) {
+	file, err := os.Open(HistoryPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rotation history: %w", err)
+	}
+	defer file.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("corrupt rotation history line %d: %w", len(records)+1, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// LastCompleted returns when an interface's key was last rotated successfully; key is
+// KeyServer for the bastion's key and empty for the client key
+func LastCompleted(records []Record, instanceID, iface, key string) (time.Time, bool) {
+	var last time.Time
+	for _, record := range records {
+		if record.InstanceID == instanceID && record.Interface == iface && record.Key == key &&
+			record.Result == ResultCompleted && record.Time.After(last) {
+			last = record.Time
+		}
+	}
+	return last, !last.IsZero()
+}
@@ -0,0 +1,432 @@
+// Package rotation replaces a tunnel's keys without dropping the tunnel. For the client key
+// the bastion agent moves the peer's allowed IPs to the new key and keeps the old one until
+// the new key has completed a handshake, so traffic only pauses for the re-handshake. The
+// bastion's own key is swapped after the client has switched its peer, and put back if no
+// handshake follows.
+package rotation
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/research-computing/mole/internal/agent"
+	"github.com/research-computing/mole/internal/keystore"
+	"github.com/research-computing/mole/internal/tunnel"
+)
+
+// privateKeyLine matches the [Interface] PrivateKey of a wg-quick config
+var privateKeyLine = regexp.MustCompile(`(?m)^(PrivateKey\s*=\s*)(\S+)[ \t]*$`)
+
+// peerKeyLine and presharedKeyLine match the bastion's [Peer] PublicKey and PresharedKey;
+// the other lines are what a replaced peer carries over
+var (
+	peerKeyLine      = regexp.MustCompile(`(?m)^(PublicKey\s*=\s*)(\S+)[ \t]*$`)
+	presharedKeyLine = regexp.MustCompile(`(?m)^(PresharedKey\s*=\s*)(\S+)[ \t]*$`)
+	endpointLine     = regexp.MustCompile(`(?m)^Endpoint\s*=\s*(\S+)[ \t]*$`)
+	allowedIPsLine   = regexp.MustCompile(`(?m)^AllowedIPs\s*=\s*(.+?)[ \t]*$`)
+	keepaliveLine    = regexp.MustCompile(`(?m)^PersistentKeepalive\s*=\s*(\S+)[ \t]*$`)
+)
+
+// Bastion is the agent API used for rotation; *agent.Client implements it
+type Bastion interface {
+	StartRotation(ctx context.Context, iface string, req agent.RotationRequest) (*agent.RotationStatus, error)
+	StartServerRotation(ctx context.Context, iface string, req agent.ServerRotationRequest) (*agent.RotationStatus, error)
+	Rotation(ctx context.Context, iface string) (*agent.RotationStatus, error)
+}
+
+// Local switches the keys of a running local interface
+type Local interface {
+	SetKeys(ctx context.Context, iface string, keys LocalKeys) error
+	ReplacePeer(ctx context.Context, iface, oldPublicKey string, peer LocalPeer) error
+}
+
+// LocalKeys are the client-side keys of a tunnel. PresharedKey is only set for tunnels that
+// use one, and applies to the bastion peer identified by PeerPublicKey.
+type LocalKeys struct {
+	PrivateKey    string
+	PeerPublicKey string
+	PresharedKey  string
+}
+
+// LocalPeer is the bastion peer of a tunnel as the client config describes it
+type LocalPeer struct {
+	PublicKey           string
+	Endpoint            string
+	AllowedIPs          string
+	PresharedKey        string
+	PersistentKeepalive string
+}
+
+// Rotator rotates keys of one bastion's tunnels
+type Rotator struct {
+	InstanceID   string
+	Bastion      Bastion
+	Local        Local
+	Timeout      time.Duration // Bastion-side wait for the new key's handshake
+	PollInterval time.Duration
+	Keys         *keystore.Store // Updated with the new keys when set
+}
+
+// Rotate replaces the client key of iface, whose wg-quick config is at configPath, along with
+// its pre-shared key if it has one. The config is only rewritten once the bastion confirms the
+// new key; on rollback the old keys are restored locally. The outcome is always appended to the history.
+func (r *Rotator) Rotate(ctx context.Context, iface, configPath string) (Record, error) {
+	record := Record{Time: time.Now().UTC(), InstanceID: r.InstanceID, Interface: iface}
+
+	err := r.rotate(ctx, iface, configPath, &record)
+	if err != nil && record.Result == "" {
+		record.Result = ResultFailed
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if historyErr := AppendRecord(record); historyErr != nil && err == nil {
+		err = historyErr
+	}
+	return record, err
+}
+
+func (r *Rotator) rotate(ctx context.Context, iface, configPath string, record *Record) error {
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", configPath, err)
+	}
+	match := privateKeyLine.FindSubmatch(content)
+	if match == nil {
+		return fmt.Errorf("%s has no PrivateKey", configPath)
+	}
+	oldKeys := LocalKeys{PrivateKey: string(match[2])}
+	oldPublicKey, err := tunnel.PublicKeyFromPrivate(oldKeys.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("%s: %w", configPath, err)
+	}
+	record.OldPublicKey = oldPublicKey
+
+	newPrivateKey, newPublicKey, err := tunnel.GenerateWireGuardKeys()
+	if err != nil {
+		return err
+	}
+	record.NewPublicKey = newPublicKey
+	newKeys := LocalKeys{PrivateKey: newPrivateKey}
+
+	// A tunnel with a pre-shared key gets a fresh one with the new key pair
+	if match := presharedKeyLine.FindSubmatch(content); match != nil {
+		peer := peerKeyLine.FindSubmatch(content)
+		if peer == nil {
+			return fmt.Errorf("%s has no peer PublicKey", configPath)
+		}
+		oldKeys.PeerPublicKey, oldKeys.PresharedKey = string(peer[2]), string(match[2])
+		newKeys.PeerPublicKey = oldKeys.PeerPublicKey
+		if newKeys.PresharedKey, err = tunnel.GeneratePresharedKey(); err != nil {
+			return err
+		}
+	}
+
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = agent.DefaultRotationTimeout
+	}
+	// A locked store must fail before the bastion starts swapping keys
+	if r.Keys != nil {
+		if err := r.Keys.Unlock(); err != nil {
+			return fmt.Errorf("failed to unlock key store: %w", err)
+		}
+	}
+	if _, err := r.Bastion.StartRotation(ctx, iface, agent.RotationRequest{
+		OldPublicKey:    oldPublicKey,
+		NewPublicKey:    newPublicKey,
+		TimeoutSeconds:  int(timeout / time.Second),
+		NewPresharedKey: newKeys.PresharedKey,
+	}); err != nil {
+		return fmt.Errorf("failed to start rotation on the bastion: %w", err)
+	}
+
+	// The bastion rolls back by itself if the switch fails and no handshake arrives
+	if err := r.Local.SetKeys(ctx, iface, newKeys); err != nil {
+		return fmt.Errorf("failed to switch the local key: %w", err)
+	}
+
+	status, err := r.wait(ctx, iface, timeout)
+	if err != nil || status.State != agent.RotationCompleted {
+		if restoreErr := r.Local.SetKeys(ctx, iface, oldKeys); restoreErr != nil {
+			return fmt.Errorf("rotation failed and the old key could not be restored: %w", restoreErr)
+		}
+		if err != nil {
+			return err
+		}
+		record.Result = ResultRolledBack
+		return fmt.Errorf("bastion rolled back the rotation: %s", status.Error)
+	}
+
+	record.Result = ResultCompleted
+	updated := privateKeyLine.ReplaceAll(content, []byte("${1}"+newKeys.PrivateKey))
+	if newKeys.PresharedKey != "" {
+		updated = presharedKeyLine.ReplaceAll(updated, []byte("${1}"+newKeys.PresharedKey))
+	}
+	if err := keystore.WriteFileAtomic(configPath, updated); err != nil {
+		return fmt.Errorf("new key is live but %s was not updated: %w", configPath, err)
+	}
+	if err := r.storeKeys(iface, newKeys); err != nil {
+		return fmt.Errorf("new key is live but the key store was not updated: %w", err)
+	}
+	if status.Error != "" {
+		return fmt.Errorf("rotation completed with a bastion warning: %s", status.Error)
+	}
+	return nil
+}
+
+// RotateServer replaces the bastion's key on iface. The bastion generates the key; this host
+// switches its peer to it before the bastion starts using it, and the config at configPath is
+// only rewritten once the bastion sees a handshake with the new key. keyTag is the instance
+// tag the bastion republishes its public key under. The outcome is appended to the history.
+func (r *Rotator) RotateServer(ctx context.Context, iface, configPath, keyTag string) (Record, error) {
+	record := Record{Time: time.Now().UTC(), InstanceID: r.InstanceID, Interface: iface, Key: KeyServer}
+
+	err := r.rotateServer(ctx, iface, configPath, keyTag, &record)
+	if err != nil && record.Result == "" {
+		record.Result = ResultFailed
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if historyErr := AppendRecord(record); historyErr != nil && err == nil {
+		err = historyErr
+	}
+	return record, err
+}
+
+func (r *Rotator) rotateServer(ctx context.Context, iface, configPath, keyTag string, record *Record) error {
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", configPath, err)
+	}
+	match := privateKeyLine.FindSubmatch(content)
+	if match == nil {
+		return fmt.Errorf("%s has no PrivateKey", configPath)
+	}
+	clientPublicKey, err := tunnel.PublicKeyFromPrivate(string(match[2]))
+	if err != nil {
+		return fmt.Errorf("%s: %w", configPath, err)
+	}
+	oldPeer, err := configPeer(content)
+	if err != nil {
+		return fmt.Errorf("%s: %w", configPath, err)
+	}
+	record.OldPublicKey = oldPeer.PublicKey
+
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = agent.DefaultRotationTimeout
+	}
+	started, err := r.Bastion.StartServerRotation(ctx, iface, agent.ServerRotationRequest{
+		OldPublicKey:   oldPeer.PublicKey,
+		PeerPublicKey:  clientPublicKey,
+		TimeoutSeconds: int(timeout / time.Second),
+		KeyTag:         keyTag,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start rotation on the bastion: %w", err)
+	}
+	record.NewPublicKey = started.NewPublicKey
+
+	// The bastion switches keys after a short delay and rolls back by itself if this fails
+	newPeer := oldPeer
+	newPeer.PublicKey = started.NewPublicKey
+	if err := r.Local.ReplacePeer(ctx, iface, oldPeer.PublicKey, newPeer); err != nil {
+		return fmt.Errorf("failed to switch the local peer: %w", err)
+	}
+
+	status, err := r.wait(ctx, iface, timeout)
+	if err != nil || status.State != agent.RotationCompleted {
+		if restoreErr := r.Local.ReplacePeer(ctx, iface, newPeer.PublicKey, oldPeer); restoreErr != nil {
+			return fmt.Errorf("rotation failed and the old peer could not be restored: %w", restoreErr)
+		}
+		if err != nil {
+			return err
+		}
+		record.Result = ResultRolledBack
+		return fmt.Errorf("bastion rolled back the rotation: %s", status.Error)
+	}
+
+	record.Result = ResultCompleted
+	updated := peerKeyLine.ReplaceAll(content, []byte("${1}"+newPeer.PublicKey))
+	if err := keystore.WriteFileAtomic(configPath, updated); err != nil {
+		return fmt.Errorf("new key is live but %s was not updated: %w", configPath, err)
+	}
+	if status.Error != "" {
+		return fmt.Errorf("rotation completed with a bastion warning: %s", status.Error)
+	}
+	return nil
+}
+
+// configPeer reads the bastion peer of a client config, which has only the one [Peer]
+func configPeer(content []byte) (LocalPeer, error) {
+	var peer LocalPeer
+	match := peerKeyLine.FindSubmatch(content)
+	if match == nil {
+		return peer, fmt.Errorf("no peer PublicKey")
+	}
+	peer.PublicKey = string(match[2])
+	if match := endpointLine.FindSubmatch(content); match != nil {
+		peer.Endpoint = string(match[1])
+	}
+	if match := allowedIPsLine.FindSubmatch(content); match != nil {
+		peer.AllowedIPs = strings.ReplaceAll(string(match[1]), " ", "")
+	}
+	if match := presharedKeyLine.FindSubmatch(content); match != nil {
+		peer.PresharedKey = string(match[2])
+	}
+	if match := keepaliveLine.FindSubmatch(content); match != nil {
+		peer.PersistentKeepalive = string(match[1])
+	}
+	return peer, nil
+}
+
+func (r *Rotator) storeKeys(iface string, keys LocalKeys) error {
+	if r.Keys == nil {
+		return nil
+	}
+	if err := r.Keys.Put(keystore.ClientKeyName(r.InstanceID, iface), keys.PrivateKey); err != nil {
+		return err
+	}
+	if keys.PresharedKey == "" {
+		return nil
+	}
+	return r.Keys.Put(keystore.PresharedKeyName(r.InstanceID, iface), keys.PresharedKey)
+}
+
+// wait polls the bastion until the rotation finishes. Polls fail while the tunnel
+// re-handshakes, so errors are retried until the deadline.
+func (r *Rotator) wait(ctx context.Context, iface string, timeout time.Duration) (*agent.RotationStatus, error) {
+	interval := r.PollInterval
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout+time.Minute)
+	defer cancel()
+
+	var lastErr error
+	for {
+		status, err := r.Bastion.Rotation(ctx, iface)
+		if err == nil && status.Done() {
+			return status, nil
+		}
+		lastErr = err
+
+		select {
+		case <-ctx.Done():
+			if lastErr != nil {
+				return nil, fmt.Errorf("lost contact with the bastion during rotation: %w", lastErr)
+			}
+			return nil, fmt.Errorf("rotation did not finish in time")
+		case <-time.After(interval):
+		}
+	}
+}
+
+// WGLocal switches keys with `wg set`, through sudo unless running as root
+type WGLocal struct{}
+
+// SetKeys sets the private key, and the peer's pre-shared key if given, in one `wg set`
+func (WGLocal) SetKeys(ctx context.Context, iface string, keys LocalKeys) error {
+	device, err := deviceName(iface)
+	if err != nil {
+		return err
+	}
+
+	keyFile, err := writeKeyFile(keys.PrivateKey)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(keyFile)
+
+	args := []string{"wg", "set", device, "private-key", keyFile}
+	if keys.PresharedKey != "" {
+		pskFile, err := writeKeyFile(keys.PresharedKey)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(pskFile)
+		args = append(args, "peer", keys.PeerPublicKey, "preshared-key", pskFile)
+	}
+	return runWG(ctx, device, args)
+}
+
+// ReplacePeer swaps the bastion peer for one with a new public key in one `wg set`, keeping
+// its endpoint, allowed IPs, pre-shared key and keepalive
+func (WGLocal) ReplacePeer(ctx context.Context, iface, oldPublicKey string, peer LocalPeer) error {
+	device, err := deviceName(iface)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"wg", "set", device, "peer", oldPublicKey, "remove", "peer", peer.PublicKey}
+	if peer.Endpoint != "" {
+		args = append(args, "endpoint", peer.Endpoint)
+	}
+	if peer.AllowedIPs != "" {
+		args = append(args, "allowed-ips", peer.AllowedIPs)
+	}
+	if peer.PersistentKeepalive != "" {
+		args = append(args, "persistent-keepalive", peer.PersistentKeepalive)
+	}
+	if peer.PresharedKey != "" {
+		pskFile, err := writeKeyFile(peer.PresharedKey)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(pskFile)
+		args = append(args, "preshared-key", pskFile)
+	}
+	return runWG(ctx, device, args)
+}
+
+// runWG runs a `wg set` command line, through sudo unless running as root
+func runWG(ctx context.Context, device string, args []string) error {
+	if os.Geteuid() != 0 {
+		sudo := []string{"sudo"}
+		if os.Getenv("SUDO_ASKPASS") != "" {
+			sudo = append(sudo, "-A")
+		}
+		args = append(sudo, args...)
+	}
+	if output, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput(); err != nil {
+		return fmt.Errorf("wg set %s: %w: %s", device, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// writeKeyFile puts a key in a private temporary file for `wg set`
+func writeKeyFile(key string) (string, error) {
+	file, err := os.CreateTemp("", "mole-key-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create key file: %w", err)
+	}
+	if _, err := file.WriteString(key + "\n"); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write key file: %w", err)
+	}
+	file.Close()
+	return file.Name(), nil
+}
+
+// deviceName maps a wg-quick interface name to the kernel device. On macOS wg-quick runs
+// wireguard-go on a utun device and records the mapping under /var/run/wireguard.
+func deviceName(iface string) (string, error) {
+	if runtime.GOOS != "darwin" {
+		return iface, nil
+	}
+	data, err := os.ReadFile(filepath.Join("/var/run/wireguard", iface+".name"))
+	if err != nil {
+		return "", fmt.Errorf("interface %s is not running: %w", iface, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,260 @@
+package rotation
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/research-computing/mole/internal/agent"
+	"github.com/research-computing/mole/internal/keystore"
+	"github.com/research-computing/mole/internal/tunnel"
+)
+
+// fakeBastion finishes every rotation with the given state
+type fakeBastion struct {
+	state         string
+	request       agent.RotationRequest
+	serverRequest agent.ServerRotationRequest
+}
+
+// newServerKey is the bastion key fakeBastion hands out for server rotations
+const newServerKey = "bmV3LXNlcnZlci1rZXktZm9yLXJvdGF0aW9uLXRlc3Q="
+
+func (f *fakeBastion) StartServerRotation(ctx context.Context, iface string, req agent.ServerRotationRequest) (*agent.RotationStatus, error) {
+	f.serverRequest = req
+	return &agent.RotationStatus{Interface: iface, Server: true, OldPublicKey: req.OldPublicKey, NewPublicKey: newServerKey, State: agent.RotationPending}, nil
+}
+
+func (f *fakeBastion) StartRotation(ctx context.Context, iface string, req agent.RotationRequest) (*agent.RotationStatus, error) {
+	f.request = req
+	return &agent.RotationStatus{Interface: iface, State: agent.RotationPending}, nil
+}
+
+func (f *fakeBastion) Rotation(ctx context.Context, iface string) (*agent.RotationStatus, error) {
+	status := &agent.RotationStatus{Interface: iface, State: f.state}
+	if f.state == agent.RotationRolledBack {
+		status.Error = "no handshake from the new key within 2m0s"
+	}
+	return status, nil
+}
+
+// fakeLocal records the keys and peers set on the interface
+type fakeLocal struct {
+	keys  []string
+	sets  []LocalKeys
+	peers []LocalPeer
+}
+
+func (f *fakeLocal) ReplacePeer(ctx context.Context, iface, oldPublicKey string, peer LocalPeer) error {
+	f.peers = append(f.peers, peer)
+	return nil
+}
+
+func (f *fakeLocal) SetKeys(ctx context.Context, iface string, keys LocalKeys) error {
+	f.keys = append(f.keys, keys.PrivateKey)
+	f.sets = append(f.sets, keys)
+	return nil
+}
+
+func writeClientConfig(t *testing.T) (path, privateKey, publicKey string) {
+	return writeClientConfigWith(t, "")
+}
+
+func writeClientConfigWith(t *testing.T, peerExtra string) (path, privateKey, publicKey string) {
+	t.Helper()
+	privateKey, publicKey, err := tunnel.GenerateWireGuardKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(t.TempDir(), "wg0.conf")
+	config := "[Interface]\nPrivateKey = " + privateKey + "\nAddress = 10.100.1.2/24\n\n[Peer]\nPublicKey = c2VydmVy\n" + peerExtra
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, privateKey, publicKey
+}
+
+func TestRotateCompleted(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	path, _, oldPublicKey := writeClientConfig(t)
+
+	bastion := &fakeBastion{state: agent.RotationCompleted}
+	local := &fakeLocal{}
+	rotator := &Rotator{InstanceID: "i-0123", Bastion: bastion, Local: local, PollInterval: time.Millisecond}
+
+	record, err := rotator.Rotate(context.Background(), "wg0", path)
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if record.Result != ResultCompleted || bastion.request.OldPublicKey != oldPublicKey {
+		t.Errorf("Unexpected record %+v for request %+v", record, bastion.request)
+	}
+	if len(local.keys) != 1 {
+		t.Fatalf("Expected one local key switch, got %d", len(local.keys))
+	}
+
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "PrivateKey = "+local.keys[0]+"\n") {
+		t.Errorf("Config not updated with the new key:\n%s", data)
+	}
+	newPublicKey, _ := tunnel.PublicKeyFromPrivate(local.keys[0])
+	if newPublicKey != bastion.request.NewPublicKey {
+		t.Error("Bastion was given a key that does not match the local private key")
+	}
+
+	records, err := ReadHistory()
+	if err != nil || len(records) != 1 {
+		t.Fatalf("Expected one history record, got %v (%v)", records, err)
+	}
+	if last, ok := LastCompleted(records, "i-0123", "wg0", ""); !ok || !last.Equal(record.Time) {
+		t.Errorf("LastCompleted = %v, %v", last, ok)
+	}
+}
+
+func TestRotateRolledBack(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	path, oldPrivateKey, _ := writeClientConfig(t)
+	before, _ := os.ReadFile(path)
+
+	local := &fakeLocal{}
+	rotator := &Rotator{InstanceID: "i-0123", Bastion: &fakeBastion{state: agent.RotationRolledBack}, Local: local, PollInterval: time.Millisecond}
+
+	record, err := rotator.Rotate(context.Background(), "wg0", path)
+	if err == nil || !strings.Contains(err.Error(), "no handshake") {
+		t.Fatalf("Expected rollback error, got %v", err)
+	}
+	if record.Result != ResultRolledBack {
+		t.Errorf("Expected rolled_back, got %s", record.Result)
+	}
+	if len(local.keys) != 2 || local.keys[1] != oldPrivateKey {
+		t.Error("Expected the old key to be restored locally")
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(before) {
+		t.Error("Config must not change when the rotation rolls back")
+	}
+
+	records, _ := ReadHistory()
+	if len(records) != 1 || records[0].Result != ResultRolledBack {
+		t.Errorf("Expected a rolled_back history record, got %+v", records)
+	}
+	if _, ok := LastCompleted(records, "i-0123", "wg0", ""); ok {
+		t.Error("A rolled back rotation is not a completed one")
+	}
+}
+
+func TestRotatePresharedKey(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	oldPSK, _ := tunnel.GeneratePresharedKey()
+	path, _, _ := writeClientConfigWith(t, "PresharedKey = "+oldPSK+"\n")
+
+	bastion := &fakeBastion{state: agent.RotationRolledBack}
+	local := &fakeLocal{}
+	rotator := &Rotator{InstanceID: "i-0123", Bastion: bastion, Local: local, PollInterval: time.Millisecond}
+	if _, err := rotator.Rotate(context.Background(), "wg0", path); err == nil {
+		t.Fatal("Expected rollback error")
+	}
+	if len(local.sets) != 2 || local.sets[1].PresharedKey != oldPSK || local.sets[1].PeerPublicKey != "c2VydmVy" {
+		t.Fatalf("Expected the old PSK to be restored, got %+v", local.sets)
+	}
+
+	bastion.state = agent.RotationCompleted
+	local = &fakeLocal{}
+	rotator.Local = local
+	if _, err := rotator.Rotate(context.Background(), "wg0", path); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	newPSK := bastion.request.NewPresharedKey
+	if newPSK == "" || newPSK == oldPSK || local.sets[0].PresharedKey != newPSK {
+		t.Errorf("Expected a fresh PSK on both ends, got %q locally and %q on the bastion", local.sets[0].PresharedKey, newPSK)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "PresharedKey = "+newPSK+"\n") {
+		t.Errorf("Config not updated with the new PSK:\n%s", data)
+	}
+}
+
+func TestRotateWithoutPresharedKey(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(keystore.PassphraseEnv, "test passphrase")
+	path, _, _ := writeClientConfig(t)
+	keys, err := keystore.Open(keystore.BackendFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bastion := &fakeBastion{state: agent.RotationCompleted}
+	local := &fakeLocal{}
+	rotator := &Rotator{InstanceID: "i-0123", Bastion: bastion, Local: local, PollInterval: time.Millisecond, Keys: keys}
+	if _, err := rotator.Rotate(context.Background(), "wg0", path); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if bastion.request.NewPresharedKey != "" {
+		t.Error("A tunnel without a PSK must not gain one on rotation")
+	}
+	if key, err := keys.Get(keystore.ClientKeyName("i-0123", "wg0")); err != nil || key != local.keys[0] {
+		t.Errorf("Key store not updated with the new key: %v", err)
+	}
+	if keys.Has(keystore.PresharedKeyName("i-0123", "wg0")) {
+		t.Error("No PSK should be stored for a tunnel without one")
+	}
+}
+
+func TestRotateServer(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	psk, _ := tunnel.GeneratePresharedKey()
+	path, _, clientPublicKey := writeClientConfigWith(t,
+		"PresharedKey = "+psk+"\nEndpoint = 198.51.100.1:51820\nAllowedIPs = 10.0.0.0/16, 10.100.1.0/24\nPersistentKeepalive = 25\n")
+	before, _ := os.ReadFile(path)
+
+	bastion := &fakeBastion{state: agent.RotationRolledBack}
+	local := &fakeLocal{}
+	rotator := &Rotator{InstanceID: "i-0123", Bastion: bastion, Local: local, PollInterval: time.Millisecond}
+
+	record, err := rotator.RotateServer(context.Background(), "wg0", path, "WireGuardPublicKey")
+	if err == nil || record.Result != ResultRolledBack {
+		t.Fatalf("Expected a rolled back rotation, got %+v (%v)", record, err)
+	}
+	if len(local.peers) != 2 || local.peers[1].PublicKey != "c2VydmVy" {
+		t.Fatalf("Expected the old peer to be restored, got %+v", local.peers)
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(before) {
+		t.Error("Config must not change when the rotation rolls back")
+	}
+
+	bastion.state = agent.RotationCompleted
+	local = &fakeLocal{}
+	rotator.Local = local
+	record, err = rotator.RotateServer(context.Background(), "wg0", path, "WireGuardPublicKey")
+	if err != nil {
+		t.Fatalf("RotateServer failed: %v", err)
+	}
+	request := bastion.serverRequest
+	if request.OldPublicKey != "c2VydmVy" || request.PeerPublicKey != clientPublicKey || request.KeyTag != "WireGuardPublicKey" {
+		t.Errorf("Unexpected request %+v", request)
+	}
+	expected := LocalPeer{
+		PublicKey:           newServerKey,
+		Endpoint:            "198.51.100.1:51820",
+		AllowedIPs:          "10.0.0.0/16,10.100.1.0/24",
+		PresharedKey:        psk,
+		PersistentKeepalive: "25",
+	}
+	if len(local.peers) != 1 || local.peers[0] != expected {
+		t.Errorf("Expected the peer to keep its settings under the new key, got %+v", local.peers)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "PublicKey = "+newServerKey+"\n") || strings.Contains(string(data), "c2VydmVy") {
+		t.Errorf("Config not updated with the new bastion key:\n%s", data)
+	}
+
+	records, _ := ReadHistory()
+	if last, ok := LastCompleted(records, "i-0123", "wg0", KeyServer); !ok || !last.Equal(record.Time) {
+		t.Errorf("LastCompleted(server) = %v, %v", last, ok)
+	}
+	if _, ok := LastCompleted(records, "i-0123", "wg0", ""); ok {
+		t.Error("A server rotation does not count as a client key rotation")
+	}
+}
@@ -0,0 +1,65 @@
+package tunnel
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Backend brings WireGuard interfaces up and down on the client
+type Backend interface {
+	Name() string
+	Up(config *WireGuardConfig) error
+	Down(iface string) error
+	Stats(iface string) (*WireGuardStats, error)
+	Validate(iface string) error
+}
+
+// Backend names accepted by TunnelConfig.Backend
+const (
+	BackendWGQuick = "wg-quick" // wg-quick with a materialised config, works everywhere wireguard-tools does
+	BackendNetlink = "netlink"  // Direct kernel configuration through netlink and wgctrl (Linux)
+	BackendMemory  = "memory"   // In-memory fake for tests and dry runs
+)
+
+// NewBackend returns the backend with the given name; empty selects wg-quick
+func NewBackend(name string) (Backend, error) {
+	switch name {
+	case "", BackendWGQuick:
+		return NewWGQuickBackend(), nil
+	case BackendNetlink:
+		return NewNetlinkBackend()
+	case BackendMemory:
+		return NewMemoryBackend(), nil
+	default:
+		return nil, fmt.Errorf("unknown WireGuard backend %q (want %s, %s or %s)", name, BackendWGQuick, BackendNetlink, BackendMemory)
+	}
+}
+
+// parseWGDump parses `wg show <iface> dump`: the first line describes the interface and
+// each further line a peer (public key, psk, endpoint, allowed ips, handshake, rx, tx, keepalive)
+func parseWGDump(iface, dump string) (*WireGuardStats, error) {
+	stats := &WireGuardStats{Interface: iface}
+
+	lines := strings.Split(strings.TrimSpace(dump), "\n")
+	if len(lines) == 0 || lines[0] == "" {
+		return nil, fmt.Errorf("empty wg dump for %s", iface)
+	}
+	for _, line := range lines[1:] {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 7 {
+			return nil, fmt.Errorf("malformed wg dump peer line: %q", line)
+		}
+		rx, err := strconv.ParseInt(fields[5], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed rx bytes %q: %w", fields[5], err)
+		}
+		tx, err := strconv.ParseInt(fields[6], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed tx bytes %q: %w", fields[6], err)
+		}
+		stats.Peers = append(stats.Peers, PeerStats{PublicKey: fields[0], RxBytes: rx, TxBytes: tx})
+	}
+
+	return stats, nil
+}
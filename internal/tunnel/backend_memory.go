@@ -0,0 +1,220 @@
+package tunnel
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// MemoryBackend keeps interfaces in memory. Tests use it in place of the kernel and can
+// set per-peer counters with SetStats. It is also its own Router, so routes stay in
+// memory too.
+type MemoryBackend struct {
+	mu         sync.Mutex
+	interfaces map[string]*WireGuardConfig
+	stats      map[string][]PeerStats
+	routes     map[netip.Prefix]Route
+	rules      map[netip.Addr]SourceRule
+	hashPolicy bool
+}
+
+// NewMemoryBackend returns an empty in-memory backend
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		interfaces: make(map[string]*WireGuardConfig),
+		stats:      make(map[string][]PeerStats),
+		routes:     make(map[netip.Prefix]Route),
+	}
+}
+
+// Name returns the backend name
+func (b *MemoryBackend) Name() string {
+	return BackendMemory
+}
+
+// Up records the interface config
+func (b *MemoryBackend) Up(config *WireGuardConfig) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, exists := b.interfaces[config.Interface]; exists {
+		return fmt.Errorf("WireGuard interface %s already exists", config.Interface)
+	}
+	stored := *config
+	b.interfaces[config.Interface] = &stored
+	return nil
+}
+
+// Down forgets the interface
+func (b *MemoryBackend) Down(iface string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, exists := b.interfaces[iface]; !exists {
+		return fmt.Errorf("WireGuard interface %s not found", iface)
+	}
+	delete(b.interfaces, iface)
+	delete(b.stats, iface)
+	// Like the kernel, drop routes through the interface, whole multipath routes included
+	for destination, route := range b.routes {
+		if slices.ContainsFunc(route.Nexthops, func(n Nexthop) bool { return n.Interface == iface }) {
+			delete(b.routes, destination)
+		}
+	}
+	return nil
+}
+
+// Stats returns the counters set with SetStats
+func (b *MemoryBackend) Stats(iface string) (*WireGuardStats, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, exists := b.interfaces[iface]; !exists {
+		return nil, fmt.Errorf("WireGuard interface %s not found", iface)
+	}
+	return &WireGuardStats{Interface: iface, Peers: append([]PeerStats(nil), b.stats[iface]...)}, nil
+}
+
+// Validate succeeds for interfaces that are up
+func (b *MemoryBackend) Validate(iface string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, exists := b.interfaces[iface]; !exists {
+		return fmt.Errorf("WireGuard interface %s not found", iface)
+	}
+	return nil
+}
+
+// SetStats sets the peer counters Stats reports for an interface
+func (b *MemoryBackend) SetStats(iface string, peers []PeerStats) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stats[iface] = append([]PeerStats(nil), peers...)
+}
+
+// Config returns a copy of the config an interface was brought up with
+func (b *MemoryBackend) Config(iface string) (*WireGuardConfig, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	config, exists := b.interfaces[iface]
+	if !exists {
+		return nil, false
+	}
+	stored := *config
+	return &stored, true
+}
+
+// Interfaces lists the interfaces that are up
+func (b *MemoryBackend) Interfaces() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	names := make([]string, 0, len(b.interfaces))
+	for name := range b.interfaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetHashPolicy records that L4 hashing was requested
+func (b *MemoryBackend) SetHashPolicy() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.hashPolicy = true
+	return nil
+}
+
+// ReplaceRoute records a route; every nexthop must be an interface that is up
+func (b *MemoryBackend) ReplaceRoute(route Route) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(route.Nexthops) == 0 {
+		return fmt.Errorf("route to %s has no nexthops", route.Destination)
+	}
+	for _, nexthop := range route.Nexthops {
+		if _, exists := b.interfaces[nexthop.Interface]; !exists {
+			return fmt.Errorf("tunnel interface %s not found", nexthop.Interface)
+		}
+	}
+	route.Nexthops = slices.Clone(route.Nexthops)
+	b.routes[route.Destination] = route
+	return nil
+}
+
+// DeleteRoute forgets a route
+func (b *MemoryBackend) DeleteRoute(destination netip.Prefix) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.routes, destination)
+	return nil
+}
+
+// Routes returns the installed routes ordered by destination
+func (b *MemoryBackend) Routes() []Route {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	routes := make([]Route, 0, len(b.routes))
+	for _, route := range b.routes {
+		route.Nexthops = slices.Clone(route.Nexthops)
+		routes = append(routes, route)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Destination.String() < routes[j].Destination.String() })
+	return routes
+}
+
+// HashPolicy reports whether SetHashPolicy was called
+func (b *MemoryBackend) HashPolicy() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.hashPolicy
+}
+
+// AddSourceRule records a source rule; its interface must be up
+func (b *MemoryBackend) AddSourceRule(rule SourceRule) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, exists := b.interfaces[rule.Interface]; !exists {
+		return fmt.Errorf("tunnel interface %s not found", rule.Interface)
+	}
+	if b.rules == nil {
+		b.rules = make(map[netip.Addr]SourceRule)
+	}
+	rule.Destinations = slices.Clone(rule.Destinations)
+	b.rules[rule.Source] = rule
+	return nil
+}
+
+// DeleteSourceRule forgets a source rule
+func (b *MemoryBackend) DeleteSourceRule(rule SourceRule) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.rules, rule.Source)
+	return nil
+}
+
+// SourceRules returns the installed source rules ordered by table and address
+func (b *MemoryBackend) SourceRules() []SourceRule {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rules := make([]SourceRule, 0, len(b.rules))
+	for _, rule := range b.rules {
+		rule.Destinations = slices.Clone(rule.Destinations)
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Table != rules[j].Table {
+			return rules[i].Table < rules[j].Table
+		}
+		return rules[i].Source.Less(rules[j].Source)
+	})
+	return rules
+}
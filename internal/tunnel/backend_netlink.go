@@ -0,0 +1,76 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// persistentKeepalive matches the PersistentKeepalive written into wg-quick configs
+const persistentKeepalive = 25 * time.Second
+
+// deviceConfig translates a WireGuardConfig into the wgctrl configuration the netlink
+// backend applies, replacing any peers the device already had
+func deviceConfig(config *WireGuardConfig) (wgtypes.Config, error) {
+	privateKey, err := wgtypes.ParseKey(config.PrivateKey)
+	if err != nil {
+		return wgtypes.Config{}, fmt.Errorf("invalid private key: %w", err)
+	}
+	device := wgtypes.Config{PrivateKey: &privateKey, ReplacePeers: true}
+	if config.ListenPort > 0 {
+		port := config.ListenPort
+		device.ListenPort = &port
+	}
+
+	if config.PeerPublicKey == "" {
+		return device, nil
+	}
+	peerKey, err := wgtypes.ParseKey(config.PeerPublicKey)
+	if err != nil {
+		return wgtypes.Config{}, fmt.Errorf("invalid peer public key: %w", err)
+	}
+	keepalive := persistentKeepalive
+	peer := wgtypes.PeerConfig{
+		PublicKey:                   peerKey,
+		ReplaceAllowedIPs:           true,
+		PersistentKeepaliveInterval: &keepalive,
+	}
+	if config.PresharedKey != "" {
+		psk, err := wgtypes.ParseKey(config.PresharedKey)
+		if err != nil {
+			return wgtypes.Config{}, fmt.Errorf("invalid pre-shared key: %w", err)
+		}
+		peer.PresharedKey = &psk
+	}
+	if config.PeerEndpoint != "" {
+		endpoint, err := net.ResolveUDPAddr("udp", config.PeerEndpoint)
+		if err != nil {
+			return wgtypes.Config{}, fmt.Errorf("invalid peer endpoint %s: %w", config.PeerEndpoint, err)
+		}
+		peer.Endpoint = endpoint
+	}
+	for _, cidr := range splitList(config.AllowedIPs) {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return wgtypes.Config{}, fmt.Errorf("invalid allowed IPs %s: %w", cidr, err)
+		}
+		peer.AllowedIPs = append(peer.AllowedIPs, *network)
+	}
+	device.Peers = []wgtypes.PeerConfig{peer}
+
+	return device, nil
+}
+
+// splitList splits the comma-separated lists wg-quick accepts for Address and AllowedIPs
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,130 @@
+//go:build linux
+
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// NetlinkBackend configures the kernel WireGuard module directly: links and addresses over
+// rtnetlink and keys and peers over the WireGuard generic netlink family. It needs
+// CAP_NET_ADMIN but no wireguard-tools, and never writes the private key to disk.
+// Routes are left to ECMP configuration.
+type NetlinkBackend struct {
+	client *wgctrl.Client
+}
+
+// NewNetlinkBackend opens a WireGuard netlink client
+func NewNetlinkBackend() (Backend, error) {
+	client, err := wgctrl.New()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WireGuard netlink client: %w", err)
+	}
+	return &NetlinkBackend{client: client}, nil
+}
+
+// Name returns the backend name
+func (b *NetlinkBackend) Name() string {
+	return BackendNetlink
+}
+
+// Up creates the wireguard link, configures it and brings it up
+func (b *NetlinkBackend) Up(config *WireGuardConfig) error {
+	device, err := deviceConfig(config)
+	if err != nil {
+		return err
+	}
+
+	attrs := netlink.NewLinkAttrs()
+	attrs.Name = config.Interface
+	if config.MTU > 0 {
+		attrs.MTU = config.MTU
+	}
+	link := &netlink.Wireguard{LinkAttrs: attrs}
+	if err := netlink.LinkAdd(link); err != nil {
+		return fmt.Errorf("failed to create WireGuard link %s: %w", config.Interface, err)
+	}
+
+	if err := b.configure(link, config.Address, device); err != nil {
+		// Don't leave a half-configured link behind
+		netlink.LinkDel(link)
+		return err
+	}
+	return nil
+}
+
+// configure assigns the addresses, applies keys and peers and sets the link up
+func (b *NetlinkBackend) configure(link netlink.Link, address string, device wgtypes.Config) error {
+	iface := link.Attrs().Name
+	for _, cidr := range splitList(address) {
+		addr, err := netlink.ParseAddr(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid address %s: %w", cidr, err)
+		}
+		if err := netlink.AddrAdd(link, addr); err != nil {
+			return fmt.Errorf("failed to add address %s to %s: %w", cidr, iface, err)
+		}
+	}
+	if err := b.client.ConfigureDevice(iface, device); err != nil {
+		return fmt.Errorf("failed to configure WireGuard device %s: %w", iface, err)
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		return fmt.Errorf("failed to bring up %s: %w", iface, err)
+	}
+	return nil
+}
+
+// Down deletes the link, which also drops its peers
+func (b *NetlinkBackend) Down(iface string) error {
+	link, err := netlink.LinkByName(iface)
+	if err != nil {
+		return fmt.Errorf("WireGuard interface %s not found: %w", iface, err)
+	}
+	if err := netlink.LinkDel(link); err != nil {
+		return fmt.Errorf("failed to delete WireGuard link %s: %w", iface, err)
+	}
+	return nil
+}
+
+// Stats reads per-peer transfer counters from the kernel
+func (b *NetlinkBackend) Stats(iface string) (*WireGuardStats, error) {
+	device, err := b.client.Device(iface)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get WireGuard stats: %w", err)
+	}
+
+	stats := &WireGuardStats{Interface: iface}
+	for _, peer := range device.Peers {
+		stats.Peers = append(stats.Peers, PeerStats{
+			PublicKey: peer.PublicKey.String(),
+			RxBytes:   peer.ReceiveBytes,
+			TxBytes:   peer.TransmitBytes,
+		})
+	}
+	return stats, nil
+}
+
+// Validate checks that the link is up and is a WireGuard device
+func (b *NetlinkBackend) Validate(iface string) error {
+	link, err := netlink.LinkByName(iface)
+	if err != nil {
+		return fmt.Errorf("WireGuard interface %s not found: %w", iface, err)
+	}
+	if link.Attrs().Flags&net.FlagUp == 0 {
+		return fmt.Errorf("WireGuard interface %s is not up", iface)
+	}
+	if _, err := b.client.Device(iface); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%s is not a WireGuard interface", iface)
+		}
+		return fmt.Errorf("WireGuard interface %s configuration error: %w", iface, err)
+	}
+	return nil
+}
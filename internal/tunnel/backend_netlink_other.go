@@ -0,0 +1,10 @@
+//go:build !linux
+
+package tunnel
+
+import "fmt"
+
+// NewNetlinkBackend reports that kernel configuration needs Linux
+func NewNetlinkBackend() (Backend, error) {
+	return nil, fmt.Errorf("the %s WireGuard backend requires Linux; use %s", BackendNetlink, BackendWGQuick)
+}
@@ -0,0 +1,165 @@
+package tunnel
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNewBackend(t *testing.T) {
+	for _, name := range []string{"", BackendWGQuick, BackendMemory} {
+		backend, err := NewBackend(name)
+		if err != nil {
+			t.Fatalf("NewBackend(%q) failed: %v", name, err)
+		}
+		if name != "" && backend.Name() != name {
+			t.Errorf("NewBackend(%q) returned %s", name, backend.Name())
+		}
+	}
+	if _, err := NewBackend("userspace"); err == nil {
+		t.Error("Expected an unknown backend to fail")
+	}
+
+	// Unknown names fall back to wg-quick rather than failing deployment
+	tm := NewTunnelManager(&TunnelConfig{MaxTunnels: 1, Backend: "userspace"})
+	if tm.backend.Name() != BackendWGQuick {
+		t.Errorf("Expected wg-quick fallback, got %s", tm.backend.Name())
+	}
+}
+
+func TestParseWGDump(t *testing.T) {
+	dump := "cHJpdmF0ZQ==\tcHVibGlj\t51820\toff\n" +
+		"cGVlcjE=\t(none)\t1.2.3.4:51820\t0.0.0.0/0\t1700000000\t4096\t8192\t25\n" +
+		"cGVlcjI=\t(none)\t(none)\t10.0.0.0/8\t0\t0\t0\toff\n"
+
+	stats, err := parseWGDump("wg0", dump)
+	if err != nil {
+		t.Fatalf("parseWGDump failed: %v", err)
+	}
+	if len(stats.Peers) != 2 {
+		t.Fatalf("Expected 2 peers (interface line skipped), got %+v", stats.Peers)
+	}
+	if peer := stats.Peers[0]; peer.PublicKey != "cGVlcjE=" || peer.RxBytes != 4096 || peer.TxBytes != 8192 {
+		t.Errorf("Unexpected peer stats: %+v", peer)
+	}
+
+	if _, err := parseWGDump("wg0", ""); err == nil {
+		t.Error("Expected an empty dump to fail")
+	}
+	if _, err := parseWGDump("wg0", "iface\nshort\tline\n"); err == nil {
+		t.Error("Expected a malformed peer line to fail")
+	}
+}
+
+func TestWGQuickBackend(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+
+	var commands []string
+	var fail error
+	backend := &WGQuickBackend{run: func(name string, args ...string) ([]byte, error) {
+		commands = append(commands, name+" "+strings.Join(args, " "))
+		return nil, fail
+	}}
+
+	config := &WireGuardConfig{Interface: "wg0", PrivateKey: "private", Address: "10.100.1.2/24", ListenPort: 51820}
+	if err := backend.Up(config); err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+	if len(commands) != 1 || !strings.HasPrefix(commands[0], "wg-quick up ") || !strings.HasSuffix(commands[0], "/wg0.conf") {
+		t.Fatalf("Unexpected commands: %v", commands)
+	}
+	path := strings.TrimPrefix(commands[0], "wg-quick up ")
+	if data, err := os.ReadFile(path); err != nil || !strings.Contains(string(data), "PrivateKey = private") {
+		t.Errorf("Config not materialised: %v", err)
+	}
+
+	if err := backend.Down("wg0"); err != nil {
+		t.Fatalf("Down failed: %v", err)
+	}
+	if commands[1] != "wg-quick down "+path {
+		t.Errorf("Expected wg-quick down on the materialised config, got %s", commands[1])
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Config should be wiped after Down")
+	}
+
+	// A failed wg-quick up must not leave the private key behind
+	fail = errors.New("exit status 1")
+	if err := backend.Up(config); err == nil {
+		t.Fatal("Expected Up to fail")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Config should be wiped after a failed Up")
+	}
+}
+
+func TestDeviceConfig(t *testing.T) {
+	privateKey, publicKey, _ := GenerateWireGuardKeys()
+	psk, _ := GeneratePresharedKey()
+
+	device, err := deviceConfig(&WireGuardConfig{
+		Interface:     "wg0",
+		PrivateKey:    privateKey,
+		ListenPort:    51820,
+		PeerPublicKey: publicKey,
+		PeerEndpoint:  "1.2.3.4:51820",
+		AllowedIPs:    "0.0.0.0/0, ::/0",
+		PresharedKey:  psk,
+	})
+	if err != nil {
+		t.Fatalf("deviceConfig failed: %v", err)
+	}
+	if device.PrivateKey.String() != privateKey || *device.ListenPort != 51820 || !device.ReplacePeers {
+		t.Errorf("Unexpected device config: %+v", device)
+	}
+	if len(device.Peers) != 1 {
+		t.Fatalf("Expected one peer, got %d", len(device.Peers))
+	}
+	peer := device.Peers[0]
+	if peer.PublicKey.String() != publicKey || peer.PresharedKey.String() != psk || peer.Endpoint.String() != "1.2.3.4:51820" {
+		t.Errorf("Unexpected peer config: %+v", peer)
+	}
+	if len(peer.AllowedIPs) != 2 || peer.AllowedIPs[1].String() != "::/0" {
+		t.Errorf("Expected both allowed IP ranges, got %v", peer.AllowedIPs)
+	}
+	if *peer.PersistentKeepaliveInterval != persistentKeepalive {
+		t.Errorf("Expected %v keepalive, got %v", persistentKeepalive, *peer.PersistentKeepaliveInterval)
+	}
+
+	// Without a peer only the interface is configured
+	device, err = deviceConfig(&WireGuardConfig{Interface: "wg0", PrivateKey: privateKey})
+	if err != nil || len(device.Peers) != 0 || device.ListenPort != nil {
+		t.Errorf("Unexpected peerless config: %+v, %v", device, err)
+	}
+	if _, err := deviceConfig(&WireGuardConfig{Interface: "wg0", PrivateKey: "short"}); err == nil {
+		t.Error("Expected an invalid private key to fail")
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	backend := NewMemoryBackend()
+	tm := NewTunnelManagerWithBackend(nil, backend)
+
+	if err := tm.CreateTunnels(1); err != nil {
+		t.Fatalf("CreateTunnels failed: %v", err)
+	}
+	backend.SetStats("wg0", []PeerStats{{PublicKey: "peer", RxBytes: 10, TxBytes: 20}})
+	stats, err := tm.GetWireGuardStats("wg0")
+	if err != nil || len(stats.Peers) != 1 || stats.Peers[0].TxBytes != 20 {
+		t.Errorf("Unexpected stats: %+v, %v", stats, err)
+	}
+
+	if err := backend.Up(&WireGuardConfig{Interface: "wg0"}); err == nil {
+		t.Error("Expected bringing up an existing interface to fail")
+	}
+	if err := tm.DestroyWireGuardInterface("wg0"); err != nil {
+		t.Fatalf("DestroyWireGuardInterface failed: %v", err)
+	}
+	if err := tm.ValidateWireGuardInterface("wg0"); err == nil {
+		t.Error("Expected a destroyed interface to fail validation")
+	}
+	if _, err := tm.GetWireGuardStats("wg0"); err == nil {
+		t.Error("Expected stats of a destroyed interface to fail")
+	}
+}
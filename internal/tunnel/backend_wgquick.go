@@ -0,0 +1,93 @@
+package tunnel
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/research-computing/mole/internal/keystore"
+)
+
+// WGQuickBackend drives wg-quick and wg. Commands run through sudo unless mole already runs
+// as root; sudo uses the askpass helper from SUDO_ASKPASS when one is set.
+type WGQuickBackend struct {
+	run func(name string, args ...string) ([]byte, error)
+}
+
+// NewWGQuickBackend returns a backend that shells out to wireguard-tools
+func NewWGQuickBackend() *WGQuickBackend {
+	return &WGQuickBackend{run: runPrivileged}
+}
+
+// Name returns the backend name
+func (b *WGQuickBackend) Name() string {
+	return BackendWGQuick
+}
+
+// Up writes the config to the private runtime directory and runs wg-quick up on it
+func (b *WGQuickBackend) Up(config *WireGuardConfig) error {
+	// The config holds the private key, so it only lives in the private runtime directory
+	configFile, err := keystore.Materialize(config.Interface, []byte(generateWireGuardConfig(config)))
+	if err != nil {
+		return fmt.Errorf("failed to write WireGuard config: %w", err)
+	}
+
+	if output, err := b.run("wg-quick", "up", configFile); err != nil {
+		keystore.Wipe(config.Interface)
+		return fmt.Errorf("failed to bring up WireGuard interface: %w, output: %s", err, string(output))
+	}
+	return nil
+}
+
+// Down runs wg-quick down and wipes the materialised config
+func (b *WGQuickBackend) Down(iface string) error {
+	if output, err := b.run("wg-quick", "down", keystore.ConfigOrName(iface)); err != nil {
+		return fmt.Errorf("failed to bring down WireGuard interface: %w, output: %s", err, string(output))
+	}
+
+	// Wipe the config with its private key
+	if err := keystore.Wipe(iface); err != nil {
+		// Not fatal, just log
+		fmt.Printf("Warning: failed to wipe config for %s: %v\n", iface, err)
+	}
+	return nil
+}
+
+// Stats reads transfer counters from `wg show dump`
+func (b *WGQuickBackend) Stats(iface string) (*WireGuardStats, error) {
+	output, err := b.run("wg", "show", iface, "dump")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get WireGuard stats: %w", err)
+	}
+	return parseWGDump(iface, string(output))
+}
+
+// Validate checks that the interface exists, is up and has a WireGuard configuration
+func (b *WGQuickBackend) Validate(iface string) error {
+	// On macOS the kernel device is a utun, so only wg can resolve the name
+	if runtime.GOOS == "linux" {
+		if output, err := exec.Command("ip", "link", "show", iface, "up").CombinedOutput(); err != nil || len(output) == 0 {
+			return fmt.Errorf("WireGuard interface %s is missing or down", iface)
+		}
+	}
+
+	if output, err := b.run("wg", "show", iface); err != nil {
+		return fmt.Errorf("WireGuard interface %s configuration error: %w, output: %s", iface, err, string(output))
+	}
+	return nil
+}
+
+// runPrivileged runs a command as root, through sudo when needed
+func runPrivileged(name string, args ...string) ([]byte, error) {
+	var cmd *exec.Cmd
+	switch {
+	case os.Geteuid() == 0:
+		cmd = exec.Command(name, args...)
+	case os.Getenv("SUDO_ASKPASS") != "":
+		cmd = exec.Command("sudo", append([]string{"-A", name}, args...)...)
+	default:
+		cmd = exec.Command("sudo", append([]string{name}, args...)...)
+	}
+	return cmd.CombinedOutput()
+}
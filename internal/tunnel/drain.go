@@ -0,0 +1,93 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+)
+
+// Draining defaults for mole scale and the autoscaler
+const (
+	DefaultDrainTimeout = 5 * time.Minute // How long a removed tunnel's connections may take to finish
+	DrainPollInterval   = 2 * time.Second // How often a draining tunnel's connections are counted
+)
+
+// ConnectionCounter reports how many open connections come from any of the addresses
+type ConnectionCounter func(sources []netip.Addr) (int, error)
+
+// Drain is a tunnel removed by DrainTunnel
+type Drain struct {
+	ID        int
+	Interface string
+	Remaining int // Connections still open when the tunnel was removed
+}
+
+// DrainTunnel removes the tunnel RemoveTunnel would, once the connections on it have
+// finished. The tunnel leaves the multipath routes straight away, so new connections take
+// the others, while source rules keep its existing connections on it as their addresses
+// are its own. connections is polled until none are left or ctx ends; the tunnel is
+// removed either way and Remaining says how many were cut off. If the connections cannot
+// be counted the tunnel goes back into service.
+func (tm *TunnelManager) DrainTunnel(ctx context.Context, connections ConnectionCounter, poll time.Duration) (Drain, error) {
+	tm.mu.Lock()
+	tunnel, err := tm.removalCandidate()
+	if err != nil {
+		tm.mu.Unlock()
+		return Drain{}, err
+	}
+	drain := Drain{ID: tunnel.ID, Interface: tunnel.Interface}
+	err = tm.holdConnections(tunnel)
+	tm.mu.Unlock()
+	if err != nil {
+		return drain, err
+	}
+	if tm.logger != nil {
+		tm.logger.Info("Draining tunnel", "tunnel", tunnel.Interface)
+	}
+
+	sources := tunnelAddresses(tunnel)
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+wait:
+	for {
+		count, err := connections(sources)
+		if err != nil {
+			tm.mu.Lock()
+			defer tm.mu.Unlock()
+			err = fmt.Errorf("failed to count connections on %s: %w", tunnel.Interface, err)
+			tm.setState(tunnel, "active")
+			return drain, errors.Join(err, tm.syncRoutes())
+		}
+		drain.Remaining = count
+		if count == 0 {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			break wait
+		case <-ticker.C:
+		}
+	}
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if err := tm.destroyTunnel(tunnel.ID); err != nil {
+		tm.setState(tunnel, "active")
+		return drain, errors.Join(err, tm.syncRoutes())
+	}
+	return drain, nil
+}
+
+// holdConnections takes a tunnel out of the multipath routes; its source rules keep its
+// connections on it when ECMP is configured. The caller holds tm.mu.
+func (tm *TunnelManager) holdConnections(tunnel *WireGuardTunnel) error {
+	tm.setState(tunnel, "draining")
+	if err := tm.syncRoutes(); err != nil {
+		tm.setState(tunnel, "active")
+		return errors.Join(err, tm.syncRoutes())
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestDrainTunnel(t *testing.T) {
+	tm, backend := newPinningManager(t)
+
+	// While connections remain, wg2 is out of the multipath route but keeps its own traffic
+	remaining := []int{2, 1, 0}
+	var polled []netip.Addr
+	counter := func(sources []netip.Addr) (int, error) {
+		polled = sources
+		if hops := nexthops(backend); len(hops) != 2 || hops[1] != "wg1" {
+			t.Errorf("Expected wg2 out of the multipath route while draining, got %v", hops)
+		}
+		if rules := backend.SourceRules(); len(rules) != 3 || rules[2].Interface != "wg2" {
+			t.Errorf("Expected wg2's connections held by its source rule, got %+v", rules)
+		}
+		count := remaining[0]
+		remaining = remaining[1:]
+		return count, nil
+	}
+
+	drain, err := tm.DrainTunnel(context.Background(), counter, time.Millisecond)
+	if err != nil {
+		t.Fatalf("DrainTunnel failed: %v", err)
+	}
+	if drain.ID != 2 || drain.Interface != "wg2" || drain.Remaining != 0 || len(remaining) != 0 {
+		t.Errorf("Expected wg2 removed once its connections finished, got %+v with %v left", drain, remaining)
+	}
+	if len(polled) != 1 || polled[0].String() != "10.100.3.2" {
+		t.Errorf("Expected connections counted from wg2's address, got %v", polled)
+	}
+	if _, ok := backend.Config("wg2"); ok || len(backend.SourceRules()) != 2 {
+		t.Error("Expected wg2 and its source rule gone")
+	}
+}
+
+func TestDrainTunnelTimeout(t *testing.T) {
+	tm, backend := newPinningManager(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	drain, err := tm.DrainTunnel(ctx, func([]netip.Addr) (int, error) { return 3, nil }, time.Hour)
+	if err != nil {
+		t.Fatalf("DrainTunnel failed: %v", err)
+	}
+	if drain.Remaining != 3 {
+		t.Errorf("Expected the cut-off connections reported, got %+v", drain)
+	}
+	if _, ok := backend.Config("wg2"); ok {
+		t.Error("Expected wg2 removed when the wait ends")
+	}
+
+	// A tunnel whose connections cannot be counted goes back into service
+	_, err = tm.DrainTunnel(context.Background(), func([]netip.Addr) (int, error) {
+		return 0, errors.New("conntrack unavailable")
+	}, time.Millisecond)
+	if err == nil {
+		t.Fatal("Expected the counting error")
+	}
+	if hops := nexthops(backend); len(hops) != 2 || len(backend.SourceRules()) != 2 {
+		t.Errorf("Expected wg1 back in the multipath route with its rule, got %v", hops)
+	}
+	if state := tm.tunnels[1].Status.State; state != "active" {
+		t.Errorf("Expected wg1 active again, got %s", state)
+	}
+}
+
+func TestAddTunnelReusesFreedID(t *testing.T) {
+	tm, backend := newPinningManager(t)
+
+	// Dedicating wg2 makes wg1 the next one removed, leaving a gap in the middle
+	if _, err := tm.PinFlow(transfer(2, 50000)); err != nil {
+		t.Fatalf("PinFlow failed: %v", err)
+	}
+	if err := tm.RemoveTunnel(); err != nil {
+		t.Fatalf("RemoveTunnel failed: %v", err)
+	}
+	if _, exists := tm.tunnels[1]; exists {
+		t.Fatal("Expected wg1 removed")
+	}
+
+	if id := tm.NextTunnelID(); id != 1 {
+		t.Errorf("Expected the freed ID next, got %d", id)
+	}
+	pinned := tm.tunnels[2]
+	if err := tm.AddTunnel(); err != nil {
+		t.Fatalf("AddTunnel failed: %v", err)
+	}
+	if tm.tunnels[1] == nil || tm.tunnels[1].Interface != "wg1" || tm.tunnels[1].Port != 51821 {
+		t.Errorf("Expected wg1 back on its own port, got %+v", tm.tunnels[1])
+	}
+	if tm.tunnels[2] != pinned || len(tm.PinnedFlows()) != 1 {
+		t.Error("The pinned tunnel should be left alone")
+	}
+	if hops := nexthops(backend); len(hops) != 2 || hops[0] != "wg0" || hops[1] != "wg1" {
+		t.Errorf("Expected wg1 back in the multipath route, got %v", hops)
+	}
+}
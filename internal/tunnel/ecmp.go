@@ -0,0 +1,150 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Nexthop is one tunnel a multipath route spreads traffic over
+type Nexthop struct {
+	Interface string
+	Weight    int
+}
+
+// Route sends a destination across one or more tunnels
+type Route struct {
+	Destination netip.Prefix
+	Nexthops    []Nexthop
+}
+
+// Policy routing for the tunnels' own addresses: tunnel N's rules look up table
+// flowTableBase+N
+const (
+	flowTableBase    = 5180
+	flowRulePriority = 1000 // Ahead of the main table's 32766
+)
+
+// ErrNeedsPrivilege is returned when the kernel routing table cannot be changed
+var ErrNeedsPrivilege = errors.New("ECMP routing needs root or CAP_NET_ADMIN")
+
+// SourceRule keeps packets from one of a tunnel's addresses on that tunnel, whatever the
+// multipath routes say
+type SourceRule struct {
+	Source       netip.Addr
+	Interface    string
+	Destinations []netip.Prefix
+	Table        int
+}
+
+// Router installs the client's multipath routes. Backends that keep interfaces outside
+// the kernel implement it themselves; otherwise the kernel routing table is used.
+type Router interface {
+	// SetHashPolicy makes multipath selection hash on the L4 5-tuple, so separate flows
+	// between the same two hosts can take different tunnels
+	SetHashPolicy() error
+	ReplaceRoute(route Route) error
+	DeleteRoute(destination netip.Prefix) error
+
+	// AddSourceRule and DeleteSourceRule manage a tunnel address's rule. The source
+	// address of a connection comes from the nexthop the multipath route picked first,
+	// and later lookups hash in the ports and may pick another tunnel, whose bastion
+	// peer would drop a packet from a different tunnel's address.
+	AddSourceRule(rule SourceRule) error
+	DeleteSourceRule(rule SourceRule) error
+}
+
+// parseDestinations parses the CIDRs routed across the tunnels
+func parseDestinations(cidrs []string) ([]netip.Prefix, error) {
+	var destinations []netip.Prefix
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ECMP destination %q: %w", cidr, err)
+		}
+		destinations = append(destinations, prefix.Masked())
+	}
+	return destinations, nil
+}
+
+// computeRoutes spreads every destination across the active tunnels, in tunnel order.
+// IPv6 destinations only use tunnels with an IPv6 address; a destination no tunnel can
+// carry gets no route.
+func computeRoutes(destinations []netip.Prefix, tunnels []*WireGuardTunnel) []Route {
+	sorted := slices.Clone(tunnels)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	var routes []Route
+	for _, destination := range destinations {
+		route := Route{Destination: destination}
+		for _, tunnel := range sorted {
+			tunnel.mu.RLock()
+			usable := tunnel.Status.State == "active" && (destination.Addr().Is4() || tunnel.EndpointIPv6 != "")
+			tunnel.mu.RUnlock()
+			if usable {
+				route.Nexthops = append(route.Nexthops, Nexthop{Interface: tunnel.Interface, Weight: 1})
+			}
+		}
+		if len(route.Nexthops) > 0 {
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
+
+// diffRoutes returns the routes to replace and the destinations to delete to get from
+// the installed routes to the desired ones
+func diffRoutes(installed map[netip.Prefix]Route, desired []Route) (replace []Route, remove []netip.Prefix) {
+	wanted := make(map[netip.Prefix]bool)
+	for _, route := range desired {
+		wanted[route.Destination] = true
+		if current, ok := installed[route.Destination]; !ok || !slices.Equal(current.Nexthops, route.Nexthops) {
+			replace = append(replace, route)
+		}
+	}
+	for destination := range installed {
+		if !wanted[destination] {
+			remove = append(remove, destination)
+		}
+	}
+	sort.Slice(remove, func(i, j int) bool { return remove[i].String() < remove[j].String() })
+	return replace, remove
+}
+
+// sourceRules returns a rule per tunnel address, covering the destinations of its family
+func sourceRules(tunnel *WireGuardTunnel, destinations []netip.Prefix) ([]SourceRule, error) {
+	var rules []SourceRule
+	for _, source := range tunnelAddresses(tunnel) {
+		rule := SourceRule{Source: source, Interface: tunnel.Interface, Table: flowTableBase + tunnel.ID}
+		for _, destination := range destinations {
+			if destination.Addr().Is4() == source.Is4() {
+				rule.Destinations = append(rule.Destinations, destination)
+			}
+		}
+		if len(rule.Destinations) > 0 {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == 0 {
+		var cidrs []string
+		for _, destination := range destinations {
+			cidrs = append(cidrs, destination.String())
+		}
+		return nil, fmt.Errorf("%s has no address for the ECMP destinations (%s)", tunnel.Interface, strings.Join(cidrs, ", "))
+	}
+	return rules, nil
+}
+
+// tunnelAddresses returns a tunnel's client addresses
+func tunnelAddresses(tunnel *WireGuardTunnel) []netip.Addr {
+	var addresses []netip.Addr
+	for _, address := range []string{tunnel.EndpointIP, tunnel.EndpointIPv6} {
+		if prefix, err := netip.ParsePrefix(address); err == nil {
+			addresses = append(addresses, prefix.Addr())
+		}
+	}
+	return addresses
+}
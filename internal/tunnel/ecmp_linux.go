@@ -0,0 +1,170 @@
+//go:build linux
+
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+// Multipath hash policy sysctls; 1 hashes on the L4 5-tuple instead of addresses only
+var hashPolicySysctls = []string{
+	"/proc/sys/net/ipv4/fib_multipath_hash_policy",
+	"/proc/sys/net/ipv6/fib_multipath_hash_policy",
+}
+
+// KernelRouter installs routes in the main routing table over rtnetlink
+type KernelRouter struct{}
+
+// capNetAdmin is CAP_NET_ADMIN's bit in the capability sets of /proc/self/status
+const capNetAdmin = 12
+
+// NewKernelRouter returns a router for the kernel routing table. Changing it needs root or
+// CAP_NET_ADMIN; without either it fails with ErrNeedsPrivilege.
+func NewKernelRouter() (Router, error) {
+	if os.Geteuid() != 0 && !hasCapability(capNetAdmin) {
+		return nil, ErrNeedsPrivilege
+	}
+	return &KernelRouter{}, nil
+}
+
+// hasCapability reports whether the process has a capability in its effective set
+func hasCapability(bit uint) bool {
+	status, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		if value, ok := strings.CutPrefix(line, "CapEff:"); ok {
+			caps, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+			return err == nil && caps&(1<<bit) != 0
+		}
+	}
+	return false
+}
+
+// SetHashPolicy switches IPv4 and, where available, IPv6 multipath hashing to L4
+func (r *KernelRouter) SetHashPolicy() error {
+	for _, path := range hashPolicySysctls {
+		err := os.WriteFile(path, []byte("1"), 0644)
+		if errors.Is(err, os.ErrNotExist) {
+			continue // IPv6 disabled
+		}
+		if errors.Is(err, os.ErrPermission) {
+			return fmt.Errorf("failed to set multipath hash policy: %w", ErrNeedsPrivilege)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to set multipath hash policy: %w", err)
+		}
+	}
+	return nil
+}
+
+// ReplaceRoute installs or updates a route
+func (r *KernelRouter) ReplaceRoute(route Route) error {
+	nlRoute, err := netlinkRoute(route, func(name string) (int, error) {
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			return 0, err
+		}
+		return link.Attrs().Index, nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := netlink.RouteReplace(nlRoute); err != nil {
+		return fmt.Errorf("failed to install route to %s: %w", route.Destination, err)
+	}
+	return nil
+}
+
+// DeleteRoute removes a route; one that is already gone is not an error
+func (r *KernelRouter) DeleteRoute(destination netip.Prefix) error {
+	err := netlink.RouteDel(&netlink.Route{Dst: ipNet(destination)})
+	if err != nil && !errors.Is(err, syscall.ESRCH) {
+		return fmt.Errorf("failed to delete route to %s: %w", destination, err)
+	}
+	return nil
+}
+
+// netlinkRoute builds the rtnetlink route, a plain device route for a single nexthop and
+// a multipath route otherwise
+func netlinkRoute(route Route, linkIndex func(name string) (int, error)) (*netlink.Route, error) {
+	if len(route.Nexthops) == 0 {
+		return nil, fmt.Errorf("route to %s has no nexthops", route.Destination)
+	}
+	nlRoute := &netlink.Route{Dst: ipNet(route.Destination), Scope: netlink.SCOPE_LINK}
+	for _, nexthop := range route.Nexthops {
+		index, err := linkIndex(nexthop.Interface)
+		if err != nil {
+			return nil, fmt.Errorf("tunnel interface %s not found: %w", nexthop.Interface, err)
+		}
+		// Hops is the weight minus one, as in rtnh_hops
+		nlRoute.MultiPath = append(nlRoute.MultiPath, &netlink.NexthopInfo{LinkIndex: index, Hops: max(nexthop.Weight, 1) - 1})
+	}
+	if len(nlRoute.MultiPath) == 1 {
+		nlRoute.LinkIndex = nlRoute.MultiPath[0].LinkIndex
+		nlRoute.MultiPath = nil
+	}
+	return nlRoute, nil
+}
+
+func ipNet(prefix netip.Prefix) *net.IPNet {
+	return &net.IPNet{IP: prefix.Addr().AsSlice(), Mask: net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen())}
+}
+
+// AddSourceRule routes the rule's destinations through its interface in the rule's table,
+// and sends packets from its source address to that table
+func (r *KernelRouter) AddSourceRule(rule SourceRule) error {
+	link, err := netlink.LinkByName(rule.Interface)
+	if err != nil {
+		return fmt.Errorf("tunnel interface %s not found: %w", rule.Interface, err)
+	}
+	for _, destination := range rule.Destinations {
+		route := &netlink.Route{Dst: ipNet(destination), LinkIndex: link.Attrs().Index, Scope: netlink.SCOPE_LINK, Table: rule.Table}
+		if err := netlink.RouteReplace(route); err != nil {
+			return fmt.Errorf("failed to route %s through %s in table %d: %w", destination, rule.Interface, rule.Table, err)
+		}
+	}
+	if err := netlink.RuleAdd(netlinkRule(rule)); err != nil && !errors.Is(err, syscall.EEXIST) {
+		return fmt.Errorf("failed to add rule from %s: %w", rule.Source, err)
+	}
+	return nil
+}
+
+// DeleteSourceRule removes the rule and its table routes; ones already gone, such as with
+// the interface, are not an error
+func (r *KernelRouter) DeleteSourceRule(rule SourceRule) error {
+	var errs []error
+	if err := netlink.RuleDel(netlinkRule(rule)); err != nil && !errors.Is(err, syscall.ENOENT) {
+		errs = append(errs, fmt.Errorf("failed to delete rule from %s: %w", rule.Source, err))
+	}
+	for _, destination := range rule.Destinations {
+		err := netlink.RouteDel(&netlink.Route{Dst: ipNet(destination), Table: rule.Table})
+		if err != nil && !errors.Is(err, syscall.ESRCH) {
+			errs = append(errs, fmt.Errorf("failed to delete route to %s from table %d: %w", destination, rule.Table, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// netlinkRule builds the rtnetlink rule for a source address
+func netlinkRule(rule SourceRule) *netlink.Rule {
+	nlRule := netlink.NewRule()
+	nlRule.Src = ipNet(netip.PrefixFrom(rule.Source, rule.Source.BitLen()))
+	nlRule.Table = rule.Table
+	nlRule.Priority = flowRulePriority
+	nlRule.Family = netlink.FAMILY_V4
+	if rule.Source.Is6() {
+		nlRule.Family = netlink.FAMILY_V6
+	}
+	return nlRule
+}
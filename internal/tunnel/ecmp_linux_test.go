@@ -0,0 +1,59 @@
+//go:build linux
+
+package tunnel
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestNetlinkRoute(t *testing.T) {
+	indexes := map[string]int{"wg0": 7, "wg1": 8}
+	linkIndex := func(name string) (int, error) {
+		if index, ok := indexes[name]; ok {
+			return index, nil
+		}
+		return 0, fmt.Errorf("no such link")
+	}
+	destination := netip.MustParsePrefix("10.0.0.0/16")
+
+	route, err := netlinkRoute(Route{Destination: destination, Nexthops: []Nexthop{{"wg0", 1}, {"wg1", 3}}}, linkIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if route.Dst.String() != "10.0.0.0/16" || route.Scope != netlink.SCOPE_LINK || len(route.MultiPath) != 2 {
+		t.Fatalf("Unexpected route %+v", route)
+	}
+	if route.MultiPath[0].LinkIndex != 7 || route.MultiPath[0].Hops != 0 || route.MultiPath[1].Hops != 2 {
+		t.Errorf("Unexpected nexthops %v", route.MultiPath)
+	}
+
+	route, err = netlinkRoute(Route{Destination: netip.MustParsePrefix("2600:1f14::/56"), Nexthops: []Nexthop{{"wg1", 1}}}, linkIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if route.LinkIndex != 8 || route.MultiPath != nil || route.Dst.String() != "2600:1f14::/56" {
+		t.Errorf("A single nexthop should be a plain device route, got %+v", route)
+	}
+
+	if _, err := netlinkRoute(Route{Destination: destination, Nexthops: []Nexthop{{"wg9", 1}}}, linkIndex); err == nil {
+		t.Error("Expected a missing interface to fail")
+	}
+	if _, err := netlinkRoute(Route{Destination: destination}, linkIndex); err == nil {
+		t.Error("Expected a route without nexthops to fail")
+	}
+}
+
+func TestNetlinkRule(t *testing.T) {
+	rule := netlinkRule(SourceRule{Source: netip.MustParseAddr("10.100.2.2"), Interface: "wg1", Table: flowTableBase + 1})
+	if rule.Src.String() != "10.100.2.2/32" || rule.Table != 5181 || rule.Priority != flowRulePriority || rule.Family != netlink.FAMILY_V4 {
+		t.Errorf("Unexpected rule %+v", rule)
+	}
+	rule = netlinkRule(SourceRule{Source: netip.MustParseAddr("fd6d:6f6c:6500:2::2"), Table: flowTableBase + 1})
+	if rule.Src.String() != "fd6d:6f6c:6500:2::2/128" || rule.Family != netlink.FAMILY_V6 {
+		t.Errorf("Unexpected IPv6 rule %+v", rule)
+	}
+}
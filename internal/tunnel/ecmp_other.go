@@ -0,0 +1,10 @@
+//go:build !linux
+
+package tunnel
+
+import "fmt"
+
+// NewKernelRouter fails outside Linux, where mole does not manage multipath routes
+func NewKernelRouter() (Router, error) {
+	return nil, fmt.Errorf("ECMP routing is only supported on Linux")
+}
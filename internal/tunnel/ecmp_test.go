@@ -0,0 +1,68 @@
+package tunnel
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestComputeRoutes(t *testing.T) {
+	tunnels := []*WireGuardTunnel{
+		{ID: 2, Interface: "wg2", EndpointIPv6: "fd6d:6f6c:6500:3::2/64", Status: TunnelStatus{State: "active"}},
+		{ID: 0, Interface: "wg0", EndpointIPv6: "fd6d:6f6c:6500:1::2/64", Status: TunnelStatus{State: "active"}},
+		{ID: 1, Interface: "wg1", Status: TunnelStatus{State: "active"}},
+		{ID: 3, Interface: "wg3", EndpointIPv6: "fd6d:6f6c:6500:4::2/64", Status: TunnelStatus{State: "error"}},
+	}
+	destinations, err := parseDestinations([]string{"10.0.0.0/16", "2600:1f14::/56", "172.31.0.1/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := computeRoutes(destinations, tunnels)
+	if len(routes) != 3 {
+		t.Fatalf("Expected 3 routes, got %+v", routes)
+	}
+	want := []Nexthop{{"wg0", 1}, {"wg1", 1}, {"wg2", 1}}
+	if got := routes[0].Nexthops; len(got) != 3 || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("IPv4 route nexthops = %v, want %v", got, want)
+	}
+	if got := routes[1].Nexthops; len(got) != 2 || got[0].Interface != "wg0" || got[1].Interface != "wg2" {
+		t.Errorf("IPv6 route should only use dual-stack tunnels, got %v", got)
+	}
+	if routes[2].Destination.String() != "172.31.0.0/16" {
+		t.Errorf("Destinations should be masked, got %s", routes[2].Destination)
+	}
+
+	// No active tunnel, no route
+	for _, tunnel := range tunnels {
+		tunnel.Status.State = "error"
+	}
+	if routes := computeRoutes(destinations, tunnels); len(routes) != 0 {
+		t.Errorf("Expected no routes without active tunnels, got %+v", routes)
+	}
+}
+
+func TestDiffRoutes(t *testing.T) {
+	a, b, c := netip.MustParsePrefix("10.0.0.0/16"), netip.MustParsePrefix("10.1.0.0/16"), netip.MustParsePrefix("10.2.0.0/16")
+	both := []Nexthop{{"wg0", 1}, {"wg1", 1}}
+	installed := map[netip.Prefix]Route{
+		a: {Destination: a, Nexthops: both},
+		b: {Destination: b, Nexthops: both},
+	}
+	desired := []Route{
+		{Destination: a, Nexthops: both},
+		{Destination: c, Nexthops: both[:1]},
+	}
+
+	replace, remove := diffRoutes(installed, desired)
+	if len(replace) != 1 || replace[0].Destination != c {
+		t.Errorf("Only the new route should be replaced, got %+v", replace)
+	}
+	if len(remove) != 1 || remove[0] != b {
+		t.Errorf("Expected %s to be removed, got %v", b, remove)
+	}
+
+	desired[0].Nexthops = both[1:]
+	if replace, _ := diffRoutes(installed, desired); len(replace) != 2 {
+		t.Errorf("A changed nexthop set should be replaced, got %+v", replace)
+	}
+}
@@ -0,0 +1,266 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Default tunnel address plan: tunnel N gets 10.100.(N+1).0/24, as the bastion has always used
+const (
+	DefaultBaseCIDR        = "10.100.0.0/16"
+	DefaultTunnelPrefixLen = 24
+)
+
+// Allocation is the IPv4 network of one tunnel. The bastion takes the first host address
+// and this host the second; the rest are left for extra peers.
+type Allocation struct {
+	ID      int          `json:"id"`
+	Network netip.Prefix `json:"network"`
+}
+
+// Server returns the bastion's address with the network's prefix length
+func (a Allocation) Server() netip.Prefix {
+	return netip.PrefixFrom(a.Network.Addr().Next(), a.Network.Bits())
+}
+
+// Client returns this host's address with the network's prefix length
+func (a Allocation) Client() netip.Prefix {
+	return netip.PrefixFrom(a.Network.Addr().Next().Next(), a.Network.Bits())
+}
+
+// Reservation is a range tunnels must stay clear of, such as the VPC or a local route
+type Reservation struct {
+	Prefix netip.Prefix
+	Reason string
+}
+
+// IPAM carves per-tunnel networks out of a base CIDR, avoiding reserved ranges. With a
+// state file, allocations are saved on every change so tunnels keep their networks across
+// restarts.
+type IPAM struct {
+	Base        netip.Prefix `json:"base"`
+	PrefixLen   int          `json:"prefix_len"`
+	Allocations []Allocation `json:"allocations"`
+
+	reserved []Reservation
+	path     string
+	mu       sync.Mutex
+}
+
+// IPAMPath is where this host's tunnel allocations are kept. Interface names are per host,
+// so one file covers every bastion.
+func IPAMPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".mole", "ipam.json")
+}
+
+// NewIPAM returns an allocator kept in memory. prefixLen 0 selects DefaultTunnelPrefixLen.
+func NewIPAM(baseCIDR string, prefixLen int) (*IPAM, error) {
+	if prefixLen == 0 {
+		prefixLen = DefaultTunnelPrefixLen
+	}
+	base, err := netip.ParsePrefix(baseCIDR)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tunnel base CIDR %q: %w", baseCIDR, err)
+	}
+	if !base.Addr().Is4() {
+		return nil, fmt.Errorf("tunnel base CIDR %s must be IPv4", baseCIDR)
+	}
+	// Each tunnel needs a network address, the bastion and this host
+	if prefixLen <= base.Bits() || prefixLen > 30 {
+		return nil, fmt.Errorf("tunnel prefix length /%d must be longer than %s and at most /30", prefixLen, baseCIDR)
+	}
+	return &IPAM{Base: base.Masked(), PrefixLen: prefixLen}, nil
+}
+
+// LoadIPAM returns an allocator saved at path. Allocations made for a different base
+// CIDR or prefix length are dropped; a missing file starts empty.
+func LoadIPAM(path, baseCIDR string, prefixLen int) (*IPAM, error) {
+	ipam, err := NewIPAM(baseCIDR, prefixLen)
+	if err != nil {
+		return nil, err
+	}
+	ipam.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ipam, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tunnel allocations: %w", err)
+	}
+	var saved IPAM
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("failed to parse tunnel allocations %s: %w", path, err)
+	}
+	if saved.Base == ipam.Base && saved.PrefixLen == ipam.PrefixLen {
+		ipam.Allocations = saved.Allocations
+	}
+	return ipam, nil
+}
+
+// Reserve keeps tunnels clear of prefixes. It fails when a prefix covers the whole base
+// CIDR, since no tunnel could be allocated.
+func (p *IPAM) Reserve(reason string, prefixes ...netip.Prefix) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, prefix := range prefixes {
+		prefix = prefix.Masked()
+		if !prefix.Addr().Is4() {
+			continue
+		}
+		if prefix.Bits() <= p.Base.Bits() && prefix.Contains(p.Base.Addr()) {
+			return fmt.Errorf("tunnel base CIDR %s lies within %s %s; set tunnel.base_cidr to a free range", p.Base, reason, prefix)
+		}
+		p.reserved = append(p.reserved, Reservation{Prefix: prefix, Reason: reason})
+	}
+	return nil
+}
+
+// ReserveLocalRoutes keeps tunnels clear of the host's routes, skipping the named
+// interfaces. Routes broader than the base CIDR, such as a campus 10.0.0.0/8, are left
+// out: the tunnel routes are more specific and take precedence.
+func (p *IPAM) ReserveLocalRoutes(skip []string) error {
+	routes, err := LocalRoutes(skip)
+	if err != nil {
+		return err
+	}
+	for _, route := range routes {
+		if route.Prefix.Bits() < p.Base.Bits() {
+			continue
+		}
+		if err := p.Reserve(route.Reason, route.Prefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TunnelInterfaces names the interfaces of tunnels 0 to count-1
+func TunnelInterfaces(count int) []string {
+	names := make([]string, count)
+	for i := range names {
+		names[i] = fmt.Sprintf("wg%d", i)
+	}
+	return names
+}
+
+// Allocate returns the network of tunnel id. An existing allocation is kept unless it
+// now overlaps a reservation. New tunnels prefer the (id+1)th network of the base, which
+// matches the default plan, and otherwise take the first free one.
+func (p *IPAM) Allocate(id int) (Allocation, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if i := p.index(id); i >= 0 {
+		allocation := p.Allocations[i]
+		if p.conflict(allocation.Network, id) == "" {
+			return allocation, nil
+		}
+		p.Allocations = slices.Delete(p.Allocations, i, i+1)
+	}
+
+	count := 1 << (p.PrefixLen - p.Base.Bits())
+	candidates := []int{id + 1}
+	for n := 1; n < count; n++ {
+		candidates = append(candidates, n)
+	}
+	candidates = append(candidates, 0)
+
+	var blocked []string
+	for _, n := range candidates {
+		if n >= count {
+			continue
+		}
+		network := p.subnet(n)
+		if reason := p.conflict(network, id); reason != "" {
+			if n == id+1 {
+				blocked = append(blocked, reason)
+			}
+			continue
+		}
+		allocation := Allocation{ID: id, Network: network}
+		p.Allocations = append(p.Allocations, allocation)
+		sort.Slice(p.Allocations, func(i, j int) bool { return p.Allocations[i].ID < p.Allocations[j].ID })
+		return allocation, p.save()
+	}
+	return Allocation{}, fmt.Errorf("no free /%d left in %s for tunnel %d (%s)", p.PrefixLen, p.Base, id, strings.Join(blocked, "; "))
+}
+
+// Lookup returns tunnel id's allocation without allocating
+func (p *IPAM) Lookup(id int) (Allocation, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if i := p.index(id); i >= 0 {
+		return p.Allocations[i], true
+	}
+	return Allocation{}, false
+}
+
+// Release frees tunnel id's network
+func (p *IPAM) Release(id int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i := p.index(id)
+	if i < 0 {
+		return nil
+	}
+	p.Allocations = slices.Delete(p.Allocations, i, i+1)
+	return p.save()
+}
+
+func (p *IPAM) index(id int) int {
+	return slices.IndexFunc(p.Allocations, func(a Allocation) bool { return a.ID == id })
+}
+
+// subnet returns the n'th network of the base
+func (p *IPAM) subnet(n int) netip.Prefix {
+	addr := p.Base.Addr().As4()
+	value := uint32(addr[0])<<24 | uint32(addr[1])<<16 | uint32(addr[2])<<8 | uint32(addr[3])
+	value += uint32(n) << (32 - p.PrefixLen)
+	return netip.PrefixFrom(netip.AddrFrom4([4]byte{byte(value >> 24), byte(value >> 16), byte(value >> 8), byte(value)}), p.PrefixLen)
+}
+
+// conflict describes what network overlaps, other than tunnel id's own allocation
+func (p *IPAM) conflict(network netip.Prefix, id int) string {
+	for _, reservation := range p.reserved {
+		if reservation.Prefix.Overlaps(network) {
+			return fmt.Sprintf("%s overlaps %s %s", network, reservation.Reason, reservation.Prefix)
+		}
+	}
+	for _, allocation := range p.Allocations {
+		if allocation.ID != id && allocation.Network.Overlaps(network) {
+			return fmt.Sprintf("%s is used by tunnel %d", network, allocation.ID)
+		}
+	}
+	return ""
+}
+
+// save writes the allocations readable only by the current user; in-memory allocators
+// are not saved
+func (p *IPAM) save() error {
+	if p.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p.path), 0700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode tunnel allocations: %w", err)
+	}
+	if err := os.WriteFile(p.path, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to save tunnel allocations: %w", err)
+	}
+	return nil
+}
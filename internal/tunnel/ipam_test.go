@@ -0,0 +1,177 @@
+package tunnel
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIPAMDefaultPlan(t *testing.T) {
+	ipam, err := NewIPAM(DefaultBaseCIDR, 0)
+	if err != nil {
+		t.Fatalf("NewIPAM failed: %v", err)
+	}
+
+	// Matches the bastion's 10.100.(N+1).0/24 plan
+	for id, expected := range []string{"10.100.1.0/24", "10.100.2.0/24", "10.100.3.0/24"} {
+		allocation, err := ipam.Allocate(id)
+		if err != nil {
+			t.Fatalf("Allocate(%d) failed: %v", id, err)
+		}
+		if allocation.Network.String() != expected {
+			t.Errorf("Tunnel %d: expected %s, got %s", id, expected, allocation.Network)
+		}
+	}
+
+	allocation, _ := ipam.Lookup(0)
+	if allocation.Server().String() != "10.100.1.1/24" || allocation.Client().String() != "10.100.1.2/24" {
+		t.Errorf("Unexpected peer addresses %s and %s", allocation.Server(), allocation.Client())
+	}
+	if _, ok := ipam.Lookup(5); ok {
+		t.Error("Lookup should not allocate")
+	}
+}
+
+func TestIPAMReservations(t *testing.T) {
+	ipam, _ := NewIPAM(DefaultBaseCIDR, 0)
+
+	// The VPC's public subnet sits on tunnel 0's default network
+	if err := ipam.Reserve("public subnet", netip.MustParsePrefix("10.100.1.0/24")); err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+	allocation, err := ipam.Allocate(0)
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+	if allocation.Network.String() != "10.100.2.0/24" {
+		t.Errorf("Expected the first free network, got %s", allocation.Network)
+	}
+	allocation, _ = ipam.Allocate(1)
+	if allocation.Network.String() != "10.100.3.0/24" {
+		t.Errorf("Tunnel 1 should skip tunnel 0's network, got %s", allocation.Network)
+	}
+
+	// IPv6 prefixes do not affect IPv4 allocation
+	if err := ipam.Reserve("VPC", netip.MustParsePrefix("2600:1f14::/56")); err != nil {
+		t.Errorf("IPv6 reservation rejected: %v", err)
+	}
+
+	// A VPC covering the whole base leaves nothing to allocate
+	err = ipam.Reserve("VPC", netip.MustParsePrefix("10.100.0.0/16"))
+	if err == nil || !strings.Contains(err.Error(), "base_cidr") {
+		t.Errorf("Expected a base CIDR conflict, got %v", err)
+	}
+}
+
+func TestIPAMExhausted(t *testing.T) {
+	ipam, err := NewIPAM("192.168.50.0/29", 30)
+	if err != nil {
+		t.Fatalf("NewIPAM failed: %v", err)
+	}
+	first, _ := ipam.Allocate(0)
+	second, _ := ipam.Allocate(1)
+	if first.Network.String() != "192.168.50.4/30" || second.Network.String() != "192.168.50.0/30" {
+		t.Errorf("Unexpected /30 allocations %s and %s", first.Network, second.Network)
+	}
+	if second.Client().String() != "192.168.50.2/30" {
+		t.Errorf("Unexpected /30 client address %s", second.Client())
+	}
+	if _, err := ipam.Allocate(2); err == nil {
+		t.Error("Expected an error once the base is exhausted")
+	}
+
+	// A released network can be reused
+	if err := ipam.Release(0); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if allocation, err := ipam.Allocate(2); err != nil || allocation.Network != first.Network {
+		t.Errorf("Expected tunnel 2 to reuse %s, got %s (%v)", first.Network, allocation.Network, err)
+	}
+}
+
+func TestIPAMPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "ipam.json")
+
+	ipam, err := LoadIPAM(path, "172.29.0.0/20", 26)
+	if err != nil {
+		t.Fatalf("LoadIPAM failed: %v", err)
+	}
+	ipam.Reserve("local route", netip.MustParsePrefix("172.29.0.64/26"))
+	allocated, err := ipam.Allocate(0)
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+	if allocated.Network.String() != "172.29.0.128/26" {
+		t.Errorf("Unexpected allocation %s", allocated.Network)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("State file should be saved with mode 0600: %v", err)
+	}
+
+	// Stable across restarts, even once the route that moved it is gone
+	reloaded, err := LoadIPAM(path, "172.29.0.0/20", 26)
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if allocation, err := reloaded.Allocate(0); err != nil || allocation != allocated {
+		t.Errorf("Expected %s after reload, got %s (%v)", allocated.Network, allocation.Network, err)
+	}
+
+	// A new conflict moves the tunnel, here back to its preferred network
+	reloaded.Reserve("VPC", netip.MustParsePrefix("172.29.0.128/25"))
+	if allocation, _ := reloaded.Allocate(0); allocation.Network.String() != "172.29.0.64/26" {
+		t.Errorf("Expected tunnel 0 to move off the VPC, got %s", allocation.Network)
+	}
+
+	// Allocations for another base are dropped
+	changed, err := LoadIPAM(path, DefaultBaseCIDR, 0)
+	if err != nil {
+		t.Fatalf("LoadIPAM with a new base failed: %v", err)
+	}
+	if _, ok := changed.Lookup(0); ok {
+		t.Error("Allocations from a different base CIDR should be dropped")
+	}
+}
+
+func TestNewIPAMValidation(t *testing.T) {
+	invalid := []struct {
+		base      string
+		prefixLen int
+	}{
+		{"not-a-cidr", 24},
+		{"fd6d:6f6c:6500::/48", 64}, // IPv6
+		{"10.100.0.0/24", 24},       // No room to split
+		{"10.100.0.0/16", 31},       // No room for both peers
+	}
+	for _, test := range invalid {
+		if _, err := NewIPAM(test.base, test.prefixLen); err == nil {
+			t.Errorf("Expected %s with /%d to be rejected", test.base, test.prefixLen)
+		}
+	}
+}
+
+func TestManagerUsesBaseCIDR(t *testing.T) {
+	tm := NewTunnelManagerWithBackend(&TunnelConfig{
+		MinTunnels:      1,
+		MaxTunnels:      2,
+		BaseCIDR:        "172.29.0.0/16",
+		TunnelPrefixLen: 30,
+		MTU:             1420,
+		ListenPort:      51820,
+	}, NewMemoryBackend())
+	if err := tm.ReserveNetworks("VPC", "172.29.0.4/30"); err != nil {
+		t.Fatalf("ReserveNetworks failed: %v", err)
+	}
+
+	if err := tm.AttachTunnel(0, "wg0", "key"); err != nil {
+		t.Fatalf("AttachTunnel failed: %v", err)
+	}
+	if ip := tm.tunnels[0].EndpointIP; ip != "172.29.0.10/30" {
+		t.Errorf("Expected tunnel 0 past the reserved network, got %s", ip)
+	}
+	if err := tm.ReserveNetworks("VPC", "not-a-cidr"); err == nil {
+		t.Error("Expected an invalid CIDR to be rejected")
+	}
+}
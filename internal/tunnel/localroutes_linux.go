@@ -0,0 +1,47 @@
+//go:build linux
+
+package tunnel
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"github.com/vishvananda/netlink"
+)
+
+// LocalRoutes returns the host's IPv4 routes, other than the default route and routes
+// through the skipped interfaces (mole's own tunnels)
+func LocalRoutes(skip []string) ([]Reservation, error) {
+	routes, err := netlink.RouteList(nil, netlink.FAMILY_V4)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list local routes: %w", err)
+	}
+
+	var reservations []Reservation
+	for _, route := range routes {
+		if route.Dst == nil {
+			continue
+		}
+		name := fmt.Sprintf("link %d", route.LinkIndex)
+		if link, err := netlink.LinkByIndex(route.LinkIndex); err == nil {
+			name = link.Attrs().Name
+		}
+		if slices.Contains(skip, name) {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(route.Dst.IP)
+		if !ok {
+			continue
+		}
+		bits, _ := route.Dst.Mask.Size()
+		if bits == 0 {
+			continue
+		}
+		reservations = append(reservations, Reservation{
+			Prefix: netip.PrefixFrom(addr.Unmap(), bits),
+			Reason: "local route via " + name,
+		})
+	}
+	return reservations, nil
+}
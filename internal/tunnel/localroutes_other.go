@@ -0,0 +1,45 @@
+//go:build !linux
+
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+)
+
+// LocalRoutes returns the networks of the host's interfaces, other than the skipped ones
+// (mole's own tunnels). Outside Linux only connected networks are considered.
+func LocalRoutes(skip []string) ([]Reservation, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list local interfaces: %w", err)
+	}
+
+	var reservations []Reservation
+	for _, iface := range interfaces {
+		if slices.Contains(skip, iface.Name) || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			prefix, err := netip.ParsePrefix(ipNet.String())
+			if err != nil || !prefix.Addr().Is4() {
+				continue
+			}
+			reservations = append(reservations, Reservation{
+				Prefix: prefix.Masked(),
+				Reason: "local network on " + iface.Name,
+			})
+		}
+	}
+	return reservations, nil
+}
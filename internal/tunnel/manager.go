@@ -1,7 +1,11 @@
 package tunnel
 
 import (
+	"errors"
 	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,7 +17,17 @@ import (
 type TunnelManager struct {
 	config  *TunnelConfig
 	tunnels map[int]*WireGuardTunnel
-	scaler  *TunnelScaler
+	backend Backend
+	router  Router
+	ipam    *IPAM
+	// provisioner configures the bastion end of tunnels; nil when it is managed elsewhere
+	provisioner Provisioner
+	// routes are the installed ECMP routes; nil until ConfigureECMP has run
+	routes map[netip.Prefix]Route
+	// rules are the source rules installed for each tunnel once ECMP is configured
+	rules map[int][]SourceRule
+	// pins are the elephant flows on each dedicated tunnel, which the ECMP routes skip
+	pins    map[int][]monitoring.FlowKey
 	logger  *logger.Logger
 	monitor *monitoring.Monitor
 	mu      sync.RWMutex
@@ -26,19 +40,38 @@ type TunnelConfig struct {
 	BaseCIDR   string `yaml:"base_cidr"`   // Base CIDR for tunnel IPs
 	MTU        int    `yaml:"mtu"`         // Tunnel MTU
 	ListenPort int    `yaml:"listen_port"` // Starting port for tunnels
+
+	// BaseIPv6CIDR is a ULA prefix (/48 or shorter) for dual-stack tunnels; empty disables IPv6
+	BaseIPv6CIDR string `yaml:"base_ipv6_cidr"`
+
+	// Backend selects how interfaces are configured: wg-quick (default), netlink or memory
+	Backend string `yaml:"backend"`
+
+	// TunnelPrefixLen is the size of each tunnel's network carved from BaseCIDR (default: 24)
+	TunnelPrefixLen int `yaml:"tunnel_prefix_len"`
+
+	// IPAMState is the file tunnel allocations are kept in; empty keeps them in memory
+	IPAMState string `yaml:"ipam_state"`
+
+	// Destinations are the AWS-side CIDRs ConfigureECMP spreads across the tunnels
+	Destinations []string `yaml:"destinations"`
 }
 
+// DefaultIPv6CIDR is the ULA prefix used for dual-stack tunnels ("mole" in the global ID)
+const DefaultIPv6CIDR = "fd6d:6f6c:6500::/48"
+
 // WireGuardTunnel represents a single WireGuard tunnel
 type WireGuardTunnel struct {
-	ID         int
-	Interface  string
-	PrivateKey string
-	PublicKey  string
-	EndpointIP string
-	Port       int
-	Status     TunnelStatus
-	Metrics    TunnelMetrics
-	mu         sync.RWMutex
+	ID           int
+	Interface    string
+	PrivateKey   string
+	PublicKey    string
+	EndpointIP   string
+	EndpointIPv6 string // Empty unless dual-stack is enabled
+	Port         int
+	Status       TunnelStatus
+	Metrics      TunnelMetrics
+	mu           sync.RWMutex
 }
 
 // TunnelStatus represents tunnel state
@@ -59,18 +92,28 @@ type TunnelMetrics struct {
 	LastUpdate time.Time
 }
 
-// TunnelScaler manages dynamic tunnel scaling (placeholder for now)
-type TunnelScaler struct {
-	// Implementation will be moved from dynamic-scaling.go
+// NewTunnelManager creates a new tunnel manager using the backend named in the config.
+// An unavailable backend falls back to wg-quick.
+func NewTunnelManager(config *TunnelConfig) *TunnelManager {
+	var name string
+	if config != nil {
+		name = config.Backend
+	}
+	backend, err := NewBackend(name)
+	if err != nil {
+		fmt.Printf("⚠️  %v, using %s\n", err, BackendWGQuick)
+		backend = NewWGQuickBackend()
+	}
+	return NewTunnelManagerWithBackend(config, backend)
 }
 
-// NewTunnelManager creates a new tunnel manager
-func NewTunnelManager(config *TunnelConfig) *TunnelManager {
+// NewTunnelManagerWithBackend creates a tunnel manager that configures interfaces through backend
+func NewTunnelManagerWithBackend(config *TunnelConfig, backend Backend) *TunnelManager {
 	if config == nil {
 		config = &TunnelConfig{
 			MinTunnels: 1,
 			MaxTunnels: 8,
-			BaseCIDR:   "10.100.0.0/16",
+			BaseCIDR:   DefaultBaseCIDR,
 			MTU:        1420,
 			ListenPort: 51820,
 		}
@@ -79,18 +122,72 @@ func NewTunnelManager(config *TunnelConfig) *TunnelManager {
 	tm := &TunnelManager{
 		config:  config,
 		tunnels: make(map[int]*WireGuardTunnel),
+		backend: backend,
 		monitor: monitoring.NewMonitor(time.Second * 5), // Update every 5 seconds
 	}
+	if router, ok := backend.(Router); ok {
+		tm.router = router
+	}
+	tm.ipam = newManagerIPAM(config)
 
 	// Initialize logger
 	if l, err := logger.New(logger.Config{Component: "tunnel-manager", Level: logger.LevelInfo}); err == nil {
 		tm.logger = l
-		tm.logger.Info("Created tunnel manager", "min_tunnels", config.MinTunnels, "max_tunnels", config.MaxTunnels)
+		tm.logger.Info("Created tunnel manager", "min_tunnels", config.MinTunnels, "max_tunnels", config.MaxTunnels, "backend", backend.Name())
 	}
 
 	return tm
 }
 
+// newManagerIPAM builds the allocator for the config. A bad base CIDR or state file falls
+// back to the default plan in memory.
+func newManagerIPAM(config *TunnelConfig) *IPAM {
+	baseCIDR := config.BaseCIDR
+	if baseCIDR == "" {
+		baseCIDR = DefaultBaseCIDR
+	}
+	var ipam *IPAM
+	var err error
+	if config.IPAMState != "" {
+		ipam, err = LoadIPAM(config.IPAMState, baseCIDR, config.TunnelPrefixLen)
+	} else {
+		ipam, err = NewIPAM(baseCIDR, config.TunnelPrefixLen)
+	}
+	if err != nil {
+		fmt.Printf("⚠️  %v, using %s\n", err, DefaultBaseCIDR)
+		ipam, _ = NewIPAM(DefaultBaseCIDR, DefaultTunnelPrefixLen)
+	}
+	return ipam
+}
+
+// ReserveNetworks keeps tunnel networks clear of cidrs, such as the VPC. Existing tunnels
+// keep their addresses; the reservation applies to tunnels created afterwards.
+func (tm *TunnelManager) ReserveNetworks(reason string, cidrs ...string) error {
+	var prefixes []netip.Prefix
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid %s CIDR %q: %w", reason, cidr, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return tm.ipam.Reserve(reason, prefixes...)
+}
+
+// ReserveLocalRoutes keeps tunnel networks clear of this host's routes, other than those
+// through the manager's own interfaces
+func (tm *TunnelManager) ReserveLocalRoutes() error {
+	return tm.ipam.ReserveLocalRoutes(TunnelInterfaces(tm.config.MaxTunnels))
+}
+
+// SetProvisioner makes the manager configure the far end of every tunnel it creates or
+// destroys from now on
+func (tm *TunnelManager) SetProvisioner(provisioner Provisioner) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.provisioner = provisioner
+}
+
 // CreateTunnels creates the specified number of tunnels
 func (tm *TunnelManager) CreateTunnels(count int) error {
 	tm.mu.Lock()
@@ -110,6 +207,40 @@ func (tm *TunnelManager) CreateTunnels(count int) error {
 	return nil
 }
 
+// AttachTunnel records a client interface that was brought up outside the manager, such as
+// the per-tunnel wg-quick configs written during deployment, so status and ECMP include it
+func (tm *TunnelManager) AttachTunnel(id int, iface, publicKey string) error {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	if _, exists := tm.tunnels[id]; exists {
+		return fmt.Errorf("tunnel %d already exists", id)
+	}
+	if len(tm.tunnels) >= tm.config.MaxTunnels {
+		return fmt.Errorf("maximum tunnel count reached (%d)", tm.config.MaxTunnels)
+	}
+
+	tunnelIP, err := tm.calculateTunnelIP(id)
+	if err != nil {
+		return err
+	}
+
+	tm.tunnels[id] = &WireGuardTunnel{
+		ID:           id,
+		Interface:    iface,
+		PublicKey:    publicKey,
+		EndpointIP:   tunnelIP,
+		EndpointIPv6: tm.calculateTunnelIPv6(id),
+		Port:         tm.config.ListenPort + id,
+		Status: TunnelStatus{
+			State:    "active",
+			LastSeen: time.Now(),
+		},
+	}
+
+	return tm.syncRoutes()
+}
+
 // AddTunnel adds a new tunnel
 func (tm *TunnelManager) AddTunnel() error {
 	tm.mu.Lock()
@@ -119,8 +250,28 @@ func (tm *TunnelManager) AddTunnel() error {
 		return fmt.Errorf("maximum tunnel count reached (%d)", tm.config.MaxTunnels)
 	}
 
-	nextID := len(tm.tunnels)
-	return tm.createTunnel(nextID)
+	if err := tm.createTunnel(tm.nextTunnelID()); err != nil {
+		return err
+	}
+	return tm.syncRoutes()
+}
+
+// NextTunnelID returns the ID AddTunnel will use next
+func (tm *TunnelManager) NextTunnelID() int {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	return tm.nextTunnelID()
+}
+
+// nextTunnelID returns the lowest unused ID, so a tunnel added after a removal takes over
+// the freed interface name, port and network rather than colliding with a later tunnel.
+// The caller holds tm.mu.
+func (tm *TunnelManager) nextTunnelID() int {
+	id := 0
+	for tm.tunnels[id] != nil {
+		id++
+	}
+	return id
 }
 
 // RemoveTunnel removes a tunnel
@@ -128,29 +279,273 @@ func (tm *TunnelManager) RemoveTunnel() error {
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
 
+	tunnel, err := tm.removalCandidate()
+	if err != nil {
+		return err
+	}
+
+	// Take the tunnel out of the routes first: deleting an interface drops every
+	// multipath route through it
+	tm.setState(tunnel, "draining")
+	if err := tm.syncRoutes(); err != nil {
+		tm.setState(tunnel, "active")
+		return err
+	}
+
+	if err := tm.destroyTunnel(tunnel.ID); err != nil {
+		tm.setState(tunnel, "active")
+		return errors.Join(err, tm.syncRoutes())
+	}
+	return nil
+}
+
+// removalCandidate picks the highest numbered tunnel, sparing those dedicated to elephant
+// flows or already draining and the last one left for everything else. The caller holds
+// tm.mu.
+func (tm *TunnelManager) removalCandidate() (*WireGuardTunnel, error) {
 	if len(tm.tunnels) <= tm.config.MinTunnels {
-		return fmt.Errorf("minimum tunnel count reached (%d)", tm.config.MinTunnels)
+		return nil, fmt.Errorf("minimum tunnel count reached (%d)", tm.config.MinTunnels)
 	}
 
-	// Remove the highest numbered tunnel
-	var highestID int
-	for id := range tm.tunnels {
-		if id > highestID {
-			highestID = id
+	highestID, shared := -1, 0
+	for id, tunnel := range tm.tunnels {
+		tunnel.mu.RLock()
+		draining := tunnel.Status.State == "draining"
+		tunnel.mu.RUnlock()
+		if tm.dedicated(id) || draining {
+			continue
 		}
+		shared++
+		highestID = max(highestID, id)
 	}
-
-	return tm.destroyTunnel(highestID)
+	if shared <= 1 && shared < len(tm.tunnels) {
+		return nil, fmt.Errorf("cannot remove a tunnel: %w", ErrNoSpareTunnel)
+	}
+	return tm.tunnels[highestID], nil
 }
 
-// ConfigureECMP configures Equal Cost Multi-Path routing for tunnels
+// ConfigureECMP spreads the configured destinations across all active tunnels with
+// multipath routes hashed on the L4 5-tuple. From then on the routes follow tunnels
+// being added, removed or failing.
 func (tm *TunnelManager) ConfigureECMP() error {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	destinations, err := parseDestinations(tm.config.Destinations)
+	if err != nil {
+		return err
+	}
+	if len(destinations) == 0 {
+		return fmt.Errorf("no ECMP destinations configured")
+	}
+	if tm.router == nil {
+		if tm.router, err = NewKernelRouter(); err != nil {
+			return err
+		}
+	}
+	if err := tm.router.SetHashPolicy(); err != nil {
+		return err
+	}
+
+	if tm.routes == nil {
+		tm.routes = make(map[netip.Prefix]Route)
+		tm.rules = make(map[int][]SourceRule)
+	}
+	if err := tm.syncRoutes(); err != nil {
+		return err
+	}
+	if tm.logger != nil {
+		tm.logger.Info("Configured ECMP", "destinations", len(destinations), "tunnels", len(tm.tunnels))
+	}
+	return nil
+}
+
+// SetTunnelState marks a tunnel active or failed ("error") and updates the ECMP routes
+func (tm *TunnelManager) SetTunnelState(id int, state string) error {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	tunnel, exists := tm.tunnels[id]
+	if !exists {
+		return fmt.Errorf("tunnel %d does not exist", id)
+	}
+	tm.setState(tunnel, state)
+	return tm.syncRoutes()
+}
+
+// CheckTunnels validates every tunnel through the backend, marks failing ones "error" and
+// recovered ones "active", and updates the ECMP routes. It returns the failed tunnel IDs.
+func (tm *TunnelManager) CheckTunnels() ([]int, error) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	var failed []int
+	for id, tunnel := range tm.tunnels {
+		tunnel.mu.RLock()
+		state := tunnel.Status.State
+		tunnel.mu.RUnlock()
+		if state != "active" && state != "error" {
+			continue
+		}
+		if err := tm.backend.Validate(tunnel.Interface); err != nil {
+			failed = append(failed, id)
+			tm.setState(tunnel, "error")
+		} else {
+			tm.setState(tunnel, "active")
+		}
+	}
+	sort.Ints(failed)
+	return failed, tm.syncRoutes()
+}
+
+// setState updates a tunnel's state, recording when it was last seen active
+func (tm *TunnelManager) setState(tunnel *WireGuardTunnel, state string) {
+	tunnel.mu.Lock()
+	defer tunnel.mu.Unlock()
+	tunnel.Status.State = state
+	if state == "active" {
+		tunnel.Status.LastSeen = time.Now()
+	}
+}
+
+// syncRoutes brings the installed ECMP routes and source rules in line with the active
+// tunnels. The caller holds tm.mu. It does nothing before ConfigureECMP.
+func (tm *TunnelManager) syncRoutes() error {
+	if tm.routes == nil {
+		return nil
+	}
+	destinations, err := parseDestinations(tm.config.Destinations)
+	if err != nil {
+		return err
+	}
+	errs := []error{tm.syncRules(destinations)}
+
+	tunnels := make([]*WireGuardTunnel, 0, len(tm.tunnels))
+	for id, tunnel := range tm.tunnels {
+		if !tm.dedicated(id) {
+			tunnels = append(tunnels, tunnel)
+		}
+	}
+	replace, remove := diffRoutes(tm.routes, computeRoutes(destinations, tunnels))
+
+	for _, route := range replace {
+		if err := tm.router.ReplaceRoute(route); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		tm.routes[route.Destination] = route
+	}
+	for _, destination := range remove {
+		if err := tm.router.DeleteRoute(destination); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delete(tm.routes, destination)
+	}
+	return errors.Join(errs...)
+}
+
+// syncRules gives every active or draining tunnel a source rule per address, so the
+// packets of a connection stay on the tunnel whose address it took whichever nexthop the
+// multipath route picks. Rules of removed tunnels are deleted. The caller holds tm.mu.
+func (tm *TunnelManager) syncRules(destinations []netip.Prefix) error {
+	var errs []error
+	for id, tunnel := range tm.tunnels {
+		tunnel.mu.RLock()
+		state := tunnel.Status.State
+		tunnel.mu.RUnlock()
+		if (state != "active" && state != "draining") || len(tm.rules[id]) > 0 {
+			continue
+		}
+		rules, err := sourceRules(tunnel, destinations)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, rule := range rules {
+			if err := tm.router.AddSourceRule(rule); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			tm.rules[id] = append(tm.rules[id], rule)
+		}
+	}
+	for id := range tm.rules {
+		if _, exists := tm.tunnels[id]; !exists {
+			errs = append(errs, tm.deleteRules(id))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// deleteRules removes a tunnel's source rules. The caller holds tm.mu.
+func (tm *TunnelManager) deleteRules(id int) error {
+	var errs []error
+	for _, rule := range tm.rules[id] {
+		errs = append(errs, tm.router.DeleteSourceRule(rule))
+	}
+	delete(tm.rules, id)
+	return errors.Join(errs...)
+}
+
+// Routes returns the installed ECMP routes ordered by destination
+func (tm *TunnelManager) Routes() []Route {
 	tm.mu.RLock()
 	defer tm.mu.RUnlock()
 
-	// Implementation placeholder
-	fmt.Printf("Configuring ECMP for %d tunnels\n", len(tm.tunnels))
-	return nil
+	routes := make([]Route, 0, len(tm.routes))
+	for _, route := range tm.routes {
+		routes = append(routes, route)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Destination.String() < routes[j].Destination.String() })
+	return routes
+}
+
+// tunnelCount returns the number of tunnels, whatever their state
+func (tm *TunnelManager) tunnelCount() int {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	return len(tm.tunnels)
+}
+
+// utilisation reads every active tunnel's counters from the backend into the monitor and
+// returns each tunnel's throughput as a fraction of capacity (Mbps). Tunnels sampled for
+// the first time have no rate yet and are left out.
+func (tm *TunnelManager) utilisation(capacity float64, now time.Time) map[string]float64 {
+	tm.mu.RLock()
+	var tunnels []*WireGuardTunnel
+	for _, tunnel := range tm.tunnels {
+		tunnel.mu.RLock()
+		if tunnel.Status.State == "active" {
+			tunnels = append(tunnels, tunnel)
+		}
+		tunnel.mu.RUnlock()
+	}
+	tm.mu.RUnlock()
+
+	result := make(map[string]float64)
+	for _, tunnel := range tunnels {
+		stats, err := tm.backend.Stats(tunnel.Interface)
+		if err != nil {
+			continue
+		}
+		var bytesIn, bytesOut uint64
+		for _, peer := range stats.Peers {
+			bytesIn += uint64(peer.RxBytes)
+			bytesOut += uint64(peer.TxBytes)
+		}
+		mbps, ok := tm.monitor.RecordTunnelCounters(tunnel.Interface, bytesIn, bytesOut, now)
+		if !ok {
+			continue
+		}
+		result[tunnel.Interface] = mbps / capacity
+
+		tunnel.mu.Lock()
+		tunnel.Metrics.Throughput = int64(mbps * 1e6)
+		tunnel.Metrics.LastUpdate = now
+		tunnel.mu.Unlock()
+	}
+	return result
 }
 
 // GetActiveTunnels returns status of all active tunnels
@@ -227,12 +622,27 @@ func (tm *TunnelManager) destroyTunnel(id int) error {
 		return fmt.Errorf("tunnel %d does not exist", id)
 	}
 
+	// Its connections have ended or are being cut off, so its source rules go first
+	delete(tm.pins, id)
+	if err := tm.deleteRules(id); err != nil {
+		fmt.Printf("⚠️  %v\n", err)
+	}
+
 	// Bring down interface (placeholder)
 	if err := tm.teardownInterface(tunnel); err != nil {
 		return err
 	}
 
 	delete(tm.tunnels, id)
+	tm.monitor.RemoveTunnel(tunnel.Interface)
+	if tm.provisioner != nil {
+		if err := tm.provisioner.Deprovision(tunnel.Interface); err != nil {
+			fmt.Printf("⚠️  Failed to remove the far end of %s: %v\n", tunnel.Interface, err)
+		}
+	}
+	if err := tm.ipam.Release(id); err != nil {
+		fmt.Printf("⚠️  %v\n", err)
+	}
 	fmt.Printf("Destroyed tunnel %d\n", id)
 
 	return nil
@@ -255,7 +665,18 @@ func (tm *TunnelManager) generateWireGuardKeys(tunnel *WireGuardTunnel) error {
 // configureInterface configures the WireGuard interface
 func (tm *TunnelManager) configureInterface(tunnel *WireGuardTunnel) error {
 	// Calculate tunnel IP address
-	tunnelIP := tm.calculateTunnelIP(tunnel.ID)
+	tunnelIP, err := tm.calculateTunnelIP(tunnel.ID)
+	if err != nil {
+		return err
+	}
+	tunnelIPv6 := tm.calculateTunnelIPv6(tunnel.ID)
+
+	address := tunnelIP
+	allowedIPs := "0.0.0.0/0"
+	if tunnelIPv6 != "" {
+		address += ", " + tunnelIPv6
+		allowedIPs += ", ::/0"
+	}
 
 	// Create WireGuard configuration
 	wgConfig := &WireGuardConfig{
@@ -263,24 +684,69 @@ func (tm *TunnelManager) configureInterface(tunnel *WireGuardTunnel) error {
 		PrivateKey: tunnel.PrivateKey,
 		PublicKey:  tunnel.PublicKey,
 		ListenPort: tunnel.Port,
-		Address:    tunnelIP,
+		Address:    address,
 		MTU:        tm.config.MTU,
 		// Peer configuration will be added when connecting to AWS bastion
-		AllowedIPs: "0.0.0.0/0",
+		AllowedIPs: allowedIPs,
+	}
+
+	if tm.provisioner != nil {
+		if err := tm.provisionPeer(tunnel, wgConfig, tunnelIP, tunnelIPv6); err != nil {
+			return err
+		}
 	}
 
 	// Create the WireGuard interface
 	if err := tm.CreateWireGuardInterface(wgConfig); err != nil {
+		if tm.provisioner != nil {
+			err = errors.Join(err, tm.provisioner.Deprovision(tunnel.Interface))
+		}
 		return fmt.Errorf("failed to create WireGuard interface: %w", err)
 	}
 
 	tunnel.Status.State = "active"
 	tunnel.Status.LastSeen = time.Now()
 	tunnel.EndpointIP = tunnelIP
+	tunnel.EndpointIPv6 = tunnelIPv6
 
 	return nil
 }
 
+// provisionPeer configures the far end of a new tunnel and points the interface at it. The
+// interface only carries its own networks and the ECMP destinations, and leaves routing to
+// ConfigureECMP.
+func (tm *TunnelManager) provisionPeer(tunnel *WireGuardTunnel, wgConfig *WireGuardConfig, tunnelIP, tunnelIPv6 string) error {
+	allocation, _ := tm.ipam.Lookup(tunnel.ID)
+	serverAddress := allocation.Server().String()
+	allowedIPs := []string{allocation.Network.String()}
+	if tunnelIPv6 != "" {
+		prefix := netip.MustParsePrefix(tunnelIPv6)
+		server := prefix.Addr().As16()
+		server[15] = 1
+		serverAddress += ", " + netip.PrefixFrom(netip.AddrFrom16(server), prefix.Bits()).String()
+		allowedIPs = append(allowedIPs, prefix.Masked().String())
+	}
+	allowedIPs = append(allowedIPs, tm.config.Destinations...)
+
+	peer, err := tm.provisioner.Provision(ProvisionRequest{
+		ID:            tunnel.ID,
+		Interface:     tunnel.Interface,
+		Port:          tunnel.Port,
+		ServerAddress: serverAddress,
+		ClientAddress: wgConfig.Address,
+		PublicKey:     tunnel.PublicKey,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to provision the far end of %s: %w", tunnel.Interface, err)
+	}
+
+	wgConfig.PeerPublicKey = peer.PublicKey
+	wgConfig.PeerEndpoint = peer.Endpoint
+	wgConfig.AllowedIPs = strings.Join(allowedIPs, ", ")
+	wgConfig.Table = "off"
+	return nil
+}
+
 // teardownInterface tears down the WireGuard interface
 func (tm *TunnelManager) teardownInterface(tunnel *WireGuardTunnel) error {
 	// Destroy the actual WireGuard interface
@@ -292,9 +758,34 @@ func (tm *TunnelManager) teardownInterface(tunnel *WireGuardTunnel) error {
 	return nil
 }
 
-// calculateTunnelIP calculates the IP address for a tunnel
-func (tm *TunnelManager) calculateTunnelIP(tunnelID int) string {
-	// Parse base CIDR to get network address
-	// For simplicity, assume 10.100.0.0/16 and assign 10.100.X.1 to each tunnel
-	return fmt.Sprintf("10.100.%d.1/24", tunnelID+1)
+// calculateTunnelIP returns the client IP address for a tunnel from its allocated network,
+// with the bastion on the first host and the client on the second. With the default base
+// tunnel N uses 10.100.(N+1).0/24, matching the bastion config.
+func (tm *TunnelManager) calculateTunnelIP(tunnelID int) (string, error) {
+	allocation, err := tm.ipam.Allocate(tunnelID)
+	if err != nil {
+		return "", err
+	}
+	return allocation.Client().String(), nil
+}
+
+// calculateTunnelIPv6 calculates the client ULA address for a tunnel, mirroring calculateTunnelIP:
+// tunnel N gets ::2 in the (N+1)th /64 of BaseIPv6CIDR. Returns "" when IPv6 is disabled.
+func (tm *TunnelManager) calculateTunnelIPv6(tunnelID int) string {
+	if tm.config.BaseIPv6CIDR == "" {
+		return ""
+	}
+
+	prefix, err := netip.ParsePrefix(tm.config.BaseIPv6CIDR)
+	if err != nil || !prefix.Addr().Is6() || prefix.Bits() > 48 {
+		return ""
+	}
+
+	addr := prefix.Masked().Addr().As16()
+	subnet := tunnelID + 1
+	addr[6] = byte(subnet >> 8)
+	addr[7] = byte(subnet)
+	addr[15] = 2
+
+	return netip.PrefixFrom(netip.AddrFrom16(addr), 64).String()
 }
@@ -1,6 +1,7 @@
 package tunnel
 
 import (
+	"fmt"
 	"testing"
 	"time"
 )
@@ -58,22 +59,57 @@ func TestCalculateTunnelIP(t *testing.T) {
 		tunnelID int
 		expected string
 	}{
-		{0, "10.100.1.1/24"},
-		{1, "10.100.2.1/24"},
-		{2, "10.100.3.1/24"},
-		{7, "10.100.8.1/24"},
+		{0, "10.100.1.2/24"},
+		{1, "10.100.2.2/24"},
+		{2, "10.100.3.2/24"},
+		{7, "10.100.8.2/24"},
 	}
 
 	for _, test := range tests {
-		result := tm.calculateTunnelIP(test.tunnelID)
+		result, err := tm.calculateTunnelIP(test.tunnelID)
+		if err != nil {
+			t.Fatalf("calculateTunnelIP(%d) failed: %v", test.tunnelID, err)
+		}
 		if result != test.expected {
 			t.Errorf("For tunnelID %d, expected %s, got %s", test.tunnelID, test.expected, result)
 		}
 	}
 }
 
-func TestTunnelValidation(t *testing.T) {
+func TestCalculateTunnelIPv6(t *testing.T) {
 	tm := NewTunnelManager(nil)
+	if ip := tm.calculateTunnelIPv6(0); ip != "" {
+		t.Errorf("IPv6 should be disabled by default, got %s", ip)
+	}
+
+	tm.config.BaseIPv6CIDR = DefaultIPv6CIDR
+
+	tests := []struct {
+		tunnelID int
+		expected string
+	}{
+		{0, "fd6d:6f6c:6500:1::2/64"},
+		{1, "fd6d:6f6c:6500:2::2/64"},
+		{255, "fd6d:6f6c:6500:100::2/64"},
+	}
+
+	for _, test := range tests {
+		result := tm.calculateTunnelIPv6(test.tunnelID)
+		if result != test.expected {
+			t.Errorf("For tunnelID %d, expected %s, got %s", test.tunnelID, test.expected, result)
+		}
+	}
+
+	// Prefixes too long to carve /64s from are rejected
+	tm.config.BaseIPv6CIDR = "fd00::/64"
+	if ip := tm.calculateTunnelIPv6(0); ip != "" {
+		t.Errorf("Expected empty address for /64 base, got %s", ip)
+	}
+}
+
+func TestTunnelValidation(t *testing.T) {
+	backend := NewMemoryBackend()
+	tm := NewTunnelManagerWithBackend(nil, backend)
 
 	// Test exceeding max tunnels
 	err := tm.CreateTunnels(9) // Max is 8
@@ -82,22 +118,26 @@ func TestTunnelValidation(t *testing.T) {
 	}
 
 	// Test valid tunnel count
-	err = tm.CreateTunnels(3)
-	if err != nil {
-		// This might fail in test environment due to WireGuard dependencies
-		// but we test the validation logic
-		t.Logf("CreateTunnels failed (expected in test env): %v", err)
+	if err := tm.CreateTunnels(3); err != nil {
+		t.Fatalf("CreateTunnels failed: %v", err)
+	}
+	if ifaces := backend.Interfaces(); len(ifaces) != 3 {
+		t.Errorf("Expected 3 interfaces on the backend, got %v", ifaces)
+	}
+	if err := tm.ValidateWireGuardInterface("wg2"); err != nil {
+		t.Errorf("ValidateWireGuardInterface failed: %v", err)
 	}
 }
 
 func TestAddRemoveTunnel(t *testing.T) {
-	tm := NewTunnelManager(&TunnelConfig{
+	backend := NewMemoryBackend()
+	tm := NewTunnelManagerWithBackend(&TunnelConfig{
 		MinTunnels: 1,
 		MaxTunnels: 3,
 		BaseCIDR:   "10.100.0.0/16",
 		MTU:        1420,
 		ListenPort: 51820,
-	})
+	}, backend)
 
 	// Test adding tunnel beyond max
 	for i := 0; i < 4; i++ {
@@ -114,6 +154,18 @@ func TestAddRemoveTunnel(t *testing.T) {
 			}
 		}
 	}
+
+	config, ok := backend.Config("wg1")
+	if !ok || config.Address != "10.100.2.2/24" || config.ListenPort != 51821 || config.MTU != 1420 {
+		t.Errorf("Unexpected backend config for wg1: %+v", config)
+	}
+
+	if err := tm.RemoveTunnel(); err != nil {
+		t.Fatalf("RemoveTunnel failed: %v", err)
+	}
+	if ifaces := backend.Interfaces(); len(ifaces) != 2 || ifaces[1] != "wg1" {
+		t.Errorf("Expected wg2 to be brought down, got %v", ifaces)
+	}
 }
 
 func TestTunnelStatus(t *testing.T) {
@@ -130,6 +182,28 @@ func TestTunnelStatus(t *testing.T) {
 	}
 }
 
+func TestAttachTunnel(t *testing.T) {
+	tm := NewTunnelManager(&TunnelConfig{MinTunnels: 1, MaxTunnels: 2, ListenPort: 51820})
+
+	if err := tm.AttachTunnel(1, "wg1", "client-key"); err != nil {
+		t.Fatalf("AttachTunnel failed: %v", err)
+	}
+	tunnel := tm.tunnels[1]
+	if tunnel.EndpointIP != "10.100.2.2/24" || tunnel.Port != 51821 || tunnel.Status.State != "active" {
+		t.Errorf("Unexpected attached tunnel: %+v", tunnel)
+	}
+
+	if err := tm.AttachTunnel(1, "wg1", "client-key"); err == nil {
+		t.Error("Attaching the same tunnel ID twice should fail")
+	}
+	if err := tm.AttachTunnel(0, "wg0", "client-key"); err != nil {
+		t.Fatalf("AttachTunnel failed: %v", err)
+	}
+	if err := tm.AttachTunnel(2, "wg2", "client-key"); err == nil {
+		t.Error("Attaching beyond MaxTunnels should fail")
+	}
+}
+
 func TestTunnelMetrics(t *testing.T) {
 	tm := NewTunnelManager(nil)
 
@@ -155,14 +229,153 @@ func TestTunnelMetrics(t *testing.T) {
 }
 
 func TestConfigureECMP(t *testing.T) {
-	tm := NewTunnelManager(nil)
+	backend := NewMemoryBackend()
+	tm := NewTunnelManagerWithBackend(&TunnelConfig{
+		MinTunnels:   1,
+		MaxTunnels:   4,
+		MTU:          1420,
+		ListenPort:   51820,
+		Destinations: []string{"10.0.0.0/16", "172.31.5.0/24"},
+	}, backend)
+
+	nexthops := func(destination string) []string {
+		t.Helper()
+		var names []string
+		for _, route := range backend.Routes() {
+			if route.Destination.String() == destination {
+				for _, nexthop := range route.Nexthops {
+					names = append(names, nexthop.Interface)
+				}
+			}
+		}
+		return names
+	}
 
-	err := tm.ConfigureECMP()
+	for i := 0; i < 3; i++ {
+		if err := tm.AddTunnel(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(backend.Routes()) != 0 {
+		t.Fatal("No routes should be installed before ConfigureECMP")
+	}
+
+	if err := tm.ConfigureECMP(); err != nil {
+		t.Fatalf("ConfigureECMP failed: %v", err)
+	}
+	if !backend.HashPolicy() {
+		t.Error("Expected the L4 hash policy to be set")
+	}
+	if got := nexthops("10.0.0.0/16"); len(got) != 3 || got[0] != "wg0" || got[2] != "wg2" {
+		t.Errorf("Expected 10.0.0.0/16 across wg0-wg2, got %v", got)
+	}
+	if len(tm.Routes()) != 2 {
+		t.Errorf("Expected 2 installed routes, got %v", tm.Routes())
+	}
+
+	// New tunnels join the routes
+	if err := tm.AddTunnel(); err != nil {
+		t.Fatal(err)
+	}
+	if got := nexthops("172.31.5.0/24"); len(got) != 4 {
+		t.Errorf("Expected wg3 to join the route, got %v", got)
+	}
+
+	// Removed tunnels leave them without taking the routes down
+	if err := tm.RemoveTunnel(); err != nil {
+		t.Fatal(err)
+	}
+	if got := nexthops("10.0.0.0/16"); len(got) != 3 {
+		t.Errorf("Expected the route to survive removing wg3, got %v", got)
+	}
+
+	// Failed tunnels are dropped and come back when they recover
+	backend.Down("wg1")
+	failed, err := tm.CheckTunnels()
 	if err != nil {
-		t.Errorf("ConfigureECMP failed: %v", err)
+		t.Fatalf("CheckTunnels failed: %v", err)
+	}
+	if len(failed) != 1 || failed[0] != 1 {
+		t.Errorf("Expected tunnel 1 to fail, got %v", failed)
+	}
+	if got := nexthops("10.0.0.0/16"); len(got) != 2 || got[1] != "wg2" {
+		t.Errorf("Expected wg1 to be dropped, got %v", got)
+	}
+	backend.Up(&WireGuardConfig{Interface: "wg1"})
+	if failed, err := tm.CheckTunnels(); err != nil || len(failed) != 0 {
+		t.Fatalf("CheckTunnels = %v, %v", failed, err)
+	}
+	if got := nexthops("10.0.0.0/16"); len(got) != 3 {
+		t.Errorf("Expected wg1 back in the route, got %v", got)
+	}
+
+	if err := tm.SetTunnelState(0, "error"); err != nil {
+		t.Fatal(err)
+	}
+	if got := nexthops("10.0.0.0/16"); len(got) != 2 || got[0] != "wg1" {
+		t.Errorf("Expected wg0 to be dropped, got %v", got)
+	}
+}
+
+func TestConfigureECMPSourceRules(t *testing.T) {
+	backend := NewMemoryBackend()
+	tm := NewTunnelManagerWithBackend(&TunnelConfig{
+		MinTunnels:   1,
+		MaxTunnels:   4,
+		MTU:          1420,
+		ListenPort:   51820,
+		Destinations: []string{"10.200.0.0/16"},
+	}, backend)
+	if err := tm.CreateTunnels(3); err != nil {
+		t.Fatalf("CreateTunnels failed: %v", err)
+	}
+	if len(backend.SourceRules()) != 0 {
+		t.Fatal("No source rules should be installed before ConfigureECMP")
+	}
+
+	// Every tunnel's address keeps its packets on that tunnel
+	if err := tm.ConfigureECMP(); err != nil {
+		t.Fatalf("ConfigureECMP failed: %v", err)
+	}
+	rules := backend.SourceRules()
+	if len(rules) != 3 {
+		t.Fatalf("Expected a source rule per tunnel, got %+v", rules)
+	}
+	for i, rule := range rules {
+		source := fmt.Sprintf("10.100.%d.2", i+1)
+		if rule.Source.String() != source || rule.Interface != fmt.Sprintf("wg%d", i) || rule.Table != 5180+i {
+			t.Errorf("Expected a rule from %s through wg%d in table %d, got %+v", source, i, 5180+i, rule)
+		}
+		if len(rule.Destinations) != 1 || rule.Destinations[0].String() != "10.200.0.0/16" {
+			t.Errorf("Expected the rule to cover the ECMP destinations, got %v", rule.Destinations)
+		}
+	}
+
+	// Rules follow tunnels being added and removed
+	if err := tm.AddTunnel(); err != nil {
+		t.Fatal(err)
+	}
+	if rules := backend.SourceRules(); len(rules) != 4 || rules[3].Interface != "wg3" {
+		t.Errorf("Expected wg3 to get a rule, got %+v", rules)
+	}
+	if err := tm.RemoveTunnel(); err != nil {
+		t.Fatal(err)
+	}
+	if rules := backend.SourceRules(); len(rules) != 3 || rules[2].Interface != "wg2" {
+		t.Errorf("Expected wg3's rule removed with it, got %+v", rules)
+	}
+}
+
+func TestConfigureECMPWithoutDestinations(t *testing.T) {
+	tm := NewTunnelManagerWithBackend(nil, NewMemoryBackend())
+	if err := tm.ConfigureECMP(); err == nil {
+		t.Error("Expected ConfigureECMP without destinations to fail")
+	}
+
+	tm = NewTunnelManagerWithBackend(&TunnelConfig{MaxTunnels: 1, Destinations: []string{"10.0.0.0/33"}}, NewMemoryBackend())
+	if err := tm.ConfigureECMP(); err == nil {
+		t.Error("Expected an invalid destination to fail")
 	}
-	// In test environment, this is mostly a validation that the function exists
-	// and doesn't panic. Real ECMP configuration requires system-level permissions.
 }
 
 // Test WireGuardTunnel struct
@@ -0,0 +1,169 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sort"
+
+	"github.com/research-computing/mole/internal/monitoring"
+)
+
+// ErrNoSpareTunnel is returned when dedicating a tunnel would leave none for other traffic
+var ErrNoSpareTunnel = errors.New("no tunnel left for other traffic")
+
+// FlowPin is an elephant flow kept on a tunnel of its own
+type FlowPin struct {
+	Flow      monitoring.FlowKey
+	TunnelID  int
+	Interface string
+}
+
+// PinFlow gives an elephant flow a dedicated tunnel: the one it already uses, as its source
+// address is that tunnel's. The tunnel leaves the multipath routes, so new connections take
+// the others, while its source rules keep the flow and the connections already there on
+// it. It needs ConfigureECMP and fails with ErrNoSpareTunnel when no other active tunnel
+// would be left.
+func (tm *TunnelManager) PinFlow(flow monitoring.FlowKey) (FlowPin, error) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	if tm.routes == nil {
+		return FlowPin{}, fmt.Errorf("flow pinning needs ECMP routing")
+	}
+
+	tunnel := tm.tunnelWithAddress(flow.Source)
+	if tunnel == nil {
+		return FlowPin{}, fmt.Errorf("flow %s does not come from a tunnel address", flow)
+	}
+	pin := FlowPin{Flow: flow, TunnelID: tunnel.ID, Interface: tunnel.Interface}
+	if slices.Contains(tm.pins[tunnel.ID], flow) {
+		return pin, nil
+	}
+
+	if len(tm.pins[tunnel.ID]) == 0 {
+		shared := 0
+		for id, other := range tm.tunnels {
+			other.mu.RLock()
+			if id != tunnel.ID && other.Status.State == "active" && len(tm.pins[id]) == 0 {
+				shared++
+			}
+			other.mu.RUnlock()
+		}
+		if shared == 0 {
+			return FlowPin{}, ErrNoSpareTunnel
+		}
+	}
+
+	if tm.pins == nil {
+		tm.pins = make(map[int][]monitoring.FlowKey)
+	}
+	tm.pins[tunnel.ID] = append(tm.pins[tunnel.ID], flow)
+	if tm.logger != nil {
+		tm.logger.Info("Pinned elephant flow", "flow", flow.String(), "tunnel", tunnel.Interface)
+	}
+	return pin, tm.syncRoutes()
+}
+
+// UnpinFlow releases a pinned flow. A tunnel without pinned flows returns to the multipath
+// routes.
+func (tm *TunnelManager) UnpinFlow(flow monitoring.FlowKey) error {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	for id, flows := range tm.pins {
+		i := slices.Index(flows, flow)
+		if i < 0 {
+			continue
+		}
+		tm.pins[id] = slices.Delete(flows, i, i+1)
+		if len(tm.pins[id]) > 0 {
+			return nil
+		}
+		delete(tm.pins, id)
+		return tm.syncRoutes()
+	}
+	return nil
+}
+
+// PinElephants pins every elephant in flows and unpins pinned flows that are no longer in
+// flows because their connections closed. Elephants on a tunnel that cannot be dedicated
+// keep sharing. It returns the flows pinned and unpinned.
+func (tm *TunnelManager) PinElephants(flows []monitoring.Flow) (pinned, unpinned []FlowPin, err error) {
+	current := make(map[monitoring.FlowKey]bool)
+	for _, flow := range flows {
+		current[flow.Key] = true
+	}
+
+	var errs []error
+	for _, pin := range tm.PinnedFlows() {
+		if current[pin.Flow] {
+			continue
+		}
+		if err := tm.UnpinFlow(pin.Flow); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		unpinned = append(unpinned, pin)
+	}
+
+	already := make(map[monitoring.FlowKey]bool)
+	for _, pin := range tm.PinnedFlows() {
+		already[pin.Flow] = true
+	}
+	for _, flow := range flows {
+		if !flow.Elephant || already[flow.Key] {
+			continue
+		}
+		pin, err := tm.PinFlow(flow.Key)
+		if errors.Is(err, ErrNoSpareTunnel) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		pinned = append(pinned, pin)
+	}
+	return pinned, unpinned, errors.Join(errs...)
+}
+
+// PinnedFlows returns the pinned flows ordered by tunnel
+func (tm *TunnelManager) PinnedFlows() []FlowPin {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	var pins []FlowPin
+	for id, flows := range tm.pins {
+		for _, flow := range flows {
+			pin := FlowPin{Flow: flow, TunnelID: id}
+			if tunnel, exists := tm.tunnels[id]; exists {
+				pin.Interface = tunnel.Interface
+			}
+			pins = append(pins, pin)
+		}
+	}
+	sort.Slice(pins, func(i, j int) bool {
+		if pins[i].TunnelID != pins[j].TunnelID {
+			return pins[i].TunnelID < pins[j].TunnelID
+		}
+		return pins[i].Flow.String() < pins[j].Flow.String()
+	})
+	return pins
+}
+
+// dedicated reports whether a tunnel carries pinned flows. The caller holds tm.mu.
+func (tm *TunnelManager) dedicated(id int) bool {
+	return len(tm.pins[id]) > 0
+}
+
+// tunnelWithAddress returns the tunnel whose client address is addr. The caller holds tm.mu.
+func (tm *TunnelManager) tunnelWithAddress(addr netip.Addr) *WireGuardTunnel {
+	for _, tunnel := range tm.tunnels {
+		if slices.Contains(tunnelAddresses(tunnel), addr) {
+			return tunnel
+		}
+	}
+	return nil
+}
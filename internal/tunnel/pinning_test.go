@@ -0,0 +1,142 @@
+package tunnel
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/research-computing/mole/internal/monitoring"
+)
+
+// newPinningManager returns a manager with three tunnels spreading 10.200.0.0/16 over ECMP
+func newPinningManager(t *testing.T) (*TunnelManager, *MemoryBackend) {
+	t.Helper()
+	backend := NewMemoryBackend()
+	tm := NewTunnelManagerWithBackend(&TunnelConfig{
+		MinTunnels:   1,
+		MaxTunnels:   3,
+		MTU:          1420,
+		ListenPort:   51820,
+		Destinations: []string{"10.200.0.0/16"},
+	}, backend)
+	if err := tm.CreateTunnels(3); err != nil {
+		t.Fatalf("CreateTunnels failed: %v", err)
+	}
+	if err := tm.ConfigureECMP(); err != nil {
+		t.Fatalf("ConfigureECMP failed: %v", err)
+	}
+	return tm, backend
+}
+
+// transfer is a flow from tunnel id's client address
+func transfer(id int, port uint16) monitoring.FlowKey {
+	return monitoring.FlowKey{
+		Protocol:        "tcp",
+		Source:          netip.AddrFrom4([4]byte{10, 100, byte(id + 1), 2}),
+		Destination:     netip.MustParseAddr("10.200.2.15"),
+		SourcePort:      port,
+		DestinationPort: 2811,
+	}
+}
+
+func nexthops(backend *MemoryBackend) []string {
+	var interfaces []string
+	for _, nexthop := range backend.Routes()[0].Nexthops {
+		interfaces = append(interfaces, nexthop.Interface)
+	}
+	return interfaces
+}
+
+func TestPinFlow(t *testing.T) {
+	tm, backend := newPinningManager(t)
+
+	pin, err := tm.PinFlow(transfer(1, 50000))
+	if err != nil {
+		t.Fatalf("PinFlow failed: %v", err)
+	}
+	if pin.TunnelID != 1 || pin.Interface != "wg1" {
+		t.Errorf("Expected the flow's own tunnel, got %+v", pin)
+	}
+
+	// The tunnel keeps its own traffic through its source rule and leaves the shared routes
+	rules := backend.SourceRules()
+	if len(rules) != 3 || rules[1].Source.String() != "10.100.2.2" || rules[1].Interface != "wg1" || rules[1].Table != 5181 {
+		t.Fatalf("Unexpected source rules %+v", rules)
+	}
+	if hops := nexthops(backend); len(hops) != 2 || hops[0] != "wg0" || hops[1] != "wg2" {
+		t.Errorf("Expected wg1 out of the multipath route, got %v", hops)
+	}
+
+	// A second elephant on the same tunnel shares it
+	if _, err := tm.PinFlow(transfer(1, 50001)); err != nil {
+		t.Fatalf("PinFlow of a second flow failed: %v", err)
+	}
+	if len(tm.PinnedFlows()) != 2 {
+		t.Errorf("Expected two pinned flows, got %+v", tm.PinnedFlows())
+	}
+
+	// The last shared tunnel cannot be dedicated
+	if _, err := tm.PinFlow(transfer(2, 50002)); err != nil {
+		t.Fatalf("PinFlow on wg2 failed: %v", err)
+	}
+	if _, err := tm.PinFlow(transfer(0, 50003)); !errors.Is(err, ErrNoSpareTunnel) {
+		t.Errorf("Expected ErrNoSpareTunnel, got %v", err)
+	}
+	if _, err := tm.PinFlow(monitoring.FlowKey{Protocol: "tcp", Source: netip.MustParseAddr("192.0.2.10")}); err == nil {
+		t.Error("Expected a flow from outside the tunnels to be rejected")
+	}
+
+	// Scaling down keeps a tunnel for the other traffic
+	if err := tm.RemoveTunnel(); !errors.Is(err, ErrNoSpareTunnel) {
+		t.Errorf("Expected the last shared tunnel to stay, got %v", err)
+	}
+
+	// wg1 rejoins once both its flows are released
+	tm.UnpinFlow(transfer(1, 50000))
+	if hops := nexthops(backend); len(hops) != 1 {
+		t.Errorf("wg1 should stay dedicated while a flow is pinned, got %v", hops)
+	}
+	tm.UnpinFlow(transfer(1, 50001))
+	if hops := nexthops(backend); len(hops) != 2 || hops[0] != "wg0" || hops[1] != "wg1" {
+		t.Errorf("Expected wg1 back in the multipath route, got %v", hops)
+	}
+	if rules := backend.SourceRules(); len(rules) != 3 {
+		t.Errorf("Expected every tunnel to keep its rule, got %+v", rules)
+	}
+}
+
+func TestPinElephants(t *testing.T) {
+	tm, backend := newPinningManager(t)
+
+	flows := []monitoring.Flow{
+		{Key: transfer(2, 50000), Elephant: true},
+		{Key: transfer(0, 50001)},
+	}
+	pinned, unpinned, err := tm.PinElephants(flows)
+	if err != nil || len(pinned) != 1 || pinned[0].Interface != "wg2" || len(unpinned) != 0 {
+		t.Fatalf("Expected the elephant pinned to wg2, got %+v %+v (%v)", pinned, unpinned, err)
+	}
+
+	// Pinned flows are not removed when scaling down
+	if err := tm.RemoveTunnel(); err != nil {
+		t.Fatalf("RemoveTunnel failed: %v", err)
+	}
+	if _, exists := tm.tunnels[2]; !exists {
+		t.Error("The dedicated tunnel should not be removed")
+	}
+	if _, exists := tm.tunnels[1]; exists {
+		t.Error("Expected the highest shared tunnel to be removed")
+	}
+
+	// The closed connection releases its tunnel
+	pinned, unpinned, err = tm.PinElephants(flows[1:])
+	if err != nil || len(pinned) != 0 || len(unpinned) != 1 || unpinned[0].Flow != flows[0].Key {
+		t.Fatalf("Expected the closed flow unpinned, got %+v %+v (%v)", pinned, unpinned, err)
+	}
+	if rules := backend.SourceRules(); len(rules) != 2 || rules[0].Interface != "wg0" || rules[1].Interface != "wg2" {
+		t.Errorf("Expected only the remaining tunnels' rules, got %+v", rules)
+	}
+	if hops := nexthops(backend); len(hops) != 2 {
+		t.Errorf("Expected both tunnels in the multipath route, got %v", hops)
+	}
+}
@@ -0,0 +1,24 @@
+package tunnel
+
+// Provisioner configures the far end of tunnels the manager creates and destroys, such as
+// the bastion through its agent. Tunnels attached with AttachTunnel are deprovisioned too.
+type Provisioner interface {
+	Provision(req ProvisionRequest) (*ProvisionedPeer, error)
+	Deprovision(iface string) error
+}
+
+// ProvisionRequest describes a new client tunnel whose far end needs configuring
+type ProvisionRequest struct {
+	ID            int
+	Interface     string // wgN on both ends
+	Port          int    // UDP port the far end listens on
+	ServerAddress string // Far end's tunnel address with prefix length
+	ClientAddress string // This host's tunnel address with prefix length
+	PublicKey     string // This host's public key for the tunnel
+}
+
+// ProvisionedPeer is the far end of a provisioned tunnel
+type ProvisionedPeer struct {
+	PublicKey string
+	Endpoint  string // host:port
+}
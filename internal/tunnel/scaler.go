@@ -0,0 +1,214 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/research-computing/mole/internal/config"
+	"github.com/research-computing/mole/internal/monitoring"
+)
+
+// Scaling actions
+const (
+	ScaleUp   = "scale-up"
+	ScaleDown = "scale-down"
+	ScaleHold = "hold"
+)
+
+// DefaultTunnelCapacity is the throughput in Mbps a tunnel is fully utilised at when the
+// scaling config leaves tunnel_capacity_mbps unset
+const DefaultTunnelCapacity = 1500
+
+// defaultScaleInterval applies when the scaling config leaves scale_interval unset
+const defaultScaleInterval = 30 * time.Second
+
+// ScalingDecision is the outcome of one scaler evaluation and the metrics that drove it
+type ScalingDecision struct {
+	Time        time.Time
+	Action      string
+	Reason      string
+	Tunnels     int                // Tunnels before the action
+	Utilisation float64            // Mean over the tunnels with a rate, 0-1
+	PerTunnel   map[string]float64 // Utilisation by interface
+	Err         error              // Set when the action failed
+}
+
+// String summarises the decision for logs and the terminal
+func (d ScalingDecision) String() string {
+	names := make([]string, 0, len(d.PerTunnel))
+	for name := range d.PerTunnel {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s %.0f%%", name, d.PerTunnel[name]*100)
+	}
+
+	summary := fmt.Sprintf("%s (%s): %d tunnels at %.0f%%", d.Action, d.Reason, d.Tunnels, d.Utilisation*100)
+	if len(parts) > 0 {
+		summary += " [" + strings.Join(parts, ", ") + "]"
+	}
+	if d.Err != nil {
+		summary += ": " + d.Err.Error()
+	}
+	return summary
+}
+
+// TunnelScaler adds a tunnel while the tunnels run above the scale-up threshold and
+// removes one once they have stayed below the scale-down threshold for the scale-down
+// cooldown, within the manager's minimum and maximum tunnel counts. Removed tunnels are
+// drained first, as by mole scale.
+type TunnelScaler struct {
+	manager *TunnelManager
+	config  config.ScalingConfig
+
+	// connections counts a draining tunnel's open connections for up to drainTimeout
+	connections  ConnectionCounter
+	drainTimeout time.Duration
+	drainPoll    time.Duration
+
+	lastScaleUp time.Time
+	lowSince    time.Time // Start of the current run below the scale-down threshold
+	now         func() time.Time
+	mu          sync.Mutex
+}
+
+// NewTunnelScaler creates a scaler for the manager's tunnels
+func NewTunnelScaler(manager *TunnelManager, cfg config.ScalingConfig) *TunnelScaler {
+	if cfg.TunnelCapacity <= 0 {
+		cfg.TunnelCapacity = DefaultTunnelCapacity
+	}
+	if cfg.ScaleInterval <= 0 {
+		cfg.ScaleInterval = defaultScaleInterval
+	}
+	return &TunnelScaler{
+		manager:      manager,
+		config:       cfg,
+		connections:  monitoring.CountConnections,
+		drainTimeout: DefaultDrainTimeout,
+		drainPoll:    DrainPollInterval,
+		now:          time.Now,
+	}
+}
+
+// Run evaluates the tunnels every scale interval until ctx is done, passing each decision
+// to report when it is not nil
+func (s *TunnelScaler) Run(ctx context.Context, report func(ScalingDecision)) {
+	ticker := time.NewTicker(s.config.ScaleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			decision := s.Evaluate(ctx)
+			if report != nil {
+				report(decision)
+			}
+		}
+	}
+}
+
+// Evaluate checks the tunnels, samples their utilisation and adds or removes at most one
+// tunnel. The first evaluation only records a baseline. A removal waits for the tunnel's
+// connections to finish, for up to the drain timeout or until ctx is done.
+func (s *TunnelScaler) Evaluate(ctx context.Context) ScalingDecision {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	// Failed tunnels leave the ECMP routes and are not sampled
+	s.manager.CheckTunnels()
+
+	decision := ScalingDecision{
+		Time:      now,
+		Action:    ScaleHold,
+		Tunnels:   s.manager.tunnelCount(),
+		PerTunnel: s.manager.utilisation(s.config.TunnelCapacity, now),
+	}
+	if len(decision.PerTunnel) == 0 {
+		decision.Reason = "no utilisation samples yet"
+		s.log(decision)
+		return decision
+	}
+	for _, utilisation := range decision.PerTunnel {
+		decision.Utilisation += utilisation / float64(len(decision.PerTunnel))
+	}
+
+	minTunnels, maxTunnels := s.manager.config.MinTunnels, s.manager.config.MaxTunnels
+	switch {
+	case decision.Utilisation > s.config.ScaleUpThreshold:
+		s.lowSince = time.Time{}
+		remaining := s.config.ScaleUpCooldown - now.Sub(s.lastScaleUp)
+		switch {
+		case decision.Tunnels >= maxTunnels:
+			decision.Reason = fmt.Sprintf("above %.0f%% but at max_tunnels", s.config.ScaleUpThreshold*100)
+		case !s.lastScaleUp.IsZero() && remaining > 0:
+			decision.Reason = fmt.Sprintf("above %.0f%%, scale-up cooldown for another %s", s.config.ScaleUpThreshold*100, remaining.Round(time.Second))
+		default:
+			decision.Action = ScaleUp
+			decision.Reason = fmt.Sprintf("above %.0f%%", s.config.ScaleUpThreshold*100)
+			decision.Err = s.manager.AddTunnel()
+			if decision.Err == nil {
+				s.lastScaleUp = now
+			}
+		}
+
+	case decision.Utilisation < s.config.ScaleDownThreshold:
+		if s.lowSince.IsZero() {
+			s.lowSince = now
+		}
+		low := now.Sub(s.lowSince)
+		switch {
+		case decision.Tunnels <= minTunnels:
+			decision.Reason = fmt.Sprintf("below %.0f%% but at min_tunnels", s.config.ScaleDownThreshold*100)
+		case low < s.config.ScaleDownCooldown:
+			decision.Reason = fmt.Sprintf("below %.0f%% for %s of %s", s.config.ScaleDownThreshold*100, low.Round(time.Second), s.config.ScaleDownCooldown)
+		default:
+			decision.Action = ScaleDown
+			decision.Reason = fmt.Sprintf("below %.0f%% for %s", s.config.ScaleDownThreshold*100, low.Round(time.Second))
+			drainCtx, cancel := context.WithTimeout(ctx, s.drainTimeout)
+			drain, err := s.manager.DrainTunnel(drainCtx, s.connections, s.drainPoll)
+			cancel()
+			decision.Err = err
+			if err == nil && drain.Remaining > 0 {
+				decision.Reason += fmt.Sprintf(", %s removed with %d connection(s) open", drain.Interface, drain.Remaining)
+			}
+			// The next removal needs another full cooldown below the threshold
+			s.lowSince = s.now()
+		}
+
+	default:
+		s.lowSince = time.Time{}
+		decision.Reason = "within thresholds"
+	}
+
+	s.log(decision)
+	return decision
+}
+
+// log records a decision with the metrics behind it
+func (s *TunnelScaler) log(decision ScalingDecision) {
+	logger := s.manager.logger
+	if logger == nil {
+		return
+	}
+	args := []any{
+		"action", decision.Action,
+		"reason", decision.Reason,
+		"tunnels", decision.Tunnels,
+		"utilisation", decision.Utilisation,
+		"per_tunnel", decision.PerTunnel,
+	}
+	if decision.Err != nil {
+		logger.Error("Scaling action failed", append(args, "error", decision.Err)...)
+		return
+	}
+	logger.Info("Scaling decision", args...)
+}
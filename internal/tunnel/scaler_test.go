@@ -0,0 +1,283 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/research-computing/mole/internal/config"
+)
+
+// scalerHarness drives a scaler over a memory backend with a fake clock. Each step
+// advances the clock by the scale interval and adds traffic to every tunnel at the given
+// utilisation of a 100 Mbps capacity.
+type scalerHarness struct {
+	t       *testing.T
+	backend *MemoryBackend
+	manager *TunnelManager
+	scaler  *TunnelScaler
+	clock   time.Time
+	bytes   map[string]int64
+
+	// open are the connections each draining poll finds, in order; none once used up
+	open    []int
+	drained [][]netip.Addr
+}
+
+func newScalerHarness(t *testing.T, tunnels int) *scalerHarness {
+	backend := NewMemoryBackend()
+	manager := NewTunnelManagerWithBackend(&TunnelConfig{
+		MinTunnels: 1,
+		MaxTunnels: 3,
+		MTU:        1420,
+		ListenPort: 51820,
+	}, backend)
+	if err := manager.CreateTunnels(tunnels); err != nil {
+		t.Fatalf("CreateTunnels failed: %v", err)
+	}
+
+	h := &scalerHarness{t: t, backend: backend, manager: manager, clock: time.Unix(1700000000, 0), bytes: make(map[string]int64)}
+	h.scaler = NewTunnelScaler(manager, config.ScalingConfig{
+		ScaleUpThreshold:   0.8,
+		ScaleDownThreshold: 0.3,
+		ScaleInterval:      30 * time.Second,
+		ScaleUpCooldown:    2 * time.Minute,
+		ScaleDownCooldown:  90 * time.Second,
+		TunnelCapacity:     100,
+	})
+	h.scaler.now = func() time.Time { return h.clock }
+	h.scaler.drainPoll = time.Millisecond
+	h.scaler.connections = func(sources []netip.Addr) (int, error) {
+		h.drained = append(h.drained, sources)
+		if len(h.open) == 0 {
+			return 0, nil
+		}
+		count := h.open[0]
+		h.open = h.open[1:]
+		return count, nil
+	}
+	return h
+}
+
+func (h *scalerHarness) step(utilisation float64) ScalingDecision {
+	h.clock = h.clock.Add(h.scaler.config.ScaleInterval)
+	for _, iface := range h.backend.Interfaces() {
+		// utilisation × 100 Mbps over the interval, split between the directions
+		h.bytes[iface] += int64(utilisation * 100e6 / 8 * h.scaler.config.ScaleInterval.Seconds() / 2)
+		h.backend.SetStats(iface, []PeerStats{{PublicKey: "bastion", RxBytes: h.bytes[iface], TxBytes: h.bytes[iface]}})
+	}
+	return h.scaler.Evaluate(context.Background())
+}
+
+func (h *scalerHarness) expect(decision ScalingDecision, action string, tunnels int) {
+	h.t.Helper()
+	if decision.Action != action || decision.Err != nil {
+		h.t.Fatalf("Expected %s, got %s", action, decision)
+	}
+	if count := h.manager.tunnelCount(); count != tunnels {
+		h.t.Fatalf("Expected %d tunnels after %s, got %d", tunnels, decision, count)
+	}
+}
+
+func TestScalerScaleUp(t *testing.T) {
+	h := newScalerHarness(t, 1)
+
+	// The first sample only sets the baseline
+	decision := h.step(0.9)
+	h.expect(decision, ScaleHold, 1)
+	if decision.Reason != "no utilisation samples yet" {
+		t.Errorf("Unexpected reason %q", decision.Reason)
+	}
+
+	decision = h.step(0.9)
+	h.expect(decision, ScaleUp, 2)
+	if u := decision.PerTunnel["wg0"]; u < 0.89 || u > 0.91 {
+		t.Errorf("Expected wg0 at 90%%, got %.2f", u)
+	}
+	if metrics := h.manager.tunnels[0].Metrics; metrics.Throughput != 90_000_000 {
+		t.Errorf("Expected the tunnel's throughput to be recorded, got %d", metrics.Throughput)
+	}
+
+	// The new tunnel has no rate yet and the cooldown holds further scale-ups
+	decision = h.step(0.9)
+	h.expect(decision, ScaleHold, 2)
+	if !strings.Contains(decision.Reason, "cooldown") || len(decision.PerTunnel) != 1 {
+		t.Errorf("Expected a cooldown over wg0 alone, got %s", decision)
+	}
+	h.step(0.9)
+	h.step(0.9)
+	h.expect(h.step(0.9), ScaleUp, 3)
+
+	// At max_tunnels
+	h.clock = h.clock.Add(h.scaler.config.ScaleUpCooldown)
+	h.step(0.9)
+	decision = h.step(0.9)
+	h.expect(decision, ScaleHold, 3)
+	if !strings.Contains(decision.Reason, "max_tunnels") {
+		t.Errorf("Expected to hold at max_tunnels, got %s", decision)
+	}
+}
+
+func TestScalerScaleDown(t *testing.T) {
+	h := newScalerHarness(t, 3)
+
+	h.step(0.1)
+	h.expect(h.step(0.1), ScaleHold, 3) // Low for 0s
+	h.expect(h.step(0.1), ScaleHold, 3) // 30s
+	h.expect(h.step(0.1), ScaleHold, 3) // 60s
+
+	// Traffic in between the thresholds restarts the cooldown
+	h.expect(h.step(0.5), ScaleHold, 3)
+	for i := 0; i < 3; i++ {
+		h.expect(h.step(0.1), ScaleHold, 3)
+	}
+
+	// The highest tunnel is removed once its connections have finished
+	h.open = []int{2, 1}
+	decision := h.step(0.1)
+	h.expect(decision, ScaleDown, 2)
+	if _, exists := h.manager.tunnels[2]; exists {
+		t.Error("Expected the highest tunnel to be removed")
+	}
+	if len(h.drained) != 3 || h.drained[0][0].String() != "10.100.3.2" {
+		t.Errorf("Expected wg2's connections counted until none were left, got %v", h.drained)
+	}
+	if strings.Contains(decision.Reason, "open") {
+		t.Errorf("No connections should have been cut off, got %s", decision)
+	}
+
+	// Each further removal needs another full cooldown, and stops at min_tunnels
+	for i := 0; i < 2; i++ {
+		h.expect(h.step(0.1), ScaleHold, 2)
+	}
+	h.expect(h.step(0.1), ScaleDown, 1)
+	for i := 0; i < 4; i++ {
+		decision = h.step(0.1)
+	}
+	h.expect(decision, ScaleHold, 1)
+	if !strings.Contains(decision.Reason, "min_tunnels") {
+		t.Errorf("Expected to hold at min_tunnels, got %s", decision)
+	}
+}
+
+func TestScalerDrainTimeout(t *testing.T) {
+	h := newScalerHarness(t, 2)
+	h.scaler.drainTimeout = 10 * time.Millisecond
+	h.scaler.connections = func([]netip.Addr) (int, error) { return 4, nil }
+
+	h.step(0.1)
+	for i := 0; i < 3; i++ {
+		h.step(0.1)
+	}
+	decision := h.step(0.1)
+	h.expect(decision, ScaleDown, 1)
+	if !strings.Contains(decision.Reason, "wg1 removed with 4 connection(s) open") {
+		t.Errorf("Expected the cut-off connections reported, got %s", decision)
+	}
+
+	// A tunnel whose connections cannot be counted stays
+	h = newScalerHarness(t, 2)
+	h.scaler.connections = func([]netip.Addr) (int, error) { return 0, errors.New("conntrack unavailable") }
+	for i := 0; i < 4; i++ {
+		h.step(0.1)
+	}
+	decision = h.step(0.1)
+	if decision.Action != ScaleDown || decision.Err == nil || h.manager.tunnelCount() != 2 {
+		t.Errorf("Expected the failed drain to keep wg1, got %s", decision)
+	}
+}
+
+func TestScalerSkipsFailedTunnels(t *testing.T) {
+	h := newScalerHarness(t, 2)
+	h.step(0.9)
+
+	// wg1 fails validation once its interface is gone and drops out of the mean
+	h.backend.Down("wg1")
+	decision := h.step(0.1)
+	if _, ok := decision.PerTunnel["wg1"]; ok || len(decision.PerTunnel) != 1 {
+		t.Errorf("Expected only wg0 to be sampled, got %s", decision)
+	}
+	if state := h.manager.tunnels[1].Status.State; state != "error" {
+		t.Errorf("Expected wg1 to be marked failed, got %s", state)
+	}
+}
+
+// fakeProvisioner records the far ends it is asked to configure
+type fakeProvisioner struct {
+	requests []ProvisionRequest
+	removed  []string
+	fail     error
+}
+
+func (p *fakeProvisioner) Provision(req ProvisionRequest) (*ProvisionedPeer, error) {
+	if p.fail != nil {
+		return nil, p.fail
+	}
+	p.requests = append(p.requests, req)
+	return &ProvisionedPeer{PublicKey: "bastion-key", Endpoint: "203.0.113.10:51821"}, nil
+}
+
+func (p *fakeProvisioner) Deprovision(iface string) error {
+	p.removed = append(p.removed, iface)
+	return nil
+}
+
+func TestManagerProvisionsFarEnd(t *testing.T) {
+	backend := NewMemoryBackend()
+	tm := NewTunnelManagerWithBackend(&TunnelConfig{
+		MinTunnels:   1,
+		MaxTunnels:   2,
+		MTU:          1420,
+		ListenPort:   51820,
+		Destinations: []string{"10.200.0.0/16"},
+	}, backend)
+	provisioner := &fakeProvisioner{}
+	tm.SetProvisioner(provisioner)
+
+	if err := tm.CreateTunnels(1); err != nil {
+		t.Fatalf("CreateTunnels failed: %v", err)
+	}
+	if err := tm.AddTunnel(); err != nil {
+		t.Fatalf("AddTunnel failed: %v", err)
+	}
+	if len(provisioner.requests) != 2 {
+		t.Fatalf("Expected both tunnels provisioned, got %+v", provisioner.requests)
+	}
+	req := provisioner.requests[1]
+	if req.Interface != "wg1" || req.Port != 51821 || req.ServerAddress != "10.100.2.1/24" || req.ClientAddress != "10.100.2.2/24" {
+		t.Errorf("Unexpected provision request %+v", req)
+	}
+	if req.PublicKey != tm.tunnels[1].PublicKey {
+		t.Error("Expected the tunnel's public key to be sent to the far end")
+	}
+
+	wgConfig, ok := backend.Config("wg1")
+	if !ok {
+		t.Fatal("wg1 not brought up")
+	}
+	if wgConfig.PeerPublicKey != "bastion-key" || wgConfig.PeerEndpoint != "203.0.113.10:51821" || wgConfig.Table != "off" {
+		t.Errorf("Interface not pointed at the far end: %+v", wgConfig)
+	}
+	if wgConfig.AllowedIPs != "10.100.2.0/24, 10.200.0.0/16" {
+		t.Errorf("Unexpected AllowedIPs %q", wgConfig.AllowedIPs)
+	}
+
+	if err := tm.RemoveTunnel(); err != nil {
+		t.Fatalf("RemoveTunnel failed: %v", err)
+	}
+	if len(provisioner.removed) != 1 || provisioner.removed[0] != "wg1" {
+		t.Errorf("Expected wg1's far end removed, got %v", provisioner.removed)
+	}
+
+	// A failed far end leaves no local interface behind
+	provisioner.fail = errors.New("agent unreachable")
+	if err := tm.AddTunnel(); err == nil || !strings.Contains(err.Error(), "agent unreachable") {
+		t.Errorf("Expected the provisioning error, got %v", err)
+	}
+	if _, ok := backend.Config("wg1"); ok {
+		t.Error("wg1 should not be brought up without a far end")
+	}
+}
@@ -4,8 +4,6 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
-	"os"
-	"os/exec"
 	"strings"
 
 	"golang.org/x/crypto/curve25519"
@@ -22,6 +20,9 @@ type WireGuardConfig struct {
 	PeerEndpoint  string
 	AllowedIPs    string
 	MTU           int
+	PresharedKey  string // Optional symmetric key mixed into the handshake
+	DNS           string // Optional resolvers, comma-separated as in wg-quick
+	Table         string // wg-quick routing table; "off" leaves routes to the caller
 }
 
 // GenerateWireGuardKeys generates a new WireGuard key pair
@@ -43,50 +44,59 @@ func GenerateWireGuardKeys() (privateKey, publicKey string, err error) {
 	return privateKey, publicKey, nil
 }
 
-// CreateWireGuardInterface creates and configures a WireGuard interface
-func (tm *TunnelManager) CreateWireGuardInterface(config *WireGuardConfig) error {
-	// Create WireGuard configuration file
-	configContent := tm.generateWireGuardConfig(config)
-	configFile := fmt.Sprintf("/tmp/%s.conf", config.Interface)
+// GeneratePresharedKey generates a WireGuard pre-shared key, as `wg genpsk` does
+func GeneratePresharedKey() (string, error) {
+	var psk [32]byte
+	if _, err := rand.Read(psk[:]); err != nil {
+		return "", fmt.Errorf("failed to generate pre-shared key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(psk[:]), nil
+}
 
-	if err := os.WriteFile(configFile, []byte(configContent), 0600); err != nil {
-		return fmt.Errorf("failed to write WireGuard config: %w", err)
+// PublicKeyFromPrivate derives the public key for a base64 WireGuard private key
+func PublicKeyFromPrivate(privateKey string) (string, error) {
+	private, err := base64.StdEncoding.DecodeString(strings.TrimSpace(privateKey))
+	if err != nil || len(private) != 32 {
+		return "", fmt.Errorf("invalid WireGuard private key")
 	}
 
-	// Bring up the interface using sudo -A for askpass
-	cmd := exec.Command("sudo", "-A", "wg-quick", "up", configFile)
-	cmd.Env = append(os.Environ(), "SUDO_ASKPASS=/opt/homebrew/bin/askpass")
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to bring up WireGuard interface: %w, output: %s", err, string(output))
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		return "", fmt.Errorf("failed to derive public key: %w", err)
 	}
+	return base64.StdEncoding.EncodeToString(public), nil
+}
 
+// CreateWireGuardInterface brings up a WireGuard interface through the manager's backend
+func (tm *TunnelManager) CreateWireGuardInterface(config *WireGuardConfig) error {
+	if err := tm.backend.Up(config); err != nil {
+		return err
+	}
 	fmt.Printf("✅ WireGuard interface %s created successfully\n", config.Interface)
 	return nil
 }
 
 // DestroyWireGuardInterface tears down a WireGuard interface
 func (tm *TunnelManager) DestroyWireGuardInterface(interfaceName string) error {
-	configFile := fmt.Sprintf("/tmp/%s.conf", interfaceName)
-
-	// Bring down the interface using sudo -A for askpass
-	cmd := exec.Command("sudo", "-A", "wg-quick", "down", configFile)
-	cmd.Env = append(os.Environ(), "SUDO_ASKPASS=/opt/homebrew/bin/askpass")
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to bring down WireGuard interface: %w, output: %s", err, string(output))
-	}
-
-	// Clean up config file
-	if err := os.Remove(configFile); err != nil {
-		// Not fatal, just log
-		fmt.Printf("Warning: failed to remove config file %s: %v\n", configFile, err)
+	if err := tm.backend.Down(interfaceName); err != nil {
+		return err
 	}
-
 	fmt.Printf("✅ WireGuard interface %s destroyed successfully\n", interfaceName)
 	return nil
 }
 
-// generateWireGuardConfig generates WireGuard configuration file content
-func (tm *TunnelManager) generateWireGuardConfig(config *WireGuardConfig) string {
+// GetWireGuardStats retrieves statistics for a WireGuard interface
+func (tm *TunnelManager) GetWireGuardStats(interfaceName string) (*WireGuardStats, error) {
+	return tm.backend.Stats(interfaceName)
+}
+
+// ValidateWireGuardInterface checks if WireGuard interface is operational
+func (tm *TunnelManager) ValidateWireGuardInterface(interfaceName string) error {
+	return tm.backend.Validate(interfaceName)
+}
+
+// generateWireGuardConfig generates wg-quick configuration file content
+func generateWireGuardConfig(config *WireGuardConfig) string {
 	var builder strings.Builder
 
 	// Interface section
@@ -97,6 +107,12 @@ func (tm *TunnelManager) generateWireGuardConfig(config *WireGuardConfig) string
 	if config.MTU > 0 {
 		builder.WriteString(fmt.Sprintf("MTU = %d\n", config.MTU))
 	}
+	if config.DNS != "" {
+		builder.WriteString(fmt.Sprintf("DNS = %s\n", config.DNS))
+	}
+	if config.Table != "" {
+		builder.WriteString(fmt.Sprintf("Table = %s\n", config.Table))
+	}
 
 	// Add macOS-compatible routing (simplified, no policy routing tables)
 	// Note: Advanced routing rules not supported on macOS with iproute2mac
@@ -105,6 +121,9 @@ func (tm *TunnelManager) generateWireGuardConfig(config *WireGuardConfig) string
 	if config.PeerPublicKey != "" {
 		builder.WriteString("\n[Peer]\n")
 		builder.WriteString(fmt.Sprintf("PublicKey = %s\n", config.PeerPublicKey))
+		if config.PresharedKey != "" {
+			builder.WriteString(fmt.Sprintf("PresharedKey = %s\n", config.PresharedKey))
+		}
 		if config.PeerEndpoint != "" {
 			builder.WriteString(fmt.Sprintf("Endpoint = %s\n", config.PeerEndpoint))
 		}
@@ -117,44 +136,72 @@ func (tm *TunnelManager) generateWireGuardConfig(config *WireGuardConfig) string
 	return builder.String()
 }
 
-// GetWireGuardStats retrieves statistics for a WireGuard interface
-func (tm *TunnelManager) GetWireGuardStats(interfaceName string) (*WireGuardStats, error) {
-	cmd := exec.Command("sudo", "-A", "wg", "show", interfaceName, "dump")
-	cmd.Env = append(os.Environ(), "SUDO_ASKPASS=/opt/homebrew/bin/askpass")
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get WireGuard stats: %w", err)
-	}
+// ParseWireGuardConfig reads a wg-quick config with at most one [Peer]. Keys that only
+// matter to wg-quick itself, such as Table or PostUp, are ignored.
+func ParseWireGuardConfig(data []byte) (*WireGuardConfig, error) {
+	config := &WireGuardConfig{}
+	var addresses, allowedIPs, dns []string
+	section, peers := "", 0
 
-	// Parse the dump output
-	stats := &WireGuardStats{
-		Interface: interfaceName,
-	}
-
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if line == "" {
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
-
-		fields := strings.Split(line, "\t")
-		if len(fields) >= 3 {
-			// Parse peer statistics
-			peerStats := PeerStats{
-				PublicKey: fields[0],
+		if strings.HasPrefix(line, "[") {
+			section = strings.ToLower(strings.Trim(line, "[]"))
+			if section == "peer" {
+				peers++
 			}
-
-			if len(fields) >= 6 {
-				// fields[4] = rx bytes, fields[5] = tx bytes
-				fmt.Sscanf(fields[4], "%d", &peerStats.RxBytes)
-				fmt.Sscanf(fields[5], "%d", &peerStats.TxBytes)
-			}
-
-			stats.Peers = append(stats.Peers, peerStats)
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid line %q", line)
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+
+		var err error
+		switch section + "." + key {
+		case "interface.privatekey":
+			config.PrivateKey = value
+		case "interface.address":
+			addresses = append(addresses, splitList(value)...)
+		case "interface.listenport":
+			_, err = fmt.Sscanf(value, "%d", &config.ListenPort)
+		case "interface.mtu":
+			_, err = fmt.Sscanf(value, "%d", &config.MTU)
+		case "interface.dns":
+			dns = append(dns, splitList(value)...)
+		case "peer.publickey":
+			config.PeerPublicKey = value
+		case "peer.presharedkey":
+			config.PresharedKey = value
+		case "peer.endpoint":
+			config.PeerEndpoint = value
+		case "peer.allowedips":
+			allowedIPs = append(allowedIPs, splitList(value)...)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q", key, value)
 		}
 	}
 
-	return stats, nil
+	if peers > 1 {
+		return nil, fmt.Errorf("config has %d [Peer] sections, expected one", peers)
+	}
+	if config.PrivateKey == "" || len(addresses) == 0 {
+		return nil, fmt.Errorf("config needs an [Interface] with PrivateKey and Address")
+	}
+	config.Address = strings.Join(addresses, ", ")
+	config.AllowedIPs = strings.Join(allowedIPs, ", ")
+	config.DNS = strings.Join(dns, ", ")
+	publicKey, err := PublicKeyFromPrivate(config.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	config.PublicKey = publicKey
+	return config, nil
 }
 
 // WireGuardStats represents WireGuard interface statistics
@@ -169,27 +216,3 @@ type PeerStats struct {
 	RxBytes   int64
 	TxBytes   int64
 }
-
-// ValidateWireGuardInterface checks if WireGuard interface is operational
-func (tm *TunnelManager) ValidateWireGuardInterface(interfaceName string) error {
-	// Check if interface exists
-	cmd := exec.Command("ip", "link", "show", interfaceName)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("WireGuard interface %s not found: %w", interfaceName, err)
-	}
-
-	// Check if interface is up
-	cmd = exec.Command("ip", "link", "show", interfaceName, "up")
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("WireGuard interface %s is not up: %w", interfaceName, err)
-	}
-
-	// Check WireGuard configuration
-	cmd = exec.Command("sudo", "-A", "wg", "show", interfaceName)
-	cmd.Env = append(os.Environ(), "SUDO_ASKPASS=/opt/homebrew/bin/askpass")
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("WireGuard interface %s configuration error: %w", interfaceName, err)
-	}
-
-	return nil
-}
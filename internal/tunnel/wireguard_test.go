@@ -42,9 +42,51 @@ func TestGenerateWireGuardKeys(t *testing.T) {
 	}
 }
 
-func TestGenerateWireGuardConfig(t *testing.T) {
-	tm := &TunnelManager{}
+func TestPublicKeyFromPrivate(t *testing.T) {
+	privateKey, publicKey, err := GenerateWireGuardKeys()
+	if err != nil {
+		t.Fatalf("Failed to generate WireGuard keys: %v", err)
+	}
+
+	derived, err := PublicKeyFromPrivate(privateKey)
+	if err != nil {
+		t.Fatalf("PublicKeyFromPrivate failed: %v", err)
+	}
+	if derived != publicKey {
+		t.Errorf("Derived public key %s does not match %s", derived, publicKey)
+	}
+
+	if _, err := PublicKeyFromPrivate("not-a-key"); err == nil {
+		t.Error("Expected invalid private key to fail")
+	}
+}
+
+func TestGenerateWireGuardConfigPresharedKey(t *testing.T) {
+	psk, err := GeneratePresharedKey()
+	if err != nil {
+		t.Fatalf("GeneratePresharedKey failed: %v", err)
+	}
+	if len(psk) != 44 {
+		t.Errorf("Pre-shared key should be 44 characters, got %d", len(psk))
+	}
 
+	config := &WireGuardConfig{
+		Interface:     "wg0",
+		PrivateKey:    "oK56DE9Ue9zK76rAc8pBl6opph+1v36mIqk9JuFNNT0=",
+		Address:       "10.100.1.1/24",
+		PeerPublicKey: "peer_public_key_here",
+	}
+	if strings.Contains(generateWireGuardConfig(config), "PresharedKey") {
+		t.Error("Config without a PSK should not contain PresharedKey")
+	}
+
+	config.PresharedKey = psk
+	if !strings.Contains(generateWireGuardConfig(config), "PublicKey = peer_public_key_here\nPresharedKey = "+psk+"\n") {
+		t.Error("Config should contain the PSK in the [Peer] section")
+	}
+}
+
+func TestGenerateWireGuardConfig(t *testing.T) {
 	config := &WireGuardConfig{
 		Interface:     "wg0",
 		PrivateKey:    "oK56DE9Ue9zK76rAc8pBl6opph+1v36mIqk9JuFNNT0=",
@@ -57,7 +99,7 @@ func TestGenerateWireGuardConfig(t *testing.T) {
 		AllowedIPs:    "0.0.0.0/0",
 	}
 
-	configStr := tm.generateWireGuardConfig(config)
+	configStr := generateWireGuardConfig(config)
 
 	// Test that config contains required sections
 	if !strings.Contains(configStr, "[Interface]") {
@@ -95,8 +137,6 @@ func TestGenerateWireGuardConfig(t *testing.T) {
 }
 
 func TestWireGuardConfigValidation(t *testing.T) {
-	tm := &TunnelManager{}
-
 	tests := []struct {
 		name     string
 		config   *WireGuardConfig
@@ -137,7 +177,7 @@ func TestWireGuardConfigValidation(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := tm.generateWireGuardConfig(tt.config)
+			result := generateWireGuardConfig(tt.config)
 
 			if !tt.checkFn(result) {
 				t.Errorf("Config validation failed for %s\nConfig:\n%s", tt.name, result)
@@ -148,8 +188,6 @@ func TestWireGuardConfigValidation(t *testing.T) {
 
 // Test that we can create configuration files
 func TestWireGuardConfigFileCreation(t *testing.T) {
-	tm := &TunnelManager{}
-
 	config := &WireGuardConfig{
 		Interface:  "wgtest",
 		PrivateKey: "test_private_key",
@@ -159,7 +197,7 @@ func TestWireGuardConfigFileCreation(t *testing.T) {
 	}
 
 	// Generate config content
-	configContent := tm.generateWireGuardConfig(config)
+	configContent := generateWireGuardConfig(config)
 
 	// Write to temporary file
 	tmpFile := "/tmp/wgtest_unittest.conf"
@@ -212,4 +250,43 @@ func TestKeyGeneration(t *testing.T) {
 		keys[privateKey] = true
 		keys[publicKey] = true
 	}
-}
\ No newline at end of file
+}
+func TestParseWireGuardConfig(t *testing.T) {
+	privateKey, publicKey, _ := GenerateWireGuardKeys()
+	psk, _ := GeneratePresharedKey()
+
+	original := &WireGuardConfig{
+		Interface:     "wg0",
+		PrivateKey:    privateKey,
+		ListenPort:    51820,
+		Address:       "10.100.1.2/24, fd6d:6f6c:6500:1::2/64",
+		MTU:           1420,
+		DNS:           "10.0.0.2",
+		PeerPublicKey: "XIuGKzMXouGh1FtJ+qm6q3w6j2V+jHzqwQMVpP8h0Ak=",
+		PeerEndpoint:  "1.2.3.4:51820",
+		AllowedIPs:    "10.0.1.0/24, 10.0.2.0/24",
+		PresharedKey:  psk,
+	}
+	parsed, err := ParseWireGuardConfig([]byte(generateWireGuardConfig(original) + "Table = off\n"))
+	if err != nil {
+		t.Fatalf("ParseWireGuardConfig failed: %v", err)
+	}
+	parsed.Interface = original.Interface
+	original.PublicKey = publicKey
+	if *parsed != *original {
+		t.Errorf("Round trip mismatch:\n got %+v\nwant %+v", parsed, original)
+	}
+
+	invalid := map[string]string{
+		"no address":  "[Interface]\nPrivateKey = " + privateKey + "\n",
+		"bad key":     "[Interface]\nPrivateKey = short\nAddress = 10.0.0.2/24\n",
+		"two peers":   "[Interface]\nPrivateKey = " + privateKey + "\nAddress = 10.0.0.2/24\n[Peer]\n[Peer]\n",
+		"bad port":    "[Interface]\nPrivateKey = " + privateKey + "\nAddress = 10.0.0.2/24\nListenPort = x\n",
+		"not ini":     "[Interface]\nPrivateKey\n",
+	}
+	for name, data := range invalid {
+		if _, err := ParseWireGuardConfig([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
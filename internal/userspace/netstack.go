@@ -0,0 +1,23 @@
+//go:build netstack
+
+package userspace
+
+import (
+	"fmt"
+	"net/netip"
+
+	"golang.zx2c4.com/wireguard/tun"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+// Available reports whether this binary includes the user-space network stack
+const Available = true
+
+// newNetstack creates a gVisor TCP/IP stack with the tunnel addresses, wrapped as a TUN device
+func newNetstack(addresses, dns []netip.Addr, mtu int) (tun.Device, Dialer, error) {
+	tunDevice, stack, err := netstack.CreateNetTUN(addresses, dns, mtu)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create user-space network stack: %w", err)
+	}
+	return tunDevice, stack, nil
+}
@@ -0,0 +1,18 @@
+//go:build !netstack
+
+package userspace
+
+import (
+	"fmt"
+	"net/netip"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// Available reports whether this binary includes the user-space network stack
+const Available = false
+
+// newNetstack fails in builds without the gVisor stack
+func newNetstack(addresses, dns []netip.Addr, mtu int) (tun.Device, Dialer, error) {
+	return nil, nil, fmt.Errorf("this mole binary was built without the user-space network stack; rebuild with -tags netstack")
+}
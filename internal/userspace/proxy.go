@@ -0,0 +1,314 @@
+package userspace
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/research-computing/mole/internal/forward"
+)
+
+// Dialer opens TCP connections, through the tunnel for a running Tunnel
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Proxy serves SOCKS5 and HTTP CONNECT on one listener and dials every target through
+// the tunnel. The protocol is told apart by the first byte a client sends.
+type Proxy struct {
+	Dialer Dialer
+
+	// Username and Password are required from clients when Password is set. Login nodes
+	// are shared, and any local user can reach a loopback port.
+	Username string
+	Password string
+
+	DialTimeout time.Duration // Default 15s
+}
+
+// SOCKS5 constants (RFC 1928, RFC 1929)
+const (
+	socksVersion      = 0x05
+	socksNoAuth       = 0x00
+	socksUserPass     = 0x02
+	socksNoAcceptable = 0xff
+	socksConnect      = 0x01
+	socksIPv4         = 0x01
+	socksDomain       = 0x03
+	socksIPv6         = 0x04
+
+	socksSucceeded          = 0x00
+	socksHostUnreachable    = 0x04
+	socksConnectionRefused  = 0x05
+	socksCommandUnsupported = 0x07
+	socksAddressUnsupported = 0x08
+)
+
+// Serve accepts connections until ctx is done or the listener fails
+func (p *Proxy) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("proxy listener failed: %w", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.handle(ctx, conn)
+		}()
+	}
+}
+
+func (p *Proxy) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	// Shutting down ends relays that would otherwise wait on idle peers
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	// Clients get a while to send their request; relaying has no deadline
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	reader := bufio.NewReader(conn)
+	first, err := reader.Peek(1)
+	if err != nil {
+		return
+	}
+
+	var upstream net.Conn
+	if first[0] == socksVersion {
+		upstream, err = p.socks5(ctx, conn, reader)
+	} else {
+		upstream, err = p.httpConnect(ctx, conn, reader)
+	}
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+
+	// The client's reader is drained first, since it may already hold the start of the stream
+	conn.SetDeadline(time.Time{})
+	forward.Relay(ctx, conn, reader, upstream, nil, nil)
+}
+
+// socks5 runs the SOCKS5 handshake and returns the connection to the requested target
+func (p *Proxy) socks5(ctx context.Context, conn net.Conn, reader *bufio.Reader) (net.Conn, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(reader, methods); err != nil {
+		return nil, err
+	}
+
+	method := byte(socksNoAuth)
+	if p.Password != "" {
+		method = socksUserPass
+	}
+	offered := false
+	for _, m := range methods {
+		offered = offered || m == method
+	}
+	if !offered {
+		conn.Write([]byte{socksVersion, socksNoAcceptable})
+		return nil, fmt.Errorf("client offered no acceptable SOCKS5 auth method")
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return nil, err
+	}
+	if method == socksUserPass {
+		if err := p.socksAuthenticate(conn, reader); err != nil {
+			return nil, err
+		}
+	}
+
+	// VER CMD RSV ATYP
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(reader, request); err != nil {
+		return nil, err
+	}
+	host, err := readSocksAddress(reader, request[3])
+	if err != nil {
+		socksReply(conn, socksAddressUnsupported)
+		return nil, err
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(reader, port); err != nil {
+		return nil, err
+	}
+	if request[1] != socksConnect {
+		socksReply(conn, socksCommandUnsupported)
+		return nil, fmt.Errorf("unsupported SOCKS5 command %d", request[1])
+	}
+
+	target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	upstream, err := p.dial(ctx, target)
+	if err != nil {
+		code := byte(socksHostUnreachable)
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			code = socksConnectionRefused
+		}
+		socksReply(conn, code)
+		return nil, err
+	}
+	if err := socksReply(conn, socksSucceeded); err != nil {
+		upstream.Close()
+		return nil, err
+	}
+	return upstream, nil
+}
+
+// socksAuthenticate checks RFC 1929 username/password credentials
+func (p *Proxy) socksAuthenticate(conn net.Conn, reader *bufio.Reader) error {
+	version, err := reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	username, err := readSocksString(reader)
+	if err != nil {
+		return err
+	}
+	password, err := readSocksString(reader)
+	if err != nil {
+		return err
+	}
+	if version != 0x01 || !p.authorized(username, password) {
+		conn.Write([]byte{0x01, 0x01})
+		return fmt.Errorf("SOCKS5 authentication failed")
+	}
+	_, err = conn.Write([]byte{0x01, 0x00})
+	return err
+}
+
+func readSocksString(reader *bufio.Reader) (string, error) {
+	length, err := reader.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(reader, value); err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+func readSocksAddress(reader *bufio.Reader, addressType byte) (string, error) {
+	switch addressType {
+	case socksIPv4, socksIPv6:
+		size := net.IPv4len
+		if addressType == socksIPv6 {
+			size = net.IPv6len
+		}
+		ip := make(net.IP, size)
+		if _, err := io.ReadFull(reader, ip); err != nil {
+			return "", err
+		}
+		return ip.String(), nil
+	case socksDomain:
+		// Names are resolved on the far side of the tunnel (socks5h)
+		return readSocksString(reader)
+	default:
+		return "", fmt.Errorf("unsupported SOCKS5 address type %d", addressType)
+	}
+}
+
+// socksReply sends a reply with an unspecified bound address
+func socksReply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socksVersion, code, 0x00, socksIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// httpConnect handles an HTTP CONNECT request; other methods are refused because every
+// client that tunnels through a proxy (curl, git, pip) uses CONNECT
+func (p *Proxy) httpConnect(ctx context.Context, conn net.Conn, reader *bufio.Reader) (net.Conn, error) {
+	request, err := http.ReadRequest(reader)
+	if err != nil {
+		httpReply(conn, http.StatusBadRequest, "")
+		return nil, err
+	}
+	if request.Method != http.MethodConnect {
+		httpReply(conn, http.StatusMethodNotAllowed, "Allow: CONNECT\r\n")
+		return nil, fmt.Errorf("unsupported HTTP proxy method %s", request.Method)
+	}
+	if p.Password != "" {
+		username, password, ok := proxyBasicAuth(request.Header.Get("Proxy-Authorization"))
+		if !ok || !p.authorized(username, password) {
+			httpReply(conn, http.StatusProxyAuthRequired, "Proxy-Authenticate: Basic realm=\"mole\"\r\n")
+			return nil, fmt.Errorf("HTTP proxy authentication failed")
+		}
+	}
+
+	upstream, err := p.dial(ctx, request.Host)
+	if err != nil {
+		httpReply(conn, http.StatusBadGateway, "")
+		return nil, err
+	}
+	if err := httpReply(conn, http.StatusOK, ""); err != nil {
+		upstream.Close()
+		return nil, err
+	}
+	return upstream, nil
+}
+
+// httpReply answers a CONNECT request; a 2xx reply must not carry Content-Length, since the
+// tunnelled stream follows
+func httpReply(conn net.Conn, status int, headers string) error {
+	if status != http.StatusOK {
+		headers += "Content-Length: 0\r\n"
+	}
+	_, err := fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n%s\r\n", status, http.StatusText(status), headers)
+	return err
+}
+
+func proxyBasicAuth(header string) (username, password string, ok bool) {
+	const prefix = "Basic "
+	if len(header) < len(prefix) || header[:len(prefix)] != prefix {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(header[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+	for i, c := range decoded {
+		if c == ':' {
+			return string(decoded[:i]), string(decoded[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+func (p *Proxy) authorized(username, password string) bool {
+	userOK := subtle.ConstantTimeCompare([]byte(username), []byte(p.Username)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(password), []byte(p.Password)) == 1
+	return userOK && passOK
+}
+
+func (p *Proxy) dial(ctx context.Context, target string) (net.Conn, error) {
+	timeout := p.DialTimeout
+	if timeout == 0 {
+		timeout = 15 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return p.Dialer.DialContext(ctx, "tcp", target)
+}
@@ -0,0 +1,201 @@
+package userspace
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startEcho serves an echo service standing in for a host behind the bastion
+func startEcho(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener
+}
+
+// startProxy runs a proxy that dials directly instead of through a tunnel
+func startProxy(t *testing.T, password string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	proxy := &Proxy{Dialer: &net.Dialer{}, Username: "mole", Password: password}
+	done := make(chan error, 1)
+	go func() { done <- proxy.Serve(ctx, listener) }()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Serve returned %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("Serve did not stop after cancellation")
+		}
+	})
+	return listener.Addr().String()
+}
+
+func dialProxy(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func expectBytes(t *testing.T, conn net.Conn, want []byte) {
+	t.Helper()
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Fatalf("got % x, want % x", got, want)
+	}
+}
+
+func expectEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	expectBytes(t, conn, []byte("ping"))
+}
+
+func TestSOCKS5(t *testing.T) {
+	echo := startEcho(t)
+	port := echo.Addr().(*net.TCPAddr).Port
+	addr := startProxy(t, "secret")
+
+	t.Run("domain target with password", func(t *testing.T) {
+		conn := dialProxy(t, addr)
+		conn.Write([]byte{0x05, 0x02, 0x00, 0x02})
+		expectBytes(t, conn, []byte{0x05, 0x02})
+		conn.Write(append(append([]byte{0x01, 4}, "mole"...), append([]byte{6}, "secret"...)...))
+		expectBytes(t, conn, []byte{0x01, 0x00})
+
+		request := append([]byte{0x05, 0x01, 0x00, 0x03, 9}, "localhost"...)
+		conn.Write(append(request, byte(port>>8), byte(port)))
+		expectBytes(t, conn, []byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		expectEcho(t, conn)
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		conn := dialProxy(t, addr)
+		conn.Write([]byte{0x05, 0x01, 0x02})
+		expectBytes(t, conn, []byte{0x05, 0x02})
+		conn.Write(append(append([]byte{0x01, 4}, "mole"...), append([]byte{5}, "guess"...)...))
+		expectBytes(t, conn, []byte{0x01, 0x01})
+	})
+
+	t.Run("no auth offered", func(t *testing.T) {
+		conn := dialProxy(t, addr)
+		conn.Write([]byte{0x05, 0x01, 0x00})
+		expectBytes(t, conn, []byte{0x05, 0xff})
+	})
+
+	t.Run("bind refused", func(t *testing.T) {
+		conn := dialProxy(t, startProxy(t, ""))
+		conn.Write([]byte{0x05, 0x01, 0x00})
+		expectBytes(t, conn, []byte{0x05, 0x00})
+		conn.Write([]byte{0x05, 0x02, 0x00, 0x01, 127, 0, 0, 1, byte(port >> 8), byte(port)})
+		expectBytes(t, conn, []byte{0x05, 0x07})
+	})
+
+	t.Run("IPv4 target without password", func(t *testing.T) {
+		conn := dialProxy(t, startProxy(t, ""))
+		conn.Write([]byte{0x05, 0x01, 0x00})
+		expectBytes(t, conn, []byte{0x05, 0x00})
+		conn.Write([]byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, byte(port >> 8), byte(port)})
+		expectBytes(t, conn, []byte{0x05, 0x00})
+		io.ReadFull(conn, make([]byte, 8))
+		expectEcho(t, conn)
+	})
+}
+
+func TestHTTPConnect(t *testing.T) {
+	echo := startEcho(t)
+	addr := startProxy(t, "secret")
+
+	connect := func(t *testing.T, method, auth string) (net.Conn, *http.Response) {
+		conn := dialProxy(t, addr)
+		target := echo.Addr().String()
+		if method != http.MethodConnect {
+			target = "http://" + target + "/"
+		}
+		request := method + " " + target + " HTTP/1.1\r\nHost: " + echo.Addr().String() + "\r\n"
+		if auth != "" {
+			request += "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(auth)) + "\r\n"
+		}
+		conn.Write([]byte(request + "\r\n"))
+		response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatalf("%s: reading response failed: %v", method, err)
+		}
+		return conn, response
+	}
+
+	conn, response := connect(t, "CONNECT", "mole:secret")
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %s", response.Status)
+	}
+	if response.Header.Get("Content-Length") != "" {
+		t.Error("A successful CONNECT reply must not carry Content-Length")
+	}
+	expectEcho(t, conn)
+
+	if _, response := connect(t, "CONNECT", "mole:guess"); response.StatusCode != http.StatusProxyAuthRequired ||
+		!strings.HasPrefix(response.Header.Get("Proxy-Authenticate"), "Basic") {
+		t.Errorf("Expected 407 with a Basic challenge, got %s", response.Status)
+	}
+	if _, response := connect(t, "GET", "mole:secret"); response.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %s", response.Status)
+	}
+
+	conn = dialProxy(t, addr)
+	conn.Write([]byte("NOT HTTP\r\n\r\n"))
+	if response, err := http.ReadResponse(bufio.NewReader(conn), nil); err != nil || response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a malformed request, got %v", err)
+	}
+}
+
+func TestProxyUnreachableTarget(t *testing.T) {
+	// A port nothing listens on
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	target := listener.Addr().(*net.TCPAddr)
+	listener.Close()
+
+	conn := dialProxy(t, startProxy(t, ""))
+	conn.Write([]byte{0x05, 0x01, 0x00})
+	expectBytes(t, conn, []byte{0x05, 0x00})
+	conn.Write([]byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, byte(target.Port >> 8), byte(target.Port)})
+	expectBytes(t, conn, []byte{0x05, 0x05})
+}
@@ -0,0 +1,223 @@
+// Package userspace runs a WireGuard tunnel inside the mole process on a user-space TCP/IP
+// stack, for hosts where mole may not create interfaces or routes, such as shared login
+// nodes without sudo. The private subnet is reached through a local SOCKS5 and HTTP CONNECT
+// proxy rather than through the kernel.
+package userspace
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/research-computing/mole/internal/tunnel"
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// defaultMTU leaves room for WireGuard overhead on a 1500-byte path
+const defaultMTU = 1420
+
+// Tunnel is a WireGuard tunnel running in-process. It implements Dialer.
+type Tunnel struct {
+	device *device.Device
+	stack  Dialer
+	// resolveLocally is set when the config names no DNS servers to query through the tunnel
+	resolveLocally bool
+}
+
+// Start brings up the tunnel described by a wg-quick config. It needs no privileges: the
+// WireGuard socket is an ordinary UDP socket and TCP/IP runs inside the process.
+func Start(config *tunnel.WireGuardConfig) (*Tunnel, error) {
+	uapi, err := ipcConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	addresses, err := parseAddrs(config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tunnel address: %w", err)
+	}
+	dns, err := parseAddrs(config.DNS)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS server: %w", err)
+	}
+	mtu := config.MTU
+	if mtu == 0 {
+		mtu = defaultMTU
+	}
+
+	tunDevice, stack, err := newNetstack(addresses, dns, mtu)
+	if err != nil {
+		return nil, err
+	}
+	dev, err := startDevice(tunDevice, uapi)
+	if err != nil {
+		return nil, err
+	}
+	return &Tunnel{device: dev, stack: stack, resolveLocally: len(dns) == 0}, nil
+}
+
+// DialContext connects to address through the tunnel
+func (t *Tunnel) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if t.resolveLocally {
+		var err error
+		if address, err = resolve(ctx, address); err != nil {
+			return nil, err
+		}
+	}
+	return t.stack.DialContext(ctx, network, address)
+}
+
+// WaitHandshake waits until the bastion has answered a handshake
+func (t *Tunnel) WaitHandshake(ctx context.Context) error {
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		state, err := t.device.IpcGet()
+		if err != nil {
+			return fmt.Errorf("failed to read WireGuard state: %w", err)
+		}
+		if handshakeDone(state) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("no handshake with the bastion: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stops the tunnel
+func (t *Tunnel) Close() error {
+	t.device.Close()
+	return nil
+}
+
+// startDevice runs wireguard-go on a TUN device and applies its UAPI configuration
+func startDevice(tunDevice tun.Device, uapi string) (*device.Device, error) {
+	dev := device.NewDevice(tunDevice, conn.NewDefaultBind(), device.NewLogger(device.LogLevelError, "wireguard: "))
+	if err := dev.IpcSet(uapi); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("failed to configure WireGuard: %w", err)
+	}
+	if err := dev.Up(); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("failed to start WireGuard: %w", err)
+	}
+	return dev, nil
+}
+
+// ipcConfig translates a wg-quick config into wireguard-go's UAPI format, which takes keys
+// in hex and endpoints as resolved addresses
+func ipcConfig(config *tunnel.WireGuardConfig) (string, error) {
+	if config.PeerPublicKey == "" || config.PeerEndpoint == "" {
+		return "", fmt.Errorf("config needs a [Peer] with PublicKey and Endpoint")
+	}
+
+	var uapi strings.Builder
+	privateKey, err := keyHex(config.PrivateKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid private key: %w", err)
+	}
+	fmt.Fprintf(&uapi, "private_key=%s\n", privateKey)
+	if config.ListenPort > 0 {
+		fmt.Fprintf(&uapi, "listen_port=%d\n", config.ListenPort)
+	}
+	uapi.WriteString("replace_peers=true\n")
+
+	peerKey, err := keyHex(config.PeerPublicKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid peer public key: %w", err)
+	}
+	fmt.Fprintf(&uapi, "public_key=%s\n", peerKey)
+	if config.PresharedKey != "" {
+		psk, err := keyHex(config.PresharedKey)
+		if err != nil {
+			return "", fmt.Errorf("invalid pre-shared key: %w", err)
+		}
+		fmt.Fprintf(&uapi, "preshared_key=%s\n", psk)
+	}
+	endpoint, err := net.ResolveUDPAddr("udp", config.PeerEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid endpoint %s: %w", config.PeerEndpoint, err)
+	}
+	fmt.Fprintf(&uapi, "endpoint=%s\n", endpoint)
+	uapi.WriteString("persistent_keepalive_interval=25\n")
+	uapi.WriteString("replace_allowed_ips=true\n")
+	for _, cidr := range strings.Split(config.AllowedIPs, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return "", fmt.Errorf("invalid allowed IPs %s: %w", cidr, err)
+		}
+		fmt.Fprintf(&uapi, "allowed_ip=%s\n", prefix.Masked())
+	}
+
+	return uapi.String(), nil
+}
+
+func keyHex(key string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil || len(raw) != 32 {
+		return "", fmt.Errorf("not a base64 WireGuard key")
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// parseAddrs parses a wg-quick address or DNS list; prefix lengths are dropped, since the
+// stack only needs the local addresses
+func parseAddrs(list string) ([]netip.Addr, error) {
+	var addrs []netip.Addr
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(item); err == nil {
+			addrs = append(addrs, prefix.Addr())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+// handshakeDone reports whether a UAPI dump shows a completed handshake
+func handshakeDone(state string) bool {
+	for _, line := range strings.Split(state, "\n") {
+		if value, ok := strings.CutPrefix(line, "last_handshake_time_sec="); ok && value != "0" {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve looks a host name up with the local resolver, for tunnels without DNS servers
+func resolve(ctx context.Context, address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return address, nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return "", err
+	}
+	if len(addrs) == 0 {
+		return "", fmt.Errorf("no address for %s", host)
+	}
+	return net.JoinHostPort(addrs[0].Unmap().String(), port), nil
+}
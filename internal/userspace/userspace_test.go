@@ -0,0 +1,141 @@
+package userspace
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/research-computing/mole/internal/tunnel"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func testConfig(t *testing.T) *tunnel.WireGuardConfig {
+	t.Helper()
+	privateKey, _, err := tunnel.GenerateWireGuardKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, peerKey, _ := tunnel.GenerateWireGuardKeys()
+	return &tunnel.WireGuardConfig{
+		Interface:     "wg0",
+		PrivateKey:    privateKey,
+		Address:       "10.100.1.2/24, fd6d:6f6c:6500:1::2/64",
+		PeerPublicKey: peerKey,
+		PeerEndpoint:  "198.51.100.7:51820",
+		AllowedIPs:    "10.0.1.0/24, 10.100.1.1/24",
+	}
+}
+
+func hexKey(key string) string {
+	raw, _ := base64.StdEncoding.DecodeString(key)
+	return hex.EncodeToString(raw)
+}
+
+func TestIpcConfig(t *testing.T) {
+	config := testConfig(t)
+	psk, _ := tunnel.GeneratePresharedKey()
+	config.PresharedKey = psk
+
+	uapi, err := ipcConfig(config)
+	if err != nil {
+		t.Fatalf("ipcConfig failed: %v", err)
+	}
+	for _, line := range []string{
+		"private_key=" + hexKey(config.PrivateKey),
+		"public_key=" + hexKey(config.PeerPublicKey),
+		"preshared_key=" + hexKey(psk),
+		"endpoint=198.51.100.7:51820",
+		"persistent_keepalive_interval=25",
+		"allowed_ip=10.0.1.0/24",
+		"allowed_ip=10.100.1.0/24", // Masked, as the kernel would
+	} {
+		if !strings.Contains(uapi, line+"\n") {
+			t.Errorf("UAPI config missing %q:\n%s", line, uapi)
+		}
+	}
+	if strings.Contains(uapi, "listen_port") {
+		t.Error("A client without ListenPort should use a random port")
+	}
+
+	config.PeerEndpoint = ""
+	if _, err := ipcConfig(config); err == nil {
+		t.Error("Expected a config without endpoint to fail")
+	}
+	config = testConfig(t)
+	config.PeerPublicKey = "short"
+	if _, err := ipcConfig(config); err == nil {
+		t.Error("Expected an invalid peer key to fail")
+	}
+}
+
+func TestStartDevice(t *testing.T) {
+	config := testConfig(t)
+	uapi, err := ipcConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dev, err := startDevice(tuntest.NewChannelTUN().TUN(), uapi)
+	if err != nil {
+		t.Fatalf("startDevice failed: %v", err)
+	}
+	defer dev.Close()
+
+	state, err := dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(state, "public_key="+hexKey(config.PeerPublicKey)) || !strings.Contains(state, "allowed_ip=10.0.1.0/24") {
+		t.Errorf("Device not configured:\n%s", state)
+	}
+	if handshakeDone(state) {
+		t.Error("No handshake can have completed with an unreachable bastion")
+	}
+
+	if _, err := startDevice(tuntest.NewChannelTUN().TUN(), "private_key=zz\n"); err == nil {
+		t.Error("Expected an invalid UAPI config to fail")
+	}
+}
+
+func TestStart(t *testing.T) {
+	if Available {
+		t.Skip("built with the user-space network stack")
+	}
+	if _, err := Start(testConfig(t)); err == nil || !strings.Contains(err.Error(), "-tags netstack") {
+		t.Errorf("Expected a hint to rebuild with the netstack tag, got %v", err)
+	}
+}
+
+func TestHandshakeDone(t *testing.T) {
+	if handshakeDone("public_key=ab\nlast_handshake_time_sec=0\n") {
+		t.Error("A zero handshake time means no handshake")
+	}
+	if !handshakeDone("public_key=ab\nlast_handshake_time_sec=1700000000\n") {
+		t.Error("Expected a completed handshake")
+	}
+}
+
+func TestParseAddrs(t *testing.T) {
+	addrs, err := parseAddrs("10.100.1.2/24, fd6d:6f6c:6500:1::2/64, 10.0.0.2")
+	if err != nil || len(addrs) != 3 || addrs[0].String() != "10.100.1.2" || addrs[2].String() != "10.0.0.2" {
+		t.Errorf("parseAddrs = %v, %v", addrs, err)
+	}
+	if addrs, err := parseAddrs(""); err != nil || len(addrs) != 0 {
+		t.Errorf("Expected no addresses, got %v, %v", addrs, err)
+	}
+	if _, err := parseAddrs("bastion"); err == nil {
+		t.Error("Expected a host name to fail")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	if address, err := resolve(context.Background(), "10.0.1.5:22"); err != nil || address != "10.0.1.5:22" {
+		t.Errorf("IP addresses should pass through, got %s, %v", address, err)
+	}
+	address, err := resolve(context.Background(), "localhost:22")
+	if err != nil || (address != "127.0.0.1:22" && address != "[::1]:22") {
+		t.Errorf("resolve(localhost) = %s, %v", address, err)
+	}
+}
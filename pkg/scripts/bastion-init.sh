@@ -1,244 +1,277 @@
 #!/bin/bash
-# AWS Cloud Mole Bastion Initialization Script
+# Generated from internal/aws/templates/bastion.sh.tmpl - do not edit.
+# Regenerate with: go test ./internal/aws -run TestTerraformModuleUserData -update
 set -euo pipefail
 
-# Variables from Terraform
-TUNNEL_COUNT=${tunnel_count}
-MTU_SIZE=${mtu_size}
-
-# Logging
-exec > >(tee /var/log/mole-init.log)
-exec 2>&1
-
-echo "🚀 Starting AWS Cloud Mole bastion initialization..."
-echo "   Tunnel Count: $TUNNEL_COUNT"
-echo "   MTU Size: $MTU_SIZE"
-
-# Update system
-echo "📦 Updating system packages..."
-apt-get update -y
-apt-get upgrade -y
-
-# Install required packages
-echo "📦 Installing required packages..."
-apt-get install -y \
-    wireguard \
-    wireguard-tools \
-    iptables \
-    iptables-persistent \
-    htop \
-    iotop \
-    nethogs \
-    tcpdump \
-    iperf3 \
-    jq \
-    curl \
-    wget \
-    awscli
+# Pre-calculated values, no API calls, minimal operations
+PRIVATE_SUBNET_CIDRS=""
+ROUTED_CIDRS=""
+TUNNEL_NETWORKS="10.100.1.0/24%{ if tunnel_count > 1 } 10.100.2.0/24%{ endif }%{ if tunnel_count > 2 } 10.100.3.0/24%{ endif }%{ if tunnel_count > 3 } 10.100.4.0/24%{ endif }%{ if tunnel_count > 4 } 10.100.5.0/24%{ endif }%{ if tunnel_count > 5 } 10.100.6.0/24%{ endif }%{ if tunnel_count > 6 } 10.100.7.0/24%{ endif }%{ if tunnel_count > 7 } 10.100.8.0/24%{ endif }"
+REGION=""
+
+# Install only essentials - skip updates for speed (pre-built mole images already have them)
+if ! command -v wg >/dev/null 2>&1; then
+    apt-get update -y && DEBIAN_FRONTEND=noninteractive apt-get install -y wireguard-tools
+fi
+if ! command -v aws >/dev/null 2>&1; then
+    apt-get update -y && DEBIAN_FRONTEND=noninteractive apt-get install -y awscli
+fi
 
 # Enable IP forwarding
-echo "🔄 Enabling IP forwarding..."
 echo 'net.ipv4.ip_forward=1' >> /etc/sysctl.conf
-echo 'net.ipv6.conf.all.forwarding=1' >> /etc/sysctl.conf
 sysctl -p
 
-# Optimize network stack for high performance
-echo "⚡ Optimizing network stack..."
-cat >> /etc/sysctl.conf << EOF
+mkdir -p /etc/mole/keys /etc/wireguard
+PRIMARY_INTERFACE=$(ip route show default | awk '{print $5; exit}')
 
-# AWS Cloud Mole Network Optimizations
+# Kernel tuning from the optimization config
+modprobe tcp_bbr 2>/dev/null || true
+cat > /etc/sysctl.d/90-mole-tuning.conf << EOF
 net.core.rmem_max = 134217728
 net.core.wmem_max = 134217728
 net.ipv4.tcp_rmem = 4096 87380 134217728
 net.ipv4.tcp_wmem = 4096 65536 134217728
 net.core.netdev_max_backlog = 5000
 net.ipv4.tcp_window_scaling = 1
+net.core.default_qdisc = fq
 net.ipv4.tcp_congestion_control = bbr
+net.core.rps_sock_flow_entries = 32768
+EOF
+sysctl -p /etc/sysctl.d/90-mole-tuning.conf || echo "some tunables were rejected; see 'mole status'" >&2
+
+CPUS=$(nproc)
+RX_QUEUES=0
+IRQ_QUEUES=0
+
+# Spread the ENA queues: RPS/XPS masks (first 32 CPUs) and one CPU per queue IRQ
+QUEUE_DIR=/sys/class/net/$PRIMARY_INTERFACE/queues
+CPU_MASK=$(printf '%x' $(( (1 << (CPUS < 32 ? CPUS : 32)) - 1 )))
+RX_QUEUE_COUNT=$(find $QUEUE_DIR -maxdepth 1 -name 'rx-*' 2>/dev/null | wc -l || true)
+if [ "$RX_QUEUE_COUNT" -gt 0 ]; then
+  for queue in $QUEUE_DIR/rx-*; do
+    echo $CPU_MASK > $queue/rps_cpus || true
+    echo $(( 32768 / RX_QUEUE_COUNT )) > $queue/rps_flow_cnt || true
+    RX_QUEUES=$((RX_QUEUES + 1))
+  done
+  TX_QUEUE=0
+  for queue in $QUEUE_DIR/tx-*; do
+    printf '%x' $(( 1 << (TX_QUEUE % CPUS % 32) )) > $queue/xps_cpus || true
+    TX_QUEUE=$((TX_QUEUE + 1))
+  done
+fi
+systemctl disable --now irqbalance 2>/dev/null || true
+for irq in $(grep -E "$PRIMARY_INTERFACE-Tx-Rx-[0-9]+" /proc/interrupts | cut -d: -f1 || true); do
+  echo $((IRQ_QUEUES % CPUS)) > /proc/irq/$irq/smp_affinity_list || true
+  IRQ_QUEUES=$((IRQ_QUEUES + 1))
+done
+
+for governor in /sys/devices/system/cpu/cpu*/cpufreq/scaling_governor; do
+  if [ -w "$governor" ]; then
+    echo performance > "$governor"
+  fi
+done
 
-# Reduce swappiness for better performance
-vm.swappiness = 10
+# Record what was requested; the agent compares it with the live values for 'mole status'
+cat > /etc/mole/tuning.json << EOF
+{
+  "sysctls": {
+    "net.core.rmem_max": "134217728",
+    "net.core.wmem_max": "134217728",
+    "net.ipv4.tcp_rmem": "4096 87380 134217728",
+    "net.ipv4.tcp_wmem": "4096 65536 134217728",
+    "net.core.netdev_max_backlog": "5000",
+    "net.ipv4.tcp_window_scaling": "1",
+    "net.core.default_qdisc": "fq",
+    "net.ipv4.tcp_congestion_control": "bbr",
+    "net.core.rps_sock_flow_entries": "32768"
+  },
+  "cpu_governor": "performance",
+  "spread_queues": true,
+  "cpus": $CPUS,
+  "rx_queues": $RX_QUEUES,
+  "irq_queues": $IRQ_QUEUES
+}
 EOF
 
-sysctl -p
+# Tunnel 0: wg0 on port 51820
+wg genkey | tee /etc/mole/keys/wg0_private.key | wg pubkey > /etc/mole/keys/wg0_public.key
+chmod 600 /etc/mole/keys/wg0_private.key
 
-# Create WireGuard directory structure
-echo "📁 Creating WireGuard directories..."
-mkdir -p /etc/wireguard
-mkdir -p /etc/mole/{keys,configs,logs}
-
-# Generate WireGuard keys for each tunnel
-echo "🔑 Generating WireGuard keys..."
-for i in $(seq 0 $((TUNNEL_COUNT - 1))); do
-    wg genkey | tee /etc/mole/keys/wg${i}_private.key | wg pubkey > /etc/mole/keys/wg${i}_public.key
-    chmod 600 /etc/mole/keys/wg${i}_private.key
-    chmod 644 /etc/mole/keys/wg${i}_public.key
-    echo "Generated keys for wg$i"
-done
+cat > /etc/wireguard/wg0.conf << EOF
+[Interface]
+PrivateKey = $(cat /etc/mole/keys/wg0_private.key)
+Address = 10.100.1.1/24
+ListenPort = 51820
+MTU = ${mtu_size}
 
-# Create WireGuard configuration templates
-echo "📝 Creating WireGuard configuration templates..."
-for i in $(seq 0 $((TUNNEL_COUNT - 1))); do
-    PORT=$((51820 + i))
-    PRIVATE_KEY=$(cat /etc/mole/keys/wg${i}_private.key)
+# No client key at render time: add the peer through the agent API
+EOF
+
+wg-quick up wg0
+iptables -A INPUT -p udp --dport 51820 -j ACCEPT
+iptables -A FORWARD -i wg0 -j ACCEPT
+iptables -A FORWARD -o wg0 -j ACCEPT
+
+%{ if tunnel_count > 1 }# Tunnel 1: wg1 on port 51821
+wg genkey | tee /etc/mole/keys/wg1_private.key | wg pubkey > /etc/mole/keys/wg1_public.key
+chmod 600 /etc/mole/keys/wg1_private.key
 
-    cat > /etc/wireguard/wg${i}.conf << EOF
+cat > /etc/wireguard/wg1.conf << EOF
 [Interface]
-PrivateKey = $PRIVATE_KEY
-Address = 10.100.$((i + 1)).2/24
-ListenPort = $PORT
-MTU = $MTU_SIZE
-
-# PostUp commands for routing and iptables
-PostUp = iptables -A FORWARD -i wg${i} -j ACCEPT
-PostUp = iptables -A FORWARD -o wg${i} -j ACCEPT
-PostUp = iptables -t nat -A POSTROUTING -o eth0 -j MASQUERADE
-
-# PostDown commands to clean up
-PostDown = iptables -D FORWARD -i wg${i} -j ACCEPT
-PostDown = iptables -D FORWARD -o wg${i} -j ACCEPT
-PostDown = iptables -t nat -D POSTROUTING -o eth0 -j MASQUERADE
-
-# Peer section will be added when client connects
+PrivateKey = $(cat /etc/mole/keys/wg1_private.key)
+Address = 10.100.2.1/24
+ListenPort = 51821
+MTU = ${mtu_size}
+
+# No client key at render time: add the peer through the agent API
 EOF
 
-    echo "Created configuration for wg$i (port $PORT)"
-done
+wg-quick up wg1
+iptables -A INPUT -p udp --dport 51821 -j ACCEPT
+iptables -A FORWARD -i wg1 -j ACCEPT
+iptables -A FORWARD -o wg1 -j ACCEPT
+%{ endif }
+%{ if tunnel_count > 2 }# Tunnel 2: wg2 on port 51822
+wg genkey | tee /etc/mole/keys/wg2_private.key | wg pubkey > /etc/mole/keys/wg2_public.key
+chmod 600 /etc/mole/keys/wg2_private.key
 
-# Set up monitoring script
-echo "📊 Setting up monitoring..."
-cat > /usr/local/bin/mole-monitor << 'EOF'
-#!/bin/bash
-# AWS Cloud Mole monitoring script
-
-echo "AWS Cloud Mole Bastion Status"
-echo "============================="
-echo "Uptime: $(uptime -p)"
-echo "Load: $(uptime | awk -F'load average:' '{ print $2 }')"
-echo
-
-echo "WireGuard Interfaces:"
-for interface in $(ls /etc/wireguard/*.conf 2>/dev/null | xargs -n1 basename -s .conf); do
-    if ip link show $interface >/dev/null 2>&1; then
-        echo "  ✅ $interface: UP"
-        wg show $interface transfer 2>/dev/null | head -1
-    else
-        echo "  ❌ $interface: DOWN"
-    fi
-done
+cat > /etc/wireguard/wg2.conf << EOF
+[Interface]
+PrivateKey = $(cat /etc/mole/keys/wg2_private.key)
+Address = 10.100.3.1/24
+ListenPort = 51822
+MTU = ${mtu_size}
+
+# No client key at render time: add the peer through the agent API
+EOF
 
-echo
-echo "Network Statistics:"
-ss -tuln | grep :518 | wc -l | xargs printf "  Active WireGuard listeners: %s\n"
+wg-quick up wg2
+iptables -A INPUT -p udp --dport 51822 -j ACCEPT
+iptables -A FORWARD -i wg2 -j ACCEPT
+iptables -A FORWARD -o wg2 -j ACCEPT
+%{ endif }
+%{ if tunnel_count > 3 }# Tunnel 3: wg3 on port 51823
+wg genkey | tee /etc/mole/keys/wg3_private.key | wg pubkey > /etc/mole/keys/wg3_public.key
+chmod 600 /etc/mole/keys/wg3_private.key
 
-echo
-echo "System Resources:"
-free -h | grep Mem | awk '{print "  Memory: " $3 "/" $2 " (" int($3/$2*100) "%)"}'
-df -h / | tail -1 | awk '{print "  Disk: " $3 "/" $2 " (" $5 ")"}'
+cat > /etc/wireguard/wg3.conf << EOF
+[Interface]
+PrivateKey = $(cat /etc/mole/keys/wg3_private.key)
+Address = 10.100.4.1/24
+ListenPort = 51823
+MTU = ${mtu_size}
+
+# No client key at render time: add the peer through the agent API
 EOF
 
-chmod +x /usr/local/bin/mole-monitor
-
-# Set up log rotation
-echo "📝 Setting up log rotation..."
-cat > /etc/logrotate.d/mole << EOF
-/var/log/mole-*.log {
-    daily
-    missingok
-    rotate 7
-    compress
-    delaycompress
-    notifempty
-    copytruncate
-}
+wg-quick up wg3
+iptables -A INPUT -p udp --dport 51823 -j ACCEPT
+iptables -A FORWARD -i wg3 -j ACCEPT
+iptables -A FORWARD -o wg3 -j ACCEPT
+%{ endif }
+%{ if tunnel_count > 4 }# Tunnel 4: wg4 on port 51824
+wg genkey | tee /etc/mole/keys/wg4_private.key | wg pubkey > /etc/mole/keys/wg4_public.key
+chmod 600 /etc/mole/keys/wg4_private.key
+
+cat > /etc/wireguard/wg4.conf << EOF
+[Interface]
+PrivateKey = $(cat /etc/mole/keys/wg4_private.key)
+Address = 10.100.5.1/24
+ListenPort = 51824
+MTU = ${mtu_size}
+
+# No client key at render time: add the peer through the agent API
 EOF
 
-# Create systemd service for mole monitoring
-echo "🔧 Creating monitoring service..."
-cat > /etc/systemd/system/mole-monitor.service << EOF
-[Unit]
-Description=AWS Cloud Mole Monitor
-After=network.target
+wg-quick up wg4
+iptables -A INPUT -p udp --dport 51824 -j ACCEPT
+iptables -A FORWARD -i wg4 -j ACCEPT
+iptables -A FORWARD -o wg4 -j ACCEPT
+%{ endif }
+%{ if tunnel_count > 5 }# Tunnel 5: wg5 on port 51825
+wg genkey | tee /etc/mole/keys/wg5_private.key | wg pubkey > /etc/mole/keys/wg5_public.key
+chmod 600 /etc/mole/keys/wg5_private.key
 
-[Service]
-Type=oneshot
-ExecStart=/usr/local/bin/mole-monitor
-User=root
+cat > /etc/wireguard/wg5.conf << EOF
+[Interface]
+PrivateKey = $(cat /etc/mole/keys/wg5_private.key)
+Address = 10.100.6.1/24
+ListenPort = 51825
+MTU = ${mtu_size}
 
-[Install]
-WantedBy=multi-user.target
+# No client key at render time: add the peer through the agent API
 EOF
 
-# Create systemd timer for regular monitoring
-cat > /etc/systemd/system/mole-monitor.timer << EOF
-[Unit]
-Description=Run mole-monitor every 5 minutes
-Requires=mole-monitor.service
+wg-quick up wg5
+iptables -A INPUT -p udp --dport 51825 -j ACCEPT
+iptables -A FORWARD -i wg5 -j ACCEPT
+iptables -A FORWARD -o wg5 -j ACCEPT
+%{ endif }
+%{ if tunnel_count > 6 }# Tunnel 6: wg6 on port 51826
+wg genkey | tee /etc/mole/keys/wg6_private.key | wg pubkey > /etc/mole/keys/wg6_public.key
+chmod 600 /etc/mole/keys/wg6_private.key
 
-[Timer]
-OnCalendar=*:0/5
+cat > /etc/wireguard/wg6.conf << EOF
+[Interface]
+PrivateKey = $(cat /etc/mole/keys/wg6_private.key)
+Address = 10.100.7.1/24
+ListenPort = 51826
+MTU = ${mtu_size}
 
-[Install]
-WantedBy=timers.target
+# No client key at render time: add the peer through the agent API
 EOF
 
-systemctl daemon-reload
-systemctl enable mole-monitor.timer
-systemctl start mole-monitor.timer
-
-# Store configuration in SSM Parameter Store
-echo "📡 Storing configuration in SSM..."
-AWS_REGION=$(curl -s http://169.254.169.254/latest/meta-data/placement/region)
-INSTANCE_ID=$(curl -s http://169.254.169.254/latest/meta-data/instance-id)
-
-# Store public keys in SSM for client retrieval
-for i in $(seq 0 $((TUNNEL_COUNT - 1))); do
-    PUBLIC_KEY=$(cat /etc/mole/keys/wg${i}_public.key)
-    aws ssm put-parameter \
-        --region "$AWS_REGION" \
-        --name "/mole/bastion/$INSTANCE_ID/wg${i}/public_key" \
-        --value "$PUBLIC_KEY" \
-        --type "String" \
-        --overwrite || echo "Warning: Could not store public key in SSM"
-done
+wg-quick up wg6
+iptables -A INPUT -p udp --dport 51826 -j ACCEPT
+iptables -A FORWARD -i wg6 -j ACCEPT
+iptables -A FORWARD -o wg6 -j ACCEPT
+%{ endif }
+%{ if tunnel_count > 7 }# Tunnel 7: wg7 on port 51827
+wg genkey | tee /etc/mole/keys/wg7_private.key | wg pubkey > /etc/mole/keys/wg7_public.key
+chmod 600 /etc/mole/keys/wg7_private.key
 
-# Store bastion info
-aws ssm put-parameter \
-    --region "$AWS_REGION" \
-    --name "/mole/bastion/$INSTANCE_ID/info" \
-    --value "{\"tunnel_count\":$TUNNEL_COUNT,\"mtu\":$MTU_SIZE,\"status\":\"ready\"}" \
-    --type "String" \
-    --overwrite || echo "Warning: Could not store bastion info in SSM"
-
-# Final status
-echo "✅ AWS Cloud Mole bastion initialization complete!"
-echo "   Instance ID: $INSTANCE_ID"
-echo "   Tunnel Count: $TUNNEL_COUNT"
-echo "   MTU Size: $MTU_SIZE"
-echo "   Status: Ready for client connections"
-
-# Create status file
-cat > /etc/mole/status << EOF
-{
-    "status": "ready",
-    "initialized_at": "$(date -Iseconds)",
-    "instance_id": "$INSTANCE_ID",
-    "tunnel_count": $TUNNEL_COUNT,
-    "mtu_size": $MTU_SIZE,
-    "public_keys": {
+cat > /etc/wireguard/wg7.conf << EOF
+[Interface]
+PrivateKey = $(cat /etc/mole/keys/wg7_private.key)
+Address = 10.100.8.1/24
+ListenPort = 51827
+MTU = ${mtu_size}
+
+# No client key at render time: add the peer through the agent API
 EOF
 
-for i in $(seq 0 $((TUNNEL_COUNT - 1))); do
-    PUBLIC_KEY=$(cat /etc/mole/keys/wg${i}_public.key)
-    echo "        \"wg$i\": \"$PUBLIC_KEY\"" >> /etc/mole/status
-    if [ $i -lt $((TUNNEL_COUNT - 1)) ]; then
-        echo "," >> /etc/mole/status
-    fi
+wg-quick up wg7
+iptables -A INPUT -p udp --dport 51827 -j ACCEPT
+iptables -A FORWARD -i wg7 -j ACCEPT
+iptables -A FORWARD -o wg7 -j ACCEPT
+%{ endif }
+# NAT for private subnets (minimal)
+for cidr in $PRIVATE_SUBNET_CIDRS; do
+  iptables -t nat -A POSTROUTING -s $cidr -j MASQUERADE
 done
 
-cat >> /etc/mole/status << EOF
-    }
-}
-EOF
+# Private subnets unknown at render time: NAT tunnel traffic leaving the primary interface
+for tunnel in $TUNNEL_NETWORKS; do
+  iptables -t nat -A POSTROUTING -s $tunnel -o $PRIMARY_INTERFACE -j MASQUERADE
+done
+
+# Peered VPCs / Transit Gateway cannot route the tunnel networks back, so NAT towards them
+for cidr in $ROUTED_CIDRS; do
+  for tunnel in $TUNNEL_NETWORKS; do
+    iptables -t nat -A POSTROUTING -s $tunnel -d $cidr -j MASQUERADE
+  done
+done
+
+# Get instance ID and tag with server public keys (fast)
+TOKEN=$(curl -X PUT "http://169.254.169.254/latest/api/token" -H "X-aws-ec2-metadata-token-ttl-seconds: 21600")
+INSTANCE_ID=$(curl -H "X-aws-ec2-metadata-token: $TOKEN" http://169.254.169.254/latest/meta-data/instance-id)
+REGION=$(curl -H "X-aws-ec2-metadata-token: $TOKEN" http://169.254.169.254/latest/meta-data/placement/region)
+
+# Disable source/dest check and tag
+aws ec2 modify-instance-attribute --instance-id $INSTANCE_ID --no-source-dest-check --region $REGION &
+aws ec2 create-tags --resources $INSTANCE_ID --tags Key=WireGuardPublicKey,Value="$(cat /etc/mole/keys/wg0_public.key)"%{ if tunnel_count > 1 } Key=WireGuardPublicKey1,Value="$(cat /etc/mole/keys/wg1_public.key)"%{ endif }%{ if tunnel_count > 2 } Key=WireGuardPublicKey2,Value="$(cat /etc/mole/keys/wg2_public.key)"%{ endif }%{ if tunnel_count > 3 } Key=WireGuardPublicKey3,Value="$(cat /etc/mole/keys/wg3_public.key)"%{ endif }%{ if tunnel_count > 4 } Key=WireGuardPublicKey4,Value="$(cat /etc/mole/keys/wg4_public.key)"%{ endif }%{ if tunnel_count > 5 } Key=WireGuardPublicKey5,Value="$(cat /etc/mole/keys/wg5_public.key)"%{ endif }%{ if tunnel_count > 6 } Key=WireGuardPublicKey6,Value="$(cat /etc/mole/keys/wg6_public.key)"%{ endif }%{ if tunnel_count > 7 } Key=WireGuardPublicKey7,Value="$(cat /etc/mole/keys/wg7_public.key)"%{ endif } --region $REGION &
+
+# mole agent not installed (no release download or agent token)
 
-echo "💾 Configuration saved to /etc/mole/status"
-echo "🎉 Bastion is ready for mole client connections!"
\ No newline at end of file
+# Signal ready - fast boot complete
+echo "ready" > /etc/mole/status
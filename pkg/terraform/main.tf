@@ -143,6 +143,8 @@ resource "aws_iam_role_policy" "bastion" {
           "cloudwatch:PutMetricData",
           "ec2:DescribeVolumes",
           "ec2:DescribeTags",
+          "ec2:CreateTags",
+          "ec2:ModifyInstanceAttribute",
           "logs:PutLogEvents",
           "logs:CreateLogGroup",
           "logs:CreateLogStream"
@@ -177,7 +179,7 @@ resource "random_id" "suffix" {
   byte_length = 4
 }
 
-# User data script for bastion setup
+# User data script for bastion setup (generated from mole's bastion template)
 locals {
   user_data = base64encode(templatefile("${path.module}/../scripts/bastion-init.sh", {
     tunnel_count = var.tunnel_count